@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// Files written by --with-assets next to the repo files.
+const (
+	assetsFolderName = ".hfgo-assets"      // images from other repos and hosts, by host and path
+	offlineCardName  = "README.offline.md" // the model card with those links pointing at the local copies
+)
+
+// ![alt](url "title") 和 HTML 里的 <img src="...">、<video poster="...">
+var (
+	cardImagePattern = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)>?(?:\s+["'][^"']*["'])?\s*\)`)
+	cardSrcPattern   = regexp.MustCompile(`(?i)\b(?:src|poster)\s*=\s*["']([^"']+)["']`)
+)
+
+// cardAssetRefs returns the images and videos a model card refers to, in order and without duplicates.
+func cardAssetRefs(card string) []string {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, pattern := range []*regexp.Regexp{cardImagePattern, cardSrcPattern} {
+		for _, match := range pattern.FindAllStringSubmatch(card, -1) {
+			if ref := match[1]; !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+// cardAsset is where a reference of the card is stored locally.
+type cardAsset struct {
+	repo      hfdl.Repo // repo the file is in, for files on the Hub
+	file      string    // path of the file in that repo
+	remote    string    // url of files on other hosts
+	localPath string    // below the target folder, with slashes
+	absolute  bool      // an url, which README.offline.md replaces with localPath
+}
+
+// resolveCardAsset works out what a reference of the card points at. Relative paths and
+// resolve/ or blob/ urls of the repo itself are stored at their place in the repo, so
+// README.md renders as is; files of other repos and hosts (e.g. images attached to
+// discussions on cdn-uploads.huggingface.co) go to .hfgo-assets/<host>/<path>.
+// The : of a port is replaced, Windows does not allow it in file names.
+func resolveCardAsset(ref hfdl.Repo, hubHosts map[string]bool, raw string) (cardAsset, bool) {
+	asset := cardAsset{absolute: true}
+	u, err := url.Parse(raw)
+	if err != nil || strings.HasPrefix(raw, "#") || strings.HasPrefix(raw, "//") {
+		return asset, false
+	}
+	switch {
+	case u.Scheme == "" && !strings.HasPrefix(u.Path, "/"):
+		file := path.Clean(u.Path)
+		if file == "." || strings.HasPrefix(file, "../") {
+			return asset, false
+		}
+		asset.repo, asset.file, asset.localPath, asset.absolute = ref, file, file, false
+		return asset, true
+	case u.Scheme == "":
+		// /org/model/resolve/main/x.png 是 Hub 上的绝对路径
+		u.Scheme, u.Host = "https", hfdl.DefaultEndpoint[len("https://"):]
+	case u.Scheme != "http" && u.Scheme != "https":
+		return asset, false
+	}
+	host := strings.ReplaceAll(u.Host, ":", "_")
+	if hubHosts[u.Host] {
+		target, err := hfdl.ParseRepo(u.Scheme + "://" + u.Host + u.Path)
+		if err != nil || target.File == "" {
+			return asset, false
+		}
+		file, err := url.PathUnescape(target.File)
+		if err != nil {
+			return asset, false
+		}
+		if hasDotDot(file) || hasDotDot(target.ID) || hasDotDot(target.Revision) {
+			// 卡片是别人写的，../ 可能指到仓库文件夹外面去
+			return asset, false
+		}
+		target.Endpoint = ref.Endpoint
+		asset.repo, asset.file = target, file
+		if target.Type == ref.Type && target.ID == ref.ID && target.Revision == ref.Revision {
+			asset.localPath = file
+		} else {
+			asset.localPath = path.Join(assetsFolderName, host, string(target.Type)+"s", target.ID, target.Revision, file)
+		}
+		return asset, true
+	}
+	name := path.Clean("/" + u.Path)
+	if name == "/" {
+		name = "/index"
+	}
+	u.Fragment = ""
+	asset.remote, asset.localPath = u.String(), path.Join(assetsFolderName, host, name)
+	return asset, true
+}
+
+// hasDotDot reports whether a path from a model card has a .. element, with either
+// kind of slash.
+func hasDotDot(p string) bool {
+	for _, element := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if element == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchCardAssets downloads what README.md in targetFolder shows (--with-assets), so
+// the card renders offline. When the card links to other repos or hosts, a copy with
+// those links pointing at the local files is written to README.offline.md. Returns the
+// number of assets that could not be fetched.
+func fetchCardAssets(ctx context.Context, d *hfdl.Downloader, ref hfdl.Repo, origin, targetFolder, proxyURLHead string, entries []hfdl.FileEntry) int {
+	card, err := os.ReadFile(filepath.Join(targetFolder, "README.md"))
+	if err != nil {
+		fmt.Fprintf(stdout, "No README.md in %s, no card assets to fetch\n", targetFolder)
+		return 0
+	}
+	hubHosts := map[string]bool{"huggingface.co": true, "hf.co": true}
+	for _, endpoint := range []string{origin, ref.Endpoint} {
+		if u, err := url.Parse(endpoint); err == nil {
+			hubHosts[u.Host] = true
+		}
+	}
+	listed := make(map[string]hfdl.FileEntry, len(entries))
+	for _, entry := range entries {
+		listed[entry.Path] = entry
+	}
+	var rewrites [][2]string // url in the card, local path
+	fetched, failed := 0, 0
+	for _, raw := range cardAssetRefs(string(card)) {
+		asset, ok := resolveCardAsset(ref, hubHosts, raw)
+		if !ok {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(asset.localPath)) || hasDotDot(asset.localPath) {
+			fmt.Fprintf(stdout, "Skipping card asset %s, it would be stored outside of %s\n", raw, targetFolder)
+			continue
+		}
+		filePath := filepath.Join(targetFolder, filepath.FromSlash(asset.localPath))
+		if asset.absolute {
+			rewrites = append(rewrites, [2]string{raw, asset.localPath})
+		}
+		if _, err := os.Stat(filePath); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			fmt.Fprintf(stdout, "Cannot create folder for %s: %v\n", asset.localPath, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(stdout, "Fetching card asset %s\n", asset.localPath)
+		if asset.remote != "" {
+			err = fetchURL(ctx, hfdl.ProxyURL(proxyURLHead, asset.remote), filePath)
+		} else {
+			// 被 --include/--exclude 过滤掉的文件不在列表里，大小和哈希未知
+			entry := listed[asset.file]
+			err = d.DownloadFile(ctx, asset.repo.ResolvePath(asset.file), filePath, entry.Size, entry.LFSOID)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot fetch card asset %s: %v\n", raw, err)
+			failed++
+			if asset.absolute {
+				// 没取到的图片在离线版里还指向原来的地址
+				rewrites = rewrites[:len(rewrites)-1]
+			}
+			continue
+		}
+		fetched++
+	}
+	if len(rewrites) > 0 {
+		// 长的先替换，x.png?raw=true 不会被 x.png 替换掉一半
+		sort.Slice(rewrites, func(a, b int) bool { return len(rewrites[a][0]) > len(rewrites[b][0]) })
+		var oldnew []string
+		for _, rewrite := range rewrites {
+			oldnew = append(oldnew, rewrite[0], rewrite[1])
+		}
+		offline := strings.NewReplacer(oldnew...).Replace(string(card))
+		if err := os.WriteFile(filepath.Join(targetFolder, offlineCardName), []byte(offline), 0644); err != nil {
+			fmt.Fprintf(stdout, "Cannot write %s: %v\n", offlineCardName, err)
+			failed++
+		} else {
+			fmt.Fprintf(stdout, "Wrote %s with %d links pointing at the local copies\n", offlineCardName, len(rewrites))
+		}
+	}
+	fmt.Fprintf(stdout, "Fetched %d card assets, %d failed\n", fetched, failed)
+	return failed
+}
+
+// fetchURL downloads a file from any host into filePath, through a .tmp file.
+func fetchURL(ctx context.Context, rawURL, filePath string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", response.Status)
+	}
+	tmpPath := filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, response.Body); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
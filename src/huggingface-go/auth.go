@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// authTransport adds the Hub token to requests for the Hub endpoints (and, with
+// --token-to-proxy, the url-prefix proxy in front of them). Other hosts, such as the
+// CDN a resolve url redirects to, never see the token; presigned CDN urls would reject
+// a second auth mechanism anyway.
+type authTransport struct {
+	base  http.RoundTripper
+	token string
+
+	mu    sync.Mutex // repos downloaded in parallel (--repo-workers) add their hosts
+	hosts map[string]bool
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	hub := t.hosts[req.URL.Host]
+	t.mu.Unlock()
+	if hub && req.Header.Get("Authorization") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// resolveToken returns the token given by flag or HFGO_TOKEN, falling back to HF_TOKEN.
+func resolveToken(token string) string {
+	if token != "" {
+		return token
+	}
+	return os.Getenv("HF_TOKEN")
+}
+
+// installToken makes every request to the given endpoints carry the token.
+// Calling it again adds endpoints to the already installed transport.
+func installToken(token string, endpoints ...string) {
+	if token == "" {
+		return
+	}
+	t, ok := http.DefaultTransport.(*authTransport)
+	if !ok || t.token != token {
+		t = &authTransport{base: http.DefaultTransport, token: token, hosts: make(map[string]bool)}
+		http.DefaultTransport = t
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			t.hosts[u.Host] = true
+		}
+	}
+}
+
+// installAuth makes requests to the endpoints carry the token and the request
+// signature, if any. The url-prefix proxy in front of them gets the signature, as it
+// may be the gateway checking it, but the token only with --token-to-proxy: -p is
+// often a public proxy run by someone else.
+func (g *globalOptions) installAuth(endpoints ...string) {
+	if g.tokenToProxy {
+		installToken(g.token, append([]string{g.proxyURLHead}, endpoints...)...)
+	} else {
+		installToken(g.token, endpoints...)
+	}
+	if g.signer != nil {
+		g.signer.addHosts(append([]string{g.proxyURLHead}, endpoints...)...)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// How the repos of a batch share the --repo-workers (--schedule).
+const (
+	scheduleFIFO       = "fifo"        // in the order listed, each repo to the end
+	scheduleRoundRobin = "round-robin" // a repo that had its turn goes back to the end of the queue
+	schedulePriority   = "priority"    // the highest priority= first, in the order listed between equal ones
+)
+
+// 轮转调度时一个仓库最多连续下载这么久，还有仓库在排队时下完当前文件就让出来
+const repoTurn = 5 * time.Minute
+
+func validSchedule(schedule string) bool {
+	switch schedule {
+	case scheduleFIFO, scheduleRoundRobin, schedulePriority:
+		return true
+	}
+	return false
+}
+
+// batchEntry is one repo of a --from-file list. Empty fields keep the command-line settings.
+type batchEntry struct {
+	line     int
+	url      string
+	revision string
+	folder   string // parent folder of the repo folder, like -f
+	include  []string
+	exclude  []string
+	priority int
+}
+
+// queuedRepo is a repo waiting to be downloaded with the options of its batch entry.
+type queuedRepo struct {
+	ref      hfdl.Repo
+	opts     *downloadOptions
+	depth    int // 0 for the repos given, n for the companion repos queued by a repo of depth n-1
+	priority int // of the batch entry, companion repos inherit it
+}
+
+// readBatchFile reads a --from-file list, "-" reads it from stdin. Files ending in
+// .yaml or .yml hold a list of repos:
+//
+//	# repos.yaml
+//	- url: org/model
+//	  include: ["*.safetensors", "*.json"]
+//	  folder: /data/models
+//	- datasets/org/name
+//
+// anything else one repo per line with optional key=value settings:
+//
+//	# repos.txt
+//	org/model include=*.safetensors,*.json folder=/data/models
+//	datasets/org/name revision=v1.0
+func readBatchFile(name string) ([]batchEntry, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []batchEntry
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		entries, err = parseBatchYAML(string(data))
+	default:
+		entries, err = parseBatchLines(string(data))
+	}
+	if err == nil && len(entries) == 0 {
+		err = fmt.Errorf("no repos listed")
+	}
+	return entries, err
+}
+
+func parseBatchLines(data string) ([]batchEntry, error) {
+	var entries []batchEntry
+	for i, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(stripComment(line))
+		if len(fields) == 0 {
+			continue
+		}
+		entry := batchEntry{line: i + 1, url: fields[0]}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", i+1, field)
+			}
+			if err := entry.set(key, []string{value}); err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// parseBatchYAML reads a YAML list whose items are repo urls or flat mappings with
+// the keys of batchEntry.set; values are scalars or inline lists.
+func parseBatchYAML(data string) ([]batchEntry, error) {
+	var entries []batchEntry
+	itemIndent := -1
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(trimmed)
+		if item, ok := strings.CutPrefix(trimmed, "-"); ok && (item == "" || item[0] == ' ') && (itemIndent < 0 || indent == itemIndent) {
+			itemIndent = indent
+			entries = append(entries, batchEntry{line: i + 1})
+			trimmed = strings.TrimSpace(item)
+			if trimmed == "" {
+				continue
+			}
+			if !strings.Contains(trimmed, ": ") && !strings.HasSuffix(trimmed, ":") {
+				// - org/model，只有地址
+				url, err := configScalar(trimmed)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", i+1, err)
+				}
+				entries[len(entries)-1].url = url
+				continue
+			}
+		} else if len(entries) == 0 || indent <= itemIndent {
+			return nil, fmt.Errorf("line %d: expected a list item starting with \"- \"", i+1)
+		}
+		key, value, _ := strings.Cut(trimmed, ":")
+		values, err := configValue(strings.TrimSpace(value))
+		if err == nil {
+			err = entries[len(entries)-1].set(strings.TrimSpace(key), values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+	}
+	for _, entry := range entries {
+		if entry.url == "" {
+			return nil, fmt.Errorf("line %d: the repo has no url", entry.line)
+		}
+	}
+	return entries, nil
+}
+
+// set applies one setting of a batch entry; include and exclude may be comma separated.
+func (e *batchEntry) set(key string, values []string) error {
+	single := func(field *string) error {
+		if len(values) != 1 || values[0] == "" {
+			return fmt.Errorf("%s needs a single value", key)
+		}
+		*field = values[0]
+		return nil
+	}
+	switch strings.ReplaceAll(strings.ToLower(key), "_", "-") {
+	case "url", "u":
+		return single(&e.url)
+	case "revision":
+		return single(&e.revision)
+	case "folder", "f":
+		return single(&e.folder)
+	case "include":
+		for _, value := range values {
+			(*stringList)(&e.include).Set(value)
+		}
+	case "exclude":
+		for _, value := range values {
+			(*stringList)(&e.exclude).Set(value)
+		}
+	case "priority":
+		var value string
+		if err := single(&value); err != nil {
+			return err
+		}
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("priority needs a number, got %q", value)
+		}
+		e.priority = priority
+	default:
+		return fmt.Errorf("unknown key %q, expected url, revision, folder, include, exclude or priority", key)
+	}
+	return nil
+}
+
+// batchQueue turns the entries into repos to download. Each entry gets its own copy
+// of opts where it sets the folder or filters; the revision given with --revision
+// applies to urls without one.
+func batchQueue(entries []batchEntry, opts *downloadOptions, revision string) ([]queuedRepo, error) {
+	queue := make([]queuedRepo, 0, len(entries))
+	for _, entry := range entries {
+		ref, err := opts.parseRepo(entry.url)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", entry.line, redact(err))
+		}
+		switch {
+		case entry.revision != "":
+			ref.Revision = entry.revision
+		case revision != "":
+			ref.Revision = revision
+		}
+		entryOpts := opts
+		if entry.folder != "" || entry.include != nil || entry.exclude != nil {
+			copied := *opts
+			entryOpts = &copied
+			if entry.folder != "" {
+				entryOpts.targetParentFolder = entry.folder
+			}
+			// 条目里的过滤规则取代命令行上的
+			if entry.include != nil || entry.exclude != nil {
+				entryOpts.filter = fileFilter{include: entry.include, exclude: entry.exclude}
+			}
+		}
+		queue = append(queue, queuedRepo{ref: ref, opts: entryOpts, priority: entry.priority})
+	}
+	return queue, nil
+}
+
+// runQueue downloads the queued repos in the order of schedule, up to workers of them
+// at the same time, or fewer while limit (if not nil) returns a smaller positive
+// number. process returns the repos to queue after it, e.g. the dependencies found or,
+// when pause told it that its turn is over, the repo itself to continue later.
+func runQueue(ctx context.Context, queue []queuedRepo, workers int, limit func() int, schedule string, process func(item queuedRepo, pause func() bool) []queuedRepo) {
+	if workers < 1 {
+		workers = 1
+	}
+	var mu sync.Mutex
+	wake := sync.NewCond(&mu)
+	busy := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			for {
+				// 队列空了但还有仓库在下载时等着，它们可能会加入关联仓库
+				for len(queue) == 0 && busy > 0 && ctx.Err() == nil {
+					wake.Wait()
+				}
+				if len(queue) == 0 || ctx.Err() != nil {
+					wake.Broadcast()
+					return
+				}
+				if limit != nil {
+					if n := limit(); n > 0 && busy >= n {
+						// 自动调整后同时下载的数目变少了，过一会儿再看
+						mu.Unlock()
+						select {
+						case <-ctx.Done():
+						case <-time.After(time.Second):
+						}
+						mu.Lock()
+						continue
+					}
+				}
+				next := 0
+				if schedule == schedulePriority {
+					for i, item := range queue {
+						if item.priority > queue[next].priority {
+							next = i
+						}
+					}
+				}
+				item := queue[next]
+				queue = slices.Delete(queue, next, next+1)
+				busy++
+				var pause func() bool
+				if schedule == scheduleRoundRobin {
+					started := time.Now()
+					// 轮到的时间用完并且有仓库在等时让出来
+					pause = func() bool {
+						mu.Lock()
+						defer mu.Unlock()
+						return time.Since(started) >= repoTurn && len(queue) > 0
+					}
+				}
+				mu.Unlock()
+				requeue := process(item, pause)
+				mu.Lock()
+				busy--
+				queue = append(queue, requeue...)
+				wake.Broadcast()
+			}
+		}()
+	}
+	wg.Wait()
+}
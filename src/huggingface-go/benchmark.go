@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// knownEndpoints are always probed by benchmark and --auto-mirror.
+var knownEndpoints = []string{"https://hf-mirror.com", hfdl.DefaultEndpoint}
+
+// 测速用的文件：公开、稳定、足够大，各个镜像都有
+const defaultProbeFile = "/openai-community/gpt2/resolve/main/model.safetensors"
+
+const probeTimeout = 15 * time.Second
+
+type probeResult struct {
+	endpoint string
+	latency  time.Duration // until the response headers arrived
+	speed    float64       // bytes per second over the whole request
+	err      error
+}
+
+// probeEndpoints fetches the first size bytes of probeFile from every endpoint at
+// the same time and returns the results fastest first, failures last.
+func probeEndpoints(proxyURLHead string, endpoints []string, probeFile string, size int64) []probeResult {
+	results := make([]probeResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i] = probeEndpoint(proxyURLHead, endpoint, probeFile, size)
+		}(i, endpoint)
+	}
+	wg.Wait()
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].err == nil) != (results[j].err == nil) {
+			return results[i].err == nil
+		}
+		return results[i].speed > results[j].speed
+	})
+	return results
+}
+
+func probeEndpoint(proxyURLHead, endpoint, probeFile string, size int64) probeResult {
+	result := probeResult{endpoint: endpoint}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, hfdl.ProxyURL(proxyURLHead, endpoint+probeFile), nil)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	request.Header.Set("Range", "bytes=0-"+strconv.FormatInt(size-1, 10))
+	start := time.Now()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer response.Body.Close()
+	result.latency = time.Since(start)
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusPartialContent {
+		result.err = hfdl.AccessError(response.StatusCode, response.Status)
+		return result
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(response.Body, size))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.speed = float64(n) / time.Since(start).Seconds()
+	return result
+}
+
+// candidateEndpoints lists the known endpoints, the configured mirrors and extra ones, without duplicates.
+func candidateEndpoints(g *globalOptions, extra ...string) []string {
+	var endpoints []string
+	seen := make(map[string]bool)
+	all := append(append(append([]string{}, g.hosts(g.hub())...), knownEndpoints...), extra...)
+	for _, endpoint := range all {
+		endpoint = strings.TrimRight(endpoint, "/")
+		if endpoint != "" && !seen[endpoint] {
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+func printProbeResults(results []probeResult) {
+	for _, r := range results {
+		if r.err != nil {
+			fmt.Fprintf(stdout, "  %-40s failed: %v\n", r.endpoint, r.err)
+			continue
+		}
+		speed, unit := convertBytes(r.speed)
+		fmt.Fprintf(stdout, "  %-40s %8.2f %s/s  latency %v\n", r.endpoint, speed, unit, r.latency.Round(time.Millisecond))
+	}
+}
+
+// autoSelectMirrors is --auto-mirror: it probes the candidates and makes the reachable
+// ones, fastest first, the mirror list of the session.
+func autoSelectMirrors(g *globalOptions) {
+	fmt.Fprintln(stdout, "Testing the speed of the mirrors...")
+	results := probeEndpoints(g.proxyURLHead, candidateEndpoints(g), defaultProbeFile, 1<<20)
+	printProbeResults(results)
+	var mirrors stringList
+	for _, r := range results {
+		if r.err == nil {
+			mirrors = append(mirrors, r.endpoint)
+		}
+	}
+	if len(mirrors) == 0 {
+		fmt.Fprintln(stdout, "No mirror answered the speed test, keeping the configured ones")
+		return
+	}
+	fmt.Fprintf(stdout, "Using %s\n", mirrors[0])
+	g.mirrors = mirrors
+}
+
+// runBenchmark implements `huggingface-go benchmark [flags] [endpoint...]`.
+func runBenchmark(args []string) {
+	flags := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	var probeFile, size string
+	flags.StringVar(&probeFile, "file", defaultProbeFile, "resolve path of the file used for the test")
+	flags.StringVar(&size, "size", "4M", "how much of the file to download from every endpoint")
+	extra := parseFlags(flags, &g, args, "benchmark [flags] [endpoint...]")
+	sizeBytes, err := parseByteSize(size)
+	if err != nil || sizeBytes < 1 {
+		fmt.Fprintf(stdout, "Invalid --size %q\n", size)
+		os.Exit(2)
+	}
+	endpoints := candidateEndpoints(&g, extra...)
+	g.installAuth(endpoints...)
+	fmt.Fprintf(stdout, "Downloading the first %s of %s from %d endpoints\n", size, probeFile, len(endpoints))
+	results := probeEndpoints(g.proxyURLHead, endpoints, "/"+strings.TrimPrefix(probeFile, "/"), sizeBytes)
+	printProbeResults(results)
+	if results[0].err != nil {
+		os.Exit(1)
+	}
+}
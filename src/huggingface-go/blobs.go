@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// blobStore remembers where each file content (by LFS sha256 or git blob id) was
+// already written during this run, so other revisions can link to it instead of
+// downloading it again. With a cache folder (--blob-cache) LFS files are also kept
+// there by sha256 across runs, mirrors and repos. A nil *blobStore stores nothing.
+type blobStore struct {
+	dir string // --blob-cache, may be empty
+
+	mu    sync.Mutex // repos of --from-file share the store with --repo-workers
+	paths map[string]string
+}
+
+func newBlobStore(dir string) *blobStore {
+	return &blobStore{dir: dir, paths: make(map[string]string)}
+}
+
+func blobKey(entry hfdl.FileEntry) string {
+	if entry.LFSOID != "" {
+		return "sha256:" + entry.LFSOID
+	}
+	return entry.OID
+}
+
+// cachePath returns the file of entry in the cache folder, or "" for files that
+// are not kept there: only LFS files, the small ones are not worth it.
+func (b *blobStore) cachePath(entry hfdl.FileEntry) string {
+	// 文件名来自文件列表，只接受 sha256，避免写到缓存目录外面
+	if b.dir == "" || len(entry.LFSOID) != 64 || strings.Trim(entry.LFSOID, "0123456789abcdef") != "" {
+		return ""
+	}
+	return filepath.Join(b.dir, entry.LFSOID)
+}
+
+// lookup returns a local file holding the content of entry, or "".
+func (b *blobStore) lookup(entry hfdl.FileEntry) string {
+	if b == nil || blobKey(entry) == "" {
+		return ""
+	}
+	b.mu.Lock()
+	localPath := b.paths[blobKey(entry)]
+	b.mu.Unlock()
+	for _, candidate := range []string{localPath, b.cachePath(entry)} {
+		if candidate == "" {
+			continue
+		}
+		if stat, err := os.Stat(candidate); err == nil && stat.Size() == entry.Size {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func (b *blobStore) add(entry hfdl.FileEntry, localPath string) {
+	if b == nil || blobKey(entry) == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.paths[blobKey(entry)]; !ok {
+		b.paths[blobKey(entry)] = localPath
+	}
+}
+
+// keep adds a file that was just downloaded and checked against its hash, and links
+// it into the cache folder. Files skipped because of their size are only added:
+// their content was not checked and must not end up in the cache.
+func (b *blobStore) keep(entry hfdl.FileEntry, localPath string) {
+	b.add(entry, localPath)
+	if b == nil {
+		return
+	}
+	cachePath := b.cachePath(entry)
+	if cachePath == "" {
+		return
+	}
+	if stat, err := os.Stat(cachePath); err == nil && stat.Size() == entry.Size {
+		return
+	}
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		fmt.Fprintf(stdout, "Cannot create blob cache %s: %v\n", b.dir, err)
+		return
+	}
+	if err := linkBlob(localPath, cachePath); err != nil {
+		fmt.Fprintf(stdout, "Cannot add %s to the blob cache: %v\n", localPath, err)
+	}
+}
+
+// linkBlob hard-links src to dst, copying it when the file system cannot link.
+func linkBlob(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyBlob(src, dst)
+}
+
+// copyBlob copies src to dst through a .tmp file.
+func copyBlob(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmpPath := dst + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dst)
+}
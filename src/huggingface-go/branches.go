@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// repoRef is a branch, tag or other ref of the refs api.
+type repoRef struct {
+	Name         string `json:"name"`
+	Ref          string `json:"ref"`
+	TargetCommit string `json:"targetCommit"`
+}
+
+// runBranches implements `huggingface-go branches [flags] <url>`: the branches, tags
+// and pull requests of a repo with the commit each points at.
+func runBranches(args []string) {
+	fs := flag.NewFlagSet("branches", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var repoURL string
+	var noPRs bool
+	fs.StringVar(&repoURL, "u", "", "huggingface url, hf:// uri or repo id (e.g. datasets/org/name), can also be given as the first argument")
+	fs.BoolVar(&noPRs, "no-prs", false, "leave out the pull request refs")
+	parseFlags(fs, &g, args, "branches [flags] <url>")
+	ref := g.repoArg(fs, repoURL)
+	g.openRepo(context.Background(), &ref)
+
+	var refs struct {
+		Branches     []repoRef `json:"branches"`
+		Tags         []repoRef `json:"tags"`
+		Converts     []repoRef `json:"converts"`
+		PullRequests []repoRef `json:"pullRequests"`
+	}
+	refsURL := ref.APIURL() + "/refs"
+	if !noPRs {
+		refsURL += "?include_prs=1"
+	}
+	if err := fetchJSON(g.proxyURLHead, refsURL, &refs); err != nil {
+		fmt.Fprintf(stdout, "Cannot fetch the refs: %v\n", err)
+		os.Exit(1)
+	}
+	for _, list := range []struct {
+		label string
+		refs  []repoRef
+		full  bool // print refs/pr/12 instead of the name
+	}{
+		{"Branches", refs.Branches, false},
+		{"Tags", refs.Tags, false},
+		{"Conversions", refs.Converts, true},
+		{"Pull requests", refs.PullRequests, true},
+	} {
+		if len(list.refs) == 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "%s:\n", list.label)
+		for _, r := range list.refs {
+			name := r.Name
+			if list.full && r.Ref != "" {
+				name = r.Ref
+			}
+			fmt.Fprintln(stdout, strings.TrimRight(fmt.Sprintf("  %-30s %s", name, shortCommit(r.TargetCommit)), " "))
+		}
+	}
+	fmt.Fprintf(stdout, "Download one with --revision <name> or %s@<name>\n", ref.ID)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 拉取到的文件按内容（sha256 或 git blob id）存放，不同仓库和版本里相同的文件只存一份
+const blobFolderName = ".hfgo-blobs"
+
+// 分支会前进，文件列表过一段时间要重新向上游获取
+const listingTTL = 10 * time.Minute
+
+// pullThrough fetches what the local folders do not have from the upstream hosts
+// (the mirror and huggingface.co) and keeps the files in a blob folder, so one
+// machine with internet access can feed a whole cluster.
+type pullThrough struct {
+	d            *hfdl.Downloader
+	upstream     string // preferred upstream endpoint
+	proxyURLHead string
+	blobDir      string
+
+	mu       sync.Mutex
+	listings map[string]*servedRepo   // index key -> listing fetched from upstream
+	fetching map[string]chan struct{} // blob name -> closed when its download ends
+}
+
+func newPullThrough(g *globalOptions, root string) (*pullThrough, error) {
+	blobDir := filepath.Join(root, blobFolderName)
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return nil, err
+	}
+	hosts := g.hosts(g.hub())
+	g.installAuth(hosts...)
+	d := hfdl.New(hosts, g.downloaderOptions()...)
+	return &pullThrough{
+		d:            d,
+		upstream:     hosts[0],
+		proxyURLHead: g.proxyURLHead,
+		blobDir:      blobDir,
+		listings:     make(map[string]*servedRepo),
+		fetching:     make(map[string]chan struct{}),
+	}, nil
+}
+
+// repo returns the file list of a repo revision from upstream, cached for listingTTL.
+func (p *pullThrough) repo(ctx context.Context, repoType hfdl.RepoType, id, revision string) (*servedRepo, error) {
+	key := indexKey(repoType, id, revision)
+	p.mu.Lock()
+	repo := p.listings[key]
+	p.mu.Unlock()
+	if repo != nil && time.Since(repo.marker.CompletedAt) < listingTTL {
+		return repo, nil
+	}
+	ref := hfdl.Repo{Endpoint: p.upstream, Type: repoType, ID: id, Revision: revision}
+	entries, err := p.d.ListFiles(ctx, ref, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	marker := completeMarker{Repo: id, Type: repoType, Revision: revision, CompletedAt: time.Now()}
+	for _, entry := range entries {
+		if entry.Type == "file" {
+			marker.Files = append(marker.Files, markerFile{Path: entry.Path, Size: entry.Size, OID: entry.OID, SHA256: entry.LFSOID})
+		}
+	}
+	repo = newServedRepo(p.blobDir, marker)
+	repo.pull, repo.ref = p, ref
+	p.mu.Lock()
+	p.listings[key] = repo
+	p.mu.Unlock()
+	return repo, nil
+}
+
+// blob returns the local path of a file, downloading it from upstream first when
+// it is not in the blob folder yet. Concurrent requests for the same file wait
+// for one download.
+func (p *pullThrough) blob(ctx context.Context, ref hfdl.Repo, f markerFile) (string, error) {
+	name := f.SHA256
+	if name == "" {
+		name = f.OID
+	}
+	if name == "" || strings.Trim(name, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%s has no usable content hash %q", f.Path, name)
+	}
+	blobPath := filepath.Join(p.blobDir, name)
+	for {
+		if stat, err := os.Stat(blobPath); err == nil && stat.Size() == f.Size {
+			return blobPath, nil
+		}
+		p.mu.Lock()
+		done, busy := p.fetching[name]
+		if !busy {
+			done = make(chan struct{})
+			p.fetching[name] = done
+		}
+		p.mu.Unlock()
+		if busy {
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		fmt.Fprintf(stdout, "Fetching %s %s@%s/%s from upstream\n", ref.Type, ref.ID, ref.Revision, f.Path)
+		// 不跟随客户端的 context：客户端断开后下载也要完成，留给下一个请求
+		err := p.d.DownloadFile(context.Background(), ref.ResolvePath(f.Path), blobPath, f.Size, f.SHA256)
+		p.mu.Lock()
+		delete(p.fetching, name)
+		p.mu.Unlock()
+		close(done)
+		if err != nil {
+			return "", err
+		}
+		return blobPath, nil
+	}
+}
+
+// forward passes a repo info request to upstream, redirects of renamed repos included.
+func (p *pullThrough) forward(w http.ResponseWriter, r *http.Request) {
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, hfdl.ProxyURL(p.proxyURLHead, p.upstream+r.URL.RequestURI()), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Do(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+	for _, name := range []string{"Content-Type", "Location"} {
+		if value := response.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// hubCacheDir is where huggingface_hub looks for downloads: $HF_HUB_CACHE,
+// $HF_HOME/hub or ~/.cache/huggingface/hub.
+func hubCacheDir() string {
+	if dir := os.Getenv("HF_HUB_CACHE"); dir != "" {
+		return dir
+	}
+	if home := os.Getenv("HF_HOME"); home != "" {
+		return filepath.Join(home, "hub")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".cache", "huggingface", "hub")
+}
+
+// cacheRepoFolder is the folder of a repo in the cache, e.g. models--org--name.
+func cacheRepoFolder(cacheDir string, ref hfdl.Repo) string {
+	return filepath.Join(cacheDir, string(ref.Type)+"s--"+strings.ReplaceAll(ref.ID, "/", "--"))
+}
+
+// blobName is the name huggingface_hub gives a file in blobs/: its etag, which is
+// the sha256 for LFS files and the git blob id otherwise.
+func blobName(entry hfdl.FileEntry) string {
+	if entry.LFSOID != "" {
+		return entry.LFSOID
+	}
+	return entry.OID
+}
+
+// How the files of a snapshot point at their blobs, from best to worst.
+const (
+	linkSymlink  = "symlinks"
+	linkHardlink = "hard links"
+	linkCopy     = "copies"
+)
+
+// linkSnapshot points snapshots/<commit>/<path> at ../../blobs/<blob> with relative
+// symlinks like huggingface_hub does. Where symlinks are not allowed (Windows without
+// developer mode or admin rights) it uses hard links, which NTFS allows every user,
+// and copies where those fail too, e.g. on FAT or some network drives. Junctions would
+// need no privileges either, but they only link folders. Prints which way was used.
+func linkSnapshot(snapshotFolder, blobFolder string, blobs map[string]string) error {
+	mode := linkSymlink
+	linked := make(map[string]int)
+	var symlinkErr, hardlinkErr error
+	for filePath, blob := range blobs {
+		link := filepath.Join(snapshotFolder, filepath.FromSlash(filePath))
+		blobPath := filepath.Join(blobFolder, blob)
+		target, err := filepath.Rel(filepath.Dir(link), blobPath)
+		if err != nil {
+			return err
+		}
+		if snapshotLinked(link, target, blobPath) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return err
+		}
+		os.Remove(link)
+		// 一种方式失败后，剩下的文件直接用下一种
+		if mode == linkSymlink {
+			if symlinkErr = os.Symlink(target, link); symlinkErr == nil {
+				linked[mode]++
+				continue
+			}
+			mode = linkHardlink
+		}
+		if mode == linkHardlink {
+			if hardlinkErr = os.Link(blobPath, link); hardlinkErr == nil {
+				linked[mode]++
+				continue
+			}
+			mode = linkCopy
+		}
+		if err := copyBlob(blobPath, link); err != nil {
+			return err
+		}
+		linked[mode]++
+	}
+	if n := linked[linkSymlink]; n > 0 {
+		fmt.Fprintf(stdout, "Linked %d snapshot files to blobs/ with symlinks\n", n)
+	}
+	if n := linked[linkHardlink]; n > 0 {
+		fmt.Fprintf(stdout, "Cannot create symlinks (%v), linked %d snapshot files to blobs/ with hard links instead\n", symlinkErr, n)
+	}
+	if n := linked[linkCopy]; n > 0 {
+		fmt.Fprintf(stdout, "Cannot create symlinks or hard links (%v), copied %d blobs into the snapshot instead; they take twice the space\n", hardlinkErr, n)
+	}
+	return nil
+}
+
+// snapshotLinked reports whether link already points at blobPath: as the symlink to
+// target, as a hard link, or as a complete copy from an earlier run.
+func snapshotLinked(link, target, blobPath string) bool {
+	if current, err := os.Readlink(link); err == nil {
+		return current == target
+	}
+	linkStat, err := os.Lstat(link)
+	if err != nil || !linkStat.Mode().IsRegular() {
+		return false
+	}
+	blobStat, err := os.Stat(blobPath)
+	// blob 按内容命名，大小相同的副本就是同一个文件
+	return err == nil && (os.SameFile(linkStat, blobStat) || linkStat.Size() == blobStat.Size())
+}
+
+// writeCacheRef records refs/<revision> -> commit, so the revision name can be loaded offline.
+func writeCacheRef(repoFolder, revision, commit string) error {
+	if revision == commit {
+		return nil
+	}
+	refPath := filepath.Join(repoFolder, "refs", filepath.FromSlash(revision))
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(refPath, []byte(commit), 0644)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reasons a download run is stopped before it finishes, see context.Cause.
+var (
+	errUserCancelled = errors.New("cancelled by user")
+	errTerminated    = errors.New("terminated (SIGTERM)")
+	errTimeout       = errors.New("--timeout reached")
+)
+
+// Exit codes of the download command.
+const (
+	exitOK         = 0
+	exitFailed     = 1   // some files or repos could not be downloaded
+	exitTimeout    = 124 // same as timeout(1)
+	exitCancelled  = 130 // 128 + SIGINT
+	exitTerminated = 143 // 128 + SIGTERM, e.g. docker stop
+)
+
+// runContext returns the context of a download run. It is cancelled with errUserCancelled
+// on the first Ctrl+C (a second one quits at once), with errTerminated on SIGTERM and
+// with errTimeout after timeout, 0 meaning no limit. The transfers then write out what
+// they received before returning. stop releases the signal handler.
+func runContext(timeout time.Duration) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		if sig == syscall.SIGTERM {
+			fmt.Fprintln(stdout, "\nTerminated, saving the partial files before stopping")
+			cancel(errTerminated)
+		} else {
+			fmt.Fprintln(stdout, "\nInterrupted, saving the partial files before stopping (press Ctrl+C again to quit at once)")
+			cancel(errUserCancelled)
+		}
+		if _, ok := <-signals; ok {
+			exit(exitCancelled)
+		}
+	}()
+	cancelTimeout := func() {}
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, timeout, errTimeout)
+	}
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancelTimeout()
+		cancel(nil)
+	}
+}
+
+// exitFuncs are run by exit, see atExit.
+var exitFuncs struct {
+	sync.Mutex
+	next  int
+	funcs map[int]func()
+}
+
+// atExit registers f to run when the program exits through exit, also when a second
+// Ctrl+C quits at once; remove unregisters it.
+func atExit(f func()) (remove func()) {
+	exitFuncs.Lock()
+	defer exitFuncs.Unlock()
+	if exitFuncs.funcs == nil {
+		exitFuncs.funcs = make(map[int]func())
+	}
+	id := exitFuncs.next
+	exitFuncs.next++
+	exitFuncs.funcs[id] = f
+	return func() {
+		exitFuncs.Lock()
+		defer exitFuncs.Unlock()
+		delete(exitFuncs.funcs, id)
+	}
+}
+
+// exit runs the functions registered with atExit, the last registered first, and
+// exits with code. It is the only way the download command exits.
+func exit(code int) {
+	exitFuncs.Lock()
+	funcs := exitFuncs.funcs
+	exitFuncs.funcs = nil
+	exitFuncs.Unlock()
+	ids := make([]int, 0, len(funcs))
+	for id := range funcs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for i := len(ids) - 1; i >= 0; i-- {
+		funcs[ids[i]]()
+	}
+	os.Exit(code)
+}
+
+// exitCode tells a user interrupt and a timeout apart from failed downloads.
+func exitCode(ctx context.Context, ok bool) int {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errUserCancelled):
+		return exitCancelled
+	case errors.Is(cause, errTerminated):
+		return exitTerminated
+	case errors.Is(cause, errTimeout):
+		return exitTimeout
+	case !ok:
+		return exitFailed
+	}
+	return exitOK
+}
+
+// exitStatus names an exit code in the --json summary and --notify-url payloads.
+func exitStatus(code int) string {
+	return map[int]string{exitOK: "success", exitFailed: "failed", exitCancelled: "cancelled", exitTerminated: "terminated", exitTimeout: "timeout"}[code]
+}
+
+// resumeCommand returns the command line of this run for the resume hint, quoted for
+// a POSIX shell and with the secrets in it redacted.
+func resumeCommand() string {
+	args := make([]string, len(os.Args))
+	for i, arg := range os.Args {
+		arg = redact(arg)
+		if arg == "" || strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_@%+=:,./-") != "" {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
+}
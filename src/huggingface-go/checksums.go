@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const checksumsName = "SHA256SUMS"
+
+// 每次下载后重写 SHA256SUMS 时，大小和修改时间都没变的文件不用再算一遍
+const hashCacheName = ".hfgo-hashes.json"
+
+// cachedHash is the sha256 of a file as it was with this size and modification time.
+type cachedHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256"`
+}
+
+// hashCache is the content of .hfgo-hashes.json: the hashes writeChecksums computed,
+// by path in the folder.
+type hashCache struct {
+	folder string
+	old    map[string]cachedHash
+	hashes map[string]cachedHash
+}
+
+func loadHashCache(folder string) *hashCache {
+	c := &hashCache{folder: folder, hashes: make(map[string]cachedHash)}
+	if data, err := os.ReadFile(filepath.Join(folder, hashCacheName)); err == nil {
+		json.Unmarshal(data, &c.old)
+	}
+	return c
+}
+
+// hash returns the sha256 of a file in the folder, from the cache when its size and
+// modification time are the ones it was hashed with.
+func (c *hashCache) hash(rel string) (string, error) {
+	filePath := filepath.Join(c.folder, filepath.FromSlash(rel))
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := c.old[rel]; ok && cached.Size == stat.Size() && cached.ModTime.Equal(stat.ModTime()) {
+		c.hashes[rel] = cached
+		return cached.SHA256, nil
+	}
+	sum, err := hfdl.HashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	c.hashes[rel] = cachedHash{Size: stat.Size(), ModTime: stat.ModTime(), SHA256: sum}
+	return sum, nil
+}
+
+// save writes the hashes used this time, so files that are gone drop out; errors are
+// ignored, the files are then hashed again.
+func (c *hashCache) save() {
+	path := filepath.Join(c.folder, hashCacheName)
+	if len(c.hashes) == 0 {
+		os.Remove(path)
+		return
+	}
+	data, err := json.Marshal(c.hashes)
+	if err != nil {
+		return
+	}
+	if os.WriteFile(path+".tmp", data, 0644) == nil {
+		os.Rename(path+".tmp", path)
+	}
+}
+
+// writeChecksums writes the SHA-256 of every stored file in the format of sha256sum,
+// so a copy of the folder can be checked with `sha256sum -c SHA256SUMS` on a machine
+// without huggingface-go. LFS files that were verified while downloading reuse their
+// hash; other files, decompressed or cut parquet files and the parts of split files
+// are hashed as they are on disk, or taken from .hfgo-hashes.json when their size and
+// modification time have not changed. A repo that has its own SHA256SUMS keeps it.
+func writeChecksums(folder string, files []markerFile) error {
+	lines := make(map[string]string)
+	cache := loadHashCache(folder)
+	defer cache.save()
+	for _, f := range files {
+		if f.Path == checksumsName {
+			fmt.Fprintf(stdout, "The repo has its own %s, not writing one\n", checksumsName)
+			return nil
+		}
+		if f.Parts > 0 {
+			for i := 0; i < f.Parts; i++ {
+				part := splitPartPath(f.Path, i)
+				sum, err := cache.hash(part)
+				if err != nil {
+					return err
+				}
+				lines[part] = sum
+			}
+			continue
+		}
+		sum := f.SHA256
+		if sum == "" || f.DecompressedFrom != "" || len(f.Columns) > 0 || len(f.RowGroups) > 0 {
+			var err error
+			if sum, err = cache.hash(f.Path); err != nil {
+				return err
+			}
+		}
+		lines[f.Path] = sum
+	}
+	tmpPath := filepath.Join(folder, checksumsName+".tmp")
+	if err := os.WriteFile(tmpPath, []byte(formatChecksums(lines)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(folder, checksumsName))
+}
+
+// formatChecksums formats the sha256 of each path like sha256sum, sorted by path.
+func formatChecksums(lines map[string]string) string {
+	paths := make([]string, 0, len(lines))
+	for p := range lines {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	for _, p := range paths {
+		// sha256sum 对含反斜杠或换行的文件名加前缀 \ 并转义
+		if strings.ContainsAny(p, "\\\n") {
+			fmt.Fprintf(&b, "\\%s  %s\n", lines[p], strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(p))
+			continue
+		}
+		fmt.Fprintf(&b, "%s  %s\n", lines[p], p)
+	}
+	return b.String()
+}
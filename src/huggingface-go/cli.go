@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const commandsUsage = `
+Commands:
+  download  download a repo (the default when no command is given)
+  list      print the files of a repo without downloading them
+  verify    check a downloaded folder against the files of the repo
+  search    search the Hub for models, datasets or spaces
+  info      print the latest commit of a repo and dataset metadata
+  branches  list the branches, tags and pull requests of a repo
+  serve-files  serve complete downloads over HTTP with the Hub's url layout
+  copy      copy a complete download to another disk, verifying every file
+  update    sync a complete download with the current head of its revision
+  upload    commit local files to a repo on the Hub, large files through LFS
+  benchmark test the download speed of the known mirrors
+  usage     print how much was downloaded per month and host
+  redact    print a log with tokens and proxy credentials replaced, to check it before sharing
+`
+
+// globalOptions are the flags shared by every command.
+type globalOptions struct {
+	proxyURLHead         string // -p, or the --proxy-template
+	proxyTemplate        string
+	proxy                string // --proxy, a SOCKS5 or HTTP proxy for every connection
+	mirror               string
+	mirrors              stringList // --mirror, ordered failover list that replaces -m
+	disableDefaultMirror bool
+	token                string
+	tokenToProxy         bool             // --token-to-proxy, also send the token to the -p proxy
+	endpointName         string           // --endpoint, a self-hosted Hub profile
+	profile              *endpointProfile // loaded from endpointName, nil for the public Hub
+	requestHMACKey       string
+	requestHMACTTL       time.Duration
+	requestSignCommand   string
+	signer               *requestSigner // signs requests to the hub hosts, nil without the flags above
+	userAgent            string
+	hostHeaders          stringList // --host-header, host=Name: value
+	retries              int
+	retryDelay           time.Duration
+	connectTimeout       time.Duration
+	fileTimeout          time.Duration
+}
+
+func (g *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.proxyURLHead, "p", "", "proxy url, leave it empty if you don't need it")
+	fs.StringVar(&g.proxyTemplate, "proxy-template", "", "url-rewriting proxy with placeholders instead of -p's prefix, e.g. https://gw.example.com/fetch?url={url_encoded}; also {url}, {scheme}, {host} and {path} (path and query)")
+	fs.StringVar(&g.proxy, "proxy", "", "SOCKS5 or HTTP(S) proxy for all connections, e.g. socks5://127.0.0.1:1080 or http://proxy:3128; without it HTTPS_PROXY, HTTP_PROXY and NO_PROXY are used. Unlike -p the urls are not rewritten")
+	fs.StringVar(&g.mirror, "m", "https://hf-mirror.com", "mirror url of huggingface, use this if you want to use a different mirror, use -d to disable default mirror")
+	fs.Var(&g.mirrors, "mirror", "mirror to use, can be repeated or comma separated for an ordered failover list: files are resumed from the next mirror (and finally huggingface.co) when one fails with 5xx or timeouts; replaces -m")
+	fs.BoolVar(&g.disableDefaultMirror, "d", false, "disable default mirror")
+	fs.StringVar(&g.token, "t", "", "Hugging Face access token for gated and private repos, defaults to $HF_TOKEN")
+	fs.StringVar(&g.token, "token", "", "same as -t")
+	fs.BoolVar(&g.tokenToProxy, "token-to-proxy", false, "also send the token to the -p or --proxy-template proxy, for a proxy of your own that passes it on to the Hub; without it gated and private repos are not available through the proxy, and a third-party proxy never sees the token")
+	fs.StringVar(&g.requestHMACKey, "request-hmac-key", "", "for gateways that only accept signed urls: add expires=<unix time> and signature=<hex HMAC-SHA256 of \"METHOD\\nhost\\npath\\nexpires\" with this key> query parameters to every request to the mirror or hub")
+	fs.DurationVar(&g.requestHMACTTL, "request-hmac-ttl", 5*time.Minute, "how long the signatures of --request-hmac-key are valid")
+	fs.StringVar(&g.requestSignCommand, "request-sign-command", "", "sign every request to the mirror or hub with this command: it gets HFGO_SIGN_METHOD and HFGO_SIGN_URL, and each line it prints is a \"Name: value\" header to add or an url replacing the request url")
+	fs.StringVar(&g.userAgent, "user-agent", defaultUserAgent(), "User-Agent header of every request, for mirrors that reject unknown clients")
+	fs.Var(&g.hostHeaders, "host-header", "add a header to the requests to one host, as host=Name: value, e.g. \"hf-mirror.com=Referer: https://hf-mirror.com/\" or \"mirror.example=User-Agent: Mozilla/5.0\"; can be repeated")
+	fs.IntVar(&g.retries, "retries", 5, "retry a file or listing page that failed with a server error, a timeout or a dropped connection this many times; files resume where they stopped, and a retry that got further into the file does not count")
+	fs.DurationVar(&g.retryDelay, "retry-delay", time.Second, "wait before the first retry, doubled for every further one up to 5m, with random jitter")
+	fs.DurationVar(&g.connectTimeout, "connect-timeout", 30*time.Second, "how long connecting to a host may take, and again the TLS handshake")
+	fs.DurationVar(&g.fileTimeout, "file-timeout", 0, "end a request for a file that takes longer than this, e.g. 30m, and resume the file with a new request; 0 means no limit (stalled transfers are caught by --stall-speed)")
+	fs.StringVar(&g.endpointName, "endpoint", "", "endpoint profile of a self-hosted Hub: a name from endpoints/<name>.yaml next to the config file, or the path of such a file, with its url (and path prefix), auth headers and TLS settings; repo ids are resolved against it and the mirrors are not used")
+}
+
+// parseFlags parses the arguments of a command, fills unset flags from HFGO_* variables
+// and returns the positional arguments. usage is the synopsis after the program name.
+func parseFlags(fs *flag.FlagSet, g *globalOptions, args []string, usage string) []string {
+	annotateEnvUsage(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n", os.Args[0], usage)
+		fs.PrintDefaults()
+		if fs.Name() == "download" {
+			fmt.Fprint(fs.Output(), commandsUsage)
+		}
+		fmt.Fprint(fs.Output(), envUsageFooter)
+	}
+	fs.Parse(args)
+	if err := applyEnvOverrides(fs); err != nil {
+		fmt.Fprintln(stdout, err)
+		os.Exit(2)
+	}
+	if err := applyConfigFile(fs); err != nil {
+		fmt.Fprintf(stdout, "Invalid config file %v\n", err)
+		os.Exit(2)
+	}
+	if g.retries < 0 {
+		fmt.Fprintln(stdout, "--retries cannot be negative")
+		os.Exit(2)
+	}
+	// 复用 TLS 会话和 DNS 结果，下载大量小文件时省掉重复的握手和解析
+	http.DefaultTransport = hfdl.NewTransportTimeout(g.connectTimeout)
+	g.token = resolveToken(g.token)
+	addSecret(g.token)
+	addSecret(g.requestHMACKey)
+	for _, u := range append([]string{g.proxyURLHead, g.proxyTemplate, g.proxy, g.mirror}, g.mirrors...) {
+		addURLSecrets(u)
+	}
+	if g.proxyTemplate != "" {
+		if g.proxyURLHead != "" {
+			fmt.Fprintln(stdout, "-p and --proxy-template cannot be combined")
+			os.Exit(2)
+		}
+		if !hfdl.IsProxyTemplate(g.proxyTemplate) {
+			fmt.Fprintf(stdout, "Invalid --proxy-template %q: it has none of {url}, {url_encoded}, {scheme}, {host} and {path}\n", g.proxyTemplate)
+			os.Exit(2)
+		}
+		g.proxyURLHead = g.proxyTemplate
+	}
+	if g.proxy != "" {
+		if err := useProxy(g.proxy); err != nil {
+			fmt.Fprintf(stdout, "Invalid --proxy: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if g.endpointName != "" {
+		profile, err := loadEndpointProfile(g.endpointName)
+		if err == nil {
+			err = profile.install()
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid --endpoint %s: %v\n", g.endpointName, err)
+			os.Exit(2)
+		}
+		// 内部的 Hub 没有镜像
+		g.profile, g.disableDefaultMirror = profile, true
+	}
+	if g.requestHMACKey != "" || g.requestSignCommand != "" {
+		g.signer = installRequestSigner(g.requestHMACKey, g.requestSignCommand, g.requestHMACTTL)
+	}
+	hostHeaders, err := parseHostHeaders(g.hostHeaders)
+	if err != nil {
+		fmt.Fprintf(stdout, "Invalid --host-header: %v\n", err)
+		os.Exit(2)
+	}
+	http.DefaultTransport = &quirksTransport{base: http.DefaultTransport, userAgent: g.userAgent, hosts: hostHeaders}
+	installUsageCounter()
+	// 先装好 token 的 transport，之后（包括 --repo-workers 同时打开的仓库）只添加主机
+	installToken(g.token)
+	return fs.Args()
+}
+
+// flagsFirst moves flags given after the positional arguments to the front, so
+// `search whisper --limit 5` works like `search --limit 5 whisper`.
+func flagsFirst(fs *flag.FlagSet, args []string) []string {
+	var flags, positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		// 不是布尔参数时，下一个参数是它的值
+		if f := fs.Lookup(name); f != nil && i+1 < len(args) {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+				flags = append(flags, args[i])
+			}
+		}
+	}
+	return append(append(flags, "--"), positional...)
+}
+
+// networkProxy is the proxy of every connection, also for clients that do not use
+// http.DefaultTransport, see useProxy.
+var networkProxy = http.ProxyFromEnvironment
+
+// useProxy sends every connection through a SOCKS5 or HTTP CONNECT proxy. Without
+// --proxy the transport follows HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+func useProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "socks5h":
+		// Go 的 SOCKS5 客户端本来就把域名交给代理解析
+		u.Scheme = "socks5"
+	case "socks5", "http", "https":
+	default:
+		return fmt.Errorf("unsupported scheme %q, expected socks5, http or https", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("no proxy host in %q", proxy)
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("the HTTP transport cannot be configured")
+	}
+	networkProxy = http.ProxyURL(u)
+	transport.Proxy = networkProxy
+	return nil
+}
+
+// repoArg returns the repo given with -u or as the first argument, printing the usage when there is none.
+func (g *globalOptions) repoArg(fs *flag.FlagSet, repoURL string) hfdl.Repo {
+	if repoURL == "" && fs.NArg() > 0 {
+		repoURL = fs.Arg(0)
+	}
+	if repoURL == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// 解析仓库地址，支持完整链接、hf:// 以及 org/model 这样的简写
+	ref, err := g.parseRepo(repoURL)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot parse repo url: %v\n", err)
+		os.Exit(2)
+	}
+	return ref
+}
+
+// hub returns the endpoint of repos given without a host: the hub of the --endpoint
+// profile, or huggingface.co.
+func (g *globalOptions) hub() string {
+	if g.profile != nil {
+		return g.profile.url
+	}
+	return hfdl.DefaultEndpoint
+}
+
+// parseRepo parses a repo url, hf:// uri or id, resolving ids against g.hub().
+func (g *globalOptions) parseRepo(arg string) (hfdl.Repo, error) {
+	return hfdl.ParseRepoAt(arg, g.hub())
+}
+
+// endpoint returns the host requests go to: the mirror, or the given endpoint with -d.
+func (g *globalOptions) endpoint(endpoint string) string {
+	return g.hosts(endpoint)[0]
+}
+
+// hosts returns the endpoints serving a repo of origin, preferred first: the mirrors
+// followed by origin itself, or only origin with -d.
+func (g *globalOptions) hosts(origin string) []string {
+	if g.disableDefaultMirror {
+		return []string{origin}
+	}
+	mirrors := []string(g.mirrors)
+	if len(mirrors) == 0 {
+		mirrors = []string{g.mirror}
+	}
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, host := range append(mirrors, origin) {
+		host = strings.TrimRight(host, "/")
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// openRepo points ref at the mirror, installs the token for every host serving the repo
+// and follows renames. It returns a Downloader for those hosts, preferred first, and
+// the old id of a renamed repo (or "").
+func (g *globalOptions) openRepo(ctx context.Context, ref *hfdl.Repo, options ...hfdl.Option) (*hfdl.Downloader, string) {
+	d := g.newDownloader(ref, options...)
+	// 仓库可能已经改名，沿着重定向找到新的名字
+	movedFrom, err := d.ResolveMoved(ctx, ref)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot check whether the repo has moved: %v\n", err)
+	} else if movedFrom != "" {
+		fmt.Fprintf(stdout, "Repo %s has been renamed to %s\n", movedFrom, ref.ID)
+	}
+	return d, movedFrom
+}
+
+// newDownloader returns a Downloader for ref without asking the Hub anything, for
+// when its api cannot be reached, see --manifest.
+func (g *globalOptions) newDownloader(ref *hfdl.Repo, options ...hfdl.Option) *hfdl.Downloader {
+	// 镜像之外，原始站点也可以作为备用主机
+	hosts := g.hosts(ref.Endpoint)
+	ref.Endpoint = hosts[0]
+	g.installAuth(hosts...)
+	return hfdl.New(hosts, append(g.downloaderOptions(), options...)...)
+}
+
+// downloaderOptions are the options of every Downloader: the url-prefix proxy, the
+// retry policy and the logger.
+func (g *globalOptions) downloaderOptions() []hfdl.Option {
+	return []hfdl.Option{hfdl.WithProxy(g.proxyURLHead), hfdl.WithLogger(logf), hfdl.WithRetries(g.retries, g.retryDelay), hfdl.WithFileTimeout(g.fileTimeout)}
+}
+
+// logf prints the messages of the download library.
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(stdout, format, args...)
+}
+
+// localFolderName is the folder a repo is downloaded into; a renamed repo keeps its old folder.
+func localFolderName(ref hfdl.Repo, movedFrom string) string {
+	if movedFrom != "" {
+		// 仍然下载到原来名字的目录，方便继续之前的下载
+		return path.Base(movedFrom)
+	}
+	return path.Base(ref.ID)
+}
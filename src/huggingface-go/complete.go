@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const completeMarkerName = ".complete"
+
+// completeMarker is written into the target folder once every file of the job
+// has been downloaded and verified. Its absence means the folder is partial.
+type completeMarker struct {
+	Repo           string        `json:"repo"`
+	MovedFrom      string        `json:"moved_from,omitempty"`
+	Type           hfdl.RepoType `json:"type"`
+	Revision       string        `json:"revision"`
+	Path           string        `json:"path,omitempty"`
+	Include        []string      `json:"include,omitempty"`
+	Exclude        []string      `json:"exclude,omitempty"`
+	Sample         *markerSample `json:"sample,omitempty"` // --max-total-size and --max-files, the folder holds only part of the repo
+	OfficialSplits bool          `json:"official_splits,omitempty"`
+	ManifestSHA256 string        `json:"manifest_sha256"`
+	Files          []markerFile  `json:"files"`
+	Skipped        []markerFile  `json:"skipped,omitempty"` // entries left out by --unknown-entries=skip
+	// Placement lists the files stored on another volume (--split-across) with the
+	// repo folder there; the target folder holds symlinks to them.
+	Placement   map[string]string `json:"placement,omitempty"`
+	CompletedAt time.Time         `json:"completed_at"`
+}
+
+type markerFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	OID    string `json:"oid,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// DecompressedFrom is the compressed file of the repo this one was unpacked from
+	// (--decompress); such files have no hashes, only their size is checked.
+	DecompressedFrom string `json:"decompressed_from,omitempty"`
+	// Columns and RowGroups are the parts kept of a parquet file fetched with
+	// --columns or --row-groups; like decompressed files it has no hashes.
+	Columns   []string `json:"columns,omitempty"`
+	RowGroups []int    `json:"row_groups,omitempty"`
+	// Parts is the number of <path>.partNNN files a file too large for the target
+	// file system was stored as (--oversize split), 0 for whole files.
+	Parts int `json:"parts,omitempty"`
+}
+
+// manifestHash hashes the sorted (path, size, oid, sha256) list of the job.
+func manifestHash(files []markerFile) string {
+	sorted := append([]markerFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s\t%d\t%s\t%s\n", f.Path, f.Size, f.OID, f.SHA256)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyFiles checks that every file exists in folder with the expected size and,
+// with hashes, the content: LFS files by sha256, others by git blob id.
+func verifyFiles(folder string, files []markerFile, hashes bool) error {
+	for _, f := range files {
+		if hashes && (f.SHA256 != "" || f.OID != "") {
+			// 解压和裁剪过的文件没有哈希，只比较大小
+			entry := hfdl.FileEntry{Path: f.Path, Size: f.Size, OID: f.OID, LFSOID: f.SHA256}
+			if err := verifyLocalFile(filepath.Join(folder, filepath.FromSlash(f.Path)), entry); err != nil {
+				return fmt.Errorf("%s: %v", f.Path, err)
+			}
+			continue
+		}
+		if f.Parts > 0 {
+			parts, err := splitFileReader(filepath.Join(folder, filepath.FromSlash(f.Path)), f.Parts, f.Size)
+			if err != nil {
+				return fmt.Errorf("%s: %v", f.Path, err)
+			}
+			parts.Close()
+			continue
+		}
+		stat, err := os.Stat(filepath.Join(folder, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return err
+		}
+		if stat.Size() != f.Size {
+			return fmt.Errorf("%s has size %d, expected %d", f.Path, stat.Size(), f.Size)
+		}
+	}
+	return nil
+}
+
+func removeCompleteMarker(folder string) error {
+	for _, name := range []string{completeMarkerName, manifestSignatureName, downloadManifestName} {
+		err := os.Remove(filepath.Join(folder, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCompleteMarker writes the marker atomically, so a crash never leaves a half-written one behind.
+func writeCompleteMarker(folder string, marker completeMarker) error {
+	marker.ManifestSHA256 = manifestHash(marker.Files)
+	marker.CompletedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(folder, completeMarkerName+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(folder, completeMarkerName))
+}
+
+// checkCompleteMarker is used by --require-complete: the folder only counts as usable
+// when the marker exists, matches the requested revision and all listed files are intact,
+// with hashes also in content, not only in size.
+func checkCompleteMarker(folder, revision string, hashes bool) (completeMarker, error) {
+	marker, err := readCompleteMarker(folder)
+	if err != nil {
+		return marker, err
+	}
+	if revision != "" && marker.Revision != revision {
+		return marker, fmt.Errorf("%s holds revision %s, not %s", folder, marker.Revision, revision)
+	}
+	if manifestHash(marker.Files) != marker.ManifestSHA256 {
+		return marker, fmt.Errorf("manifest hash in %s does not match its file list", completeMarkerName)
+	}
+	if err := verifyFiles(folder, marker.Files, hashes); err != nil {
+		return marker, err
+	}
+	return marker, nil
+}
+
+// readCompleteMarker loads the marker of folder without checking the files.
+func readCompleteMarker(folder string) (completeMarker, error) {
+	var marker completeMarker
+	data, err := os.ReadFile(filepath.Join(folder, completeMarkerName))
+	if os.IsNotExist(err) {
+		return marker, fmt.Errorf("no %s marker in %s, the download is partial or still running", completeMarkerName, folder)
+	}
+	if err != nil {
+		return marker, err
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return marker, fmt.Errorf("invalid %s marker: %v", completeMarkerName, err)
+	}
+	return marker, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configPath returns the config file: $HFGO_CONFIG, or config.yaml in
+// $XDG_CONFIG_HOME/huggingface-go (~/.config/huggingface-go), %AppData%\huggingface-go on Windows.
+func configPath() string {
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if runtime.GOOS == "windows" {
+		dir, _ = os.UserConfigDir()
+	} else if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "huggingface-go", "config.yaml")
+}
+
+// applyConfigFile fills in flags that were given neither on the command line nor in
+// the environment from the config file. Its keys are the names of the HFGO_*
+// variables without the prefix, e.g. mirror, folder, token or limit_rate; keys that
+// belong to other commands are ignored.
+func applyConfigFile(fs *flag.FlagSet) error {
+	path := configPath()
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	values, err := parseConfig(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	flags := make(map[string]*flag.Flag)
+	fs.VisitAll(func(f *flag.Flag) {
+		if name := envNameFor(f.Name); flags[name] == nil {
+			flags[name] = f
+		}
+	})
+	// 命令行和环境变量给出的参数优先
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[envNameFor(f.Name)] = true
+	})
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		f := flags[name]
+		if f == nil || set[name] {
+			continue
+		}
+		for _, value := range values[key] {
+			if err := fs.Set(f.Name, value); err != nil {
+				return fmt.Errorf("%s: invalid value %q for %s: %v", path, value, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfig reads the part of YAML a flat config needs: "key: value" lines,
+// lists as "key: [a, b]" or as "- item" lines below "key:", quoted strings and
+// comments. It returns the values of every key.
+func parseConfig(data string) (map[string][]string, error) {
+	values := make(map[string][]string)
+	listKey := ""
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(stripComment(line), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && listKey != "" && line != trimmed {
+			value, err := configScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			values[listKey] = append(values[listKey], value)
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || line != trimmed {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		listKey = ""
+		switch {
+		case value == "":
+			// 下面几行是 - 开头的列表
+			listKey = key
+			values[key] = nil
+		default:
+			items, err := configValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+			values[key] = items
+		}
+	}
+	return values, nil
+}
+
+// configValue returns the items of an inline list like [a, "b"], or the value itself.
+func configValue(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		value, err := configScalar(value)
+		return []string{value}, err
+	}
+	var items []string
+	for _, item := range strings.Split(value[1:len(value)-1], ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		item, err := configScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// configScalar removes the quotes of a quoted value.
+func configScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	}
+	return value, nil
+}
+
+// stripComment cuts a # comment off a line, unless the # is inside quotes or part of
+// a value like a url fragment.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
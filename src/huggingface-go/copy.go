@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runCopy implements `huggingface-go copy [flags] <src-dir> <dst-parent>`: a complete
+// download is copied into dst-parent/<name of src-dir> with several files in flight,
+// and every copied file is checked against the hashes of the .complete manifest.
+func runCopy(args []string) {
+	flags := flag.NewFlagSet("copy", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	var parallel int
+	flags.IntVar(&parallel, "parallel", 4, "number of files copied at the same time")
+	rest := parseFlags(flags, &g, args, "copy [flags] <src-dir> <dst-parent>")
+	if len(rest) != 2 || parallel < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	src := filepath.Clean(rest[0])
+	dst := filepath.Join(rest[1], filepath.Base(src))
+	if abs, err := filepath.Abs(src); err == nil {
+		if absDst, err := filepath.Abs(dst); err == nil && abs == absDst {
+			fmt.Fprintf(stdout, "Source and destination are the same folder: %s\n", abs)
+			os.Exit(2)
+		}
+	}
+	// 只复制完整的下载，源目录先按清单检查一遍；内容在复制时按哈希核对
+	marker, err := checkCompleteMarker(src, "", false)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot copy %s: %v\n", src, err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		fmt.Fprintf(stdout, "Cannot create %s: %v\n", dst, err)
+		os.Exit(1)
+	}
+	if err := removeCompleteMarker(dst); err != nil {
+		fmt.Fprintf(stdout, "Cannot remove old %s marker: %v\n", completeMarkerName, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(stdout, "Copying %s (%s@%s, %d files) to %s\n", src, marker.Repo, marker.Revision, len(marker.Files), dst)
+
+	jobs := make(chan markerFile)
+	var mu sync.Mutex
+	failed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				status, err := copyVerified(src, dst, f)
+				mu.Lock()
+				if err != nil {
+					fmt.Fprintf(stdout, "FAIL %s: %v\n", f.Path, err)
+					failed++
+				} else {
+					fmt.Fprintf(stdout, "%-7s %s\n", status, f.Path)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range marker.Files {
+		jobs <- f
+	}
+	close(jobs)
+	wg.Wait()
+	if failed > 0 {
+		fmt.Fprintf(stdout, "%d of %d files failed, not marking %s as complete\n", failed, len(marker.Files), dst)
+		os.Exit(1)
+	}
+	// 清单（和签名）原样复制过去，最后写，这样目标目录只有在全部校验通过后才算完整
+	for _, name := range []string{downloadManifestName, checksumsName, manifestSignatureName, completeMarkerName} {
+		if _, err := os.Stat(filepath.Join(src, name)); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			fmt.Fprintf(stdout, "Cannot copy %s: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Fprintf(stdout, "All %d files copied to %s and verified\n", len(marker.Files), dst)
+}
+
+// copyVerified copies one file of the manifest unless an intact copy is already
+// there, then checks the destination against the manifest hash. Files stored as parts
+// (--oversize split) are copied as parts, which is what the manifest, SHA256SUMS and
+// its signature describe.
+func copyVerified(src, dst string, f markerFile) (string, error) {
+	entry := hfdl.FileEntry{Type: "file", Path: f.Path, Size: f.Size, OID: f.OID, LFSOID: f.SHA256}
+	dstPath := filepath.Join(dst, filepath.FromSlash(f.Path))
+	if f.Parts > 0 {
+		// 以前拼成一个文件的副本不算，verifyLocalFile 会先认它
+		if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if verifyLocalFile(dstPath, entry) == nil {
+		return "SKIP", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return "", err
+	}
+	srcPath := filepath.Join(src, filepath.FromSlash(f.Path))
+	if f.Parts > 0 {
+		for i := 0; i < f.Parts; i++ {
+			if err := copyFile(splitPartPath(srcPath, i), splitPartPath(dstPath, i)); err != nil {
+				return "", err
+			}
+		}
+		// 分片清单最后复制，之前中断的副本不会被当成完整的
+		if err := copyFile(srcPath+splitManifestSuffix, dstPath+splitManifestSuffix); err != nil {
+			return "", err
+		}
+	} else if err := copyFile(srcPath, dstPath); err != nil {
+		return "", err
+	}
+	if err := verifyLocalFile(dstPath, entry); err != nil {
+		os.Remove(dstPath)
+		os.Remove(dstPath + splitManifestSuffix)
+		return "", fmt.Errorf("copy does not match the manifest (%v), the source may be damaged, check it with verify", err)
+	}
+	return "COPIED", nil
+}
+
+// copyFile copies through a .tmp file that is synced and renamed into place.
+func copyFile(srcPath, dstPath string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFileFrom(in, dstPath)
+}
+
+func writeFileFrom(in io.Reader, dstPath string) error {
+	tmpPath := dstPath + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, dstPath)
+}
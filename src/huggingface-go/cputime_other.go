@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "time"
+
+func cpuTime() (user, system time.Duration, ok bool) {
+	return 0, 0, false
+}
+
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+func cpuTime() (user, system time.Duration, ok bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0, false
+	}
+	return time.Duration(usage.Utime.Nano()), time.Duration(usage.Stime.Nano()), true
+}
+
+func peakRSS() (uint64, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, false
+	}
+	// Linux 上 ru_maxrss 的单位是 KB，macOS 上是字节
+	if runtime.GOOS == "darwin" {
+		return uint64(usage.Maxrss), true
+	}
+	return uint64(usage.Maxrss) * 1024, true
+}
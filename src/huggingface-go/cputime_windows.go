@@ -0,0 +1,22 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func cpuTime() (user, system time.Duration, ok bool) {
+	var creation, exit, kernel, usr syscall.Filetime
+	if err := syscall.GetProcessTimes(syscall.Handle(^uintptr(0)), &creation, &exit, &kernel, &usr); err != nil {
+		return 0, 0, false
+	}
+	// FILETIME 的单位是 100 纳秒
+	toDuration := func(ft syscall.Filetime) time.Duration {
+		return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+	}
+	return toDuration(usr), toDuration(kernel), true
+}
+
+func peakRSS() (uint64, bool) {
+	return 0, false
+}
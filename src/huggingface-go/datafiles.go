@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 没有声明 configs 时和 datasets 库一样按文件名和目录名里的 split 关键字找数据文件
+var (
+	splitKeywordPattern = regexp.MustCompile(`(^|[-._ 0-9/])(train|training|validation|valid|dev|val|test|testing|eval|evaluation)[-._ 0-9/]`)
+	dataFileExtensions  = map[string]bool{
+		".parquet": true, ".arrow": true, ".csv": true, ".tsv": true, ".json": true, ".jsonl": true, ".ndjson": true, ".txt": true,
+		".tar": true, ".zip": true, ".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".wav": true, ".mp3": true, ".flac": true,
+		".ogg": true, ".mp4": true, ".pdf": true, ".h5": true, ".hdf5": true, ".npy": true, ".npz": true, ".xml": true, ".lance": true,
+	}
+)
+
+// dataFilesConfig is a config of the dataset card with its data_files patterns.
+type dataFilesConfig struct {
+	name     string
+	patterns []string
+}
+
+// cardDataFiles reads the configs from the card data the Hub returns for a dataset:
+//
+//	configs:
+//	- config_name: default
+//	  data_files:
+//	  - split: train
+//	    path: data/train-*
+//	  - split: test
+//	    path: [data/test-*]
+//	- config_name: en
+//	  data_dir: en
+//
+// data_files may also be a single pattern, a list of patterns or a split -> pattern
+// mapping; patterns are relative to data_dir. A config with only data_dir has every
+// file below it.
+func cardDataFiles(cardData map[string]interface{}) []dataFilesConfig {
+	list, _ := cardData["configs"].([]interface{})
+	var configs []dataFilesConfig
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		config := dataFilesConfig{name: stringField(fields, "config_name")}
+		if config.name == "" {
+			config.name = "default"
+		}
+		dir := strings.Trim(stringField(fields, "data_dir"), "/")
+		var patterns []string
+		switch files := fields["data_files"].(type) {
+		case nil:
+			patterns = []string{"**"}
+		case map[string]interface{}:
+			for _, split := range files {
+				patterns = append(patterns, patternList(split)...)
+			}
+		case []interface{}:
+			for _, file := range files {
+				if split, ok := file.(map[string]interface{}); ok {
+					patterns = append(patterns, patternList(split["path"])...)
+				} else {
+					patterns = append(patterns, patternList(file)...)
+				}
+			}
+		default:
+			patterns = patternList(files)
+		}
+		for _, pattern := range patterns {
+			if dir != "" {
+				pattern = dir + "/" + pattern
+			}
+			config.patterns = append(config.patterns, strings.TrimPrefix(pattern, "./"))
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// infoFileDataFiles reads the configs of a dataset whose card declares none from the
+// older dataset_infos.json: each config has the files listed in its download_checksums,
+// by resolve url or by path in the repo, or else the folder named after it. Configs
+// without any of these are left out; nil means there is nothing to go by.
+func infoFileDataFiles(proxyURLHead string, ref hfdl.Repo, entries []hfdl.FileEntry) []dataFilesConfig {
+	folders := make(map[string]bool)
+	found := false
+	for _, entry := range entries {
+		if entry.Path == "dataset_infos.json" {
+			found = true
+		}
+		if dir, _, ok := strings.Cut(entry.Path, "/"); ok {
+			folders[dir] = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	var infos map[string]struct {
+		DownloadChecksums map[string]interface{} `json:"download_checksums"`
+	}
+	if err := fetchJSON(proxyURLHead, ref.Endpoint+ref.ResolvePath("dataset_infos.json"), &infos); err != nil {
+		fmt.Fprintf(stdout, "Cannot read dataset_infos.json: %v\n", err)
+		return nil
+	}
+	var configs []dataFilesConfig
+	for name, info := range infos {
+		config := dataFilesConfig{name: name}
+		for source := range info.DownloadChecksums {
+			if filePath, ok := repoFilePath(ref, source); ok {
+				config.patterns = append(config.patterns, filePath)
+			}
+		}
+		if len(config.patterns) == 0 && folders[name] {
+			config.patterns = []string{name + "/**"}
+		}
+		if len(config.patterns) > 0 {
+			sort.Strings(config.patterns)
+			configs = append(configs, config)
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].name < configs[j].name })
+	return configs
+}
+
+// repoFilePath returns the path in the repo of a download_checksums source: a resolve
+// url of this repo (at any revision) or a relative path. Other urls are not in the repo.
+func repoFilePath(ref hfdl.Repo, source string) (string, bool) {
+	if !strings.Contains(source, "://") {
+		return strings.TrimPrefix(source, "./"), source != ""
+	}
+	_, rest, ok := strings.Cut(source, "/"+ref.ID+"/resolve/")
+	if !ok {
+		return "", false
+	}
+	// 去掉 revision
+	_, filePath, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(filePath); err == nil {
+		filePath = unescaped
+	}
+	return filePath, filePath != ""
+}
+
+// patternList returns a pattern or a list of them as strings.
+func patternList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var patterns []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				patterns = append(patterns, s)
+			}
+		}
+		return patterns
+	}
+	return nil
+}
+
+// matchDataFiles matches a repo path against a data_files pattern, where ** spans
+// any number of folders and * stays within one, as in the datasets library.
+func matchDataFiles(pattern, filePath string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(filePath, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], parts[0]); !matched {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// isHiddenPath reports files the datasets library never treats as data: those in
+// or named like .folders or __pycache__.
+func isHiddenPath(filePath string) bool {
+	for _, part := range strings.Split(filePath, "/") {
+		if strings.HasPrefix(part, ".") || strings.HasPrefix(part, "__") {
+			return true
+		}
+	}
+	return false
+}
+
+// isDataFile reports whether a file has a data extension, also when compressed
+// (train.jsonl.gz, part-0.csv.zst).
+func isDataFile(filePath string) bool {
+	name := strings.ToLower(path.Base(filePath))
+	for _, ext := range []string{".gz", ".zst", ".zstd", ".bz2", ".xz", ".lz4"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return dataFileExtensions[path.Ext(name)]
+}
+
+// selectOfficialSplits keeps the files of the configs and splits the dataset card
+// declares (--official-splits) and the card itself, leaving out loading scripts,
+// notebooks and files of older versions that are still in the repo. Without declared
+// configs it takes those of dataset_infos.json, or else keeps the data files whose
+// names or folders name a split, or every data file if none do, like the datasets library.
+func selectOfficialSplits(proxyURLHead string, ref hfdl.Repo, entries []hfdl.FileEntry) ([]hfdl.FileEntry, error) {
+	if ref.Type != hfdl.RepoTypeDataset {
+		return nil, fmt.Errorf("--official-splits only applies to datasets")
+	}
+	var info map[string]interface{}
+	if err := fetchJSON(proxyURLHead, ref.APIURL()+"/revision/"+url.PathEscape(ref.Revision), &info); err != nil {
+		return nil, fmt.Errorf("cannot read the dataset card: %v", err)
+	}
+	cardData, _ := info["cardData"].(map[string]interface{})
+	configs := cardDataFiles(cardData)
+	declaredBy := "The dataset card declares"
+	if len(configs) == 0 {
+		// 老的数据集把 config 写在 dataset_infos.json 里，卡片里没有
+		configs = infoFileDataFiles(proxyURLHead, ref, entries)
+		declaredBy = "The dataset card declares no configs, dataset_infos.json has"
+	}
+	var keep func(filePath string) bool
+	if len(configs) > 0 {
+		var names []string
+		for _, config := range configs {
+			names = append(names, config.name)
+		}
+		fmt.Fprintf(stdout, "%s %d configs: %s\n", declaredBy, len(configs), strings.Join(names, ", "))
+		keep = func(filePath string) bool {
+			for _, config := range configs {
+				for _, pattern := range config.patterns {
+					if matchDataFiles(pattern, filePath) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+	} else {
+		named := false
+		for _, entry := range entries {
+			if !isHiddenPath(entry.Path) && isDataFile(entry.Path) && splitKeywordPattern.MatchString(strings.ToLower(entry.Path)) {
+				named = true
+				break
+			}
+		}
+		if named {
+			fmt.Fprintln(stdout, "The dataset card declares no configs, keeping the data files named after a split (train, validation, test)")
+		} else {
+			fmt.Fprintln(stdout, "The dataset card declares no configs and no files are named after a split, keeping every data file")
+		}
+		keep = func(filePath string) bool {
+			return isDataFile(filePath) && (!named || splitKeywordPattern.MatchString(strings.ToLower(filePath)))
+		}
+	}
+	var kept []hfdl.FileEntry
+	var dropped []string
+	for _, entry := range entries {
+		switch {
+		case entry.Path == "README.md":
+			kept = append(kept, entry)
+		case !isHiddenPath(entry.Path) && keep(entry.Path):
+			kept = append(kept, entry)
+		default:
+			dropped = append(dropped, entry.Path)
+		}
+	}
+	if len(kept) == 0 || (len(kept) == 1 && kept[0].Path == "README.md") {
+		return nil, fmt.Errorf("no files belong to the splits of the dataset card")
+	}
+	sort.Strings(dropped)
+	fmt.Fprintf(stdout, "Keeping %d files of the official splits, leaving out %d other files\n", len(kept), len(dropped))
+	for i, p := range dropped {
+		if i == 10 {
+			fmt.Fprintf(stdout, "  ... and %d more\n", len(dropped)-i)
+			break
+		}
+		fmt.Fprintf(stdout, "  %s\n", p)
+	}
+	return kept, nil
+}
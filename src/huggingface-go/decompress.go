@@ -0,0 +1,31 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// decompressorFor returns the file name without its compression suffix and the
+// decompressor used by --decompress, or nil when the file is not compressed.
+func decompressorFor(filePath string) (string, hfdl.Decompressor) {
+	switch {
+	case strings.HasSuffix(filePath, ".gz"):
+		return strings.TrimSuffix(filePath, ".gz"), func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		}
+	case strings.HasSuffix(filePath, ".zst"), strings.HasSuffix(filePath, ".zstd"):
+		return filePath[:strings.LastIndex(filePath, ".")], func(r io.Reader) (io.ReadCloser, error) {
+			decoder, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}
+	}
+	return filePath, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// dependency is a companion repo referenced by a downloaded repo.
+type dependency struct {
+	ID     string
+	reason string // e.g. "base model", "adapter base", "tokenizer"
+}
+
+const (
+	// 关联仓库的关联仓库最多追这么多层，互相引用的仓库也不会一直排下去
+	maxDependencyDepth = 2
+	// 一次运行最多排队这么多关联仓库
+	maxDependencies = 16
+)
+
+// 只认 org/name 形式的仓库名，_name_or_path 里常见的本地路径会被排除
+var repoIDPattern = regexp.MustCompile(`^[A-Za-z0-9][\w.-]*/[\w.-]+$`)
+
+// findDependencies looks for companion repos in the model card front matter (base_model),
+// adapter_config.json (base_model_name_or_path) and config.json (_name_or_path, tokenizer_name).
+// Files are read from the target folder, or fetched from the Hub when they were not
+// downloaded and fetch is set.
+func findDependencies(result repoResult, proxyURLHead string, fetch bool) []dependency {
+	if result.ref.Type != hfdl.RepoTypeModel {
+		return nil
+	}
+	var deps []dependency
+	add := func(id, reason string) {
+		id = strings.TrimSpace(strings.Trim(id, `"'`))
+		if !repoIDPattern.MatchString(id) || id == result.ref.ID || strings.Contains(id, "..") {
+			return
+		}
+		for _, dep := range deps {
+			if dep.ID == id {
+				return
+			}
+		}
+		deps = append(deps, dependency{ID: id, reason: reason})
+	}
+	if card := readRepoFile(result, proxyURLHead, "README.md", fetch); card != nil {
+		for _, id := range cardBaseModels(string(card)) {
+			add(id, "base model")
+		}
+	}
+	if base, _ := adapterBase(result, proxyURLHead, fetch); base != "" {
+		add(base, "adapter base")
+	}
+	var config struct {
+		NameOrPath    string `json:"_name_or_path"`
+		TokenizerName string `json:"tokenizer_name"`
+	}
+	if data := readRepoFile(result, proxyURLHead, "config.json", fetch); data != nil && json.Unmarshal(data, &config) == nil {
+		add(config.NameOrPath, "base model")
+		add(config.TokenizerName, "tokenizer")
+	}
+	return deps
+}
+
+// adapterBase returns the base model (and its revision, if pinned) of a PEFT adapter repo,
+// or "" if the repo has no adapter_config.json.
+func adapterBase(result repoResult, proxyURLHead string, fetch bool) (string, string) {
+	var adapter struct {
+		BaseModel string `json:"base_model_name_or_path"`
+		Revision  string `json:"revision"`
+	}
+	data := readRepoFile(result, proxyURLHead, "adapter_config.json", fetch)
+	if data == nil || json.Unmarshal(data, &adapter) != nil || !repoIDPattern.MatchString(adapter.BaseModel) {
+		return "", ""
+	}
+	return adapter.BaseModel, adapter.Revision
+}
+
+// dependencyOptions returns the options of a companion repo queued after a repo
+// downloaded with opts: the file filters were meant for that repo and are dropped.
+func dependencyOptions(opts *downloadOptions) *downloadOptions {
+	depOpts := *opts
+	depOpts.filter = fileFilter{}
+	return &depOpts
+}
+
+// printMergeCommand prints a script that merges the adapter into its base model with PEFT.
+func printMergeCommand(baseFolder, adapterFolder string) {
+	fmt.Fprintf(stdout, "\nBase model: %s\nAdapter: %s\nMerge them with:\n\n", baseFolder, adapterFolder)
+	fmt.Fprintf(stdout, `python - <<'EOF'
+from transformers import AutoModelForCausalLM, AutoTokenizer
+from peft import PeftModel
+base = AutoModelForCausalLM.from_pretrained(%[1]q, torch_dtype="auto")
+model = PeftModel.from_pretrained(base, %[2]q).merge_and_unload()
+model.save_pretrained(%[3]q)
+AutoTokenizer.from_pretrained(%[1]q).save_pretrained(%[3]q)
+EOF
+`, baseFolder, adapterFolder, adapterFolder+"-merged")
+}
+
+// readRepoFile returns a file from the repo root, or nil if the repo has no such file.
+// A file that was not downloaded is only fetched from the Hub when fetch is set.
+func readRepoFile(result repoResult, proxyURLHead, name string, fetch bool) []byte {
+	if data, err := os.ReadFile(path.Join(result.targetFolder, name)); err == nil || !fetch {
+		return data
+	}
+	response, err := http.Get(hfdl.ProxyURL(proxyURLHead, result.ref.Endpoint+result.ref.ResolvePath(name)))
+	if err != nil {
+		return nil
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// cardBaseModels reads base_model from the YAML front matter of a model card,
+// either as `base_model: org/name` or as a list below the key.
+func cardBaseModels(card string) []string {
+	scanner := bufio.NewScanner(strings.NewReader(card))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "---" {
+		return nil
+	}
+	var ids []string
+	inList := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "---" {
+			break
+		}
+		if inList {
+			item := strings.TrimSpace(line)
+			if strings.HasPrefix(item, "- ") {
+				ids = append(ids, strings.TrimSpace(item[2:]))
+				continue
+			}
+			inList = false
+		}
+		if value, ok := strings.CutPrefix(line, "base_model:"); ok {
+			value = strings.TrimSpace(value)
+			switch {
+			case value == "":
+				inList = true
+			case strings.HasPrefix(value, "["):
+				// base_model: [org/a, org/b]
+				for _, id := range strings.Split(strings.Trim(value, "[]"), ",") {
+					ids = append(ids, strings.TrimSpace(id))
+				}
+			default:
+				ids = append(ids, value)
+			}
+		}
+	}
+	return ids
+}
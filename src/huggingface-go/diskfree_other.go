@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+func diskFree(dir string) (uint64, bool) {
+	return 0, false
+}
+
+// allocatedSize returns the size of a file; sparse files are not told apart here.
+func allocatedSize(stat os.FileInfo) int64 {
+	return stat.Size()
+}
@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskFree returns the bytes available to this user on the file system holding dir.
+func diskFree(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
+
+// allocatedSize returns the bytes the file system allocated for a file, less than its
+// size for a sparse file.
+func allocatedSize(stat os.FileInfo) int64 {
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return min(int64(st.Blocks)*512, stat.Size())
+	}
+	return stat.Size()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskFree returns the bytes available to this user on the volume holding dir.
+func diskFree(dir string) (uint64, bool) {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var available uint64
+	if ok, _, _ := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&available)), 0, 0); ok == 0 {
+		return 0, false
+	}
+	return available, true
+}
+
+// allocatedSize returns the size of a file; sparse files are not told apart here.
+func allocatedSize(stat os.FileInfo) int64 {
+	return stat.Size()
+}
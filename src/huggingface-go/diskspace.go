@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// checkDiskSpace is the preflight for free space: per target folder it adds up what
+// is still missing of every file (a finished file needs nothing more, a .tmp or
+// .segtmp file only what is not allocated on disk yet) and fails when
+// the file system holding the folder has less free, so the download does not run
+// into ENOSPC hours in. With force it only warns.
+func checkDiskSpace(entries []hfdl.FileEntry, folderOf, pathOf func(hfdl.FileEntry) string, force bool) error {
+	needed := make(map[string]int64)
+	for _, entry := range entries {
+		missing := max(entry.Size, 0)
+		filePath := pathOf(entry)
+		if stat, err := os.Stat(filePath); err == nil && stat.Size() == entry.Size {
+			missing = 0
+		} else if stat, err := os.Stat(filePath + ".tmp"); err == nil {
+			// 按实际占用的块算：稀疏的 .tmp 虽然大小已经是整个文件，空间还没有占用
+			missing = max(entry.Size-allocatedSize(stat), 0)
+		} else if stat, err := os.Stat(filePath + ".segtmp"); err == nil {
+			// 分段下载续传时只下载缺的块，没下载的部分在稀疏文件里不占空间
+			missing = max(entry.Size-allocatedSize(stat), 0)
+		}
+		needed[folderOf(entry)] += missing
+	}
+	folders := make([]string, 0, len(needed))
+	for folder := range needed {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		free, ok := diskFree(existingDir(folder))
+		if !ok || needed[folder]+volumeReserve <= int64(free) {
+			continue
+		}
+		neededSize, neededUnit := convertBytes(float64(needed[folder] + volumeReserve))
+		freeSize, freeUnit := convertBytes(float64(free))
+		if force {
+			fmt.Fprintf(stdout, "Warning: %s needs %.2f %s, including 64 MB kept free, but only %.2f %s are free, continuing because of --force\n", folder, neededSize, neededUnit, freeSize, freeUnit)
+			continue
+		}
+		return fmt.Errorf("%s needs %.2f %s, including 64 MB kept free, but only %.2f %s are free; free up space, download to another disk (-f, --split-across) or pass --force", folder, neededSize, neededUnit, freeSize, freeUnit)
+	}
+	return nil
+}
+
+// existingDir returns dir or its closest parent that exists.
+func existingDir(dir string) string {
+	for {
+		if stat, err := os.Stat(dir); err == nil && stat.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointProfile describes a self-hosted Hub (--endpoint): where it is, how to
+// authenticate at the SSO gateway in front of it and how to trust its certificate.
+type endpointProfile struct {
+	name     string
+	url      string      // endpoint with the path prefix, e.g. https://hub.corp.example/hf
+	headers  http.Header // added to every request to the hub
+	token    *profileToken
+	caCert   string // PEM file with the CA of the hub, added to the system roots
+	cert     string // client certificate for mutual TLS
+	key      string
+	insecure bool // skip the certificate check, only for testing
+}
+
+// profileToken is the identity token (e.g. an OIDC id token) sent in a header. A token
+// from a command is fetched again after refresh, since such tokens expire.
+type profileToken struct {
+	header  string // Authorization unless the gateway expects another header
+	scheme  string // e.g. Bearer, put in front of the token
+	command string
+	refresh time.Duration
+
+	mu      sync.Mutex
+	value   string
+	fetched time.Time
+}
+
+// endpointProfilePath returns the file of a profile: the name itself when it is a
+// path, else <name>.yaml in the endpoints folder next to the config file.
+func endpointProfilePath(name string) string {
+	if strings.ContainsAny(name, `/\`) || strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") {
+		return name
+	}
+	return filepath.Join(filepath.Dir(configPath()), "endpoints", name+".yaml")
+}
+
+// loadEndpointProfile reads a profile, e.g. ~/.config/huggingface-go/endpoints/corp.yaml:
+//
+//	url: https://hub.corp.example/hf
+//	token_command: oidc-token corp
+//	token_header: X-Id-Token
+//	ca_cert: /etc/ssl/corp-ca.pem
+//	header: ["X-Team: ml-platform"]
+func loadEndpointProfile(name string) (*endpointProfile, error) {
+	path := endpointProfilePath(name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	p := &endpointProfile{name: name, headers: make(http.Header)}
+	var token, tokenEnv, tokenHeader, tokenScheme, tokenCommand, tokenRefresh string
+	for key, list := range values {
+		value := strings.Join(list, ",")
+		switch strings.ReplaceAll(strings.ToLower(key), "-", "_") {
+		case "url":
+			p.url = strings.TrimRight(value, "/")
+		case "header", "headers":
+			for _, header := range list {
+				name, value, ok := strings.Cut(header, ":")
+				if !ok {
+					return nil, fmt.Errorf("%s: header %q is not \"Name: value\"", path, header)
+				}
+				p.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		case "token":
+			token = value
+		case "token_env":
+			tokenEnv = value
+		case "token_command":
+			tokenCommand = value
+		case "token_header":
+			tokenHeader = value
+		case "token_scheme":
+			tokenScheme = value
+		case "token_refresh":
+			tokenRefresh = value
+		case "ca_cert":
+			p.caCert = value
+		case "client_cert":
+			p.cert = value
+		case "client_key":
+			p.key = value
+		case "insecure_skip_verify":
+			if p.insecure, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("%s: invalid insecure_skip_verify %q", path, value)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown key %q, expected url, header, token, token_env, token_command, token_header, token_scheme, token_refresh, ca_cert, client_cert, client_key or insecure_skip_verify", path, key)
+		}
+	}
+	u, err := url.Parse(p.url)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%s: url must be the http(s) url of the hub, got %q", path, p.url)
+	}
+	if (p.cert == "") != (p.key == "") {
+		return nil, fmt.Errorf("%s: client_cert and client_key must be given together", path)
+	}
+	if tokenEnv != "" {
+		if token = os.Getenv(tokenEnv); token == "" {
+			return nil, fmt.Errorf("%s: $%s is empty", path, tokenEnv)
+		}
+	}
+	if token != "" || tokenCommand != "" {
+		if tokenHeader == "" {
+			tokenHeader = "Authorization"
+		}
+		if tokenScheme == "" && http.CanonicalHeaderKey(tokenHeader) == "Authorization" {
+			tokenScheme = "Bearer"
+		}
+		p.token = &profileToken{header: tokenHeader, scheme: tokenScheme, command: tokenCommand, value: token, fetched: time.Now()}
+		if tokenCommand != "" {
+			p.token.refresh = 30 * time.Minute
+			if tokenRefresh != "" {
+				if p.token.refresh, err = time.ParseDuration(tokenRefresh); err != nil {
+					return nil, fmt.Errorf("%s: invalid token_refresh: %v", path, err)
+				}
+			}
+			// 先取一次，命令不对时马上报错
+			if _, err := p.token.get(); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// get returns the header value, running the token command when the token is missing or old.
+func (t *profileToken) get() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.command != "" && (t.value == "" || (t.refresh > 0 && time.Since(t.fetched) >= t.refresh)) {
+		cmd := shellCommand(t.command)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("token_command failed: %v", err)
+		}
+		value := strings.TrimSpace(string(out))
+		if value == "" {
+			return "", fmt.Errorf("token_command printed no token")
+		}
+		t.value, t.fetched = value, time.Now()
+		addSecret(value)
+	}
+	if t.scheme == "" {
+		return t.value, nil
+	}
+	return t.scheme + " " + t.value, nil
+}
+
+// install applies the TLS settings to every connection and makes requests to the hub
+// carry the profile's headers. A url-prefix proxy in front of it does not get them,
+// they are credentials for the hub's gateway.
+func (p *endpointProfile) install() error {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("the HTTP transport cannot be configured")
+	}
+	config := transport.TLSClientConfig
+	if p.caCert != "" {
+		pem, err := os.ReadFile(p.caCert)
+		if err != nil {
+			return err
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in %s", p.caCert)
+		}
+		config.RootCAs = roots
+	}
+	if p.cert != "" {
+		cert, err := tls.LoadX509KeyPair(p.cert, p.key)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if p.insecure {
+		fmt.Fprintf(stdout, "Warning: endpoint profile %s skips the TLS certificate check\n", p.name)
+		config.InsecureSkipVerify = true
+	}
+	for _, values := range p.headers {
+		for _, value := range values {
+			addSecret(value)
+		}
+	}
+	if p.token != nil {
+		// token_command 取到的 token 在 get 里登记
+		addSecret(p.token.value)
+	}
+	hosts := make(map[string]bool)
+	if u, err := url.Parse(p.url); err == nil && u.Host != "" {
+		hosts[u.Host] = true
+	}
+	http.DefaultTransport = &headerTransport{base: http.DefaultTransport, hosts: hosts, headers: p.headers, token: p.token}
+	return nil
+}
+
+// headerTransport adds the headers of an endpoint profile to requests for its hosts.
+// Like authTransport it leaves other hosts, e.g. a storage backend the hub redirects
+// to, alone.
+type headerTransport struct {
+	base    http.RoundTripper
+	hosts   map[string]bool
+	headers http.Header
+	token   *profileToken // may be nil
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.hosts[req.URL.Host] {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	if t.token != nil {
+		value, err := t.token.get()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(t.token.header, value)
+	}
+	return t.base.RoundTrip(req)
+}
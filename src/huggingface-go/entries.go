@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// How entries that are neither files nor directories (symlinks, submodules, unknown) are treated.
+const (
+	unknownEntriesSkip     = "skip"     // leave them out and list them in the summary
+	unknownEntriesDownload = "download" // fetch them through resolve/ like a file, which materializes symlinks
+	unknownEntriesFail     = "fail"     // abort the job
+)
+
+// Orders in which the files of a repo are downloaded (--order).
+const (
+	orderListed   = "listed"   // as the Hub lists them
+	orderSmallest = "smallest" // configs and tokenizers first, so a model can be loaded while the shards finish
+	orderLargest  = "largest"  // the long transfers first, so the run does not end with one large file
+)
+
+func validOrder(order string) bool {
+	switch order {
+	case orderListed, orderSmallest, orderLargest:
+		return true
+	}
+	return false
+}
+
+// orderEntries sorts the files for --order, keeping the listing order between files
+// of the same size. Files of unknown size come last either way.
+func orderEntries(entries []hfdl.FileEntry, order string) {
+	if order == orderListed || order == "" {
+		return
+	}
+	sort.SliceStable(entries, func(a, b int) bool {
+		sa, sb := entries[a].Size, entries[b].Size
+		switch {
+		case sa == sb:
+			return false
+		case sa == hfdl.UnknownSize:
+			return false
+		case sb == hfdl.UnknownSize:
+			return true
+		case order == orderLargest:
+			return sa > sb
+		}
+		return sa < sb
+	})
+}
+
+func validUnknownEntriesPolicy(policy string) bool {
+	switch policy {
+	case unknownEntriesSkip, unknownEntriesDownload, unknownEntriesFail:
+		return true
+	}
+	return false
+}
+
+// applyUnknownEntriesPolicy splits the listing into the files to download and the skipped entries.
+func applyUnknownEntriesPolicy(entries []hfdl.FileEntry, policy string) ([]hfdl.FileEntry, []hfdl.FileEntry, error) {
+	files := make([]hfdl.FileEntry, 0, len(entries))
+	var skipped []hfdl.FileEntry
+	for _, entry := range entries {
+		if entry.Type == "file" {
+			files = append(files, entry)
+			continue
+		}
+		switch policy {
+		case unknownEntriesDownload:
+			fmt.Fprintf(stdout, "Entry %s has type %q, downloading it as a regular file\n", entry.Path, entry.Type)
+			files = append(files, entry)
+		case unknownEntriesFail:
+			return nil, nil, fmt.Errorf("entry %s has unsupported type %q", entry.Path, entry.Type)
+		default:
+			skipped = append(skipped, entry)
+		}
+	}
+	return files, skipped, nil
+}
+
+func printSkippedEntries(skipped []hfdl.FileEntry) {
+	if len(skipped) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(skipped))
+	for _, entry := range skipped {
+		lines = append(lines, fmt.Sprintf("  %s (%s)", entry.Path, entry.Type))
+	}
+	fmt.Fprintf(stdout, "Skipped %d entries that are neither files nor directories (use --unknown-entries=download to fetch them):\n%s\n", len(skipped), strings.Join(lines, "\n"))
+}
+
+// selectFile keeps only the entry of file when a single file was requested
+// (blob/ and resolve/ urls); an empty file keeps every entry.
+func selectFile(entries []hfdl.FileEntry, file string) ([]hfdl.FileEntry, error) {
+	if file == "" {
+		return entries, nil
+	}
+	for _, entry := range entries {
+		if entry.Path == file {
+			return []hfdl.FileEntry{entry}, nil
+		}
+	}
+	return nil, fmt.Errorf("file %s not found in the repo", file)
+}
+
+// fillUnknownSizes asks the server for the sizes the listing left out, a few files at
+// a time, so totals, the disk space check and the progress bars are right. Returns
+// the number of files whose size is still unknown.
+func fillUnknownSizes(ctx context.Context, d *hfdl.Downloader, ref hfdl.Repo, entries []hfdl.FileEntry) int {
+	var unknown []int
+	for i, entry := range entries {
+		if entry.Size == hfdl.UnknownSize {
+			unknown = append(unknown, i)
+		}
+	}
+	if len(unknown) == 0 {
+		return 0
+	}
+	fmt.Fprintf(stdout, "The listing has no size for %d files, asking the server\n", len(unknown))
+	var mu sync.Mutex
+	left := 0
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(8, len(unknown)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				size, err := d.FileSize(ctx, ref.ResolvePath(entries[i].Path))
+				if err != nil {
+					fmt.Fprintf(stdout, "Cannot get the size of %s: %v\n", entries[i].Path, err)
+					mu.Lock()
+					left++
+					mu.Unlock()
+					continue
+				}
+				entries[i].Size = size
+			}
+		}()
+	}
+	for _, i := range unknown {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return left
+}
+
+// sizeColumn formats a size for the file lists, "?" when it is unknown.
+func sizeColumn(size int64) string {
+	if size == hfdl.UnknownSize {
+		return fmt.Sprintf("%13s", "?")
+	}
+	convertedSize, unit := convertBytes(float64(size))
+	return fmt.Sprintf("%10.2f %-2s", convertedSize, unit)
+}
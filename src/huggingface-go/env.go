@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envPrefix = "HFGO_"
+
+// 单字母的 flag 名称在环境变量里不好认，这里给它们起个可读的名字
+var envNames = map[string]string{
+	"u": "URL",
+	"f": "FOLDER",
+	"p": "PROXY",
+	"m": "MIRROR",
+	"d": "DISABLE_MIRROR",
+	"t": "TOKEN",
+	// HFGO_PROXY 已经是 -p
+	"proxy": "PROXY_SERVER",
+}
+
+// envNameFor returns the environment variable that overrides the given flag.
+func envNameFor(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return envPrefix + name
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// annotateEnvUsage appends the matching environment variable to every flag's usage text.
+func annotateEnvUsage(fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Usage += fmt.Sprintf(" (env %s)", envNameFor(f.Name))
+	})
+}
+
+const envUsageFooter = `
+Every flag can also be set through the environment variable shown next to it, or in
+~/.config/huggingface-go/config.yaml ($HFGO_CONFIG) with the variable name as the key
+without HFGO_, e.g. "mirror: https://hf-mirror.com" or "limit_rate: 50M".
+Precedence: command-line flag > HFGO_* environment variable > config file > built-in default.
+`
+
+// applyEnvOverrides fills in flags that were not given on the command line from HFGO_* variables.
+// Precedence: command-line flag > environment variable > built-in default.
+func applyEnvOverrides(fs *flag.FlagSet) error {
+	// 同一个变量可能对应多个别名（-t 和 -token），任一别名在命令行上给出都不再读环境变量
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[envNameFor(f.Name)] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envNameFor(f.Name)
+		if err != nil || explicit[name] {
+			return
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, name, setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// jsonEvent is one line of --json output.
+type jsonEvent struct {
+	Event string    `json:"event"` // file_started, progress, file_done, file_failed or summary
+	Time  time.Time `json:"time"`
+	Path  string    `json:"path,omitempty"` // resolve path of the file
+	Size  int64     `json:"size"`
+	Bytes int64     `json:"bytes"` // downloaded so far
+	Error string    `json:"error,omitempty"`
+	// 只有 summary 有下面这些字段
+	Status         string  `json:"status,omitempty"` // success, failed, cancelled, terminated or timeout
+	ExitCode       *int    `json:"exit_code,omitempty"`
+	Files          *int    `json:"files,omitempty"`
+	FilesDone      *int    `json:"files_done,omitempty"`
+	FilesFailed    *int    `json:"files_failed,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds,omitempty"`
+}
+
+var jsonEventNames = map[hfdl.FileState]string{
+	hfdl.FileStarted:     "file_started",
+	hfdl.FileDownloading: "progress",
+	hfdl.FileDone:        "file_done",
+	hfdl.FileFailed:      "file_failed",
+}
+
+// jsonEvents writes the progress as JSON lines to stdout (--json). Everything else the
+// program prints goes to stderr from then on, so stdout can be parsed line by line.
+type jsonEvents struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	started time.Time
+	totals  hfdl.Totals
+}
+
+func startJSONEvents() *jsonEvents {
+	e := &jsonEvents{encoder: json.NewEncoder(os.Stdout), started: time.Now()}
+	setOutput(os.Stderr)
+	return e
+}
+
+func (e *jsonEvents) write(event jsonEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	event.Time = time.Now().UTC()
+	e.encoder.Encode(event)
+}
+
+func (e *jsonEvents) OnFile(f hfdl.FileEvent) {
+	event := jsonEvent{Event: jsonEventNames[f.State], Path: f.Path, Size: f.Size, Bytes: f.Current}
+	if f.Err != nil {
+		event.Error = redact(f.Err)
+	}
+	e.write(event)
+}
+
+func (e *jsonEvents) OnTotals(t hfdl.Totals) {
+	e.mu.Lock()
+	e.totals = t
+	e.mu.Unlock()
+}
+
+// summary writes the last line with the totals of the run and its exit code.
+func (e *jsonEvents) summary(code int) {
+	status := exitStatus(code)
+	e.mu.Lock()
+	t := e.totals
+	e.mu.Unlock()
+	e.write(jsonEvent{
+		Event:          "summary",
+		Status:         status,
+		ExitCode:       &code,
+		Size:           t.Size,
+		Bytes:          t.Current,
+		Files:          &t.Files,
+		FilesDone:      &t.Done,
+		FilesFailed:    &t.Failed,
+		ElapsedSeconds: time.Since(e.started).Seconds(),
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// 解压到一半的目录或文件，完成后才改成最终的名字
+	extractPartialSuffix = ".partial"
+	// 解压完写在旁边，记着是哪个版本的压缩包，压缩包变了就重新解压
+	extractStampSuffix = ".hfgo-extracted"
+)
+
+// archiveKind returns how --extract unpacks a file and the name of what it unpacks
+// to: a folder named like the archive without its suffixes for .tar, .tar.gz, .tgz,
+// .tar.zst, .tzst and .zip files, a single file for plain .gz and .zst files. kind is
+// "" for other files.
+func archiveKind(rel string) (kind, name string) {
+	for _, suffix := range []string{".tar.gz", ".tgz", ".tar.zst", ".tar.zstd", ".tzst", ".tar"} {
+		if base, ok := strings.CutSuffix(rel, suffix); ok {
+			return "tar", base
+		}
+	}
+	if base, ok := strings.CutSuffix(rel, ".zip"); ok {
+		return "zip", base
+	}
+	if plain, dec := decompressorFor(rel); dec != nil {
+		return "file", plain
+	}
+	return "", ""
+}
+
+// extractArchive unpacks the stored file localPath of the repo file rel below
+// extractDir, keeping the folders of the repo: data/train-000.tar.gz ends up in
+// <extractDir>/data/train-000/. Everything goes into a .partial folder (or file)
+// first that is renamed when complete, and a .hfgo-extracted stamp next to it records
+// the hash of the archive; an archive with the same hash is skipped, another one
+// (the file changed upstream) replaces what was unpacked before. Members with
+// absolute paths or .. in them fail the archive, symbolic and hard links are left
+// out, so nothing is written outside its folder.
+func extractArchive(extractDir, rel, localPath, hash string) error {
+	kind, name := archiveKind(rel)
+	if kind == "" {
+		return nil
+	}
+	dest := filepath.Join(extractDir, filepath.FromSlash(name))
+	stampPath := dest + extractStampSuffix
+	if hash == "" {
+		// 列表里没有哈希时按大小和修改时间
+		stat, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		hash = fmt.Sprintf("%d %d", stat.Size(), stat.ModTime().UnixNano())
+	}
+	if stamp, err := os.ReadFile(stampPath); err == nil && string(stamp) == hash {
+		if _, err := os.Stat(dest); err == nil {
+			return nil
+		}
+	}
+	os.Remove(stampPath)
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	partial := dest + extractPartialSuffix
+	if err := os.RemoveAll(partial); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return err
+	}
+	var files, links int
+	var err error
+	switch kind {
+	case "tar":
+		files, links, err = extractTar(partial, rel, localPath)
+	case "zip":
+		files, links, err = extractZip(partial, localPath)
+	case "file":
+		files, err = 1, decompressFile(partial, rel, localPath)
+	}
+	if err == nil {
+		err = os.Rename(partial, dest)
+	}
+	if err != nil {
+		os.RemoveAll(partial)
+		return err
+	}
+	if err := os.WriteFile(stampPath, []byte(hash), 0644); err != nil {
+		return err
+	}
+	if links > 0 {
+		fmt.Fprintf(stdout, "Extracted %s to %s: %d files, left out %d links\n", rel, dest, files, links)
+	} else {
+		fmt.Fprintf(stdout, "Extracted %s to %s: %d files\n", rel, dest, files)
+	}
+	return nil
+}
+
+// memberPath returns where the archive member name goes below dest, or an error when
+// it would end up outside of it.
+func memberPath(dest, name string) (string, error) {
+	local := filepath.FromSlash(strings.TrimSuffix(name, "/"))
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("unsafe path %q in the archive", name)
+	}
+	return filepath.Join(dest, local), nil
+}
+
+func extractTar(dest, rel, localPath string) (files, links int, err error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	// .tgz 和 .tzst 按 .gz 和 .zst 解压
+	compressed := rel
+	switch path.Ext(rel) {
+	case ".tgz":
+		compressed += ".gz"
+	case ".tzst":
+		compressed += ".zst"
+	}
+	var r io.Reader = file
+	if _, dec := decompressorFor(compressed); dec != nil {
+		plain, err := dec(file)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer plain.Close()
+		r = plain
+	}
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return 0, 0, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, links, nil
+		}
+		if err != nil {
+			return files, links, err
+		}
+		target, err := memberPath(dest, header.Name)
+		if err != nil {
+			return files, links, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeMember(target, header.FileInfo().Mode(), tr)
+			files++
+		case tar.TypeSymlink, tar.TypeLink:
+			// 链接可能指向目录外面，不解压
+			links++
+		}
+		if err != nil {
+			return files, links, err
+		}
+	}
+}
+
+func extractZip(dest, localPath string) (files, links int, err error) {
+	zr, err := zip.OpenReader(localPath)
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return 0, 0, err
+	}
+	defer zr.Close()
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return 0, 0, err
+	}
+	for _, f := range zr.File {
+		target, err := memberPath(dest, f.Name)
+		if err != nil {
+			return files, links, err
+		}
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0755)
+		case mode&os.ModeSymlink != 0:
+			links++
+		case mode.IsRegular():
+			var r io.ReadCloser
+			if r, err = f.Open(); err == nil {
+				err = writeMember(target, mode, r)
+				r.Close()
+			}
+			files++
+		}
+		if err != nil {
+			return files, links, err
+		}
+	}
+	return files, links, nil
+}
+
+// writeMember writes one file of an archive, executable when the archive says so but
+// without setuid or write access for others.
+func writeMember(target string, mode os.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// decompressFile writes the decompressed content of a plain .gz or .zst file to dest.
+func decompressFile(dest, rel, localPath string) error {
+	_, dec := decompressorFor(rel)
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	plain, err := dec(file)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	return writeMember(dest, 0, plain)
+}
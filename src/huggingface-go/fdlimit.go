@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// 文件描述符里还要留一部分给网络连接和标准输入输出
+const reservedFileDescriptors = 64
+
+// preflightOpenFiles reports the descriptor limit, raises the soft limit when allowed
+// and returns how many target files may be open at once.
+func preflightOpenFiles(maxOpenFiles int) int {
+	soft, hard, err := openFileLimit()
+	if err != nil {
+		fmt.Fprintln(stdout, "Cannot read open file limit:", err)
+		if maxOpenFiles <= 0 {
+			maxOpenFiles = 256
+		}
+		return maxOpenFiles
+	}
+	if soft == 0 {
+		// 系统没有这个限制（例如 Windows）
+		if maxOpenFiles <= 0 {
+			maxOpenFiles = 1024
+		}
+		fmt.Fprintf(stdout, "Open file limit: not limited, using at most %d open files\n", maxOpenFiles)
+		return maxOpenFiles
+	}
+	wanted := uint64(maxOpenFiles) + reservedFileDescriptors
+	if maxOpenFiles <= 0 {
+		wanted = 4096
+	}
+	if soft < wanted && soft < hard {
+		if raised, err := raiseOpenFileLimit(wanted, hard); err == nil {
+			fmt.Fprintf(stdout, "Raised open file limit from %d to %d\n", soft, raised)
+			soft = raised
+		}
+	}
+	available := int(soft) - reservedFileDescriptors
+	if available < 1 {
+		available = 1
+	}
+	if maxOpenFiles <= 0 || maxOpenFiles > available {
+		if maxOpenFiles > available {
+			fmt.Fprintf(stdout, "Open file limit (soft %d, hard %d) is too low for %d open files, try `ulimit -n %d`\n", soft, hard, maxOpenFiles, wanted)
+		}
+		maxOpenFiles = available
+	}
+	fmt.Fprintf(stdout, "Open file limit: soft %d, hard %d, using at most %d open files\n", soft, hard, maxOpenFiles)
+	return maxOpenFiles
+}
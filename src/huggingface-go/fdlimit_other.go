@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+// openFileLimit reports no limit on platforms without RLIMIT_NOFILE (e.g. Windows).
+func openFileLimit() (uint64, uint64, error) {
+	return 0, 0, nil
+}
+
+func raiseOpenFileLimit(wanted, hard uint64) (uint64, error) {
+	return 0, nil
+}
@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package main
+
+import "syscall"
+
+// openFileLimit returns the soft and hard RLIMIT_NOFILE values.
+func openFileLimit() (uint64, uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, err
+	}
+	return rl.Cur, rl.Max, nil
+}
+
+// raiseOpenFileLimit raises the soft limit towards wanted, never beyond hard.
+func raiseOpenFileLimit(wanted, hard uint64) (uint64, error) {
+	if wanted > hard {
+		wanted = hard
+	}
+	rl := syscall.Rlimit{Cur: wanted, Max: hard}
+	err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl)
+	if err != nil && wanted > 10240 {
+		// macOS 不允许超过 OPEN_MAX（10240），即使硬限制是 unlimited
+		rl.Cur = 10240
+		err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return rl.Cur, nil
+}
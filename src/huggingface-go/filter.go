@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// stringList is a repeatable flag; each value may also hold several comma separated items.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// fileFilter selects files by --include / --exclude glob patterns. A pattern without
+// a slash matches the file name (*.safetensors), one with a slash matches the whole
+// path (original/*.pth), and a pattern ending in a slash matches a whole folder.
+type fileFilter struct {
+	include []string
+	exclude []string
+}
+
+func matchPattern(pattern, filePath string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(filePath+"/", pattern) || strings.Contains("/"+filePath+"/", "/"+pattern)
+	}
+	target := filePath
+	if !strings.Contains(pattern, "/") {
+		target = path.Base(filePath)
+	}
+	matched, _ := path.Match(pattern, target)
+	return matched
+}
+
+func matchAny(patterns []string, filePath string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, filePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether a file should be downloaded.
+func (f fileFilter) Match(filePath string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, filePath) {
+		return false
+	}
+	return !matchAny(f.exclude, filePath)
+}
+
+// skipDir reports whether a whole folder is excluded, so it does not need to be listed.
+func (f fileFilter) SkipDir(dirPath string) bool {
+	for _, pattern := range f.exclude {
+		if strings.HasSuffix(pattern, "/") && matchPattern(pattern, dirPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,17 +2,18 @@ module huggingface-go
 
 go 1.21.1
 
-require github.com/PuerkitoBio/goquery v1.8.1
+require (
+	github.com/cheggaaa/pb/v3 v3.1.4
+	github.com/klauspost/compress v1.17.9
+	golang.org/x/term v0.6.0
+)
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
-	github.com/andybalholm/cascadia v1.3.1 // indirect
-	github.com/cheggaaa/pb/v3 v3.1.4
 	github.com/fatih/color v1.15.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 )
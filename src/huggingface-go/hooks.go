@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const (
+	// 传给钩子命令的环境变量前缀
+	hookEnvPrefix = "HFGO_HOOK_"
+	// --post-file-background 时排队等待运行的命令最多这么多，再多时下载等着
+	postFileQueueSize = 64
+)
+
+// hooks are user commands run around downloads, see --pre-file, --post-file and --post-run.
+type hooks struct {
+	preFile  string
+	postFile string
+	postRun  string
+	// postFileBackground runs --post-file in the background instead of before the next file
+	postFileBackground bool
+}
+
+// hookPlaceholders are the {name}s filled in in the hook commands and the HFGO_HOOK_*
+// variables they stand for.
+var hookPlaceholders = map[string]string{
+	"{path}":     "PATH",
+	"{file}":     "FILE",
+	"{dir}":      "TARGET_DIR",
+	"{repo}":     "REPO",
+	"{revision}": "REVISION",
+}
+
+// fileHookEnv describes one file to the --pre-file and --post-file hooks.
+func fileHookEnv(ref hfdl.Repo, targetFolder string, entry hfdl.FileEntry, localPath, status string) map[string]string {
+	return map[string]string{
+		"REPO":       ref.ID,
+		"REPO_TYPE":  string(ref.Type),
+		"REVISION":   ref.Revision,
+		"TARGET_DIR": targetFolder,
+		"FILE":       entry.Path,
+		"PATH":       localPath,
+		"SIZE":       strconv.FormatInt(entry.Size, 10),
+		"HASH":       entry.OID,
+		"SHA256":     entry.LFSOID, // empty for non-LFS files
+		"STATUS":     status,       // pending, downloaded, skipped or failed
+	}
+}
+
+// runHookEnv describes a job to the --post-run hook.
+func runHookEnv(ref hfdl.Repo, revision, targetFolder, status string, total, failed int) map[string]string {
+	return map[string]string{
+		"REPO":         ref.ID,
+		"REPO_TYPE":    string(ref.Type),
+		"REVISION":     revision,
+		"TARGET_DIR":   targetFolder,
+		"STATUS":       status,
+		"FILES_TOTAL":  strconv.Itoa(total),
+		"FILES_FAILED": strconv.Itoa(failed),
+	}
+}
+
+// postFileQueue runs the --post-file hook of each file before the next file starts, or
+// with --post-file-background in the background, one after the other, so that a slow
+// command (a conversion, an upload) does not hold up the downloads. Downloads then wait
+// when postFileQueueSize files are queued.
+type postFileQueue struct {
+	command string
+	envs    chan map[string]string // nil when the hook runs right away
+	done    chan struct{}
+}
+
+// startPostFile starts the queue of the --post-file hook, nil when there is none.
+func (h hooks) startPostFile() *postFileQueue {
+	if h.postFile == "" {
+		return nil
+	}
+	q := &postFileQueue{command: h.postFile}
+	if !h.postFileBackground {
+		return q
+	}
+	q.envs, q.done = make(chan map[string]string, postFileQueueSize), make(chan struct{})
+	go func() {
+		defer close(q.done)
+		for env := range q.envs {
+			if err := runHook(q.command, env); err != nil {
+				fmt.Fprintf(stdout, "--post-file hook failed for %s: %v\n", env["PATH"], err)
+			}
+		}
+	}()
+	return q
+}
+
+// add runs or queues the hook of a file that was downloaded, skipped or failed (env["STATUS"]).
+func (q *postFileQueue) add(env map[string]string) {
+	switch {
+	case q == nil:
+	case q.envs == nil:
+		if err := runHook(q.command, env); err != nil {
+			fmt.Fprintf(stdout, "--post-file hook failed for %s: %v\n", env["PATH"], err)
+		}
+	default:
+		q.envs <- env
+	}
+}
+
+// wait runs the hooks still queued and returns when all have run. Nothing can be
+// added afterwards.
+func (q *postFileQueue) wait() {
+	if q != nil && q.envs != nil {
+		close(q.envs)
+		<-q.done
+	}
+}
+
+// expandHook replaces the placeholders of command by the quoted values of env, so
+// paths with spaces or quotes reach the command as one argument.
+func expandHook(command string, env map[string]string) string {
+	if command == "" {
+		return ""
+	}
+	pairs := make([]string, 0, 2*len(hookPlaceholders))
+	for placeholder, key := range hookPlaceholders {
+		pairs = append(pairs, placeholder, shellQuote(env[key]))
+	}
+	return strings.NewReplacer(pairs...).Replace(command)
+}
+
+// shellQuote quotes s for sh, or for cmd on Windows, where file names cannot contain
+// double quotes.
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + s + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runHook runs command through the system shell with its placeholders filled in and
+// HFGO_HOOK_* variables set. An empty command does nothing.
+func runHook(command string, env map[string]string) error {
+	if command == "" {
+		return nil
+	}
+	cmd := shellCommand(expandHook(command, env))
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, hookEnvPrefix+key+"="+value)
+	}
+	cmd.Stdin = os.Stdin
+	// 输出和其他信息一样去掉密钥，--json 时写到 stderr，--tui 时显示在界面里
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// shellCommand runs command through sh, or cmd on Windows.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
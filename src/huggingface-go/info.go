@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runInfo implements `huggingface-go info [flags] <url>`.
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var repoURL string
+	var metadata bool
+	fs.StringVar(&repoURL, "u", "", "huggingface url, hf:// uri or repo id (e.g. datasets/org/name), can also be given as the first argument")
+	fs.BoolVar(&metadata, "metadata", false, "print dataset features, splits and row counts (dataset_infos / croissant)")
+	parseFlags(fs, &g, args, "info [flags] <url>")
+	ref := g.repoArg(fs, repoURL)
+	ctx := context.Background()
+	d, _ := g.openRepo(ctx, &ref)
+	proxyURLHead := g.proxyURLHead
+
+	// blobs=true 让文件列表带上大小
+	var info map[string]interface{}
+	if err := fetchJSON(proxyURLHead, ref.APIURL()+"/revision/"+url.PathEscape(ref.Revision)+"?blobs=true", &info); err != nil {
+		// 有的镜像没有 /revision 接口：仓库信息用默认分支的，文件列表下面按目录树重新列出
+		info = nil
+		if fetchJSON(proxyURLHead, ref.APIURL(), &info) != nil {
+			fmt.Fprintf(stdout, "Cannot fetch repo info: %v\n", err)
+			os.Exit(1)
+		}
+		delete(info, "sha")
+		delete(info, "siblings")
+	}
+	if !siblingsSized(info) {
+		if siblings, err := treeSiblings(ctx, d, ref); err == nil {
+			info["siblings"] = siblings
+		}
+	}
+	printRepoInfo(ref, info, g.token != "")
+	printRepoRefs(proxyURLHead, ref)
+	printLastCommit(proxyURLHead, ref, info)
+
+	if !metadata {
+		return
+	}
+	if ref.Type != hfdl.RepoTypeDataset {
+		fmt.Fprintln(stdout, "--metadata is only available for datasets")
+		os.Exit(2)
+	}
+	cardData, _ := info["cardData"].(map[string]interface{})
+	if configs := datasetInfoConfigs(cardData["dataset_info"]); len(configs) > 0 {
+		for _, config := range configs {
+			printDatasetInfo(config)
+		}
+		return
+	}
+	// 老的数据集卡片里没有 dataset_info，退回到 croissant 元数据
+	var croissant map[string]interface{}
+	if err := fetchJSON(proxyURLHead, ref.APIURL()+"/croissant", &croissant); err != nil {
+		fmt.Fprintf(stdout, "No dataset_info in the dataset card and cannot fetch croissant metadata: %v\n", err)
+		os.Exit(1)
+	}
+	printCroissant(croissant)
+}
+
+// printRepoInfo prints what the Hub says about the repo: its task, library, license,
+// whether it is gated, and the number and size of its files.
+func printRepoInfo(ref hfdl.Repo, info map[string]interface{}, hasToken bool) {
+	fmt.Fprintf(stdout, "Repo: %s (%s)\n", ref.ID, ref.Type)
+	cardData, _ := info["cardData"].(map[string]interface{})
+	for _, field := range []struct{ label, value string }{
+		{"Pipeline", stringField(info, "pipeline_tag")},
+		{"Library", stringField(info, "library_name")},
+		{"SDK", stringField(info, "sdk")},
+		{"License", repoLicense(info, cardData)},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(stdout, "%s: %s\n", field.label, field.value)
+		}
+	}
+	gated := false
+	switch value := info["gated"].(type) {
+	case string:
+		// auto 是自动通过，manual 要作者审核
+		gated = true
+		fmt.Fprintf(stdout, "Gated: yes, %s approval\n", value)
+	case bool:
+		gated = value
+		if gated {
+			fmt.Fprintln(stdout, "Gated: yes")
+		} else {
+			fmt.Fprintln(stdout, "Gated: no")
+		}
+	}
+	if gated && !hasToken {
+		fmt.Fprintf(stdout, "This repo is gated: accept its conditions on %s and supply a token with -t or HF_TOKEN to download it\n", ref.WebURL())
+	}
+	if private, _ := info["private"].(bool); private {
+		fmt.Fprintln(stdout, "Private: yes")
+	}
+	siblings, _ := info["siblings"].([]interface{})
+	var total int64
+	sized := true
+	for _, sibling := range siblings {
+		size, ok := sibling.(map[string]interface{})["size"].(float64)
+		sized = sized && ok
+		total += int64(size)
+	}
+	switch {
+	case len(siblings) == 0:
+	case sized:
+		convertedSize, unit := convertBytes(float64(total))
+		fmt.Fprintf(stdout, "Files: %d, %.2f %s\n", len(siblings), convertedSize, unit)
+	default:
+		// 镜像不一定支持 blobs=true
+		fmt.Fprintf(stdout, "Files: %d, size unknown\n", len(siblings))
+	}
+}
+
+// siblingsSized reports whether info lists the files of the repo with their sizes.
+func siblingsSized(info map[string]interface{}) bool {
+	siblings, ok := info["siblings"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, sibling := range siblings {
+		if _, ok := sibling.(map[string]interface{})["size"].(float64); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// treeSiblings lists the files of the revision with the tree api, in the form of the
+// siblings of the repo info, for mirrors whose info has no sizes or no files.
+func treeSiblings(ctx context.Context, d *hfdl.Downloader, ref hfdl.Repo) ([]interface{}, error) {
+	entries, err := d.ListFiles(ctx, ref, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	siblings := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type != "file" {
+			continue
+		}
+		sibling := map[string]interface{}{"rfilename": entry.Path}
+		if entry.Size != hfdl.UnknownSize {
+			sibling["size"] = float64(entry.Size)
+		}
+		siblings = append(siblings, sibling)
+	}
+	return siblings, nil
+}
+
+// repoLicense is the license of the card, or of a license:<id> tag.
+func repoLicense(info, cardData map[string]interface{}) string {
+	switch license := cardData["license"].(type) {
+	case string:
+		return license
+	case []interface{}:
+		var names []string
+		for _, name := range license {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return strings.Join(names, ", ")
+	}
+	tags, _ := info["tags"].([]interface{})
+	for _, tag := range tags {
+		if tag, ok := tag.(string); ok && strings.HasPrefix(tag, "license:") {
+			return strings.TrimPrefix(tag, "license:")
+		}
+	}
+	return ""
+}
+
+func stringField(m map[string]interface{}, key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+// printRepoRefs prints the branches and tags of the repo; mirrors without the refs
+// api are skipped silently.
+func printRepoRefs(proxyURLHead string, ref hfdl.Repo) {
+	var refs struct {
+		Branches []struct{ Name string } `json:"branches"`
+		Tags     []struct{ Name string } `json:"tags"`
+	}
+	if fetchJSON(proxyURLHead, ref.APIURL()+"/refs", &refs) != nil {
+		return
+	}
+	for _, list := range []struct {
+		label string
+		refs  []struct{ Name string }
+	}{{"Branches", refs.Branches}, {"Tags", refs.Tags}} {
+		if len(list.refs) == 0 {
+			continue
+		}
+		names := make([]string, len(list.refs))
+		for i, r := range list.refs {
+			names[i] = r.Name
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", list.label, strings.Join(names, ", "))
+	}
+}
+
+// printLastCommit prints the commit the revision points at, with its title and date
+// when the commits api has them.
+func printLastCommit(proxyURLHead string, ref hfdl.Repo, info map[string]interface{}) {
+	sha := stringField(info, "sha")
+	var commits []struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+		Date  string `json:"date"`
+	}
+	if fetchJSON(proxyURLHead, ref.APIURL()+"/commits/"+url.PathEscape(ref.Revision), &commits) == nil && len(commits) > 0 && (sha == "" || commits[0].ID == sha) {
+		fmt.Fprintf(stdout, "Last commit: %s %s (%s)\n", commits[0].ID, commits[0].Title, commits[0].Date)
+	} else if sha != "" {
+		fmt.Fprintf(stdout, "Last commit: %s\n", sha)
+	}
+	if modified := stringField(info, "lastModified"); modified != "" {
+		fmt.Fprintf(stdout, "Last modified: %s\n", modified)
+	}
+}
+
+// fetchJSON GETs url (through the url-prefix proxy) and decodes the JSON body into v.
+func fetchJSON(proxyURLHead, url string, v interface{}) error {
+	response, err := http.Get(hfdl.ProxyURL(proxyURLHead, url))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s: %v", url, hfdl.AccessError(response.StatusCode, response.Status))
+	default:
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		return fmt.Errorf("%s: %s %s", url, response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// dataset_info in the card is either a single config or a list of configs
+func datasetInfoConfigs(v interface{}) []map[string]interface{} {
+	var configs []map[string]interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		configs = append(configs, v)
+	case []interface{}:
+		for _, item := range v {
+			if config, ok := item.(map[string]interface{}); ok {
+				configs = append(configs, config)
+			}
+		}
+	}
+	return configs
+}
+
+func printDatasetInfo(config map[string]interface{}) {
+	name, _ := config["config_name"].(string)
+	if name == "" {
+		name = "default"
+	}
+	fmt.Fprintf(stdout, "\nConfig: %s\n", name)
+	if size, ok := config["download_size"].(float64); ok {
+		convertedSize, unit := convertBytes(size)
+		fmt.Fprintf(stdout, "  Download size: %.2f %s\n", convertedSize, unit)
+	}
+	if size, ok := config["dataset_size"].(float64); ok {
+		convertedSize, unit := convertBytes(size)
+		fmt.Fprintf(stdout, "  Dataset size: %.2f %s\n", convertedSize, unit)
+	}
+	if features, ok := config["features"].([]interface{}); ok {
+		fmt.Fprintln(stdout, "  Features:")
+		for _, feature := range features {
+			if f, ok := feature.(map[string]interface{}); ok {
+				fmt.Fprintf(stdout, "    %s: %s\n", f["name"], describeFeature(f))
+			}
+		}
+	}
+	if splits, ok := config["splits"].([]interface{}); ok {
+		fmt.Fprintln(stdout, "  Splits:")
+		for _, split := range splits {
+			s, ok := split.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			rows, _ := s["num_examples"].(float64)
+			size, _ := s["num_bytes"].(float64)
+			convertedSize, unit := convertBytes(size)
+			fmt.Fprintf(stdout, "    %s: %.0f rows, %.2f %s\n", s["name"], rows, convertedSize, unit)
+		}
+	}
+}
+
+// describeFeature turns a datasets feature spec into a short type string
+func describeFeature(f map[string]interface{}) string {
+	if dtype, ok := f["dtype"].(string); ok {
+		return dtype
+	}
+	if label, ok := f["class_label"].(map[string]interface{}); ok {
+		names, _ := label["names"].(map[string]interface{})
+		return fmt.Sprintf("class_label (%d classes)", len(names))
+	}
+	for _, key := range []string{"sequence", "list"} {
+		if inner, ok := f[key]; ok {
+			return key + "<" + describeInner(inner) + ">"
+		}
+	}
+	if _, ok := f["struct"]; ok {
+		return "struct"
+	}
+	return "unknown"
+}
+
+func describeInner(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if _, ok := v["dtype"]; ok {
+			return describeFeature(v)
+		}
+		// {"sequence": {"a": {...}, "b": {...}}} 形式的字段字典
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return "{" + strings.Join(keys, ", ") + "}"
+	case []interface{}:
+		return "struct"
+	}
+	return "unknown"
+}
+
+func printCroissant(croissant map[string]interface{}) {
+	recordSets, _ := croissant["recordSet"].([]interface{})
+	if len(recordSets) == 0 {
+		fmt.Fprintln(stdout, "Croissant metadata contains no record sets")
+		return
+	}
+	for _, recordSet := range recordSets {
+		rs, ok := recordSet.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(stdout, "\nRecord set: %s\n", rs["name"])
+		fields, _ := rs["field"].([]interface{})
+		for _, field := range fields {
+			f, ok := field.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(stdout, "    %s: %v\n", f["name"], f["dataType"])
+		}
+	}
+	fmt.Fprintln(stdout, "\nSplit sizes and row counts are not part of the croissant metadata")
+}
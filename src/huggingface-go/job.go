@@ -0,0 +1,722 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// downloadOptions holds the settings shared by every repo of one run.
+type downloadOptions struct {
+	globalOptions
+	targetParentFolder string
+	folder             string // download into exactly this folder instead, see update
+	unknownEntries     string
+	filter             fileFilter
+	pluginFilters      []FileFilter
+	downloader         []hfdl.Option // transfer settings, see runDownload
+	hooks              hooks
+	prime              string
+	primeWorkers       int
+	requireComplete    bool
+	signer             crypto.Signer    // signs the .complete manifest, see --sign-manifest
+	verifyKey          crypto.PublicKey // --require-complete also checks the manifest signature
+	dryRun             bool             // only print what would be downloaded
+	revisionFolders    bool             // download into <repo>/<revision>, see --revisions
+	splitAcross        []string         // volumes the files are spread over, the first one is targetParentFolder
+	cacheDir           string           // huggingface_hub cache to download into (--cache-layout), "" for plain folders
+	decompress         bool             // store .gz/.zst files decompressed, see --decompress
+	parquet            parquetSelection // columns and row groups kept of parquet files, see --columns
+	indexTars          bool             // write a sample index next to every .tar shard, see --index-tars
+	oversize           string           // files too large for the target file system: fail, warn or split
+	interactive        bool             // pick the files in a tree view first, see --interactive
+	revisionInPath     bool             // append the short commit to the folder name, see --revision-in-path
+	preferSafetensors  bool             // skip .bin/.h5/.msgpack weights that also exist as safetensors
+	officialSplits     bool             // only the data files of the configs the dataset card declares
+	sample             sampleOptions    // --max-total-size and --max-files
+	order              string           // listed, smallest or largest first, see --order
+	allowPartial       bool             // download what was listed when parts of the tree cannot be
+	force              bool             // only warn when the free disk space looks too small
+	withAssets         bool             // also fetch the images the model card shows, see --with-assets
+	extractDir         string           // unpack archives below this folder as they finish, see --extract
+	blobs              *blobStore       // shares file contents between revisions and with --blob-cache, may be nil
+	stats              *runStats
+	manifest           *downloadManifest // files to download instead of listing the repo, see --manifest
+	saveManifest       string            // with --dry-run, write the files to this manifest for another machine
+	output             *s3Output         // store the files in this bucket instead of below targetParentFolder, see --output
+	tui                *downloadTUI      // the --tui view, nil without it
+	pause              func() bool       // reports that the repo's turn is over, see --schedule round-robin
+}
+
+// repoResult is what downloadRepo reports about one repo.
+type repoResult struct {
+	ref          hfdl.Repo // after mirror and rename resolution
+	origin       string    // endpoint the repo was given with, before the mirror was applied
+	targetFolder string
+	ok           bool
+	upToDate     bool // the same job had already completed, nothing was downloaded
+	paused       bool // stopped after a file for the next repo's turn, see --schedule round-robin
+}
+
+// downloadRepo lists and downloads one repo into its folder below opts.targetParentFolder,
+// or only checks the folder when opts.requireComplete is set.
+func downloadRepo(ctx context.Context, ref hfdl.Repo, opts *downloadOptions) (result repoResult) {
+	result = repoResult{ref: ref, origin: ref.Endpoint}
+	// 固定到某个提交的同一个任务已经完成过时不用联网就能确定，init 容器和 CI 里反复运行时几毫秒就结束
+	if opts.skippable() && isCommitSHA(ref.Revision) {
+		folder := path.Join(opts.targetParentFolder, repoFolderName(localFolderName(ref, ""), ref.Revision, ref.Revision, opts))
+		if completedJob(folder, jobFingerprint(ref, folder, opts), ref.Revision) {
+			fmt.Fprintf(stdout, "%s is already up to date at commit %s\n", folder, ref.Revision)
+			result.targetFolder, result.ok, result.upToDate = folder, true, true
+			return result
+		}
+	}
+	if opts.disableDefaultMirror {
+		fmt.Fprintf(stdout, "Mirror has been disabled, using %s as the mirror\n", ref.Endpoint) //e.g. https://huggingface.co
+	}
+	options := opts.downloader
+	var store *s3Storage
+	if opts.output != nil && !opts.dryRun {
+		// 文件写进存储桶而不是磁盘，重试、进度和停滞检查和本地下载一样
+		store = newS3Storage(ctx, &s3Client{s3Output: opts.output, retries: opts.retries, retryDelay: opts.retryDelay})
+		options = append(options[:len(options):len(options)], hfdl.WithStorage(store))
+	}
+	var d *hfdl.Downloader
+	var movedFrom string
+	if opts.manifest != nil {
+		// 清单通常用在连不上 Hub api 的机器上，不去检查改名
+		d = opts.newDownloader(&ref, options...)
+	} else {
+		d, movedFrom = opts.openRepo(ctx, &ref, options...)
+		// 有 token 时先确认它能读这个仓库，不要下载到一半才遇到 403；-p 代理拿不到 token 时没法检查
+		if opts.token != "" && (opts.proxyURLHead == "" || opts.tokenToProxy) {
+			if err := checkRepoAccess(opts.proxyURLHead, ref); err != nil {
+				fmt.Fprintf(stdout, "Cannot download %s: %v\n", ref.ID, err)
+				return result
+			}
+		}
+	}
+	modelName := localFolderName(ref, movedFrom)
+	modelURL := ref.WebURL()
+	branch := ref.Revision
+	urlFolder := ref.ListPath()
+
+	fmt.Fprintf(stdout, "Model/Datasets/Space name: %s\n", modelName)
+	fmt.Fprintf(stdout, "Model/Datasets/Space url: %s\n", modelURL)
+	fmt.Fprintf(stdout, "Branch: %s\n", branch)
+
+	var commit string
+	if opts.manifest != nil {
+		commit = opts.manifest.Commit
+		fmt.Fprintf(stdout, "Commit: %s (from --manifest)\n", commit)
+	} else if !opts.requireComplete || opts.cacheDir != "" || opts.revisionInPath {
+		// 记下版本当前指向的提交，之后可以用 --revision <commit> 重新下载同样的文件
+		var err error
+		commit, err = d.ResolveCommit(ctx, ref)
+		if err == nil {
+			fmt.Fprintf(stdout, "Commit: %s\n", commit)
+		} else if opts.cacheDir != "" || opts.revisionInPath {
+			fmt.Fprintf(stdout, "Cannot resolve the commit of %s: %v\n", branch, err)
+			return result
+		} else {
+			fmt.Fprintf(stdout, "Cannot resolve the commit of %s, it will not be recorded: %v\n", branch, err)
+		}
+	}
+
+	// 文件列表和文件都按提交获取：下载期间分支被推进了，也不会把两个版本的文件混在记录的提交下
+	pinned := ref
+	if commit != "" {
+		pinned.Revision = commit
+	}
+
+	// 创建目标文件夹
+	relFolder := repoFolderName(modelName, branch, commit, opts)
+	targetFolder := path.Join(opts.targetParentFolder, relFolder)
+	if opts.folder != "" {
+		targetFolder = opts.folder
+	}
+	if opts.output != nil {
+		targetFolder = opts.output.folder(relFolder)
+	}
+	var cacheFolder string
+	if opts.cacheDir != "" {
+		// huggingface_hub 的缓存结构：文件放在 blobs/，snapshots/<commit>/ 里是指向它们的链接
+		cacheFolder = cacheRepoFolder(opts.cacheDir, ref)
+		targetFolder = filepath.Join(cacheFolder, "snapshots", commit)
+		defer func() {
+			if result.ok {
+				if err := writeCacheRef(cacheFolder, branch, commit); err != nil {
+					fmt.Fprintf(stdout, "Cannot write refs/%s: %v\n", branch, err)
+				}
+			}
+		}()
+	}
+	result.ref, result.targetFolder = ref, targetFolder
+	fingerprint := jobFingerprint(ref, targetFolder, opts)
+	if opts.skippable() && completedJob(targetFolder, fingerprint, commit) {
+		fmt.Fprintf(stdout, "%s is already up to date at commit %s\n", targetFolder, commit)
+		result.ok, result.upToDate = true, true
+		return result
+	}
+	if opts.requireComplete {
+		// 先确认清单是签名的那份，再按清单里的 sha256 和 git blob id 核对每个文件，同样大小的篡改也能发现
+		if opts.verifyKey != nil {
+			if err := verifyManifestSignature(targetFolder, opts.verifyKey); err != nil {
+				fmt.Fprintf(stdout, "Download is not trusted: %v\n", err)
+				return result
+			}
+		}
+		marker, err := checkCompleteMarker(targetFolder, branch, true)
+		if err != nil {
+			fmt.Fprintf(stdout, "Download is not complete: %v\n", err)
+			return result
+		}
+		fmt.Fprintf(stdout, "%s is complete: %d files, revision %s, manifest %s\n", targetFolder, len(marker.Files), marker.Revision, marker.ManifestSHA256)
+		result.ok = true
+		return result
+	}
+	fileCount, failed := 0, 0
+	runStatus := "failed"
+	var posts *postFileQueue
+	if !opts.dryRun {
+		posts = opts.hooks.startPostFile()
+		// --post-run 只运行一次：仓库结束时，或者再按一次 Ctrl+C 立即退出时
+		var postRunOnce sync.Once
+		postRun := func(status string) {
+			postRunOnce.Do(func() {
+				if err := runHook(opts.hooks.postRun, runHookEnv(ref, branch, targetFolder, status, fileCount, failed)); err != nil {
+					fmt.Fprintf(stdout, "--post-run hook failed: %v\n", err)
+					result.ok = false
+				}
+			})
+		}
+		// --post-run 等 --post-file 都运行完；它失败时下载也算失败，接在后面的转换等步骤出错不会被当成成功。
+		// 列出文件之前就停下（超时、中断）时也运行。正在运行时再按 Ctrl+C，等它运行完再退出
+		remove := atExit(func() { postRun(exitStatus(exitCancelled)) })
+		defer func() {
+			posts.wait()
+			if result.paused {
+				// 仓库还没有下载完，接着下载完时再运行 --post-run
+				remove()
+				return
+			}
+			if runStatus == "failed" && ctx.Err() != nil {
+				runStatus = exitStatus(exitCode(ctx, false))
+			}
+			postRun(runStatus)
+			remove()
+		}()
+	}
+	/*if _, err := os.Stat(targetFolder); err == nil {
+		fmt.Fprintf(stdout, "Target folder %s already exists\n", targetFolder)
+		return
+	}*/
+	if !opts.dryRun && opts.output == nil {
+		if err := os.MkdirAll(targetFolder, 0755); err != nil {
+			fmt.Fprintf(stdout, "Cannot create target folder: %v\n", err)
+			return result
+		}
+	}
+	var listing []hfdl.FileEntry
+	partialListing := false
+	var state *runState
+	if opts.manifest != nil {
+		var err error
+		if listing, err = manifestEntries(*opts.manifest); err != nil {
+			fmt.Fprintf(stdout, "Cannot use --manifest: %v\n", err)
+			return result
+		}
+		fmt.Fprintf(stdout, "Using the %d files of --manifest instead of listing the repo\n", len(listing))
+	} else if state = loadRunState(targetFolder, ref, commit, opts.filter); state != nil {
+		fmt.Fprintf(stdout, "Resuming interrupted run from %s: %d of %d files done\n", stateFileName, state.count(stateDone), len(state.Entries))
+		listing = state.listing()
+	} else {
+		// 递归获取文件列表
+		fmt.Fprintln(stdout, "Fetching file list... \nthis may take a while")
+		var err error
+		if opts.dryRun || opts.output != nil {
+			listing, err = d.ListFiles(ctx, pinned, urlFolder, opts.filter)
+		} else {
+			listing, err = listWithCheckpoint(ctx, d, ref, commit, targetFolder, opts.filter)
+		}
+		var partial *hfdl.PartialListError
+		if errors.As(err, &partial) && opts.allowPartial {
+			fmt.Fprintf(stdout, "Warning: %v\n", err)
+			fmt.Fprintf(stdout, "Continuing with the %d entries that were listed (--allow-partial-listing), %s will not be marked as complete\n", len(listing), targetFolder)
+			partialListing = true
+		} else if err != nil {
+			fmt.Fprintf(stdout, "Cannot fetch entries: %v\n", err)
+			return result
+		}
+		// 不完整的列表不保存，下次运行重新列出
+		if !opts.dryRun && opts.output == nil && !partialListing {
+			state = newRunState(targetFolder, ref, commit, opts.filter, listing)
+			state.flush()
+		}
+	}
+	listing, err := selectFile(listing, ref.File)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot download file: %v\n", err)
+		return result
+	}
+	entries, skipped, err := applyUnknownEntriesPolicy(listing, opts.unknownEntries)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot download repo: %v\n", err)
+		return result
+	}
+	printSkippedEntries(skipped)
+	entries = applyFileFilters(entries, opts.pluginFilters)
+	if opts.officialSplits {
+		if entries, err = selectOfficialSplits(opts.proxyURLHead, ref, entries); err != nil {
+			fmt.Fprintf(stdout, "Cannot download repo: %v\n", err)
+			return result
+		}
+	}
+	if opts.preferSafetensors {
+		var dropped []hfdl.FileEntry
+		entries, dropped = dropDuplicateWeights(entries)
+		printDroppedWeights(dropped)
+	}
+	unknownSizes := fillUnknownSizes(ctx, d, pinned, entries)
+	if opts.interactive {
+		if entries, err = pickFiles(entries); err != nil {
+			fmt.Fprintf(stdout, "Cannot download repo: %v\n", err)
+			return result
+		}
+	}
+	if opts.sample.enabled() {
+		entries = sampleEntries(entries, ref, opts.sample)
+	}
+	orderEntries(entries, opts.order)
+	totalFileSize := 0.0
+	for _, entry := range entries {
+		totalFileSize += float64(max(entry.Size, 0))
+		fileCount += 1
+	}
+	fmt.Fprintf(stdout, "Total number of files: %d\n", fileCount)
+	convertedSize, unit := convertBytes(totalFileSize)
+	if unknownSizes > 0 {
+		fmt.Fprintf(stdout, "Total size of files: %.2f %s, plus %d files of unknown size\n", convertedSize, unit, unknownSizes)
+	} else {
+		fmt.Fprintf(stdout, "Total size of files: %.2f %s\n", convertedSize, unit)
+	}
+	var placement map[string]int
+	if len(opts.splitAcross) > 0 {
+		if placement, err = planPlacement(opts.splitAcross, relFolder, entries); err != nil {
+			fmt.Fprintf(stdout, "Cannot split the files across %s: %v\n", strings.Join(opts.splitAcross, ", "), err)
+			return result
+		}
+		fmt.Fprintln(stdout, "Placing files across volumes:")
+		printPlacement(opts.splitAcross, entries, placement)
+	}
+	completed := func() repoResult {
+		fmt.Fprintln(stdout, "Download task completed")
+		runStatus = "success"
+		result.ok = true
+		return result
+	}
+	if store != nil {
+		// 文件直接从镜像写进存储桶，本地磁盘上什么也不留
+		failed = streamToS3(ctx, d, store, posts, pinned, entries, opts, relFolder, downloadManifest{Repo: ref.ID, Type: ref.Type, Revision: branch, Commit: commit, Endpoint: redact(result.origin)})
+		switch {
+		case ctx.Err() != nil:
+			runStatus = exitStatus(exitCode(ctx, false))
+		case failed > 0:
+			fmt.Fprintf(stdout, "Download task finished with %d failed files\n", failed)
+		case partialListing:
+			fmt.Fprintf(stdout, "Stored the %d files that were listed, but the listing was incomplete; run again to fetch the rest\n", len(entries))
+		default:
+			return completed()
+		}
+		return result
+	}
+	folderOf := func(entry hfdl.FileEntry) string {
+		switch {
+		case placement[entry.Path] > 0:
+			return opts.splitAcross[placement[entry.Path]]
+		case cacheFolder != "" && blobName(entry) != "":
+			return cacheFolder
+		case opts.dryRun:
+			return opts.targetParentFolder
+		}
+		return targetFolder
+	}
+	// 目标是 FAT 盘时，4 GB 以上的文件要在开始前发现，而不是写到一半才报错
+	splitFiles, err := checkFileSizeLimits(entries, folderOf, opts.oversize)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot download repo: %v\n", err)
+		return result
+	}
+	if opts.dryRun {
+		printManifest(d, pinned, targetFolder, entries)
+		if opts.saveManifest != "" {
+			manifest := downloadManifest{Repo: ref.ID, Type: ref.Type, Revision: branch, Commit: commit, Endpoint: redact(result.origin), Files: manifestFiles(entries)}
+			if err := writeManifestFile(opts.saveManifest, manifest); err != nil {
+				fmt.Fprintf(stdout, "Cannot write --manifest %s: %v\n", opts.saveManifest, err)
+				return result
+			}
+			fmt.Fprintf(stdout, "Wrote the %d files to %s, download them elsewhere with --manifest %s\n", len(entries), opts.saveManifest, opts.saveManifest)
+		}
+		result.ok = true
+		return result
+	}
+	// 剩余空间也一样
+	err = checkDiskSpace(entries, folderOf, func(entry hfdl.FileEntry) string {
+		switch {
+		case placement[entry.Path] > 0:
+			return path.Join(opts.splitAcross[placement[entry.Path]], relFolder, entry.Path)
+		case cacheFolder != "" && blobName(entry) != "":
+			return filepath.Join(cacheFolder, "blobs", blobName(entry))
+		}
+		return path.Join(targetFolder, entry.Path)
+	}, opts.force)
+	if err != nil {
+		fmt.Fprintf(stdout, "Not enough disk space: %v\n", err)
+		return result
+	}
+	// 上次完成时 parquet 文件按什么 --columns 和 --row-groups 裁剪，删掉标记之前记下来
+	previous := make(map[string]markerFile)
+	if marker, err := readCompleteMarker(targetFolder); err == nil {
+		for _, f := range marker.Files {
+			previous[f.Path] = f
+		}
+	}
+	// 目录即将被修改，旧的完成标记不再可信
+	if err := removeCompleteMarker(targetFolder); err != nil {
+		fmt.Fprintf(stdout, "Cannot remove old %s marker: %v\n", completeMarkerName, err)
+		return result
+	}
+	files := make([]markerFile, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, markerFile{Path: entry.Path, Size: entry.Size, OID: entry.OID, SHA256: entry.LFSOID})
+	}
+	if opts.prime != "" {
+		// 只预热还没有下载好的文件
+		var pending []string
+		for _, entry := range entries {
+			stat, err := os.Stat(path.Join(targetFolder, entry.Path))
+			if err != nil || stat.Size() != entry.Size {
+				pending = append(pending, pinned.ResolvePath(entry.Path))
+			}
+		}
+		d.Prime(ctx, pending, opts.prime, opts.primeWorkers)
+	}
+	queue := opts.tui.queue(ref, entries)
+	defer queue.close()
+	placed := make(map[string]string)      // file -> repo folder on another volume
+	blobs := make(map[string]string)       // file -> blob name with --cache-layout
+	derived := make(map[string]markerFile) // repo file -> what is stored for it with --decompress or --columns
+	hookSkipped := make(map[string]bool)   // files the --pre-file hook left out
+	// 解压失败的文件本身没有问题，下次运行时跳过下载、重新解压
+	// 每个仓库和版本解压到自己的文件夹，和下载目录的结构一样
+	extract := func(entry hfdl.FileEntry, relPath, filePath string) {
+		if opts.extractDir == "" {
+			return
+		}
+		hash := entry.LFSOID
+		if hash == "" {
+			hash = entry.OID
+		}
+		if err := extractArchive(filepath.Join(opts.extractDir, filepath.FromSlash(relFolder)), relPath, filePath, hash); err != nil {
+			fmt.Fprintf(stdout, "Cannot extract %s: %v\n", relPath, err)
+			failed += 1
+		}
+	}
+	cnt := 1
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			// 已经下载的部分留在 .tmp 文件里，下次继续
+			runStatus = exitStatus(exitCode(ctx, false))
+			state.flush()
+			fmt.Fprintf(stdout, "Download of %s stopped: %v\n", ref.ID, context.Cause(ctx))
+			return result
+		}
+		if opts.pause != nil && cnt > 1 && opts.pause() {
+			state.flush()
+			fmt.Fprintf(stdout, "Pausing %s after %d of %d files to give the repos waiting in the queue a turn\n", ref.ID, cnt-1, fileCount)
+			result.paused = true
+			return result
+		}
+		// 获取文件路径
+		filePath := entry.Path
+		fmt.Fprintf(stdout, "Downloading file %d/%d: %s\n", cnt, fileCount, filePath)
+		cnt += 1
+		queue.reached()
+		var decompress hfdl.Decompressor
+		if opts.decompress {
+			if plainPath, dec := decompressorFor(filePath); dec != nil {
+				derived[entry.Path] = markerFile{Path: plainPath, DecompressedFrom: entry.Path}
+				filePath, decompress = plainPath, dec
+			}
+		}
+		partial := opts.parquet.enabled() && strings.HasSuffix(filePath, ".parquet")
+		if partial {
+			derived[entry.Path] = markerFile{Path: entry.Path, Columns: opts.parquet.columns, RowGroups: opts.parquet.rowGroupList()}
+		}
+		// 解压或裁剪过的文件内容和仓库里的不同，不能和其他版本共用
+		_, isDerived := derived[entry.Path]
+		split := splitFiles[entry.Path] && !isDerived
+		relPath := filePath
+		filePath = path.Join(targetFolder, filePath)
+		if blob := blobName(entry); cacheFolder != "" && blob != "" && !split {
+			blobs[entry.Path] = blob
+			filePath = filepath.Join(cacheFolder, "blobs", blob)
+		}
+		if volume := placement[entry.Path]; volume > 0 && !split {
+			// 放在其他卷上，之后在目标文件夹里建符号链接
+			placed[relPath] = path.Join(opts.splitAcross[volume], relFolder)
+			filePath = path.Join(placed[relPath], relPath)
+		}
+		if split {
+			if _, err := os.Stat(filePath + splitManifestSuffix); err == nil {
+				fmt.Fprintf(stdout, "File %s is already stored as parts, skipping\n", filePath)
+				state.setStatus(entry.Path, stateDone)
+				continue
+			}
+		}
+		// 如果文件已经存在并且大小相同，则跳过
+		stat, err := os.Stat(filePath)
+		if err == nil && partial && !opts.parquet.sameAs(previous[entry.Path]) {
+			// 上次按别的选择裁剪过、下载了整个文件或者没有完成，不知道里面有什么
+			fmt.Fprintf(stdout, "File %s was not stored with these --columns and --row-groups, fetching it again\n", filePath)
+		} else if err == nil && !split {
+			if isDerived {
+				// 解压或裁剪后的大小事先不知道，文件在就说明上次已经完整写好并改名
+				fmt.Fprintf(stdout, "File %s already exists, skipping\n", filePath)
+				state.setStatus(entry.Path, stateDone)
+				extract(entry, relPath, filePath)
+				continue
+			}
+			// 不知道大小时按哈希判断，没有哈希就重新下载
+			unchanged, same := stat.Size() == entry.Size, "size"
+			switch {
+			case entry.Size == hfdl.UnknownSize:
+				unchanged, same = (entry.LFSOID != "" || entry.OID != "") && verifyLocalFile(filePath, entry) == nil, "hash"
+			case unchanged && opts.manifest != nil:
+				// 按清单下载时已有的文件也要核对哈希
+				unchanged, same = verifyLocalFile(filePath, entry) == nil, "hash"
+			}
+			if unchanged {
+				fmt.Fprintf(stdout, "File %s already exists and has the same %s, skipping\n", filePath, same)
+				opts.blobs.add(entry, filePath)
+				state.setStatus(entry.Path, stateDone)
+				extract(entry, relPath, filePath)
+				posts.add(fileHookEnv(ref, targetFolder, entry, filePath, "skipped"))
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			// 处理其他错误
+			fmt.Fprintln(stdout, "Error getting file info:", err)
+			fmt.Fprintln(stdout, "Attempting to download the file anyway")
+		}
+		// 获取文件夹路径
+		dirPath := filepath.Dir(filePath)
+		// 检查文件夹是否存在，如果不存在则创建它
+		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+			err := os.MkdirAll(dirPath, os.ModePerm)
+			if err != nil {
+				fmt.Fprintln(stdout, "Error creating directory:", err)
+				return result
+			}
+		}
+		if err := runHook(opts.hooks.preFile, fileHookEnv(ref, targetFolder, entry, filePath, "pending")); err != nil {
+			// 钩子用来挑选文件时跳过的文件不算失败，也不记进完成标记
+			fmt.Fprintf(stdout, "--pre-file hook exited with %v, skipping %s\n", err, filePath)
+			hookSkipped[entry.Path] = true
+			state.setStatus(entry.Path, stateSkipped)
+			continue
+		}
+		// 其他版本或 --blob-cache 里已经有相同内容的文件，直接链接过来
+		if src := opts.blobs.lookup(entry); src != "" && !isDerived && !split {
+			err := linkBlob(src, filePath)
+			if err == nil {
+				fmt.Fprintf(stdout, "File %s has the same content as %s, linked\n", filePath, src)
+				state.setStatus(entry.Path, stateDone)
+				extract(entry, relPath, filePath)
+				posts.add(fileHookEnv(ref, targetFolder, entry, filePath, "skipped"))
+				continue
+			}
+			fmt.Fprintf(stdout, "Cannot link %s to %s, downloading it instead: %v\n", src, filePath, err)
+		}
+		// 下载文件并保存到目标文件夹
+		status := "downloaded"
+		switch {
+		case split:
+			err = downloadSplit(ctx, d, pinned.ResolvePath(entry.Path), filePath, entry.Size, entry.LFSOID)
+		case decompress != nil:
+			err = d.DownloadDecompressed(ctx, pinned.ResolvePath(entry.Path), filePath, entry.Size, entry.LFSOID, decompress)
+		case partial:
+			var fetched int64
+			if fetched, err = fetchParquetColumns(ctx, d, pinned.ResolvePath(entry.Path), filePath, entry.Size, opts.parquet); err == nil {
+				fetchedSize, fetchedUnit := convertBytes(float64(fetched))
+				fullSize, fullUnit := convertBytes(float64(entry.Size))
+				fmt.Fprintf(stdout, "Fetched %.2f %s of %.2f %s for the selected columns and row groups\n", fetchedSize, fetchedUnit, fullSize, fullUnit)
+			}
+		default:
+			err = d.DownloadFile(ctx, pinned.ResolvePath(entry.Path), filePath, entry.Size, entry.LFSOID)
+			if err == nil && opts.manifest != nil {
+				err = verifyManifestFile(filePath, entry)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot download file %s: %v\n", filePath, err)
+			failed += 1
+			status = "failed"
+			if ctx.Err() != nil {
+				state.setStatus(entry.Path, statePending)
+			} else {
+				state.setStatus(entry.Path, stateFailed)
+			}
+		} else {
+			if !isDerived && !split {
+				opts.blobs.keep(entry, filePath)
+			}
+			state.setStatus(entry.Path, stateDone)
+			if !split && !partial {
+				extract(entry, relPath, filePath)
+			}
+			if opts.stats != nil && !partial {
+				if stat, err := os.Stat(filePath); err == nil {
+					opts.stats.addFileBytes(stat.Size())
+				}
+			}
+		}
+		state.save()
+		posts.add(fileHookEnv(ref, targetFolder, entry, filePath, status))
+	}
+	if err := linkSnapshot(targetFolder, filepath.Join(cacheFolder, "blobs"), blobs); err != nil {
+		fmt.Fprintf(stdout, "Cannot link the snapshot files: %v\n", err)
+		return result
+	}
+	if err := linkPlacedFiles(targetFolder, placed); err != nil {
+		fmt.Fprintf(stdout, "Cannot link files from the other volumes: %v\n", err)
+		return result
+	}
+	if failed > 0 {
+		state.flush()
+		fmt.Fprintf(stdout, "Download task finished with %d failed files, not marking %s as complete\n", failed, targetFolder)
+		return result
+	}
+	if len(hookSkipped) > 0 {
+		files = slices.DeleteFunc(files, func(f markerFile) bool { return hookSkipped[f.Path] })
+		fmt.Fprintf(stdout, "The --pre-file hook skipped %d files, they are left out of %s\n", len(hookSkipped), completeMarkerName)
+	}
+	if partialListing {
+		fmt.Fprintf(stdout, "Downloaded the %d files that were listed, but the listing was incomplete; not marking %s as complete, run again to fetch the rest\n", len(files), targetFolder)
+		return result
+	}
+	for i, f := range files {
+		if _, ok := derived[f.Path]; splitFiles[f.Path] && !ok {
+			files[i].Parts = splitPartCount(f.Size)
+			continue
+		}
+		// 清单里记录实际保存的文件，解压或裁剪后的大小只有现在才知道
+		if stored, ok := derived[f.Path]; ok {
+			stat, err := os.Stat(path.Join(targetFolder, stored.Path))
+			if err != nil {
+				fmt.Fprintf(stdout, "Cannot find %s: %v\n", stored.Path, err)
+				return result
+			}
+			stored.Size = stat.Size()
+			files[i] = stored
+			continue
+		}
+		if f.Size == hfdl.UnknownSize {
+			// 列表里没有大小的文件，清单里记录下载到的大小
+			stat, err := os.Stat(path.Join(targetFolder, f.Path))
+			if err != nil {
+				fmt.Fprintf(stdout, "Cannot find %s: %v\n", f.Path, err)
+				return result
+			}
+			files[i].Size = stat.Size()
+		}
+	}
+	// LFS 文件下载时已经按 sha256 校验过
+	if err := verifyFiles(targetFolder, files, false); err != nil {
+		fmt.Fprintf(stdout, "Verification failed, not marking %s as complete: %v\n", targetFolder, err)
+		return result
+	}
+	if opts.indexTars {
+		indexTarShards(targetFolder, files)
+	}
+	manifest := downloadManifest{Repo: ref.ID, Type: ref.Type, Revision: branch, Commit: commit, Endpoint: redact(result.origin), Job: fingerprint, Files: files}
+	if err := writeDownloadManifest(targetFolder, manifest); err != nil {
+		fmt.Fprintf(stdout, "Cannot write %s: %v\n", downloadManifestName, err)
+		return result
+	}
+	if err := writeChecksums(targetFolder, files); err != nil {
+		fmt.Fprintf(stdout, "Cannot write %s: %v\n", checksumsName, err)
+		return result
+	}
+	marker := completeMarker{Repo: ref.ID, MovedFrom: movedFrom, Type: ref.Type, Revision: branch, Path: urlFolder, Include: opts.filter.include, Exclude: opts.filter.exclude, Sample: opts.sample.marker(), OfficialSplits: opts.officialSplits, Files: files}
+	if len(placed) > 0 {
+		marker.Placement = placed
+	}
+	for _, entry := range skipped {
+		marker.Skipped = append(marker.Skipped, markerFile{Path: entry.Path, Size: entry.Size, OID: entry.OID})
+	}
+	if err := writeCompleteMarker(targetFolder, marker); err != nil {
+		fmt.Fprintf(stdout, "Cannot write %s marker: %v\n", completeMarkerName, err)
+		return result
+	}
+	if opts.signer != nil {
+		if err := signManifest(targetFolder, opts.signer); err != nil {
+			fmt.Fprintf(stdout, "Cannot sign %s manifest: %v\n", completeMarkerName, err)
+			return result
+		}
+		fmt.Fprintf(stdout, "Signed manifest written to %s\n", path.Join(targetFolder, manifestSignatureName))
+	}
+	if opts.withAssets {
+		// 图片不在清单里，取不到也不影响下载本身
+		fetchCardAssets(ctx, d, ref, result.origin, targetFolder, opts.proxyURLHead, entries)
+	}
+	state.remove()
+	return completed()
+}
+
+// printManifest lists the files of a --dry-run, marking those already present in targetFolder.
+func printManifest(d *hfdl.Downloader, ref hfdl.Repo, targetFolder string, entries []hfdl.FileEntry) {
+	var missing int64
+	for _, entry := range entries {
+		state := ""
+		if stat, err := os.Stat(path.Join(targetFolder, entry.Path)); err == nil && stat.Size() == entry.Size {
+			state = " (already downloaded)"
+		} else {
+			missing += max(entry.Size, 0)
+		}
+		fmt.Fprintf(stdout, "%s  %s%s\n            %s\n", sizeColumn(entry.Size), entry.Path, state, d.FileURL(ref.ResolvePath(entry.Path)))
+	}
+	convertedSize, unit := convertBytes(float64(missing))
+	fmt.Fprintf(stdout, "Dry run: %d files, %.2f %s still to download into %s\n", len(entries), convertedSize, unit, targetFolder)
+}
+
+// shortCommit returns the first 7 characters of a commit sha, like git.
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
+
+// repoFolderName returns the folder of a repo below the target parent folder.
+func repoFolderName(modelName, revision, commit string, opts *downloadOptions) string {
+	relFolder := modelName
+	if opts.revisionInPath {
+		// bert-base-uncased@a1b2c3d，不同提交的下载可以并存，路径也不会再变
+		relFolder += "@" + shortCommit(commit)
+	}
+	if opts.revisionFolders {
+		// 每个版本一个子目录，refs/pr/3 这样的版本名里的 / 换成 --
+		relFolder = path.Join(relFolder, strings.ReplaceAll(revision, "/", "--"))
+	}
+	return relFolder
+}
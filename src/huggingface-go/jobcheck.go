@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// jobFingerprint identifies what a download stores in folder: the repo, revision and
+// path, the filters and the options that change the files written. A complete folder
+// whose manifest has the same fingerprint and commit would not change by running the
+// job again.
+func jobFingerprint(ref hfdl.Repo, folder string, opts *downloadOptions) string {
+	if abs, err := filepath.Abs(folder); err == nil {
+		folder = abs
+	}
+	extract := opts.extractDir
+	if abs, err := filepath.Abs(extract); err == nil && extract != "" {
+		extract = abs
+	}
+	job := struct {
+		Repo              string        `json:"repo"`
+		Type              hfdl.RepoType `json:"type"`
+		Revision          string        `json:"revision"`
+		Path              string        `json:"path"`
+		File              string        `json:"file"`
+		Folder            string        `json:"folder"`
+		Include           []string      `json:"include"`
+		Exclude           []string      `json:"exclude"`
+		UnknownEntries    string        `json:"unknown_entries"`
+		Decompress        bool          `json:"decompress"`
+		Columns           []string      `json:"columns"`
+		RowGroups         []int         `json:"row_groups"`
+		IndexTars         bool          `json:"index_tars"`
+		Oversize          string        `json:"oversize"`
+		PreferSafetensors bool          `json:"prefer_safetensors"`
+		Signed            bool          `json:"signed"`
+		WithAssets        bool          `json:"with_assets,omitempty"`
+		Sample            *markerSample `json:"sample,omitempty"`
+		OfficialSplits    bool          `json:"official_splits,omitempty"`
+		Extract           string        `json:"extract,omitempty"`
+	}{ref.ID, ref.Type, ref.Revision, ref.Path, ref.File, folder, opts.filter.include, opts.filter.exclude, opts.unknownEntries,
+		opts.decompress, opts.parquet.columns, opts.parquet.rowGroupList(), opts.indexTars, opts.oversize, opts.preferSafetensors, opts.signer != nil, opts.withAssets, opts.sample.marker(), opts.officialSplits, extract}
+	data, _ := json.Marshal(job)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// skippable reports whether a job can be recognized by its fingerprint. Picking files
+// by hand or through plugins can give another result every time.
+func (opts *downloadOptions) skippable() bool {
+	return !opts.requireComplete && !opts.dryRun && !opts.interactive && len(opts.pluginFilters) == 0 &&
+		opts.folder == "" && opts.cacheDir == "" && len(opts.splitAcross) == 0 && !opts.allowPartial && opts.manifest == nil && opts.output == nil
+}
+
+// completedJob reports whether folder holds a complete download of the job with this
+// fingerprint at commit. Only the sizes of the files are checked, so it takes
+// milliseconds; verify checks the hashes.
+func completedJob(folder, fingerprint, commit string) bool {
+	manifest, err := readDownloadManifest(folder)
+	if err != nil || manifest.Job != fingerprint || commit == "" || manifest.Commit != commit {
+		return false
+	}
+	marker, err := readCompleteMarker(folder)
+	if err != nil {
+		return false
+	}
+	for _, f := range marker.Files {
+		filePath := filepath.Join(folder, filepath.FromSlash(f.Path))
+		if f.Parts > 0 {
+			filePath += splitManifestSuffix
+		}
+		stat, err := os.Stat(filePath)
+		if err != nil || (f.Parts == 0 && stat.Size() != f.Size) {
+			return false
+		}
+	}
+	return true
+}
+
+// isCommitSHA reports whether a revision is a full commit sha, which never moves.
+func isCommitSHA(revision string) bool {
+	if len(revision) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(revision)
+	return err == nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runList implements `huggingface-go list [flags] <url>`.
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var repoURL string
+	var filter fileFilter
+	fs.StringVar(&repoURL, "u", "", "huggingface url, hf:// uri or repo id, can also be given as the first argument")
+	fs.Var((*stringList)(&filter.include), "include", "only list files matching this glob, can be repeated or comma separated")
+	fs.Var((*stringList)(&filter.exclude), "exclude", "leave out files matching this glob, can be repeated or comma separated")
+	parseFlags(fs, &g, args, "list [flags] <url>")
+	ref := g.repoArg(fs, repoURL)
+	ctx := context.Background()
+	d, _ := g.openRepo(ctx, &ref)
+
+	entries, err := d.ListFiles(ctx, ref, ref.ListPath(), filter)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot fetch entries: %v\n", err)
+		os.Exit(1)
+	}
+	if entries, err = selectFile(entries, ref.File); err != nil {
+		fmt.Fprintln(stdout, err)
+		os.Exit(1)
+	}
+	var total int64
+	unknown := 0
+	for _, entry := range entries {
+		kind := ""
+		if entry.Type != "file" {
+			kind = " (" + entry.Type + ")"
+		}
+		fmt.Fprintf(stdout, "%s  %s%s\n", sizeColumn(entry.Size), entry.Path, kind)
+		if entry.Size == hfdl.UnknownSize {
+			unknown++
+		} else {
+			total += entry.Size
+		}
+	}
+	convertedSize, unit := convertBytes(float64(total))
+	if unknown > 0 {
+		fmt.Fprintf(stdout, "%d files, %.2f %s and %d files of unknown size\n", len(entries), convertedSize, unit, unknown)
+		return
+	}
+	fmt.Fprintf(stdout, "%d files, %.2f %s\n", len(entries), convertedSize, unit)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 列出几十万个文件的仓库可能要很久，列到一半被中断时从这里继续，而不是从仓库根目录重新列
+const listingFileName = ".hfgo-listing.json"
+
+// 列目录时最多每隔这么久保存一次进度，中断时总会保存
+const listingSaveInterval = 10 * time.Second
+
+// listingState is the content of .hfgo-listing.json. Like the run state it is only
+// valid for the same repo, revision, path and filters, and for the same commit when
+// that is known.
+type listingState struct {
+	Repo       string               `json:"repo"`
+	Type       hfdl.RepoType        `json:"type"`
+	Revision   string               `json:"revision"`
+	Commit     string               `json:"commit,omitempty"`
+	Path       string               `json:"path,omitempty"`
+	Include    []string             `json:"include,omitempty"`
+	Exclude    []string             `json:"exclude,omitempty"`
+	Checkpoint *hfdl.ListCheckpoint `json:"checkpoint"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+func (s listingState) sameJob(other listingState) bool {
+	return s.Repo == other.Repo && s.Type == other.Type && s.Revision == other.Revision && s.Commit == other.Commit &&
+		s.Path == other.Path && slices.Equal(s.Include, other.Include) && slices.Equal(s.Exclude, other.Exclude)
+}
+
+// listWithCheckpoint lists the repo like ListFiles, saving the progress to
+// .hfgo-listing.json in folder and going on from an earlier one of the same job.
+// The file is removed once the listing is complete.
+func listWithCheckpoint(ctx context.Context, d *hfdl.Downloader, ref hfdl.Repo, commit, folder string, filter fileFilter) ([]hfdl.FileEntry, error) {
+	statePath := filepath.Join(folder, listingFileName)
+	state := listingState{Repo: ref.ID, Type: ref.Type, Revision: ref.Revision, Commit: commit, Path: ref.ListPath(), Include: filter.include, Exclude: filter.exclude}
+	var saved listingState
+	if data, err := os.ReadFile(statePath); err == nil && json.Unmarshal(data, &saved) == nil && saved.sameJob(state) && saved.Checkpoint != nil {
+		state.Checkpoint = saved.Checkpoint
+		listed := len(saved.Checkpoint.Entries)
+		for _, page := range saved.Checkpoint.Pending {
+			listed += len(page.Entries)
+		}
+		fmt.Fprintf(stdout, "Resuming the listing from %s: %d entries listed, %d folders to go\n", listingFileName, listed, len(saved.Checkpoint.Pending))
+	}
+	var lastSave time.Time
+	write := func() {
+		state.UpdatedAt = time.Now().UTC()
+		data, err := json.Marshal(state)
+		if err != nil {
+			return
+		}
+		if err := os.WriteFile(statePath+".tmp", data, 0644); err == nil {
+			os.Rename(statePath+".tmp", statePath)
+		}
+		lastSave = time.Now()
+	}
+	// 按提交列出，续传的列表和之后下载的文件属于同一个版本
+	listRef := ref
+	if commit != "" {
+		listRef.Revision = commit
+	}
+	listing, err := d.ListFilesFrom(ctx, listRef, ref.ListPath(), filter, state.Checkpoint, func(cp *hfdl.ListCheckpoint) {
+		state.Checkpoint = cp
+		if time.Since(lastSave) >= listingSaveInterval {
+			write()
+		}
+	})
+	if err != nil {
+		if state.Checkpoint != nil {
+			write()
+		}
+		return listing, err
+	}
+	os.Remove(statePath)
+	return listing, nil
+}
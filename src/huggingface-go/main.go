@@ -1,312 +1,601 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto"
 	"fmt"
-	"io"
-	"net/http"
+	"sync"
 
 	"flag"
 	"os"
-	"path"
-	"strings"
+	"time"
 
-	"path/filepath"
-
-	"github.com/PuerkitoBio/goquery"
-	"github.com/cheggaaa/pb/v3"
-
-	"encoding/base64"
-	"strconv"
+	"huggingface-go/pkg/hfdl"
 )
 
-var huggingfaceHead string
-
 func main() {
-	var url, targetParentFolder, proxyURLHead, homepage string
-	var disableDefaultMirror bool
-	flag.StringVar(&url, "u", "", "huggingface url, such as: https://hf-mirror.com/Finnish-NLP/t5-large-nl36-finnish/tree/main")
-	flag.StringVar(&targetParentFolder, "f", "./", "path to your target folder")
-	flag.StringVar(&proxyURLHead, "p", "", "proxy url, leave it empty if you don't need it")
-	flag.StringVar(&homepage, "homepage", "https://github.com/xieincz/huggingface-go", "homepage url of this tool")
-	flag.StringVar(&huggingfaceHead, "m", "https://hf-mirror.com", "mirror url of huggingface, use this if you want to use a different mirror, use -d to disable default mirror")
-	flag.BoolVar(&disableDefaultMirror, "d", false, "disable default mirror")
-
-	flag.Parse()
-
-	if url == "" {
-		flag.Usage()
-		return
+	args := os.Args[1:]
+	command := "download"
+	if len(args) > 0 {
+		switch args[0] {
+		case "download", "list", "verify", "search", "info", "branches", "serve-files", "copy", "benchmark", "update", "usage", "redact", "upload":
+			command, args = args[0], args[1:]
+		}
 	}
-
-	// 提取文件名和链接
-	modelURL := strings.Split(url, "/tree/")[0]
-	branch := strings.Split(strings.Split(url, "/tree/")[1], "/")[0] //需要输入url必须含branch，否则后面会出问题
-	modelName := path.Base(modelURL)
-	tmp := strings.Split(url, branch+"/") //需要输入url末尾不含/，否则后面会出问题
-	var urlFolder string
-	if len(tmp) < 2 {
-		urlFolder = ""
-	} else {
-		urlFolder = tmp[1]
+	// 还没写入账本的字节数，再按一次 Ctrl+C 立即退出时也写
+	atExit(func() { usage.flush() })
+	code := exitOK
+	switch command {
+	case "list":
+		runList(args)
+	case "verify":
+		runVerify(args)
+	case "search":
+		runSearch(args)
+	case "info":
+		runInfo(args)
+	case "branches":
+		runBranches(args)
+	case "serve-files":
+		runServeFiles(args)
+	case "copy":
+		runCopy(args)
+	case "benchmark":
+		runBenchmark(args)
+	case "usage":
+		runUsage(args)
+	case "update":
+		code = runUpdate(args)
+	case "redact":
+		code = runRedact(args)
+	case "upload":
+		code = runUpload(args)
+	default:
+		code = runDownload(args)
 	}
+	exit(code)
+}
 
-	//提取出域名
-	tmp = strings.Split(url, "/")
-	if disableDefaultMirror {
-		huggingfaceHead = tmp[0] + "//" + tmp[2] //e.g. https://huggingface.co
-		fmt.Printf("Mirror has been disabled, using %s as the mirror\n", huggingfaceHead)
-	} else {
-		//将huggingfaceHead替换到modelURL
-		modelURL = strings.Replace(modelURL, tmp[0]+"//"+tmp[2], huggingfaceHead, 1)
+// runDownload implements `huggingface-go [download] [flags] <url>` and returns the exit code.
+func runDownload(args []string) int {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var url, revision, targetParentFolder, fromFile, blobCache, homepage, unknownEntries, oversize, minSpeed, stallSpeed, limitRate, prime, segmentMinSize, signKey, manifestKey, manifestPath, rowGroups, networkProfile, progressMode, maxTotalSize, order, schedule, output, extractDir string
+	var h hooks
+	var filter fileFilter
+	var pluginPaths, revisions, peers, splitAcross, columns, notifyURLs stringList
+	var minSpeedTime, stallTime, timeout time.Duration
+	var requestRate float64
+	var requireComplete, dryRun, showStats, withDependencies, withBase, autoMirror, cacheLayout, decompress, indexTars, interactive, revisionInPath, preferSafetensors, officialSplits, jsonOutput, allowPartialListing, force, withAssets, tui bool
+	var maxOpenFiles, writeQueue, primeWorkers, segments, adaptiveSegments, repoWorkers int
+	var sample sampleOptions
+	fs.StringVar(&url, "u", "", "huggingface url, such as: https://hf-mirror.com/Finnish-NLP/t5-large-nl36-finnish/tree/main, also accepts hf:// uris and repo ids like org/model, datasets/org/name@revision or spaces/owner/app, can be given as the first argument")
+	fs.StringVar(&fromFile, "from-file", "", "download every repo listed in this file (- reads stdin) instead of a single url: one url per line with optional include=, exclude=, folder=, revision= and priority= settings, or a YAML list of {url, include, exclude, folder, revision, priority} in a .yaml/.yml file; settings of an entry replace the command-line ones for that repo")
+	fs.IntVar(&repoWorkers, "repo-workers", 1, "with --from-file, download this many repos at the same time (their output is interleaved)")
+	fs.StringVar(&schedule, "schedule", scheduleFIFO, "how the repos of --from-file share the --repo-workers: fifo (in the order listed), round-robin (a repo that downloaded for 5 minutes while others wait pauses after its file and goes back to the end of the queue, so a large dataset does not hold up the small repos after it) or priority (the highest priority= setting of the entries first)")
+	fs.StringVar(&revision, "revision", "", "branch, tag or commit sha to download, overrides the one in the url; the commit it resolves to is recorded in .hfgo-manifest.json")
+	fs.BoolVar(&revisionInPath, "revision-in-path", false, "append the short commit sha the revision resolves to to the folder name, e.g. bert-base-uncased@a1b2c3d, so pinned versions can live side by side under immutable paths")
+	fs.StringVar(&targetParentFolder, "f", "./", "path to your target folder")
+	fs.StringVar(&output, "output", "", "store the files in S3 or a compatible store (MinIO, Ceph, R2) instead of -f, e.g. s3://bucket/models: each file is streamed from the mirror, with the retries, resume and progress of a local download, into a multipart upload in memory (--segments parts of 64 MB at a time) that is only completed when its sha256 matches; credentials, region and endpoint come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL")
+	fs.StringVar(&homepage, "homepage", "https://github.com/xieincz/huggingface-go", "homepage url of this tool")
+	fs.IntVar(&maxOpenFiles, "max-open-files", 0, "maximum number of target files open at the same time, 0 means derive it from the open file limit (ulimit -n)")
+	fs.IntVar(&writeQueue, "write-queue", 16, "number of 256KB buffers queued between network reads and disk writes, 0 writes directly from the connection")
+	fs.StringVar(&order, "order", orderListed, "order in which the files of a repo are downloaded: listed, smallest (configs and tokenizers first, so the model can be loaded while large shards finish) or largest")
+	fs.StringVar(&unknownEntries, "unknown-entries", unknownEntriesSkip, "what to do with listing entries that are neither files nor directories (e.g. symlinks): skip, download or fail")
+	fs.IntVar(&segments, "segments", 4, "number of parallel connections for one large file, 1 disables segmented downloads")
+	fs.IntVar(&adaptiveSegments, "adaptive-segments", 0, "let the connections per large file float between 1 and this many, starting at --segments: one is added at a time while the combined speed goes up, and they are halved when the mirror answers 429 or 5xx; with --repo-workers the repos downloaded at the same time are adjusted as well; 0 keeps --segments fixed")
+	fs.StringVar(&segmentMinSize, "segment-min-size", "256M", "only files at least this large are downloaded in segments")
+	fs.StringVar(&minSpeed, "min-speed", "0", "switch to another host (mirror or origin) when a file stays slower than this many bytes per second, e.g. 200K, 0 disables it")
+	fs.StringVar(&limitRate, "limit-rate", "0", "cap the total download speed of all connections in bytes per second, e.g. 50M or 500k, 0 means unlimited")
+	fs.Float64Var(&requestRate, "request-rate", 0, "start at most this many requests per second across all downloads, e.g. 2 or 0.5, to stay below a mirror's rate limit; 0 means unlimited")
+	fs.Var(&peers, "peer", "LAN cache tried before the mirror for every file, e.g. http://labcache:8080 (see serve-files --pull-through); files it misses come from the mirror, can be repeated")
+	fs.StringVar(&networkProfile, "profile-network", "", "tune segments, chunk sizes, stall detection and mirror selection for a kind of network: china-intl (high latency, lossy international routes) or auto (measure the round trip time to the mirror, or huggingface.co with -d, through the proxy settings and pick one); flags given explicitly win")
+	fs.BoolVar(&autoMirror, "auto-mirror", false, "test the speed of hf-mirror.com, huggingface.co and the -m/--mirror mirrors first and use the fastest (the others become failover mirrors)")
+	fs.Var(&splitAcross, "split-across", "spread the files over several volumes by free space, e.g. /mnt/disk1,/mnt/disk2; the repo folder on the first one (replacing -f) links to the files on the others")
+	fs.StringVar(&blobCache, "blob-cache", "", "keep every downloaded LFS file once in this folder by its sha256 and hard-link (or copy) it into the target folders, so other revisions, mirrors or copies of the same model do not download it again")
+	fs.BoolVar(&cacheLayout, "cache-layout", false, "download into the huggingface_hub cache ($HF_HUB_CACHE, $HF_HOME/hub or ~/.cache/huggingface/hub) with its blobs/, snapshots/<commit>/ and refs/ layout instead of -f, so transformers and diffusers load it directly")
+	fs.BoolVar(&decompress, "decompress", false, "store .gz, .zst and .zstd files decompressed (e.g. data.jsonl.zst becomes data.jsonl), decompressing while downloading; the sha256 is checked on the compressed stream")
+	fs.Var(&columns, "columns", "for .parquet files, fetch only these top-level columns with Range requests and store them as a smaller parquet file, e.g. text,label")
+	fs.StringVar(&rowGroups, "row-groups", "", "for .parquet files, fetch only these row groups, e.g. 0-9 or 0,5,7 (can be combined with --columns)")
+	fs.StringVar(&oversize, "oversize", oversizeFail, "what to do with files over 4 GB when the target is on a FAT32 disk: fail before downloading, warn, or split them into <file>.partNNN parts with a <file>.parts.json rejoin manifest")
+	fs.StringVar(&extractDir, "extract", "", "unpack .tar, .tar.gz, .tgz, .tar.zst, .zip and plain .gz/.zst files into this folder as soon as each one is downloaded, below the name of the repo folder, e.g. data/train-000.tar.gz of org/images into <folder>/images/data/train-000/, again when the archive changes; members with absolute or .. paths fail the archive and links are left out, the archives themselves are kept")
+	fs.BoolVar(&indexTars, "index-tars", false, "after downloading, write a <shard>.tar.idx next to every .tar (WebDataset) shard: one JSON line per sample with the offset and size of each of its files, for random access")
+	fs.DurationVar(&timeout, "timeout", 0, "give up when the whole run takes longer than this, e.g. 2h (exit code 124), 0 means no limit")
+	fs.DurationVar(&minSpeedTime, "min-speed-time", 30*time.Second, "how long a transfer may stay below --min-speed before switching hosts")
+	fs.StringVar(&stallSpeed, "stall-speed", "1K", "reconnect a transfer that stays slower than this many bytes per second for --stall-time and resume it where it stopped, instead of waiting on a dead connection; 0 disables it")
+	fs.DurationVar(&stallTime, "stall-time", time.Minute, "how long a transfer may stay below --stall-speed before it is reconnected; a file is given up after 5 reconnects")
+	fs.StringVar(&prime, "prime", "", "warm the mirror cache before downloading by requesting every file first: head (HEAD requests) or range (first byte only), empty disables it")
+	fs.IntVar(&primeWorkers, "prime-workers", 8, "number of concurrent requests of the --prime pass")
+	fs.Var((*stringList)(&filter.include), "include", "only download files matching this glob, e.g. *.safetensors or tokenizer*, can be repeated or comma separated")
+	fs.BoolVar(&force, "force", false, "start even when the target file system has less free space than the files still to download need")
+	fs.BoolVar(&allowPartialListing, "allow-partial-listing", false, "when folders of the repo still cannot be listed after retries, download the files that were listed instead of giving up; the download is reported as incomplete")
+	fs.StringVar(&maxTotalSize, "max-total-size", "0", "download only files adding up to at most this size, e.g. 100GB, for a slice of a huge dataset; files too large for what is left are passed over for smaller ones, 0 means no limit")
+	fs.IntVar(&sample.maxFiles, "max-files", 0, "download at most this many files, 0 means no limit")
+	fs.StringVar(&sample.mode, "sample", sampleFirst, "which files --max-total-size and --max-files keep: first (in listing order) or random (a random selection that is the same on every run, so it can be resumed)")
+	fs.Int64Var(&sample.seed, "sample-seed", 0, "seed of --sample random for another selection, 0 derives it from the repo and revision")
+	fs.BoolVar(&officialSplits, "official-splits", false, "for datasets, download only the data files of the configs and splits declared in the dataset card (configs: data_files), plus README.md; loading scripts and stale files are left out")
+	fs.BoolVar(&preferSafetensors, "prefer-safetensors", false, "skip pytorch_model*.bin, tf_model*.h5, flax_model*.msgpack and other weights (with their index files) whose model*.safetensors counterpart is in the same folder")
+	fs.Var((*stringList)(&filter.exclude), "exclude", "skip files matching this glob, e.g. *.bin or original/, can be repeated or comma separated")
+	fs.Var(&pluginPaths, "plugin", "Go plugin (.so) exporting KeepFile and/or RewriteURL to filter files and rewrite download urls, can be repeated")
+	fs.StringVar(&h.preFile, "pre-file", "", "shell command run before each file is downloaded, a non-zero exit skips the file; HFGO_HOOK_* variables describe the file, and {path} (the file on disk, or its s3:// url with --output), {file} (its path in the repo), {dir} (the repo folder, or the s3:// url of its prefix), {repo} and {revision} are filled in, quoted")
+	fs.StringVar(&h.postFile, "post-file", "", "shell command run after each file, e.g. \"python convert.py {path}\", with the placeholders of --pre-file; HFGO_HOOK_STATUS is downloaded, skipped or failed. The next file starts when it has finished")
+	fs.BoolVar(&h.postFileBackground, "post-file-background", false, "run the --post-file commands one after the other in the background while the download goes on")
+	fs.StringVar(&h.postRun, "post-run", "", "shell command run when the job ends, after the --post-file commands, e.g. \"./convert.sh {dir}\"; HFGO_HOOK_STATUS is success, failed or cancelled, and if it fails after a successful download the exit code is 1")
+	fs.StringVar(&h.postFile, "on-file", "", "same as --post-file")
+	fs.StringVar(&h.postRun, "on-complete", "", "same as --post-run; check HFGO_HOOK_STATUS, it also runs when the job failed or was cancelled")
+	fs.Var(&notifyURLs, "notify-url", "when the run ends, post a summary of it (status, repos, bytes, time taken) to this url: a Slack incoming webhook (https://hooks.slack.com/..., or slack+https://... for compatible servers), the Telegram bot api (https://api.telegram.org/bot<token>/sendMessage?chat_id=<chat>) or any other url as JSON; can be repeated")
+	fs.StringVar(&progressMode, "progress", "", "how to show the download progress: bars, plain (a line per file every 30s or 5%, for log files) or none; by default bars on a terminal and plain otherwise")
+	fs.BoolVar(&jsonOutput, "json", false, "write one JSON line per event to stdout (file_started, progress, file_done, file_failed and a final summary) instead of progress bars; all other output goes to stderr")
+	fs.BoolVar(&showStats, "stats", false, "print peak memory, goroutines, CPU time and disk write amplification at the end")
+	fs.Var(&revisions, "revisions", "download several revisions (branches, tags, commits or refs/pr/N) side by side into per-revision subfolders, e.g. main,v1.0,refs/pr/3; files shared between them are downloaded once")
+	fs.BoolVar(&withDependencies, "with-dependencies", false, "also download companion repos referenced by the model card or config (base model, adapter base, tokenizer)")
+	fs.BoolVar(&withBase, "with-base", false, "for PEFT adapter repos, also download the base model from adapter_config.json and print the merge command")
+	fs.BoolVar(&withAssets, "with-assets", false, "after downloading, also fetch the images and videos README.md shows that are not part of the download (from other repos, cdn-uploads.huggingface.co or other hosts, into .hfgo-assets/) and write README.offline.md with links to the local copies, so the model card renders offline")
+	fs.BoolVar(&tui, "tui", false, "show the download in a full-screen view instead of progress bars: active transfers with their speeds, queued files, recent errors and the total ETA; tab switches between them, q stops")
+	fs.BoolVar(&interactive, "interactive", false, "after fetching the file list, pick the files to download in a tree view with sizes and checkboxes (space toggles a file or folder, x all files with the same extension)")
+	fs.BoolVar(&dryRun, "dry-run", false, "only print every file with its size and download url and the total, then exit")
+	fs.StringVar(&manifestPath, "manifest", "", "with --dry-run, also write the files with their sizes and hashes and the commit to this JSON file; without it, download exactly the files of such a file (or of a .hfgo-manifest.json) at its commit and check their hashes, without listing the repo, e.g. on a machine that can reach the file storage but not the Hub api; the repo url may then be left out")
+	fs.BoolVar(&requireComplete, "require-complete", false, "do not download, only check that the target folder holds a complete download of this revision, reading every file to compare its sha256 or git blob id with the .complete marker (exit code 1 if not)")
+	fs.StringVar(&signKey, "sign-manifest", "", "PEM private key (ed25519, ECDSA or RSA) used to sign the .complete manifest of a finished download, written to .complete.sig")
+	fs.StringVar(&manifestKey, "manifest-key", "", "PEM public key, with --require-complete the .complete.sig signature must also be valid for it")
+	parseFlags(fs, &g, args, "[download] [flags] <url>")
+	if networkProfile != "" {
+		if err := applyNetworkProfile(fs, &g, networkProfile); err != nil {
+			fmt.Fprintf(stdout, "Invalid --profile-network: %v\n", err)
+			os.Exit(2)
+		}
 	}
-
-	fmt.Printf("Model/Datasets name: %s\n", modelName)
-	fmt.Printf("Model/Datasets url: %s\n", modelURL)
-	fmt.Printf("Branch: %s\n", branch)
-
-	// 创建目标文件夹
-	targetFolder := path.Join(targetParentFolder, modelName)
-	/*if _, err := os.Stat(targetFolder); err == nil {
-		fmt.Printf("Target folder %s already exists\n", targetFolder)
-		return
-	}*/
-	if err := os.MkdirAll(targetFolder, 0755); err != nil {
-		fmt.Printf("Cannot create target folder: %v\n", err)
-		return
-	}
-	// 递归获取文件列表
-	fmt.Println("Fetching file list... \nthis may take a while")
-	entries, err := fetchDirectoryEntriesRecursively(proxyURLHead, modelURL+"/tree/"+branch, urlFolder)
-	if err != nil {
-		fmt.Printf("Cannot fetch entries: %v\n", err)
-		return
-	}
-	totalFileSize := 0.0
-	fileCount := 0
-	for _, entry := range entries {
-		totalFileSize += entry["size"].(float64)
-		fileCount += 1
-	}
-	fmt.Printf("Total number of files: %d\n", fileCount)
-	convertedSize, unit := convertBytes(totalFileSize)
-	fmt.Printf("Total size of files: %.2f %s\n", convertedSize, unit)
-	cnt := 1
-	for _, entry := range entries {
-		// 获取文件路径
-		filePath := entry["path"].(string)
-		fmt.Printf("Downloading file %d/%d: %s\n", cnt, fileCount, filePath)
-		cnt += 1
-		filePath = path.Join(targetFolder, filePath)
-		// 如果文件已经存在并且大小相同，则跳过
-		stat, err := os.Stat(filePath)
-		if err == nil {
-			if stat.Size() == int64(entry["size"].(float64)) {
-				fmt.Printf("File %s already exists and has the same size, skipping\n", filePath)
-				continue
-			}
-		} else if !os.IsNotExist(err) {
-			// 处理其他错误
-			fmt.Println("Error getting file info:", err)
-			fmt.Println("Attempting to download the file anyway")
-		}
-		// 获取文件夹路径
-		dirPath := filepath.Dir(filePath)
-		// 检查文件夹是否存在，如果不存在则创建它
-		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-			err := os.MkdirAll(dirPath, os.ModePerm)
-			if err != nil {
-				fmt.Println("Error creating directory:", err)
-				return
+	var ref hfdl.Repo
+	var batch []batchEntry
+	var manifest *downloadManifest
+	if manifestPath != "" && (fromFile != "" || len(revisions) > 0 || withDependencies || withBase) {
+		fmt.Fprintln(stdout, "--manifest is for one repo, it cannot be combined with --from-file, --revisions, --with-dependencies or --with-base")
+		os.Exit(2)
+	}
+	if manifestPath != "" && !dryRun {
+		m, err := readManifestFile(manifestPath)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid --manifest %s: %v\n", manifestPath, err)
+			os.Exit(2)
+		}
+		if revision != "" {
+			fmt.Fprintln(stdout, "--revision cannot be combined with --manifest, the files are downloaded at the commit of the manifest")
+			os.Exit(2)
+		}
+		manifest = &m
+	}
+	if manifest != nil && url == "" && fs.NArg() == 0 {
+		ref = hfdl.Repo{Endpoint: g.hub(), Type: manifest.Type, ID: manifest.Repo, Revision: "main"}
+	} else if fromFile != "" {
+		if url != "" || fs.NArg() > 0 || len(revisions) > 0 {
+			fmt.Fprintln(stdout, "--from-file cannot be combined with a repo url or --revisions")
+			os.Exit(2)
+		}
+		entries, err := readBatchFile(fromFile)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid --from-file %s: %v\n", fromFile, err)
+			os.Exit(2)
+		}
+		batch = entries
+	} else {
+		ref = g.repoArg(fs, url)
+		if revision != "" {
+			if len(revisions) > 0 {
+				fmt.Fprintln(stdout, "--revision cannot be combined with --revisions")
+				os.Exit(2)
 			}
+			ref.Revision = revision
 		}
-		// 拼接文件下载链接
-		fileURL := modelURL + "/resolve/" + branch + "/" + entry["path"].(string)
-		//拼接文件下载代理链接
-		proxyFileURL := proxyURLHead + fileURL
-		// 下载文件并保存到目标文件夹
-		if err := downloadFileWithProgressBar(proxyFileURL, filePath, int(entry["size"].(float64))); err != nil {
-			fmt.Printf("Cannot download file %s: %v\n", filePath, err)
+		if manifest != nil && (ref.ID != manifest.Repo || ref.Type != manifest.Type) {
+			fmt.Fprintf(stdout, "--manifest %s is for %s %s, not %s\n", manifestPath, manifest.Type, manifest.Repo, ref.ID)
+			os.Exit(2)
 		}
-
 	}
-	fmt.Println("Download task completed")
-}
-
-// Helper function to convert Bytes to appropriate unit
-func convertBytes(bytes float64) (float64, string) {
-	const (
-		KB = 1 << 10
-		MB = 1 << 20
-		GB = 1 << 30
-	)
-	switch {
-	case bytes >= GB:
-		return bytes / GB, "GB"
-	case bytes >= MB:
-		return bytes / MB, "MB"
-	case bytes >= KB:
-		return bytes / KB, "KB"
-	default:
-		return bytes, "B"
+	if manifest != nil {
+		// 有 commit 时按 commit 下载，拿到的正是生成清单时的文件
+		if manifest.Commit != "" {
+			ref.Revision = manifest.Commit
+		} else if manifest.Revision != "" {
+			ref.Revision = manifest.Revision
+		}
+	}
+	if repoWorkers < 1 {
+		fmt.Fprintf(stdout, "Invalid --repo-workers value %d, expected at least 1\n", repoWorkers)
+		os.Exit(2)
+	}
+	if adaptiveSegments < 0 {
+		fmt.Fprintf(stdout, "Invalid --adaptive-segments value %d, expected 0 or more\n", adaptiveSegments)
+		os.Exit(2)
+	}
+	if tui && (jsonOutput || interactive || progressMode != "") {
+		fmt.Fprintln(stdout, "--tui cannot be combined with --json, --interactive or --progress")
+		os.Exit(2)
+	}
+	for _, notifyURL := range notifyURLs {
+		if err := checkNotifyURL(notifyURL); err != nil {
+			fmt.Fprintf(stdout, "Invalid --notify-url: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var events *jsonEvents
+	if jsonOutput {
+		events = startJSONEvents()
+	}
+	if autoMirror && !g.disableDefaultMirror {
+		autoSelectMirrors(&g)
 	}
-}
 
-func fetchDirectoryEntriesRecursively(proxyURLHead, baseURL, path string) ([]map[string]interface{}, error) {
-	res := make([]map[string]interface{}, 0)
-	url := baseURL
-	if path != "" {
-		url += "/" + path
-	}
-	proxyURL := proxyURLHead + url
-	response, err := http.Get(proxyURL)
+	var stats *runStats
+	if showStats {
+		stats = startStats()
+		defer stats.report()
+	}
+	var pluginFilters []FileFilter
+	var pluginRewriters []URLRewriter
+	for _, pluginPath := range pluginPaths {
+		filter, rewriter, err := loadPlugin(pluginPath)
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot load plugin: %v\n", err)
+			os.Exit(2)
+		}
+		if filter != nil {
+			pluginFilters = append(pluginFilters, filter)
+		}
+		if rewriter != nil {
+			pluginRewriters = append(pluginRewriters, rewriter)
+		}
+	}
+	segmentMinBytes, err := parseByteSize(segmentMinSize)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(stdout, "Invalid --segment-min-size: %v\n", err)
+		os.Exit(2)
 	}
-	defer response.Body.Close()
-
-	document, err := goquery.NewDocumentFromReader(response.Body)
+	minSpeedBytes, err := parseByteSize(minSpeed)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(stdout, "Invalid --min-speed: %v\n", err)
+		os.Exit(2)
 	}
-
-	selection := document.Find("body > div > main > div.container.relative.flex.flex-col.md\\:grid.md\\:space-y-0.w-full.md\\:grid-cols-12.space-y-4.md\\:gap-6.mb-16 > section > div:nth-child(4)")
-
-	dataProps, exists := selection.Attr("data-props")
-	if !exists {
-		fmt.Println("Current url:", url)
-		fmt.Println("Current proxy url:", proxyURL)
-		return nil, fmt.Errorf("data-props attribute not found")
+	stallSpeedBytes, err := parseByteSize(stallSpeed)
+	if err != nil {
+		fmt.Fprintf(stdout, "Invalid --stall-speed: %v\n", err)
+		os.Exit(2)
 	}
-
-	entries, err := extractEntries(dataProps, proxyURLHead)
+	limitRateBytes, err := parseByteSize(limitRate)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(stdout, "Invalid --limit-rate: %v\n", err)
+		os.Exit(2)
 	}
-
-	for _, entry := range entries {
-		if entry["type"] == "file" {
-			res = append(res, entry)
-		} else if entry["type"] == "directory" {
-			subDirEntries, err := fetchDirectoryEntriesRecursively(proxyURLHead, baseURL, entry["path"].(string))
-			if err != nil {
-				return nil, err
-			}
-			res = append(res, subDirEntries...)
-		} else {
-			fmt.Println("Unconsidered file type:", entry["type"])
+	if !validSchedule(schedule) {
+		fmt.Fprintf(stdout, "Invalid --schedule value %q, expected fifo, round-robin or priority\n", schedule)
+		os.Exit(2)
+	}
+	if !validOrder(order) {
+		fmt.Fprintf(stdout, "Invalid --order value %q, expected listed, smallest or largest\n", order)
+		os.Exit(2)
+	}
+	if sample.maxSize, err = parseByteSize(maxTotalSize); err != nil {
+		fmt.Fprintf(stdout, "Invalid --max-total-size: %v\n", err)
+		os.Exit(2)
+	}
+	if sample.mode != sampleFirst && sample.mode != sampleRandom {
+		fmt.Fprintf(stdout, "Invalid --sample value %q, expected first or random\n", sample.mode)
+		os.Exit(2)
+	}
+	if prime != "" && prime != "head" && prime != "range" {
+		fmt.Fprintf(stdout, "Invalid --prime value %q, expected head or range\n", prime)
+		os.Exit(2)
+	}
+	if !validProgressMode(progressMode) {
+		fmt.Fprintf(stdout, "Invalid --progress value %q, expected bars, plain or none\n", progressMode)
+		os.Exit(2)
+	}
+	if !validOversizePolicy(oversize) {
+		fmt.Fprintf(stdout, "Invalid --oversize value %q, expected fail, warn or split\n", oversize)
+		os.Exit(2)
+	}
+	if !validUnknownEntriesPolicy(unknownEntries) {
+		fmt.Fprintf(stdout, "Invalid --unknown-entries value %q, expected skip, download or fail\n", unknownEntries)
+		os.Exit(2)
+	}
+	var signer crypto.Signer
+	if signKey != "" {
+		if signer, err = loadSigningKey(signKey); err != nil {
+			fmt.Fprintf(stdout, "Invalid --sign-manifest: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	var verifyKey crypto.PublicKey
+	if manifestKey != "" {
+		if verifyKey, err = loadVerifyKey(manifestKey); err != nil {
+			fmt.Fprintf(stdout, "Invalid --manifest-key: %v\n", err)
+			os.Exit(2)
 		}
 	}
 
-	return res, nil
-}
-
-func extractEntries(dataProps, proxyURLHead string) ([]map[string]interface{}, error) {
-	var props map[string]interface{}
-	err := json.Unmarshal([]byte(dataProps), &props)
+	if len(splitAcross) > 0 {
+		targetParentFolder = splitAcross[0]
+	}
+	if revisionInPath && (cacheLayout || len(revisions) > 0) {
+		fmt.Fprintln(stdout, "--revision-in-path cannot be combined with --cache-layout or --revisions")
+		os.Exit(2)
+	}
+	if cacheLayout && len(splitAcross) > 0 {
+		fmt.Fprintln(stdout, "--cache-layout cannot be combined with --split-across")
+		os.Exit(2)
+	}
+	rowGroupSet, err := parseRowGroups(rowGroups)
 	if err != nil {
-		return nil, err
+		fmt.Fprintf(stdout, "Invalid --row-groups: %v\n", err)
+		os.Exit(2)
+	}
+	parquet := parquetSelection{columns: columns, rowGroups: rowGroupSet}
+	if cacheLayout && (decompress || parquet.enabled()) {
+		fmt.Fprintln(stdout, "--cache-layout cannot be combined with --decompress, --columns or --row-groups")
+		os.Exit(2)
+	}
+	var s3 *s3Output
+	if output != "" {
+		if cacheLayout || len(splitAcross) > 0 || blobCache != "" || decompress || parquet.enabled() || indexTars || extractDir != "" || requireComplete || signer != nil || withAssets {
+			fmt.Fprintln(stdout, "--output cannot be combined with --cache-layout, --split-across, --blob-cache, --decompress, --columns, --row-groups, --index-tars, --extract, --require-complete, --sign-manifest or --with-assets")
+			os.Exit(2)
+		}
+		if s3, err = parseS3Output(output); err != nil {
+			fmt.Fprintf(stdout, "Invalid --output: %v\n", err)
+			os.Exit(2)
+		}
+		s3.workers = max(segments, 1)
+	}
+	var cacheDir string
+	if cacheLayout {
+		cacheDir = hubCacheDir()
 	}
 
-	nextURL := props["nextURL"]
-	if nextURL != nil {
-		baseURL := proxyURLHead + huggingfaceHead + strings.Split(nextURL.(string), "?cursor=")[0]
-		last := ""
-		entries := make([]map[string]interface{}, 0)
-		for {
-			var url string
-			if last == "" {
-				url = baseURL
-			} else {
-				cursor := base64.StdEncoding.EncodeToString([]byte(base64.StdEncoding.EncodeToString([]byte(last)) + ":" + strconv.Itoa(len(entries))))
-				url = baseURL + "?cursor=" + cursor + "&expand=true"
-			}
-			resp, err := http.Get(url)
-			if err != nil {
-				fmt.Println("Error:", err)
-				return nil, err
+	opts := &downloadOptions{
+		globalOptions:      g,
+		targetParentFolder: targetParentFolder,
+		unknownEntries:     unknownEntries,
+		filter:             filter,
+		pluginFilters:      pluginFilters,
+		hooks:              h,
+		prime:              prime,
+		primeWorkers:       primeWorkers,
+		requireComplete:    requireComplete,
+		signer:             signer,
+		verifyKey:          verifyKey,
+		dryRun:             dryRun,
+		manifest:           manifest,
+		output:             s3,
+		splitAcross:        splitAcross,
+		cacheDir:           cacheDir,
+		decompress:         decompress,
+		parquet:            parquet,
+		indexTars:          indexTars,
+		oversize:           oversize,
+		interactive:        interactive,
+		revisionInPath:     revisionInPath,
+		preferSafetensors:  preferSafetensors,
+		officialSplits:     officialSplits,
+		sample:             sample,
+		order:              order,
+		allowPartial:       allowPartialListing,
+		force:              force,
+		withAssets:         withAssets,
+		extractDir:         extractDir,
+		stats:              stats,
+		downloader: []hfdl.Option{
+			hfdl.WithWriteQueue(writeQueue),
+			hfdl.WithSegments(segments, segmentMinBytes),
+			hfdl.WithMinSpeed(minSpeedBytes, minSpeedTime),
+			hfdl.WithStallRestart(stallSpeedBytes, stallTime),
+		},
+	}
+	switch {
+	case events != nil:
+		// 文件事件每个文件最多一秒一条
+		opts.downloader = append(opts.downloader, hfdl.WithProgress(false), hfdl.WithProgressListener(hfdl.NewProgress(events, time.Second)))
+	case tui:
+		// 界面每半秒重画一次，速度按这个间隔计算
+		if opts.tui, err = newTUI(); err != nil {
+			fmt.Fprintf(stdout, "Cannot show the --tui view: %v\n", err)
+			os.Exit(2)
+		}
+		opts.downloader = append(opts.downloader, hfdl.WithProgress(false), hfdl.WithProgressListener(hfdl.NewProgress(opts.tui, tuiRedraw)))
+	default:
+		opts.downloader = append(opts.downloader, progressOptions(progressMode)...)
+	}
+	if len(peers) > 0 {
+		opts.downloader = append(opts.downloader, hfdl.WithPeers(peers...))
+	}
+	for _, rewriter := range pluginRewriters {
+		opts.downloader = append(opts.downloader, hfdl.WithURLRewriter(rewriter.RewriteURL))
+	}
+	clientOptions := []hfdl.ClientOption{hfdl.ClientRateLimit(limitRateBytes), hfdl.ClientRequestRate(requestRate)}
+	if adaptiveSegments > 0 {
+		clientOptions = append(clientOptions, hfdl.ClientAdaptiveSegments(segments, adaptiveSegments))
+	}
+	if !requireComplete && !dryRun {
+		slots := hfdl.NewFileSlots(preflightOpenFiles(maxOpenFiles))
+		clientOptions = append(clientOptions, hfdl.ClientFileSlots(slots))
+		if stats != nil {
+			stats.slots = slots
+		}
+	}
+	// 所有仓库共用一个客户端，它们都来自同一个镜像：限流、限速和打开的文件数都合在一起算
+	client := hfdl.NewClient(clientOptions...)
+	opts.downloader = append(opts.downloader, hfdl.WithClient(client))
+	// 同时下载的仓库数也跟着速度和 429/5xx 调整
+	client.Concurrency().LimitWorkers(repoWorkers)
+	queue := []queuedRepo{{ref: ref, opts: opts}}
+	if dryRun {
+		opts.saveManifest = manifestPath
+	}
+	if len(revisions) > 0 || blobCache != "" {
+		opts.blobs = newBlobStore(blobCache)
+	}
+	if len(revisions) > 0 {
+		opts.revisionFolders = true
+		queue = queue[:0]
+		for _, revision := range revisions {
+			ref.Revision = revision
+			queue = append(queue, queuedRepo{ref: ref, opts: opts})
+		}
+	}
+	if batch != nil {
+		if queue, err = batchQueue(batch, opts, revision); err != nil {
+			fmt.Fprintf(stdout, "Invalid --from-file %s: %v\n", fromFile, err)
+			os.Exit(2)
+		}
+	}
+	ok := true
+	seen := make(map[string]bool)
+	for _, item := range queue {
+		seen[item.ref.ID] = true
+	}
+	folders := make(map[string]string) // repo id -> target folder
+	var merges [][2]string             // adapter id, base model id
+	var notified []notifyRepo          // for --notify-url
+	dependencies := 0                  // companion repos queued, at most maxDependencies
+	var mu sync.Mutex                  // guards the above with --repo-workers
+	started := time.Now()
+	ctx, stop := runContext(timeout)
+	defer stop()
+	if opts.tui != nil {
+		if err := opts.tui.start(); err != nil {
+			fmt.Fprintf(stdout, "Cannot show the --tui view: %v\n", err)
+			return exitFailed
+		}
+		ctx = opts.tui.attach(ctx)
+	}
+	runQueue(ctx, queue, repoWorkers, client.Concurrency().Workers, schedule, func(item queuedRepo, pause func() bool) []queuedRepo {
+		ref := item.ref
+		repoOpts := item.opts
+		if pause != nil {
+			copied := *item.opts
+			copied.pause = pause
+			repoOpts = &copied
+		}
+		result := downloadRepo(ctx, ref, repoOpts)
+		if result.paused {
+			// 轮到别的仓库，之后从状态文件接着下载
+			return []queuedRepo{item}
+		}
+		if len(notifyURLs) > 0 {
+			mu.Lock()
+			notified = append(notified, notifyRepo{Repo: result.ref.ID, Type: string(result.ref.Type), Revision: result.ref.Revision, Folder: result.targetFolder, OK: result.ok, UpToDate: result.upToDate})
+			mu.Unlock()
+		}
+		if !result.ok {
+			mu.Lock()
+			ok = false
+			mu.Unlock()
+			return nil
+		}
+		if requireComplete {
+			return nil
+		}
+		mu.Lock()
+		folders[ref.ID] = result.targetFolder
+		mu.Unlock()
+		if result.upToDate && !withBase && !withDependencies {
+			// 关联仓库的提示上次已经打印过，不用再联网查找
+			return nil
+		}
+		var next []queuedRepo
+		// queueDependency queues a companion repo once, with its own filters, unless the
+		// chain is too deep or too many were queued already; mu is held.
+		queueDependency := func(depRef hfdl.Repo) bool {
+			if seen[depRef.ID] {
+				return false
 			}
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				fmt.Println("Error reading response body:", err)
-				return nil, err
+			seen[depRef.ID] = true
+			if item.depth >= maxDependencyDepth || dependencies >= maxDependencies {
+				fmt.Fprintf(stdout, "Not queueing %s: at most %d companion repos, %d levels deep, are downloaded\n", depRef.ID, maxDependencies, maxDependencyDepth)
+				return false
 			}
-			var data []interface{}
-			err = json.Unmarshal(body, &data)
-			if err != nil {
-				var responseDict map[string]interface{}
-				err = json.Unmarshal(body, &responseDict)
-				if err != nil {
-					fmt.Println("Error decoding JSON:", err)
-					return nil, err
+			dependencies++
+			next = append(next, queuedRepo{ref: depRef, opts: dependencyOptions(item.opts), depth: item.depth + 1, priority: item.priority})
+			return true
+		}
+		if withBase {
+			// LoRA 等 PEFT 适配器：把基础模型也下载下来，方便之后合并
+			base, revision := adapterBase(result, g.proxyURLHead, true)
+			mu.Lock()
+			if base != "" {
+				merges = append(merges, [2]string{ref.ID, base})
+				if revision == "" {
+					revision = "main"
 				}
-				if _, ok := responseDict["error"]; ok { //如果后面没有了，就会返回一个含有error的字典，其实也可以根据上一次的entries长度来判断是否结束，小于50说明后面没有了
-					break
+				if queueDependency(hfdl.Repo{Endpoint: result.origin, Type: hfdl.RepoTypeModel, ID: base, Revision: revision}) {
+					fmt.Fprintf(stdout, "Queueing base model %s@%s of adapter %s\n", base, revision, ref.ID)
 				}
-				fmt.Println("Error decoding JSON:", err)
-				return nil, err
+			} else if len(merges) == 0 && len(folders) == 1 {
+				fmt.Fprintf(stdout, "%s has no adapter_config.json, --with-base only applies to PEFT adapter repos\n", ref.ID)
 			}
-			dataMaps := make([]map[string]interface{}, len(data))
-			for i, v := range data {
-				dataMap, ok := v.(map[string]interface{})
-				if !ok {
-					return nil, fmt.Errorf("v is not a valid object")
+			mu.Unlock()
+		}
+		// 查找基础模型、分词器等关联仓库；没有 --with-dependencies 时只看下载了的文件，
+		// 不为了提示去请求 Hub
+		deps := findDependencies(result, g.proxyURLHead, withDependencies)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, dep := range deps {
+			if !withDependencies {
+				if !seen[dep.ID] {
+					seen[dep.ID] = true
+					fmt.Fprintf(stdout, "%s references %s (%s), use --with-dependencies to download it too\n", ref.ID, dep.ID, dep.reason)
 				}
-				dataMaps[i] = dataMap
+				continue
 			}
-			entries = append(entries, dataMaps...)
-			lastBytes, err := json.Marshal(map[string]string{"file_name": dataMaps[len(dataMaps)-1]["path"].(string)})
-			if err != nil {
-				panic(err)
+			if queueDependency(hfdl.Repo{Endpoint: result.origin, Type: hfdl.RepoTypeModel, ID: dep.ID, Revision: "main"}) {
+				fmt.Fprintf(stdout, "Queueing %s (%s of %s)\n", dep.ID, dep.reason, ref.ID)
 			}
-			last = string(lastBytes)
 		}
-		return entries, nil
+		return next
+	})
+	if opts.tui != nil {
+		opts.tui.stop()
 	}
-
-	entriesValue, exists := props["entries"]
-	if !exists {
-		return nil, fmt.Errorf("Entries not found in data-props")
-	}
-	entries, ok := entriesValue.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("Entries is not a valid array")
+	for _, merge := range merges {
+		if dryRun {
+			break
+		}
+		adapterFolder, baseFolder := folders[merge[0]], folders[merge[1]]
+		if adapterFolder != "" && baseFolder != "" {
+			printMergeCommand(baseFolder, adapterFolder)
+		}
 	}
-	entryMaps := make([]map[string]interface{}, len(entries))
-	for i, entry := range entries {
-		entryMap, ok := entry.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("Entry is not a valid object")
+	if ctx.Err() != nil {
+		fmt.Fprintf(stdout, "Stopped: %v\n", context.Cause(ctx))
+		if !dryRun && !requireComplete {
+			fmt.Fprintf(stdout, "Finished files and the partial .tmp files are kept, run the same command again to resume:\n  %s\n", resumeCommand())
 		}
-		entryMaps[i] = entryMap
 	}
-	return entryMaps, nil
-}
-
-func downloadFileWithProgressBar(url, filePath string, fileSize int) error {
-	response, err := http.Get(url)
-	if err != nil {
-		return err
+	code := exitCode(ctx, ok)
+	if events != nil {
+		events.summary(code)
 	}
-	defer response.Body.Close()
-
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+	if len(notifyURLs) > 0 && !dryRun {
+		notify(notifyURLs, newRunSummary(code, notified, started), g.retries, g.retryDelay)
 	}
-	defer file.Close()
-
-	bar := pb.New(int(fileSize)).Set(pb.Bytes, true)
-	bar.Start()
-
-	reader := bar.NewProxyReader(response.Body)
+	return code
+}
 
-	_, err = io.Copy(file, reader)
-	if err != nil {
-		return err
+// Helper function to convert Bytes to appropriate unit
+func convertBytes(bytes float64) (float64, string) {
+	const (
+		KB = 1 << 10
+		MB = 1 << 20
+		GB = 1 << 30
+	)
+	switch {
+	case bytes >= GB:
+		return bytes / GB, "GB"
+	case bytes >= MB:
+		return bytes / MB, "MB"
+	case bytes >= KB:
+		return bytes / KB, "KB"
+	default:
+		return bytes, "B"
 	}
-
-	bar.Finish()
-	return nil
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const downloadManifestName = ".hfgo-manifest.json"
+
+// downloadManifest records which commit a finished download came from, so the same
+// files can be fetched again later with --revision <commit> and audited against it.
+type downloadManifest struct {
+	Repo         string        `json:"repo"`
+	Type         hfdl.RepoType `json:"type"`
+	Revision     string        `json:"revision"`         // as requested: branch, tag or commit
+	Commit       string        `json:"commit,omitempty"` // what the revision pointed at when the download started
+	Endpoint     string        `json:"endpoint"`
+	Job          string        `json:"job,omitempty"` // fingerprint of the job, see jobFingerprint
+	Files        []markerFile  `json:"files"`
+	DownloadedAt time.Time     `json:"downloaded_at"`
+}
+
+// writeDownloadManifest writes the manifest atomically like the .complete marker.
+func writeDownloadManifest(folder string, manifest downloadManifest) error {
+	return writeManifestFile(filepath.Join(folder, downloadManifestName), manifest)
+}
+
+func writeManifestFile(filePath string, manifest downloadManifest) error {
+	manifest.DownloadedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+func readDownloadManifest(folder string) (downloadManifest, error) {
+	return readManifestFile(filepath.Join(folder, downloadManifestName))
+}
+
+func readManifestFile(filePath string) (downloadManifest, error) {
+	var manifest downloadManifest
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// manifestEntries returns the files of a manifest given with --manifest as a listing.
+// The manifest of a --dry-run and the .hfgo-manifest.json of a finished download both
+// work, unless files of the latter were stored decompressed, cut or split, as the
+// files of the repo they came from are not recorded. Paths that would end up outside
+// the target folder (absolute or with ..) fail the manifest, it may come from anyone.
+func manifestEntries(manifest downloadManifest) ([]hfdl.FileEntry, error) {
+	if manifest.Repo == "" || len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("no repo or no files")
+	}
+	entries := make([]hfdl.FileEntry, 0, len(manifest.Files))
+	for _, f := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) || hasDotDot(f.Path) {
+			return nil, fmt.Errorf("unsafe path %q in the manifest", f.Path)
+		}
+		if f.DecompressedFrom != "" || len(f.Columns) > 0 || len(f.RowGroups) > 0 || f.Parts > 0 {
+			return nil, fmt.Errorf("%s was decompressed, cut to some columns or split into parts, use the manifest of a --dry-run instead", f.Path)
+		}
+		entries = append(entries, hfdl.FileEntry{Type: "file", Path: f.Path, Size: f.Size, OID: f.OID, LFSOID: f.SHA256})
+	}
+	return entries, nil
+}
+
+// manifestFiles records the entries of a --dry-run for --manifest.
+func manifestFiles(entries []hfdl.FileEntry) []markerFile {
+	files := make([]markerFile, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, markerFile{Path: entry.Path, Size: entry.Size, OID: entry.OID, SHA256: entry.LFSOID})
+	}
+	return files
+}
+
+// verifyManifestFile checks a file downloaded with --manifest against its git blob
+// id; LFS files were checked against their sha256 while downloading. A file that does
+// not match is removed, so no later run takes it for downloaded.
+func verifyManifestFile(filePath string, entry hfdl.FileEntry) error {
+	if entry.LFSOID != "" || entry.OID == "" {
+		return nil
+	}
+	err := verifyLocalFile(filePath, entry)
+	if err != nil {
+		os.Remove(filePath)
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+package main
+
+import "syscall"
+
+// maxFileSize returns the largest file the file system holding dir can store and its
+// name, or 0 when there is no limit that matters (or it cannot be determined).
+func maxFileSize(dir string) (int64, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, ""
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	// FAT12/16/32 都挂载为 msdos，exFAT 是 exfat
+	if string(name) == "msdos" {
+		return fatMaxFileSize, "FAT"
+	}
+	return 0, ""
+}
@@ -0,0 +1,19 @@
+package main
+
+import "syscall"
+
+// statfs 的 f_type，vfat 和 msdos 都是这个值；exFAT 没有 4 GB 的限制
+const msdosSuperMagic = 0x4d44
+
+// maxFileSize returns the largest file the file system holding dir can store and its
+// name, or 0 when there is no limit that matters (or it cannot be determined).
+func maxFileSize(dir string) (int64, string) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, ""
+	}
+	if st.Type == msdosSuperMagic {
+		return fatMaxFileSize, "FAT"
+	}
+	return 0, ""
+}
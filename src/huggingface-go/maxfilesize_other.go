@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+func maxFileSize(dir string) (int64, string) {
+	return 0, ""
+}
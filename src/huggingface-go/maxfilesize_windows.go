@@ -0,0 +1,34 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	getVolumePathName    = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumePathNameW")
+	getVolumeInformation = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumeInformationW")
+)
+
+// maxFileSize returns the largest file the volume holding dir can store and its
+// file system name, or 0 when there is no limit that matters (or it cannot be determined).
+func maxFileSize(dir string) (int64, string) {
+	name, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, ""
+	}
+	root := make([]uint16, syscall.MAX_PATH+1)
+	if ok, _, _ := getVolumePathName.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&root[0])), uintptr(len(root))); ok == 0 {
+		return 0, ""
+	}
+	fsName := make([]uint16, syscall.MAX_PATH+1)
+	if ok, _, _ := getVolumeInformation.Call(uintptr(unsafe.Pointer(&root[0])), 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&fsName[0])), uintptr(len(fsName))); ok == 0 {
+		return 0, ""
+	}
+	// FAT 和 FAT32 单个文件最大 4 GB，exFAT 和 NTFS 没有这个限制
+	switch fs := syscall.UTF16ToString(fsName); fs {
+	case "FAT", "FAT32":
+		return fatMaxFileSize, fs
+	}
+	return 0, ""
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// networkProfiles are the flag values set by --profile-network. Flags given on the
+// command line or through HFGO_* variables keep their own value.
+var networkProfiles = map[string]map[string]string{
+	// 国内访问海外线路：延迟高、丢包多，单个连接跑不满带宽，也容易长时间卡住
+	"china-intl": {
+		"segments":         "8",
+		"segment-min-size": "64M",
+		"write-queue":      "64",
+		"min-speed":        "50K",
+		"min-speed-time":   "60s",
+		"auto-mirror":      "true",
+	},
+}
+
+// 到镜像（或 huggingface.co）的往返时间超过这个值时，auto 选择 china-intl
+const highRTT = 150 * time.Millisecond
+
+// applyNetworkProfile sets the flags of the named profile that were not set explicitly.
+// "auto" measures the round trip time to the host downloads go to first and picks a
+// profile from it.
+func applyNetworkProfile(fs *flag.FlagSet, g *globalOptions, name string) error {
+	if name == "auto" {
+		if name = detectNetworkProfile(g); name == "" {
+			return nil
+		}
+	}
+	profile, ok := networkProfiles[name]
+	if !ok {
+		var names []string
+		for n := range networkProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown network profile %q, expected auto or %s", name, strings.Join(names, ", "))
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	var applied []string
+	for flagName, value := range profile {
+		if explicit[flagName] {
+			continue
+		}
+		if err := fs.Set(flagName, value); err != nil {
+			return err
+		}
+		applied = append(applied, "--"+flagName+"="+value)
+	}
+	sort.Strings(applied)
+	fmt.Fprintf(stdout, "Network profile %s: %s\n", name, strings.Join(applied, " "))
+	return nil
+}
+
+// detectNetworkProfile sends a few requests to the mirror (the hub with -d) the way
+// downloads do, through -p, --proxy and the other settings of http.DefaultTransport,
+// and returns china-intl when the median time is above highRTT or none gets an answer.
+// The connection is reused, so after the first request the time is the round trip.
+func detectNetworkProfile(g *globalOptions) string {
+	endpoint := g.endpoint(g.hub())
+	client := &http.Client{Transport: http.DefaultTransport, Timeout: 5 * time.Second}
+	var rtts []time.Duration
+	for i := 0; i < 3; i++ {
+		request, err := http.NewRequest(http.MethodHead, hfdl.ProxyURL(g.proxyURLHead, endpoint+"/"), nil)
+		if err != nil {
+			break
+		}
+		start := time.Now()
+		response, err := client.Do(request)
+		if err != nil {
+			continue
+		}
+		rtts = append(rtts, time.Since(start))
+		response.Body.Close()
+	}
+	if len(rtts) == 0 {
+		fmt.Fprintf(stdout, "Cannot reach %s, using the china-intl network profile\n", endpoint)
+		return "china-intl"
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	rtt := rtts[len(rtts)/2]
+	if rtt < highRTT {
+		fmt.Fprintf(stdout, "Round trip time to %s is %v, using the default settings\n", endpoint, rtt.Round(time.Millisecond))
+		return ""
+	}
+	fmt.Fprintf(stdout, "Round trip time to %s is %v, using the china-intl network profile\n", endpoint, rtt.Round(time.Millisecond))
+	return "china-intl"
+}
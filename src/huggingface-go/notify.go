@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// 通知里最多列出这么多个仓库，其余的只给出数目
+	notifyMaxRepos = 20
+	notifyTimeout  = 30 * time.Second
+)
+
+// notifyRepo is one repo of a run in the --notify-url summary.
+type notifyRepo struct {
+	Repo     string `json:"repo"`
+	Type     string `json:"type"`
+	Revision string `json:"revision"`
+	Folder   string `json:"folder,omitempty"`
+	OK       bool   `json:"ok"`
+	UpToDate bool   `json:"up_to_date,omitempty"` // nothing was downloaded, it was complete already
+}
+
+// runSummary is the JSON payload of a generic --notify-url webhook. Text is the same
+// message Slack and Telegram get, so chat tools that read a text field (Mattermost,
+// Rocket.Chat) can take the generic payload as it is.
+type runSummary struct {
+	Status         string       `json:"status"` // success, failed, cancelled, terminated or timeout
+	ExitCode       int          `json:"exit_code"`
+	Host           string       `json:"host"`
+	Repos          []notifyRepo `json:"repos"`
+	BytesReceived  int64        `json:"bytes_received"`
+	ElapsedSeconds float64      `json:"elapsed_seconds"`
+	FinishedAt     time.Time    `json:"finished_at"`
+	Text           string       `json:"text"`
+}
+
+// newRunSummary describes a finished run of the download command.
+func newRunSummary(code int, repos []notifyRepo, started time.Time) runSummary {
+	host, _ := os.Hostname()
+	s := runSummary{
+		Status:         exitStatus(code),
+		ExitCode:       code,
+		Host:           host,
+		Repos:          repos,
+		BytesReceived:  usage.received(),
+		ElapsedSeconds: time.Since(started).Seconds(),
+		FinishedAt:     time.Now().UTC(),
+	}
+	s.Text = s.message()
+	return s
+}
+
+// message is the summary as a few lines of plain text for chat messages.
+func (s runSummary) message() string {
+	var b strings.Builder
+	size, unit := convertBytes(float64(s.BytesReceived))
+	fmt.Fprintf(&b, "huggingface-go on %s: download %s (exit code %d) after %s, %.2f %s received", s.Host, s.Status, s.ExitCode, time.Duration(s.ElapsedSeconds*float64(time.Second)).Round(time.Second), size, unit)
+	for i, repo := range s.Repos {
+		if i == notifyMaxRepos {
+			fmt.Fprintf(&b, "\n... and %d more", len(s.Repos)-i)
+			break
+		}
+		state := "failed"
+		switch {
+		case repo.UpToDate:
+			state = "already up to date"
+		case repo.OK:
+			state = "ok"
+		}
+		fmt.Fprintf(&b, "\n%s %s@%s: %s", repo.Type, repo.Repo, repo.Revision, state)
+		if repo.Folder != "" {
+			fmt.Fprintf(&b, " (%s)", repo.Folder)
+		}
+	}
+	return b.String()
+}
+
+// notifyFormat tells which payload a --notify-url gets: slack for Slack incoming
+// webhooks (hooks.slack.com, or any url written as slack+https://... for compatible
+// servers), telegram for the sendMessage method of the Telegram bot api with chat_id
+// in the query, generic JSON otherwise. Returns the url to post to.
+func notifyFormat(rawURL string) (format, target string) {
+	if rest, ok := strings.CutPrefix(rawURL, "slack+"); ok {
+		return "slack", rest
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "generic", rawURL
+	}
+	switch {
+	case u.Host == "hooks.slack.com":
+		return "slack", rawURL
+	case u.Host == "api.telegram.org":
+		return "telegram", rawURL
+	}
+	return "generic", rawURL
+}
+
+// checkNotifyURL rejects a --notify-url that cannot work and keeps the secrets in it
+// (the path of a Slack webhook, the token of a Telegram bot, query values) out of the
+// output. Paths of other webhooks are not registered: /api or /hooks would be replaced
+// in every url printed afterwards.
+func checkNotifyURL(rawURL string) error {
+	format, target := notifyFormat(rawURL)
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("expected an http(s) url, got %q", rawURL)
+	}
+	if format == "telegram" && (u.Query().Get("chat_id") == "" || !strings.HasSuffix(u.Path, "/sendMessage")) {
+		return fmt.Errorf("expected https://api.telegram.org/bot<token>/sendMessage?chat_id=<chat>")
+	}
+	addURLSecrets(target)
+	switch format {
+	case "slack":
+		// hooks.slack.com/services/T000/B000/XXXX 和 Mattermost 的 /hooks/xxxx，最后一段是密钥
+		if key := path.Base(u.Path); len(key) >= 16 {
+			addSecret(key)
+		}
+	case "telegram":
+		// /bot<token>/sendMessage
+		addSecret(strings.TrimPrefix(strings.Split(strings.TrimPrefix(u.Path, "/"), "/")[0], "bot"))
+	}
+	return nil
+}
+
+// notifyClient sends the notifications with a transport of its own: the token, the
+// usage ledger and the headers of an endpoint profile are for the hosts downloaded
+// from, not for webhooks. Only the --proxy (or HTTPS_PROXY) of every connection applies.
+func notifyClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{Proxy: networkProxy, TLSHandshakeTimeout: 10 * time.Second}}
+}
+
+// notify posts the summary to every url, each in its format. A notification that
+// cannot be sent is reported and does not change the exit code.
+func notify(urls []string, summary runSummary, retries int, retryDelay time.Duration) {
+	for _, rawURL := range urls {
+		format, target := notifyFormat(rawURL)
+		var payload interface{} = summary
+		switch format {
+		case "slack":
+			payload = map[string]string{"text": summary.Text}
+		case "telegram":
+			u, _ := url.Parse(target)
+			payload = map[string]string{"chat_id": u.Query().Get("chat_id"), "text": summary.Text}
+		}
+		data, err := json.Marshal(payload)
+		if err == nil {
+			err = postNotification(target, data, retries, retryDelay)
+		}
+		if err != nil {
+			// 地址里有 webhook 的密钥，只打印主机名
+			host := target
+			if u, perr := url.Parse(target); perr == nil {
+				host = u.Host
+			}
+			fmt.Fprintf(stdout, "Cannot send the %s notification to %s: %v\n", format, host, err)
+		}
+	}
+}
+
+// postNotification posts data, again after server errors, 429 and network errors.
+func postNotification(target string, data []byte, retries int, retryDelay time.Duration) error {
+	client := notifyClient()
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		// 运行可能是被取消的，通知还是要发出去
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+		if err != nil {
+			cancel()
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, err := client.Do(request)
+		retry := err != nil
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode < 300 {
+				cancel()
+				return nil
+			}
+			err = fmt.Errorf("%s", response.Status)
+			retry = response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500
+		}
+		cancel()
+		if !retry || attempt >= retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, time.Minute)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// FAT32 单个文件最大 4 GB - 1 字节
+const fatMaxFileSize = 1<<32 - 1
+
+// 分片比上限小 1 MiB
+const fatPartSize = 1<<32 - 1<<20
+
+// What --oversize does with files larger than the target file system can hold.
+const (
+	oversizeFail  = "fail"  // abort before downloading anything
+	oversizeWarn  = "warn"  // download anyway, e.g. when the check is wrong
+	oversizeSplit = "split" // store them as parts with a rejoin manifest
+)
+
+const splitManifestSuffix = ".parts.json"
+
+func validOversizePolicy(policy string) bool {
+	switch policy {
+	case oversizeFail, oversizeWarn, oversizeSplit:
+		return true
+	}
+	return false
+}
+
+// splitManifest is written as <file>.parts.json next to the parts of a split file.
+type splitManifest struct {
+	Path   string      `json:"path"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256,omitempty"`
+	Parts  []splitPart `json:"parts"`
+}
+
+type splitPart struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+func splitPartPath(filePath string, i int) string {
+	return fmt.Sprintf("%s.part%03d", filePath, i)
+}
+
+func splitPartCount(size int64) int {
+	return int((size + fatPartSize - 1) / fatPartSize)
+}
+
+// checkFileSizeLimits is the preflight for --oversize: it looks up the file system of
+// the folder each entry goes to and returns the entries that have to be split. With
+// the fail policy any file that cannot be stored is an error.
+func checkFileSizeLimits(entries []hfdl.FileEntry, folderOf func(hfdl.FileEntry) string, policy string) (map[string]bool, error) {
+	split := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Size <= fatMaxFileSize {
+			continue
+		}
+		folder := folderOf(entry)
+		limit, fsName := maxFileSize(folder)
+		if limit == 0 || entry.Size <= limit {
+			continue
+		}
+		size, unit := convertBytes(float64(entry.Size))
+		switch policy {
+		case oversizeWarn:
+			fmt.Fprintf(stdout, "Warning: %s is %.2f %s but %s is on %s, which cannot hold files over 4 GB; writing it will fail\n", entry.Path, size, unit, folder, fsName)
+		case oversizeSplit:
+			fmt.Fprintf(stdout, "%s is %.2f %s and %s is on %s, storing it as %d parts\n", entry.Path, size, unit, folder, fsName, splitPartCount(entry.Size))
+			split[entry.Path] = true
+		default:
+			return nil, fmt.Errorf("%s is %.2f %s but %s is on %s, which cannot hold files over 4 GB; download to another disk, reformat this one as exFAT or NTFS, or pass --oversize split", entry.Path, size, unit, folder, fsName)
+		}
+	}
+	return split, nil
+}
+
+// downloadSplit downloads a file as parts of at most fatPartSize bytes named
+// <file>.part000, <file>.part001, ..., checks the sha256 of all of them in order and
+// writes the rejoin manifest. Complete parts are kept, a partial one is resumed.
+func downloadSplit(ctx context.Context, d *hfdl.Downloader, resolvePath, filePath string, size int64, sha string) error {
+	h := sha256.New()
+	manifest := splitManifest{Path: filepath.Base(filePath), Size: size, SHA256: sha}
+	count := splitPartCount(size)
+	for i, offset := 0, int64(0); offset < size; i++ {
+		length := int64(fatPartSize)
+		if size-offset < length {
+			length = size - offset
+		}
+		name := splitPartPath(filePath, i)
+		fmt.Fprintf(stdout, "Part %d/%d: %s\n", i+1, count, filepath.Base(name))
+		if err := downloadPart(ctx, d, resolvePath, name, offset, length, h); err != nil {
+			return err
+		}
+		manifest.Parts = append(manifest.Parts, splitPart{Name: filepath.Base(name), Size: length})
+		offset += length
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); sha != "" && digest != sha {
+		for i := 0; i < count; i++ {
+			os.Remove(splitPartPath(filePath, i))
+		}
+		return fmt.Errorf("sha256 of the parts is %s, expected %s", digest, sha)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filePath + splitManifestSuffix + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filePath+splitManifestSuffix)
+}
+
+// downloadPart fetches one part into its .tmp file with a Range request and feeds
+// its content, including what was already there, into h.
+func downloadPart(ctx context.Context, d *hfdl.Downloader, resolvePath, name string, offset, length int64, h hash.Hash) error {
+	if stat, err := os.Stat(name); err == nil && stat.Size() == length {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(h, file)
+		return err
+	}
+	tmpPath := name + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// 上次下了一半的分片接着下，已有的内容先算进哈希
+	done, err := io.Copy(h, io.LimitReader(file, length))
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(done); err != nil {
+		return err
+	}
+	if done < length {
+		if err := d.ReadRange(ctx, resolvePath, offset+done, length-done, io.MultiWriter(file, h)); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, name)
+}
+
+// splitFileReader returns the content of a file stored as parts, or an error when a
+// part is missing or has the wrong size.
+func splitFileReader(filePath string, parts int, size int64) (io.ReadCloser, error) {
+	var readers []io.Reader
+	var files multiCloser
+	total := int64(0)
+	for i := 0; i < parts; i++ {
+		file, err := os.Open(splitPartPath(filePath, i))
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		files = append(files, file)
+		stat, err := file.Stat()
+		if err != nil {
+			files.Close()
+			return nil, err
+		}
+		total += stat.Size()
+		readers = append(readers, file)
+	}
+	if total != size {
+		files.Close()
+		return nil, fmt.Errorf("parts have %d bytes, expected %d", total, size)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), files}, nil
+}
+
+type multiCloser []*os.File
+
+func (m multiCloser) Close() error {
+	for _, f := range m {
+		f.Close()
+	}
+	return nil
+}
+
+func readSplitManifest(filePath string) (splitManifest, error) {
+	var manifest splitManifest
+	data, err := os.ReadFile(filePath + splitManifestSuffix)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// verifySplitFile checks the parts of a split file together against the entry.
+func verifySplitFile(filePath string, manifest splitManifest, entry hfdl.FileEntry) error {
+	if manifest.Size != entry.Size {
+		return fmt.Errorf("parts hold %d bytes, expected %d", manifest.Size, entry.Size)
+	}
+	parts, err := splitFileReader(filePath, len(manifest.Parts), entry.Size)
+	if err != nil {
+		return err
+	}
+	defer parts.Close()
+	if entry.LFSOID == "" {
+		return nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, parts); err != nil {
+		return err
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != entry.LFSOID {
+		return fmt.Errorf("sha256 of the parts is %s, expected %s", digest, entry.LFSOID)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// parquet 文件末尾是 footer（thrift 编码的 FileMetaData）、4 字节的 footer 长度和 "PAR1"
+const parquetMagic = "PAR1"
+
+// 会用到的 parquet.thrift 字段编号
+const (
+	fileMetaSchema       = 2
+	fileMetaNumRows      = 3
+	fileMetaRowGroups    = 4
+	fileMetaColumnOrders = 7
+
+	schemaName        = 4
+	schemaNumChildren = 5
+
+	rowGroupColumns        = 1
+	rowGroupTotalByteSize  = 2
+	rowGroupNumRows        = 3
+	rowGroupSortingColumns = 4
+	rowGroupFileOffset     = 5
+	rowGroupCompressedSize = 6
+	rowGroupOrdinal        = 7
+
+	columnChunkFilePath          = 1
+	columnChunkFileOffset        = 2
+	columnChunkMetaData          = 3
+	columnChunkOffsetIndexOffset = 4
+	columnChunkOffsetIndexLength = 5
+	columnChunkColumnIndexOffset = 6
+	columnChunkColumnIndexLength = 7
+
+	columnMetaUncompressedSize  = 6
+	columnMetaCompressedSize    = 7
+	columnMetaDataPageOffset    = 9
+	columnMetaIndexPageOffset   = 10
+	columnMetaDictionaryOffset  = 11
+	columnMetaBloomFilterOffset = 14
+	columnMetaBloomFilterLength = 15
+)
+
+// parquet 的 row group 序号（RowGroup.ordinal）是 i16，--row-groups 不能超过它，
+// 否则 0-2000000000 这样的范围会把内存和 .complete 撑爆
+const rowGroupMax = 1<<15 - 1
+
+// parquetSelection is what --columns and --row-groups keep of a parquet file.
+type parquetSelection struct {
+	columns   []string     // top-level column names, empty keeps all
+	rowGroups map[int]bool // row group indexes, nil keeps all
+}
+
+func (s parquetSelection) enabled() bool {
+	return len(s.columns) > 0 || s.rowGroups != nil
+}
+
+// sameAs reports whether a file recorded in the marker was stored with this selection.
+func (s parquetSelection) sameAs(f markerFile) bool {
+	return slices.Equal(f.Columns, s.columns) && slices.Equal(f.RowGroups, s.rowGroupList())
+}
+
+// rowGroupList returns the selected row groups in order, nil when all are kept.
+func (s parquetSelection) rowGroupList() []int {
+	var groups []int
+	for g := range s.rowGroups {
+		groups = append(groups, g)
+	}
+	sort.Ints(groups)
+	return groups
+}
+
+// parseRowGroups parses --row-groups, e.g. 0,3,10-19.
+func parseRowGroups(value string) (map[int]bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	groups := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid row group %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid row group range %q", part)
+			}
+		}
+		if end > rowGroupMax {
+			return nil, fmt.Errorf("row group %d is beyond the largest possible one, %d", end, rowGroupMax)
+		}
+		for i := start; i <= end; i++ {
+			groups[i] = true
+		}
+	}
+	return groups, nil
+}
+
+// parquetRange is a byte range copied from the remote file into the new one.
+type parquetRange struct {
+	offset, length int64
+}
+
+// fetchParquetColumns reads the footer of a remote parquet file with Range requests
+// and writes a valid parquet file to filePath that holds only the selected columns and
+// row groups, fetching only their column chunks. It returns the bytes transferred.
+func fetchParquetColumns(ctx context.Context, d *hfdl.Downloader, resolvePath, filePath string, fileSize int64, sel parquetSelection) (int64, error) {
+	if fileSize < 12 {
+		return 0, fmt.Errorf("too small for a parquet file")
+	}
+	var tail bytes.Buffer
+	if err := d.ReadRange(ctx, resolvePath, fileSize-8, 8, &tail); err != nil {
+		return 0, err
+	}
+	if string(tail.Bytes()[4:]) != parquetMagic {
+		return 0, fmt.Errorf("not a parquet file or an encrypted one")
+	}
+	footerSize := int64(binary.LittleEndian.Uint32(tail.Bytes()))
+	if footerSize > fileSize-12 {
+		return 0, fmt.Errorf("invalid footer length %d", footerSize)
+	}
+	var footer bytes.Buffer
+	if err := d.ReadRange(ctx, resolvePath, fileSize-8-footerSize, footerSize, &footer); err != nil {
+		return 0, err
+	}
+	meta, err := decodeThriftStruct(footer.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse the parquet footer: %v", err)
+	}
+	ranges, err := pruneParquetMeta(meta, sel)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath := filePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+	transferred := 8 + footerSize
+	if _, err := file.WriteString(parquetMagic); err != nil {
+		return 0, err
+	}
+	// 相邻的列块合并成一个请求
+	for i := 0; i < len(ranges); {
+		r := ranges[i]
+		for i++; i < len(ranges) && ranges[i].offset == r.offset+r.length; i++ {
+			r.length += ranges[i].length
+		}
+		if err := d.ReadRange(ctx, resolvePath, r.offset, r.length, file); err != nil {
+			return 0, err
+		}
+		transferred += r.length
+	}
+	encoded := encodeThriftStruct(meta)
+	if _, err := file.Write(encoded); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(file, binary.LittleEndian, uint32(len(encoded))); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteString(parquetMagic); err != nil {
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, err
+	}
+	return transferred, os.Rename(tmpPath, filePath)
+}
+
+// pruneParquetMeta drops the unselected columns and row groups from the footer and
+// moves the offsets of the kept column chunks to where they will be in the new file,
+// which starts with the magic and then holds the returned ranges back to back.
+// Page indexes, bloom filters and sorting columns are not copied, so their fields are dropped.
+func pruneParquetMeta(meta *thriftStructValue, sel parquetSelection) ([]parquetRange, error) {
+	schema := meta.list(fileMetaSchema)
+	if schema == nil || schema.elem != thriftStruct || len(schema.values) == 0 {
+		return nil, fmt.Errorf("parquet footer has no schema")
+	}
+	root := schema.values[0].(*thriftStructValue)
+	children, _ := root.int(schemaNumChildren)
+
+	// 顶层每一列在 schema 里是一棵子树，叶子节点按顺序对应 row group 里的列块
+	keptSchema := []interface{}{root}
+	var keptLeaves []bool
+	var names []string
+	found := make(map[string]bool)
+	pos := 1
+	for c := int64(0); c < children; c++ {
+		if pos >= len(schema.values) {
+			return nil, fmt.Errorf("parquet schema is truncated")
+		}
+		top := schema.values[pos].(*thriftStructValue)
+		name := top.string(schemaName)
+		names = append(names, name)
+		keep := len(sel.columns) == 0
+		for _, column := range sel.columns {
+			keep = keep || column == name
+		}
+		found[name] = keep
+		end, leaves, err := parquetSubtree(schema.values, pos)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			keptSchema = append(keptSchema, schema.values[pos:end]...)
+		}
+		for i := 0; i < leaves; i++ {
+			keptLeaves = append(keptLeaves, keep)
+		}
+		pos = end
+	}
+	for _, column := range sel.columns {
+		if !found[column] {
+			return nil, fmt.Errorf("no column %q, the file has %s", column, strings.Join(names, ", "))
+		}
+	}
+	kept := int64(0)
+	for _, column := range names {
+		if found[column] {
+			kept++
+		}
+	}
+	schema.values = keptSchema
+	root.setInt(schemaNumChildren, kept)
+
+	if orders := meta.list(fileMetaColumnOrders); orders != nil && len(orders.values) == len(keptLeaves) {
+		var values []interface{}
+		for i, v := range orders.values {
+			if keptLeaves[i] {
+				values = append(values, v)
+			}
+		}
+		orders.values = values
+	}
+
+	var ranges []parquetRange
+	newOffset := int64(len(parquetMagic))
+	var numRows int64
+	rowGroups := meta.list(fileMetaRowGroups)
+	if rowGroups == nil || rowGroups.elem != thriftStruct {
+		return nil, fmt.Errorf("parquet footer has no row groups")
+	}
+	var keptGroups []interface{}
+	for g, v := range rowGroups.values {
+		if sel.rowGroups != nil && !sel.rowGroups[g] {
+			continue
+		}
+		group := v.(*thriftStructValue)
+		columns := group.list(rowGroupColumns)
+		if columns == nil || columns.elem != thriftStruct || len(columns.values) != len(keptLeaves) {
+			return nil, fmt.Errorf("row group %d does not match the schema", g)
+		}
+		var keptColumns []interface{}
+		var byteSize, compressedSize int64
+		groupOffset := newOffset
+		for i, cv := range columns.values {
+			if !keptLeaves[i] {
+				continue
+			}
+			chunk := cv.(*thriftStructValue)
+			if chunk.string(columnChunkFilePath) != "" {
+				return nil, fmt.Errorf("column chunks stored in other files are not supported")
+			}
+			cm := chunk.structField(columnChunkMetaData)
+			if cm == nil {
+				return nil, fmt.Errorf("row group %d has a column without metadata (encrypted?)", g)
+			}
+			start, _ := cm.int(columnMetaDataPageOffset)
+			if dict, ok := cm.int(columnMetaDictionaryOffset); ok && dict > 0 && dict < start {
+				start = dict
+			}
+			length, _ := cm.int(columnMetaCompressedSize)
+			uncompressed, _ := cm.int(columnMetaUncompressedSize)
+			shift := newOffset - start
+			for _, id := range []int16{columnMetaDataPageOffset, columnMetaDictionaryOffset, columnMetaIndexPageOffset} {
+				if old, ok := cm.int(id); ok && old > 0 {
+					cm.setInt(id, old+shift)
+				}
+			}
+			cm.remove(columnMetaBloomFilterOffset, columnMetaBloomFilterLength)
+			if old, ok := chunk.int(columnChunkFileOffset); ok {
+				if old >= start && old <= start+length {
+					chunk.setInt(columnChunkFileOffset, old+shift)
+				} else {
+					chunk.setInt(columnChunkFileOffset, newOffset)
+				}
+			}
+			chunk.remove(columnChunkOffsetIndexOffset, columnChunkOffsetIndexLength, columnChunkColumnIndexOffset, columnChunkColumnIndexLength)
+			ranges = append(ranges, parquetRange{offset: start, length: length})
+			keptColumns = append(keptColumns, chunk)
+			newOffset += length
+			byteSize += uncompressed
+			compressedSize += length
+		}
+		columns.values = keptColumns
+		group.setInt(rowGroupTotalByteSize, byteSize)
+		group.setInt(rowGroupCompressedSize, compressedSize)
+		group.setInt(rowGroupFileOffset, groupOffset)
+		group.setInt(rowGroupOrdinal, int64(len(keptGroups)))
+		group.remove(rowGroupSortingColumns)
+		rows, _ := group.int(rowGroupNumRows)
+		numRows += rows
+		keptGroups = append(keptGroups, group)
+	}
+	for g := range sel.rowGroups {
+		if g >= len(rowGroups.values) {
+			return nil, fmt.Errorf("no row group %d, the file has %d", g, len(rowGroups.values))
+		}
+	}
+	rowGroups.values = keptGroups
+	meta.setInt(fileMetaNumRows, numRows)
+	return ranges, nil
+}
+
+// parquetSubtree returns the index after the schema subtree starting at pos and its number of leaves.
+func parquetSubtree(schema []interface{}, pos int) (int, int, error) {
+	if pos >= len(schema) {
+		return 0, 0, fmt.Errorf("parquet schema is truncated")
+	}
+	children, ok := schema[pos].(*thriftStructValue).int(schemaNumChildren)
+	if !ok || children == 0 {
+		return pos + 1, 1, nil
+	}
+	next, leaves := pos+1, 0
+	for c := int64(0); c < children; c++ {
+		end, n, err := parquetSubtree(schema, next)
+		if err != nil {
+			return 0, 0, err
+		}
+		next, leaves = end, leaves+n
+	}
+	return next, leaves, nil
+}
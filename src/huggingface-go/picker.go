@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/term"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+var errPickerCancelled = errors.New("file selection cancelled")
+
+// pickerNode is a file or directory row of the --interactive tree.
+type pickerNode struct {
+	name     string
+	depth    int
+	entry    int // index into the entries, -1 for directories
+	parent   int
+	children []int
+	size     int64
+	open     bool
+}
+
+// filePicker is the state of the --interactive tree view.
+type filePicker struct {
+	entries  []hfdl.FileEntry
+	selected []bool
+	nodes    []pickerNode // nodes[0] is the repo root
+	cursor   int          // index into visible()
+	top      int          // first visible row on screen
+}
+
+func newFilePicker(entries []hfdl.FileEntry) *filePicker {
+	p := &filePicker{entries: entries, selected: make([]bool, len(entries))}
+	p.nodes = append(p.nodes, pickerNode{entry: -1, parent: -1, open: true})
+	dirs := map[string]int{"": 0}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return entries[order[a]].Path < entries[order[b]].Path })
+	var dirNode func(dir string) int
+	dirNode = func(dir string) int {
+		if n, ok := dirs[dir]; ok {
+			return n
+		}
+		parent := dirNode(strings.TrimSuffix(path.Dir(dir), "."))
+		n := len(p.nodes)
+		p.nodes = append(p.nodes, pickerNode{name: path.Base(dir) + "/", depth: p.nodes[parent].depth + 1, entry: -1, parent: parent})
+		p.nodes[parent].children = append(p.nodes[parent].children, n)
+		dirs[dir] = n
+		return n
+	}
+	for _, i := range order {
+		p.selected[i] = true
+		parent := dirNode(strings.TrimSuffix(path.Dir(entries[i].Path), "."))
+		n := len(p.nodes)
+		p.nodes = append(p.nodes, pickerNode{name: path.Base(entries[i].Path), depth: p.nodes[parent].depth + 1, entry: i, parent: parent})
+		p.nodes[parent].children = append(p.nodes[parent].children, n)
+		for a := parent; a >= 0; a = p.nodes[a].parent {
+			p.nodes[a].size += max(entries[i].Size, 0)
+		}
+		p.nodes[n].size = max(entries[i].Size, 0)
+	}
+	return p
+}
+
+// visible returns the rows shown: the children of open directories, depth first.
+func (p *filePicker) visible() []int {
+	var rows []int
+	var walk func(n int)
+	walk = func(n int) {
+		for _, c := range p.nodes[n].children {
+			rows = append(rows, c)
+			if p.nodes[c].entry < 0 && p.nodes[c].open {
+				walk(c)
+			}
+		}
+	}
+	walk(0)
+	return rows
+}
+
+// files calls fn for every file below node n (or n itself).
+func (p *filePicker) files(n int, fn func(entry int)) {
+	if p.nodes[n].entry >= 0 {
+		fn(p.nodes[n].entry)
+		return
+	}
+	for _, c := range p.nodes[n].children {
+		p.files(c, fn)
+	}
+}
+
+// checkbox is [x] when everything below n is selected, [ ] when nothing is and [-] otherwise.
+func (p *filePicker) checkbox(n int) string {
+	all, none := true, true
+	p.files(n, func(e int) {
+		all = all && p.selected[e]
+		none = none && !p.selected[e]
+	})
+	switch {
+	case all:
+		return "[x]"
+	case none:
+		return "[ ]"
+	}
+	return "[-]"
+}
+
+// toggle selects everything below n unless all of it is selected already.
+func (p *filePicker) toggle(n int) {
+	value := p.checkbox(n) != "[x]"
+	p.files(n, func(e int) { p.selected[e] = value })
+}
+
+// toggleExtension does the same for every file with the extension of entry, e.g. all .bin files.
+func (p *filePicker) toggleExtension(entry int) {
+	ext := path.Ext(p.entries[entry].Path)
+	value := !p.selected[entry]
+	for i, e := range p.entries {
+		if path.Ext(e.Path) == ext {
+			p.selected[i] = value
+		}
+	}
+}
+
+func (p *filePicker) selectAll(value bool) {
+	for i := range p.selected {
+		p.selected[i] = value
+	}
+}
+
+func (p *filePicker) render(width, height int) string {
+	rows := p.visible()
+	listHeight := height - 3
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	if p.cursor < p.top {
+		p.top = p.cursor
+	}
+	if p.cursor >= p.top+listHeight {
+		p.top = p.cursor - listHeight + 1
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("Select the files to download\r\n\r\n")
+	for i := p.top; i < len(rows) && i < p.top+listHeight; i++ {
+		node := p.nodes[rows[i]]
+		marker := "  "
+		if i == p.cursor {
+			marker = "> "
+		}
+		fold := "  "
+		if node.entry < 0 {
+			fold = "+ "
+			if node.open {
+				fold = "- "
+			}
+		}
+		size, unit := convertBytes(float64(node.size))
+		line := fmt.Sprintf("%s%s %s%s%s", marker, p.checkbox(rows[i]), strings.Repeat("  ", node.depth-1), fold, node.name)
+		sizeText := fmt.Sprintf("%.2f %s", size, unit)
+		if pad := width - len([]rune(line)) - len(sizeText) - 1; pad > 0 {
+			line += strings.Repeat(" ", pad) + sizeText
+		} else {
+			line += " " + sizeText
+		}
+		if i == p.cursor {
+			line = "\x1b[7m" + line + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+	count, total := 0, int64(0)
+	for i, e := range p.entries {
+		if p.selected[i] {
+			count++
+			total += max(e.Size, 0)
+		}
+	}
+	size, unit := convertBytes(float64(total))
+	allSize, allUnit := convertBytes(float64(p.nodes[0].size))
+	fmt.Fprintf(&b, "\x1b[%d;1H%d of %d files, %.2f %s of %.2f %s | space toggle  x same extension  a all  n none  <- -> fold  enter download  q quit",
+		height, count, len(p.entries), size, unit, allSize, allUnit)
+	return b.String()
+}
+
+// key applies one key press and reports whether the picker is done and confirmed.
+func (p *filePicker) key(k string, height int) (done bool, err error) {
+	rows := p.visible()
+	if len(rows) == 0 {
+		return true, nil
+	}
+	n := rows[p.cursor]
+	node := &p.nodes[n]
+	page := height - 3
+	switch k {
+	case "\x1b[A", "k":
+		p.cursor--
+	case "\x1b[B", "j":
+		p.cursor++
+	case "\x1b[5~":
+		p.cursor -= page
+	case "\x1b[6~":
+		p.cursor += page
+	case "\x1b[C", "l":
+		if node.entry < 0 {
+			node.open = true
+		}
+	case "\x1b[D", "h":
+		if node.entry < 0 && node.open {
+			node.open = false
+		} else if node.parent > 0 {
+			// 跳到上一级目录并收起
+			p.nodes[node.parent].open = false
+			for i, r := range p.visible() {
+				if r == node.parent {
+					p.cursor = i
+				}
+			}
+		}
+	case " ":
+		p.toggle(n)
+	case "x":
+		if node.entry >= 0 {
+			p.toggleExtension(node.entry)
+		}
+	case "a":
+		p.selectAll(true)
+	case "n":
+		p.selectAll(false)
+	case "\r", "\n":
+		return true, nil
+	case "q", "\x1b", "\x03":
+		return true, errPickerCancelled
+	}
+	if rows = p.visible(); p.cursor >= len(rows) {
+		p.cursor = len(rows) - 1
+	}
+	if p.cursor < 0 {
+		p.cursor = 0
+	}
+	return false, nil
+}
+
+// pickFiles shows the listing as a tree with checkboxes (--interactive) and returns
+// the entries left selected.
+func pickFiles(entries []hfdl.FileEntry) ([]hfdl.FileEntry, error) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return nil, errors.New("--interactive needs a terminal")
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return nil, err
+	}
+	// 备用屏幕，退出后恢复原来的终端内容
+	fmt.Fprint(stdout, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(stdout, "\x1b[?25h\x1b[?1049l")
+		term.Restore(in, state)
+	}()
+	p := newFilePicker(entries)
+	buf := make([]byte, 16)
+	for {
+		width, height, err := term.GetSize(out)
+		if err != nil || width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		fmt.Fprint(stdout, p.render(width, height))
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		done, err := p.key(string(buf[:n]), height)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	var picked []hfdl.FileEntry
+	for i, e := range entries {
+		if p.selected[i] {
+			picked = append(picked, e)
+		}
+	}
+	if len(picked) == 0 {
+		return nil, errors.New("no files selected")
+	}
+	return picked, nil
+}
@@ -0,0 +1,138 @@
+package hfdl
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// chunkMapSuffix is appended to the .segtmp file of a segmented download for its chunk map.
+const chunkMapSuffix = ".chunks.json"
+
+// 分段下载时每个块最大这么多，中断时最多丢掉正在下载的这几块
+const chunkMax = 64 << 20
+
+// chunkMap is the content of <file>.segtmp.chunks.json: the chunks a segmented download
+// is split into, which of them are complete and the CRC-32 of each. It is only valid
+// for the same size and sha256.
+type chunkMap struct {
+	Size   int64        `json:"size"`
+	SHA256 string       `json:"sha256,omitempty"`
+	Chunks []chunkState `json:"chunks"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type chunkState struct {
+	Start int64  `json:"start"`
+	End   int64  `json:"end"` // inclusive
+	Done  bool   `json:"done,omitempty"`
+	CRC32 uint32 `json:"crc32,omitempty"`
+}
+
+// newChunkMap splits a file into chunks, about four for each connection, and discards
+// what an earlier download left in tmpPath.
+func (d *Downloader) newChunkMap(tmpPath string, fileSize int64, wantSHA256 string) *chunkMap {
+	removeSegmented(tmpPath)
+	connections := d.segments
+	if d.adaptive != nil {
+		connections = d.adaptive.max
+	}
+	chunkSize := min(max(fileSize/int64(4*max(connections, 1)), adaptChunkMin), chunkMax)
+	chunks := &chunkMap{Size: fileSize, SHA256: wantSHA256, path: tmpPath + chunkMapSuffix}
+	for start := int64(0); start < fileSize; start += chunkSize {
+		chunks.Chunks = append(chunks.Chunks, chunkState{Start: start, End: min(start+chunkSize, fileSize) - 1})
+	}
+	return chunks
+}
+
+// loadChunkMap returns the chunk map of an interrupted download of the same file, or nil
+// when there is none. Complete chunks whose data no longer matches their CRC-32 (the
+// machine crashed before they reached the disk) are marked missing again.
+func (d *Downloader) loadChunkMap(tmpPath string, fileSize int64, wantSHA256 string) *chunkMap {
+	data, err := os.ReadFile(tmpPath + chunkMapSuffix)
+	if err != nil {
+		return nil
+	}
+	var chunks chunkMap
+	if err := json.Unmarshal(data, &chunks); err != nil || chunks.Size != fileSize || chunks.SHA256 != wantSHA256 {
+		return nil
+	}
+	file, err := os.Open(tmpPath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	if stat, err := file.Stat(); err != nil || stat.Size() != fileSize {
+		return nil
+	}
+	chunks.path = tmpPath + chunkMapSuffix
+	for i := range chunks.Chunks {
+		c := &chunks.Chunks[i]
+		if c.Start < 0 || c.End < c.Start || c.End >= fileSize {
+			return nil
+		}
+		if !c.Done {
+			continue
+		}
+		crc := crc32.NewIEEE()
+		if _, err := io.Copy(crc, io.NewSectionReader(file, c.Start, c.End-c.Start+1)); err != nil || crc.Sum32() != c.CRC32 {
+			d.logf("\nChunk %d-%d of %s is damaged, downloading it again\n", c.Start, c.End, tmpPath)
+			c.Done, c.CRC32 = false, 0
+		}
+	}
+	return &chunks
+}
+
+// pending returns the indexes of the chunks that are not complete.
+func (m *chunkMap) pending() []int {
+	var pending []int
+	for i, c := range m.Chunks {
+		if !c.Done {
+			pending = append(pending, i)
+		}
+	}
+	return pending
+}
+
+// completed returns the number of bytes in complete chunks.
+func (m *chunkMap) completed() int64 {
+	var n int64
+	for _, c := range m.Chunks {
+		if c.Done {
+			n += c.End - c.Start + 1
+		}
+	}
+	return n
+}
+
+// complete marks chunk i as complete with the CRC-32 of its data and saves the map.
+func (m *chunkMap) complete(i int, crc uint32) {
+	m.mu.Lock()
+	m.Chunks[i].Done, m.Chunks[i].CRC32 = true, crc
+	m.mu.Unlock()
+	m.save()
+}
+
+// save writes the map atomically; errors are ignored, the chunks are then fetched again.
+func (m *chunkMap) save() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(m.path+".tmp", data, 0644); err != nil {
+		return
+	}
+	os.Rename(m.path+".tmp", m.path)
+}
+
+// removeSegmented removes the .segtmp file of a segmented download and its chunk map.
+func removeSegmented(tmpPath string) {
+	os.Remove(tmpPath)
+	os.Remove(tmpPath + chunkMapSuffix)
+}
@@ -0,0 +1,140 @@
+package hfdl
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Client is the state that Downloaders of one application share so that together
+// they act like a single client of the hosts: the HTTP client with its connections,
+// the Throttle that backs off on 429/503, the bandwidth limit, the cap on open files,
+// the request rate and the adaptive number of connections per file. Without it every Downloader has its own, and an application
+// syncing many repos multiplies its request rate against the mirror.
+type Client struct {
+	http     *http.Client
+	throttle *Throttle
+	limiter  *RateLimiter
+	slots    *FileSlots
+	requests *RequestLimiter
+	adaptive *Concurrency
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// ClientHTTP sends the requests through client instead of http.DefaultClient.
+func ClientHTTP(client *http.Client) ClientOption {
+	return func(c *Client) { c.http = client }
+}
+
+// ClientRateLimit caps the combined download speed, 0 means no limit.
+func ClientRateLimit(bytesPerSecond int64) ClientOption {
+	return func(c *Client) { c.limiter = NewRateLimiter(bytesPerSecond) }
+}
+
+// ClientRequestRate caps the number of requests started per second, 0 means no limit.
+func ClientRequestRate(perSecond float64) ClientOption {
+	return func(c *Client) { c.requests = NewRequestLimiter(perSecond) }
+}
+
+// ClientAdaptiveSegments lets the number of connections per large file float between
+// 1 and ceiling, starting at start, see Concurrency.
+func ClientAdaptiveSegments(start, ceiling int) ClientOption {
+	return func(c *Client) { c.adaptive = NewConcurrency(start, ceiling) }
+}
+
+// ClientFileSlots caps the number of target files open at the same time.
+func ClientFileSlots(slots *FileSlots) ClientOption {
+	return func(c *Client) { c.slots = slots }
+}
+
+// NewClient returns a Client to pass to every Downloader with WithClient.
+func NewClient(options ...ClientOption) *Client {
+	c := &Client{http: http.DefaultClient, throttle: NewThrottle()}
+	for _, option := range options {
+		option(c)
+	}
+	if c.slots == nil {
+		c.slots = NewFileSlots(256)
+	}
+	if c.adaptive != nil {
+		// 限流的 429/503 由 Throttle 重试，从它那里数
+		c.adaptive.throttle = c.throttle
+	}
+	return c
+}
+
+// Concurrency returns the controller of ClientAdaptiveSegments, nil without it.
+func (c *Client) Concurrency() *Concurrency {
+	return c.adaptive
+}
+
+// WithClient makes the Downloader use the connections and limits of c. Options given
+// after it still override single parts, e.g. WithHTTPClient.
+func WithClient(c *Client) Option {
+	return func(d *Downloader) {
+		d.client, d.throttle, d.limiter, d.slots, d.requests, d.adaptive = c.http, c.throttle, c.limiter, c.slots, c.requests, c.adaptive
+	}
+}
+
+// RequestLimiter spaces out the start of requests to at most a given number per
+// second. Waiting for a turn ends early when the request's context is cancelled.
+type RequestLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRequestLimiter returns a limiter for perSecond requests, or nil (no limit) when it is not positive.
+func NewRequestLimiter(perSecond float64) *RequestLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RequestLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next request may start. A nil limiter returns at once.
+func (l *RequestLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, delay)
+}
+
+// sleepContext waits for delay or until ctx is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// do sends request after waiting for the request rate and through the throttle.
+func (d *Downloader) do(client *http.Client, request *http.Request) (*http.Response, error) {
+	if err := d.requests.wait(request.Context()); err != nil {
+		return nil, err
+	}
+	response, err := d.throttle.do(client, request)
+	// 429 和 503 已经由 Throttle 记下，Concurrency 从那里数
+	if err == nil && response.StatusCode >= 500 && response.StatusCode != http.StatusServiceUnavailable {
+		d.adaptive.failed()
+	}
+	return response, err
+}
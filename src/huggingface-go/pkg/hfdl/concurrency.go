@@ -0,0 +1,183 @@
+package hfdl
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 每隔这么久根据这段时间的总速度和出错次数调整一次
+	adaptPeriod = 10 * time.Second
+	// 多加一个连接后速度至少要提高这么多才算有用
+	adaptGain = 1.1
+	// 加连接没有用时，退回去之后等这么多个周期再试
+	adaptHold = 3
+	// 自动调整时大文件切成的块至少这么大
+	adaptChunkMin = 8 << 20
+)
+
+// Concurrency picks the number of connections large files are downloaded with from
+// what it observes, instead of a fixed --segments: it adds one connection at a time
+// while that makes the combined download faster, goes back when it does not, and halves
+// the connections when the hosts answer 429 or 5xx. With LimitWorkers it also sets how
+// many downloads run at the same time: once every file has the most connections, more
+// downloads are tried the same way, and they are halved with the connections. Share one
+// between Downloaders, see ClientAdaptiveSegments.
+type Concurrency struct {
+	bytes    int64 // received by file transfers since the last adjustment
+	failures int32 // 5xx answers other than 503 since the last adjustment, see failed
+
+	mu         sync.Mutex
+	current    int
+	max        int
+	workers    int // downloads at the same time, 0 without LimitWorkers
+	maxWorkers int
+	throttle   *Throttle
+	hits       int // throttle hits seen at the last adjustment
+	since      time.Time
+	previous   [2]int  // connections and workers before the last raise, 0 when not probing
+	rate       float64 // bytes per second at previous
+	hold       int
+	logf       func(format string, args ...interface{})
+}
+
+// NewConcurrency returns a controller starting at start connections per file and
+// staying between 1 and ceiling.
+func NewConcurrency(start, ceiling int) *Concurrency {
+	ceiling = max(ceiling, 1)
+	return &Concurrency{current: min(max(start, 1), ceiling), max: ceiling, since: time.Now()}
+}
+
+// setLogger makes c report to logf unless it reports somewhere already, like
+// Throttle.setLogger.
+func (c *Concurrency) setLogger(logf func(format string, args ...interface{})) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.logf == nil {
+		c.logf = logf
+	}
+}
+
+// LimitWorkers lets c also decide how many downloads run at the same time, between 1
+// and ceiling, starting at ceiling. Does nothing when c is nil.
+func (c *Concurrency) LimitWorkers(ceiling int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxWorkers = max(ceiling, 1)
+	c.workers = c.maxWorkers
+}
+
+// Workers returns the number of downloads that should run at the same time now, 0
+// when c is nil or LimitWorkers was not called.
+func (c *Concurrency) Workers() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.since) >= adaptPeriod {
+		c.adjust()
+	}
+	return c.workers
+}
+
+// level returns the number of connections a large file should use now, adjusting it
+// when a period has passed. Returns 0 when c is nil.
+func (c *Concurrency) level() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.since) >= adaptPeriod {
+		c.adjust()
+	}
+	return c.current
+}
+
+// adjust looks at the period that just ended. Called with c.mu held.
+func (c *Concurrency) adjust() {
+	elapsed := time.Since(c.since)
+	c.since = time.Now()
+	bytes := atomic.SwapInt64(&c.bytes, 0)
+	failures := int(atomic.SwapInt32(&c.failures, 0))
+	// 429 和 503 由 Throttle 数，其他 5xx 由 failed 数，每个回答只算一次
+	if c.throttle != nil {
+		hits := c.throttle.hitCount()
+		failures += hits - c.hits
+		c.hits = hits
+	}
+	if bytes == 0 && failures == 0 {
+		// 这段时间没有下载，没有可比的数据
+		return
+	}
+	rate := float64(bytes) / elapsed.Seconds()
+	switch {
+	case failures > 0:
+		c.previous, c.hold = [2]int{}, adaptHold
+		if c.current > 1 || c.workers > 1 {
+			c.current, c.workers = max(c.current/2, 1), (c.workers+1)/2
+			c.logf("\nHosts answered %d times with 429 or 5xx, lowering to %s\n", failures, c.describe())
+		}
+	case c.previous[0] > 0 && rate < c.rate*adaptGain:
+		// 多加的连接没有让总速度变快，退回去
+		c.current, c.workers, c.previous, c.hold = c.previous[0], c.previous[1], [2]int{}, adaptHold
+		c.logf("\nMore connections did not help, back to %s\n", c.describe())
+	case c.hold > 0:
+		c.hold--
+	case c.current < c.max:
+		c.previous, c.rate = [2]int{c.current, c.workers}, rate
+		c.current++
+		c.logf("\nTrying %s (%.1f MB/s so far)\n", c.describe(), rate/(1<<20))
+	case c.workers < c.maxWorkers:
+		// 每个文件的连接数已经到顶，再试多一个同时下载
+		c.previous, c.rate = [2]int{c.current, c.workers}, rate
+		c.workers++
+		c.logf("\nTrying %s (%.1f MB/s so far)\n", c.describe(), rate/(1<<20))
+	default:
+		c.previous = [2]int{}
+	}
+}
+
+// describe tells the current levels for the log. Called with c.mu held.
+func (c *Concurrency) describe() string {
+	if c.maxWorkers == 0 {
+		return fmt.Sprintf("%d connections per file", c.current)
+	}
+	return fmt.Sprintf("%d connections per file and %d downloads at the same time", c.current, c.workers)
+}
+
+// failed records a 5xx answer that the Throttle does not retry. Does nothing when c is nil.
+func (c *Concurrency) failed() {
+	if c != nil {
+		atomic.AddInt32(&c.failures, 1)
+	}
+}
+
+// reader counts what is read from r towards the throughput. Returns r when c is nil.
+func (c *Concurrency) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &countingReader{r: r, n: &c.bytes}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
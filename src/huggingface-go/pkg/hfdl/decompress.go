@@ -0,0 +1,115 @@
+package hfdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Decompressor wraps a compressed stream (gzip, zstd, ...) into the plain one.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// DownloadDecompressed downloads a compressed file and writes it decompressed to
+// filePath (through filePath+".tmp"), so the compressed copy never touches the disk.
+// fileSize and wantSHA256 describe the compressed file as listed in the repo; the
+// hash is computed on the compressed stream. A decompressor cannot pick up in the
+// middle of a stream, so an interrupted transfer starts again from the beginning,
+// on the next healthy host when the current one fails.
+func (d *Downloader) DownloadDecompressed(ctx context.Context, resolvePath, filePath string, fileSize int64, wantSHA256 string, decompress Decompressor) error {
+	d.progress.start(resolvePath, fileSize)
+	// 解压的下载每次都从头开始，收到的数据不算进度
+	err := d.retryFile(ctx, resolvePath, false, func(ctx context.Context) error {
+		return d.downloadDecompressed(ctx, resolvePath, filePath, fileSize, wantSHA256, decompress)
+	})
+	d.progress.finish(resolvePath, err)
+	return err
+}
+
+func (d *Downloader) downloadDecompressed(ctx context.Context, resolvePath, filePath string, fileSize int64, wantSHA256 string, decompress Decompressor) error {
+	tmpPath := filePath + ".tmp"
+	bar := d.startBar(resolvePath, fileSize)
+	// 局域网缓存可能没有这个文件，而且解压的下载不能续传，直接从镜像下载
+	host := d.firstMirror()
+	stalls := 0
+	for switches := 0; ; switches++ {
+		requestCtx, cancel := d.fileRequestContext(ctx)
+		err := d.fetchDecompressed(requestCtx, host, resolvePath, tmpPath, fileSize, wantSHA256, bar, decompress)
+		cancel()
+		err = fileTimeoutError(requestCtx, err)
+		if err == nil {
+			break
+		}
+		d.files().Remove(tmpPath)
+		if next, ok := d.restartSlow(host, resolvePath, err, &stalls, switches); ok {
+			host = next
+			continue
+		}
+		if switches < 2*len(d.hosts) {
+			if next := d.failover(ctx, host, err); next >= 0 {
+				host = next
+				continue
+			}
+		}
+		return cancelCause(ctx, err)
+	}
+	d.finishBar(bar)
+	return d.files().Rename(tmpPath, filePath)
+}
+
+// fetchDecompressed streams the whole file from d.hosts[host] through decompress into tmpPath.
+func (d *Downloader) fetchDecompressed(ctx context.Context, host int, resolvePath, tmpPath string, fileSize int64, wantSHA256 string, bar *fileBar, decompress Decompressor) error {
+	response, err := d.getFile(ctx, host, resolvePath, "")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return AccessError(response.StatusCode, response.Status)
+	}
+	bar.SetCurrent(0)
+	// 哈希和长度按压缩后的数据计算，和仓库列表里的一致
+	hash := sha256.New()
+	counter := &countingWriter{w: hash}
+	monitor := d.watchSpeed(ctx, response.Body, func() bool {
+		return d.healthyAlternate(host) >= 0
+	})
+	defer monitor.stop()
+	compressed := io.TeeReader(bar.NewProxyReader(d.limiter.reader(ctx, monitor)), counter)
+	err = d.writeStored(tmpPath, true, func(file io.Writer) error {
+		plain, err := decompress(compressed)
+		if err != nil {
+			return err
+		}
+		defer plain.Close()
+		if _, err := pipelineCopy(file, plain, d.writeQueue); err != nil {
+			return err
+		}
+		// 压缩流结束后可能还有填充数据，也要算进哈希
+		_, err = io.Copy(io.Discard, compressed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// 大小未知的文件只能靠流本身结束和哈希来判断完整
+	if fileSize != UnknownSize && counter.n != fileSize {
+		return fmt.Errorf("received %d compressed bytes, expected %d", counter.n, fileSize)
+	}
+	if digest := hex.EncodeToString(hash.Sum(nil)); wantSHA256 != "" && digest != wantSHA256 {
+		return fmt.Errorf("sha256 mismatch: got %s, expected %s", digest, wantSHA256)
+	}
+	return nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.w.Write(p)
+}
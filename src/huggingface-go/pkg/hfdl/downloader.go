@@ -0,0 +1,484 @@
+// Package hfdl downloads repos from the Hugging Face Hub and its mirrors.
+//
+// A Downloader lists repo files and fetches them with resume, parallel ranges,
+// host switching on slow transfers, throttling backoff and SHA-256 verification:
+//
+//	repo, _ := hfdl.ParseRepo("org/model")
+//	repo.Endpoint = "https://hf-mirror.com"
+//	d := hfdl.New([]string{repo.Endpoint, hfdl.DefaultEndpoint}, hfdl.WithProgress(true))
+//	files, err := d.ListFiles(ctx, repo, "", nil)
+//	for _, f := range files {
+//		err = d.DownloadFile(ctx, repo.ResolvePath(f.Path), filepath.Join("out", f.Path), f.Size, f.LFSOID)
+//	}
+package hfdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// 被判定为太慢的主机在这段时间内不会再被选中
+const hostCooldown = 2 * time.Minute
+
+// errStaleTmp means the .tmp file is larger than the file on the server, which answered
+// the Range request with 416.
+var errStaleTmp = errors.New("partial file is larger than the file on the server")
+
+// Downloader lists and downloads files of Hub repos. It can be shared by several
+// goroutines and repos that are served by the same hosts.
+type Downloader struct {
+	proxyURLHead string
+	hosts        []string // endpoints serving the same repo, the first one is preferred
+	peers        int      // the first peers hosts are LAN caches that may miss files
+	client       *http.Client
+	slots        *FileSlots
+	writeQueue   int
+	minSpeed     int64 // bytes per second, 0 disables switching hosts on slow transfers
+	minSpeedTime time.Duration
+	stallSpeed   int64 // bytes per second, 0 disables restarting stalled transfers
+	stallTime    time.Duration
+	retries      int           // attempts after the first that a file or listing page gets
+	retryDelay   time.Duration // wait before the first retry, doubled for every further one
+	fileTimeout  time.Duration // longest a single request for a file may take, 0 means no limit
+	rewriters    []func(url string) string
+	throttle     *Throttle
+	limiter      *RateLimiter    // nil means no bandwidth limit
+	requests     *RequestLimiter // nil means no request rate limit
+	adaptive     *Concurrency    // nil means a fixed number of segments
+	// files of at least segmentMinSize are fetched with this many parallel ranges
+	segments       int
+	segmentMinSize int64
+	bars           bool
+	progress       *Progress
+	logf           func(format string, args ...interface{})
+	redirects      *redirectCache // signed storage urls the file urls redirected to
+	storage        Storage        // nil means the local file system, see files
+
+	mu           sync.Mutex
+	hostFailures map[string]time.Time
+}
+
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithProxy sends every request through a url-rewriting proxy: urlHead is put in
+// front of the request url, or filled in when it is a template, see ProxyURL.
+func WithProxy(urlHead string) Option {
+	return func(d *Downloader) { d.proxyURLHead = urlHead }
+}
+
+// WithHTTPClient sends requests through client instead of http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Downloader) { d.client = client }
+}
+
+// WithFileSlots caps the number of target files open at the same time.
+func WithFileSlots(slots *FileSlots) Option {
+	return func(d *Downloader) { d.slots = slots }
+}
+
+// WithWriteQueue sets how many 256KB buffers are queued between network reads and disk writes.
+func WithWriteQueue(depth int) Option {
+	return func(d *Downloader) { d.writeQueue = depth }
+}
+
+// WithMinSpeed switches to another host when a transfer stays below bytesPerSecond for window.
+func WithMinSpeed(bytesPerSecond int64, window time.Duration) Option {
+	return func(d *Downloader) { d.minSpeed, d.minSpeedTime = bytesPerSecond, window }
+}
+
+// WithStallRestart reconnects a transfer that stays below bytesPerSecond for window and
+// resumes it with a Range request, on another host when there is a healthy one. A file
+// is given up after a few restarts.
+func WithStallRestart(bytesPerSecond int64, window time.Duration) Option {
+	return func(d *Downloader) { d.stallSpeed, d.stallTime = bytesPerSecond, window }
+}
+
+// WithRetries retries files and listing pages that failed with a server error, a timeout
+// or a dropped connection on every host up to n times, waiting delay before the first
+// retry and twice as long before each further one (with jitter). Files resume where
+// they stopped, and retries after which a file got further do not count.
+func WithRetries(n int, delay time.Duration) Option {
+	return func(d *Downloader) { d.retries, d.retryDelay = n, delay }
+}
+
+// WithFileTimeout ends a request for a file that takes longer than timeout; the file
+// is then resumed with a new request, on another host when there is a healthy one.
+// 0 means no limit.
+func WithFileTimeout(timeout time.Duration) Option {
+	return func(d *Downloader) { d.fileTimeout = timeout }
+}
+
+// WithURLRewriter rewrites every download url right before it is requested.
+func WithURLRewriter(rewrite func(url string) string) Option {
+	return func(d *Downloader) { d.rewriters = append(d.rewriters, rewrite) }
+}
+
+// WithSegments downloads files of at least minSize with n parallel Range requests; n < 2 disables it.
+func WithSegments(n int, minSize int64) Option {
+	return func(d *Downloader) { d.segments, d.segmentMinSize = n, minSize }
+}
+
+// WithThrottle shares a Throttle with other Downloaders, see also WithClient.
+func WithThrottle(t *Throttle) Option {
+	return func(d *Downloader) { d.throttle = t }
+}
+
+// WithRateLimit caps the download speed. Share one limiter between Downloaders to
+// cap them together.
+func WithRateLimit(l *RateLimiter) Option {
+	return func(d *Downloader) { d.limiter = l }
+}
+
+// WithProgress shows a progress bar for every file on the terminal.
+func WithProgress(show bool) Option {
+	return func(d *Downloader) { d.bars = show }
+}
+
+// WithProgressListener reports the progress of every file to p, see Progress.
+func WithProgressListener(p *Progress) Option {
+	return func(d *Downloader) { d.progress = p }
+}
+
+// WithPeers puts LAN caches (e.g. serve-files --pull-through) in front of the hosts.
+// Files are tried there first; a peer that misses a file or cannot be reached is
+// skipped and the file continues from the next host.
+func WithPeers(peers ...string) Option {
+	return func(d *Downloader) {
+		d.hosts = append(append([]string(nil), peers...), d.hosts...)
+		d.peers += len(peers)
+	}
+}
+
+// WithLogger receives messages about host switches, retries and throttling.
+func WithLogger(logf func(format string, args ...interface{})) Option {
+	return func(d *Downloader) { d.logf = logf }
+}
+
+// New returns a Downloader fetching files from hosts, the first one preferred and
+// the others used when it is too slow.
+func New(hosts []string, options ...Option) *Downloader {
+	d := &Downloader{
+		hosts:          hosts,
+		client:         http.DefaultClient,
+		writeQueue:     16,
+		minSpeedTime:   30 * time.Second,
+		retries:        5,
+		retryDelay:     time.Second,
+		segments:       4,
+		segmentMinSize: 256 << 20,
+		logf:           func(string, ...interface{}) {},
+		hostFailures:   make(map[string]time.Time),
+		redirects:      newRedirectCache(),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	if d.slots == nil {
+		d.slots = NewFileSlots(256)
+	}
+	if d.throttle == nil {
+		d.throttle = NewThrottle()
+	}
+	d.throttle.setLogger(d.logf)
+	d.adaptive.setLogger(d.logf)
+	return d
+}
+
+// Hosts returns the endpoints the Downloader fetches from, preferred first.
+func (d *Downloader) Hosts() []string {
+	return d.hosts
+}
+
+func (d *Downloader) startBar(resolvePath string, size int64) *fileBar {
+	bar := &fileBar{progress: d.progress, path: resolvePath}
+	switch {
+	case d.bars && size == UnknownSize:
+		// 不知道大小时只显示已下载的字节数和速度
+		bar.bar = pb.ProgressBarTemplate(`{{counters . }} {{cycle . "-" "\\" "|" "/" }} {{speed . }}`).New(0).Set(pb.Bytes, true).Start()
+	case d.bars:
+		bar.bar = pb.New64(size).Set(pb.Bytes, true).Start()
+	}
+	return bar
+}
+
+func (d *Downloader) finishBar(bar *fileBar) {
+	if bar.bar != nil {
+		bar.bar.Finish()
+	}
+}
+
+func (d *Downloader) markHostSlow(host string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hostFailures[host] = time.Now()
+}
+
+func (d *Downloader) hostHealthy(host int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return time.Since(d.hostFailures[d.hosts[host]]) > hostCooldown
+}
+
+// healthyAlternate returns the index of another host that has not been slow recently, or -1.
+func (d *Downloader) healthyAlternate(current int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := 1; i < len(d.hosts); i++ {
+		next := (current + i) % len(d.hosts)
+		if time.Since(d.hostFailures[d.hosts[next]]) > hostCooldown {
+			return next
+		}
+	}
+	return -1
+}
+
+// failover marks d.hosts[host] as failing and returns the host to continue with
+// when err may succeed elsewhere, or -1.
+func (d *Downloader) failover(ctx context.Context, host int, err error) int {
+	if ctx.Err() != nil || !retryElsewhere(err) {
+		return -1
+	}
+	next := d.healthyAlternate(host)
+	d.markHostSlow(d.hosts[host])
+	if next >= 0 {
+		d.logf("\n%s failed (%v), resuming from %s\n", d.hosts[host], err, d.hosts[next])
+	}
+	return next
+}
+
+// FileURL returns the url a file is downloaded from on the preferred host.
+func (d *Downloader) FileURL(resolvePath string) string {
+	return d.fileURL(0, resolvePath)
+}
+
+// fileURL is the url requested for resolvePath on d.hosts[host], after the url-prefix
+// proxy and any rewriters have been applied.
+func (d *Downloader) fileURL(host int, resolvePath string) string {
+	url := ProxyURL(d.proxyURLHead, d.hosts[host]+resolvePath)
+	for _, rewrite := range d.rewriters {
+		url = rewrite(url)
+	}
+	return url
+}
+
+// DownloadFile downloads resolvePath (e.g. /org/model/resolve/main/config.json)
+// into filePath+".tmp" and renames it into place once complete. An existing .tmp file is
+// resumed with a Range request. When the transfer stays below minSpeed for minSpeedTime,
+// or the host answers with a server error, times out or drops the connection, and
+// another host is healthy, the file is resumed from that host instead. A transfer that
+// stalls below stallSpeed is reconnected and resumed, see WithStallRestart.
+// If wantSHA256 is given (LFS files), the content is hashed while streaming and a mismatch
+// discards the file and downloads it once more from scratch.
+func (d *Downloader) DownloadFile(ctx context.Context, resolvePath, filePath string, fileSize int64, wantSHA256 string) error {
+	d.progress.start(resolvePath, fileSize)
+	err := d.retryFile(ctx, resolvePath, true, func(ctx context.Context) error {
+		return d.downloadFile(ctx, resolvePath, filePath, fileSize, wantSHA256)
+	})
+	d.progress.finish(resolvePath, err)
+	return err
+}
+
+func (d *Downloader) downloadFile(ctx context.Context, resolvePath, filePath string, fileSize int64, wantSHA256 string) error {
+	// 先试局域网缓存，没有这个文件或连不上时换下一个主机，已经收到的部分接着续传
+	for host := 0; host < d.peers; host++ {
+		if !d.hostHealthy(host) {
+			continue
+		}
+		err := d.downloadFrom(ctx, host, resolvePath, filePath, fileSize, wantSHA256)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		d.logf("\n%s from peer %s failed (%v), trying the next host\n", resolvePath, d.hosts[host], err)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			// 连不上的缓存一段时间内不再尝试
+			d.markHostSlow(d.hosts[host])
+		}
+	}
+	return d.downloadFrom(ctx, d.firstMirror(), resolvePath, filePath, fileSize, wantSHA256)
+}
+
+// firstMirror returns the first host after the peers that has not failed recently.
+func (d *Downloader) firstMirror() int {
+	first := d.peers
+	if !d.hostHealthy(first) {
+		// 刚出过错的镜像在冷却期内先不用
+		if next := d.healthyAlternate(first); next > first {
+			first = next
+		}
+	}
+	return first
+}
+
+// downloadFrom is DownloadFile starting at d.hosts[first].
+func (d *Downloader) downloadFrom(ctx context.Context, first int, resolvePath, filePath string, fileSize int64, wantSHA256 string) error {
+	for switches := 0; d.useSegments(filePath, fileSize); switches++ {
+		err := d.downloadSegmented(ctx, first, resolvePath, filePath, fileSize, wantSHA256)
+		if err == errNoRangeSupport {
+			d.logf("\n%s, falling back to a single connection\n", err)
+			break
+		}
+		if err != nil && switches < len(d.hosts) {
+			if next := d.failover(ctx, first, err); next >= 0 {
+				first = next
+				continue
+			}
+		}
+		return cancelCause(ctx, err)
+	}
+	tmpPath := filePath + ".tmp"
+	bar := d.startBar(resolvePath, fileSize)
+	host := first
+	mismatches, stalls := 0, 0
+	for switches := 0; ; switches++ {
+		requestCtx, cancel := d.fileRequestContext(ctx)
+		digest, err := d.fetchInto(requestCtx, host, resolvePath, tmpPath, bar, wantSHA256 != "")
+		cancel()
+		err = fileTimeoutError(requestCtx, err)
+		if err == errStaleTmp {
+			// .tmp 比服务器上的文件还大（上游换了文件或者 .tmp 坏了），续传不了，从头下载
+			d.logf("\n%s is larger than the file on the server, downloading it again\n", tmpPath)
+			if err = d.files().Remove(tmpPath); err == nil {
+				continue
+			}
+		}
+		if err == errStalled && stalls < maxStallRestarts {
+			// 停滞的连接重新建立，有空闲的其它主机就换过去
+			stalls++
+			if next := d.healthyAlternate(host); next >= 0 {
+				d.markHostSlow(d.hosts[host])
+				d.logf("\nTransfer of %s from %s stalled, resuming from %s\n", resolvePath, d.hosts[host], d.hosts[next])
+				host = next
+			} else {
+				d.logf("\nTransfer of %s from %s stalled, reconnecting (%d/%d)\n", resolvePath, d.hosts[host], stalls, maxStallRestarts)
+			}
+			continue
+		}
+		if err == errTooSlow && switches < 2*len(d.hosts) {
+			next := d.healthyAlternate(host)
+			d.markHostSlow(d.hosts[host])
+			if next >= 0 {
+				d.logf("\nTransfer from %s is too slow, resuming from %s\n", d.hosts[host], d.hosts[next])
+				host = next
+				continue
+			}
+		}
+		if err != nil && switches < 2*len(d.hosts) {
+			// 镜像出错（5xx、超时、连接中断）时换下一个主机接着续传
+			if next := d.failover(ctx, host, err); next >= 0 {
+				host = next
+				continue
+			}
+		}
+		if err != nil {
+			return cancelCause(ctx, err)
+		}
+		if wantSHA256 != "" && digest != wantSHA256 {
+			d.files().Remove(tmpPath)
+			mismatches++
+			if mismatches > 1 {
+				return fmt.Errorf("sha256 mismatch: got %s, expected %s", digest, wantSHA256)
+			}
+			d.logf("\nsha256 mismatch for %s, downloading it again\n", filePath)
+			continue
+		}
+		break
+	}
+	d.finishBar(bar)
+	return d.files().Rename(tmpPath, filePath)
+}
+
+// fetchInto appends the missing part of the file from d.hosts[host] to tmpPath.
+// With verify set it returns the hex SHA-256 of the whole file, including the part
+// that was already on disk.
+func (d *Downloader) fetchInto(ctx context.Context, host int, resolvePath, tmpPath string, bar *fileBar, verify bool) (string, error) {
+	var offset int64
+	if stat, err := d.files().Stat(tmpPath); err == nil {
+		offset = stat.Size()
+	}
+	byteRange := ""
+	if offset > 0 {
+		byteRange = strconv.FormatInt(offset, 10) + "-"
+	}
+	response, err := d.getFile(ctx, host, resolvePath, byteRange)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	truncate := false
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// 服务器不支持断点续传，从头开始
+		offset = 0
+		truncate = true
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset == 0 {
+			return "", AccessError(response.StatusCode, response.Status)
+		}
+		if total, ok := contentRangeTotal(response.Header.Get("Content-Range")); ok && total == offset {
+			// 上次已经下载完，只是还没改名
+			bar.SetCurrent(offset)
+			if !verify {
+				return "", nil
+			}
+			return d.hashStored(tmpPath)
+		}
+		return "", errStaleTmp
+	default:
+		return "", AccessError(response.StatusCode, response.Status)
+	}
+	hash := sha256.New()
+	if verify && offset > 0 {
+		// 续传时先把已经下载的部分算进哈希
+		if err := d.hashStoredPrefix(hash, tmpPath, offset); err != nil {
+			return "", err
+		}
+	}
+
+	bar.SetCurrent(offset)
+	monitor := d.watchSpeed(ctx, response.Body, func() bool {
+		return d.healthyAlternate(host) >= 0
+	})
+	defer monitor.stop()
+	err = d.writeStored(tmpPath, truncate, func(file io.Writer) error {
+		dst := file
+		if verify {
+			dst = io.MultiWriter(file, hash)
+		}
+		_, err := pipelineCopy(dst, bar.NewProxyReader(d.adaptive.reader(d.limiter.reader(ctx, monitor))), d.writeQueue)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !verify {
+		return "", nil
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contentRangeTotal returns the complete length of a Content-Range header such as
+// "bytes */1234" or "bytes 0-99/1234".
+func contentRangeTotal(header string) (int64, bool) {
+	_, total, found := strings.Cut(header, "/")
+	if !found || !strings.HasPrefix(header, "bytes ") {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(total, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,46 @@
+package hfdl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnknownSize is the Size of a file the listing gives no size for.
+const UnknownSize = -1
+
+// FileEntry is one entry of a repo tree listing.
+type FileEntry struct {
+	Type string `json:"type"` // file, directory, or whatever else the Hub returns
+	Path string `json:"path"`
+	Size int64  `json:"size"`          // UnknownSize when missing, see Downloader.FileSize
+	OID  string `json:"oid,omitempty"` // git blob id
+	// LFSOID is the SHA-256 of the file content, only known for LFS files
+	LFSOID string `json:"sha256,omitempty"`
+}
+
+// ParseFileEntry converts a raw listing object into a FileEntry, rejecting entries
+// that would otherwise blow up later (missing path, wrong field types).
+func ParseFileEntry(raw map[string]interface{}) (FileEntry, error) {
+	var entry FileEntry
+	path, ok := raw["path"].(string)
+	if !ok || path == "" {
+		return entry, fmt.Errorf("entry without a path: %v", raw)
+	}
+	entry.Path = path
+	entry.Type, _ = raw["type"].(string)
+	entry.OID, _ = raw["oid"].(string)
+	lfs, _ := raw["lfs"].(map[string]interface{})
+	if lfs != nil {
+		entry.LFSOID, _ = lfs["oid"].(string)
+		entry.LFSOID = strings.TrimPrefix(entry.LFSOID, "sha256:")
+	}
+	if size, ok := raw["size"].(float64); ok {
+		entry.Size = int64(size)
+	} else if size, ok := lfs["size"].(float64); ok {
+		entry.Size = int64(size)
+	} else if entry.Type != "directory" {
+		// 有些镜像的列表里没有大小，之后用 HEAD 请求补上
+		entry.Size = UnknownSize
+	}
+	return entry, nil
+}
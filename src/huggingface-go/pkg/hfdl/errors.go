@@ -0,0 +1,55 @@
+package hfdl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ErrSiblingFailed is the cancellation cause of transfers that were stopped because
+// another transfer of the same file failed; that failure is the error to report.
+var ErrSiblingFailed = errors.New("stopped because another transfer of the file failed")
+
+// cancelCause replaces the bare "context canceled" of a transfer stopped through ctx with
+// the reason ctx was cancelled (see context.WithCancelCause), so callers can tell a user
+// interrupt from a deadline or a failed sibling.
+func cancelCause(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+// StatusError is an unexpected HTTP status answered by a host.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+// Error explains 401/403 answers, which the Hub uses for gated and private repos.
+func (e *StatusError) Error() string {
+	switch e.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Sprintf("access denied (%s): the repo may be gated or private, accept its license on the Hub and supply a token with -t or HF_TOKEN", e.Status)
+	}
+	return fmt.Sprintf("unexpected status %s", e.Status)
+}
+
+// AccessError returns the *StatusError for an unexpected answer.
+func AccessError(status int, statusText string) error {
+	return &StatusError{Code: status, Status: statusText}
+}
+
+// retryElsewhere reports whether a failed transfer may succeed on another host:
+// server errors, timeouts and connections that broke off, but not 404 or 403.
+func retryElsewhere(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || err == errFileTimeout
+}
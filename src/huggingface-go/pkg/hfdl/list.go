@@ -0,0 +1,246 @@
+package hfdl
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	pathpkg "path"
+	"strings"
+	"time"
+)
+
+// PartialListError is returned by ListFiles together with the entries it could list
+// when parts of the tree still failed after retries. Callers that can live with an
+// incomplete listing use the entries, the others treat it like any error.
+type PartialListError struct {
+	Failed []string // folders whose listing is incomplete, "" is the repo root
+	Err    error    // the first failure
+}
+
+func (e *PartialListError) Error() string {
+	folders := make([]string, len(e.Failed))
+	for i, folder := range e.Failed {
+		folders[i] = "/" + folder
+	}
+	return fmt.Sprintf("listing of %s is incomplete: %v", strings.Join(folders, ", "), e.Err)
+}
+
+func (e *PartialListError) Unwrap() error {
+	return e.Err
+}
+
+// Filter selects the entries ListFiles returns.
+type Filter interface {
+	// Match reports whether a file is kept.
+	Match(filePath string) bool
+	// SkipDir reports whether a whole folder is left out, so it does not need to be listed.
+	SkipDir(dirPath string) bool
+}
+
+type keepAll struct{}
+
+func (keepAll) Match(string) bool   { return true }
+func (keepAll) SkipDir(string) bool { return false }
+
+// ListCheckpoint is how far a listing got. ListFilesFrom saves it after every page, so
+// an interrupted listing of a repo with hundreds of thousands of files goes on where
+// it stopped instead of starting over from the root. It can be stored as JSON.
+type ListCheckpoint struct {
+	Path    string      `json:"path"`    // folder the listing is for
+	Entries []FileEntry `json:"entries"` // of the folders that are done, directories included
+	Pending []ListPage  `json:"pending"` // folders still to list, the first one is in progress
+}
+
+// ListPage is the listing of one folder, from the page at URL on.
+type ListPage struct {
+	Folder  string      `json:"folder"`
+	URL     string      `json:"url"`
+	Entries []FileEntry `json:"entries,omitempty"` // from the pages before URL
+}
+
+// ListFiles returns every entry below path in the repo except directories.
+// Entries of other types are kept with their type.
+// Entries rejected by filter are left out, a nil filter keeps everything.
+// Pages that fail with a server or network error are retried with backoff; when a
+// folder still cannot be listed the other entries are returned with a *PartialListError.
+func (d *Downloader) ListFiles(ctx context.Context, ref Repo, path string, filter Filter) ([]FileEntry, error) {
+	return d.ListFilesFrom(ctx, ref, path, filter, nil, nil)
+}
+
+// ListFilesFrom is ListFiles going on from cp, unless it is nil, empty or for another
+// path, and calling save with the progress after every page. When the listing stops
+// early the checkpoint is saved once more, including the folders that failed.
+func (d *Downloader) ListFilesFrom(ctx context.Context, ref Repo, path string, filter Filter, cp *ListCheckpoint, save func(*ListCheckpoint)) ([]FileEntry, error) {
+	if filter == nil {
+		filter = keepAll{}
+	}
+	if save == nil {
+		save = func(*ListCheckpoint) {}
+	}
+	if cp == nil || cp.Path != path || len(cp.Pending) == 0 {
+		// recursive=true 一次返回整棵树（分页），不用每个目录请求一次；
+		// 不需要 expand=true，lfs 信息默认就有，而 expand 会让每页变小、变慢
+		cp = &ListCheckpoint{Path: path, Pending: []ListPage{{Folder: path, URL: treeURL(ref, path) + "?recursive=true"}}}
+	}
+	partial := &PartialListError{}
+	var failed []ListPage
+	for len(cp.Pending) > 0 {
+		page := &cp.Pending[0]
+		entries, next, err := d.fetchTreePageRetrying(ctx, page.URL)
+		if err != nil {
+			if ctx.Err() != nil || !retryListing(err) {
+				cp.Pending = append(cp.Pending, failed...)
+				save(cp)
+				return nil, cancelCause(ctx, err)
+			}
+			// 其余目录照样列出，缺的目录记下来，检查点里保留它下次重试
+			partial.Failed = append(partial.Failed, page.Folder)
+			if partial.Err == nil {
+				partial.Err = err
+			}
+			failed = append(failed, *page)
+			cp.Pending = cp.Pending[1:]
+			continue
+		}
+		page.Entries = append(page.Entries, entries...)
+		page.URL = next
+		if next == "" {
+			done := *page
+			cp.Pending = cp.Pending[1:]
+			cp.Entries = append(cp.Entries, done.Entries...)
+			cp.Pending = append(cp.Pending, unexpandedDirs(ref, done.Entries, filter)...)
+		}
+		save(cp)
+	}
+	cp.Pending = failed
+	if len(failed) > 0 {
+		save(cp)
+	}
+	res := make([]FileEntry, 0, len(cp.Entries))
+	for _, entries := range append([][]FileEntry{cp.Entries}, pageEntries(failed)...) {
+		for _, entry := range entries {
+			if entry.Type != "directory" && filter.Match(entry.Path) {
+				res = append(res, entry)
+			}
+		}
+	}
+	if len(partial.Failed) > 0 {
+		return res, partial
+	}
+	return res, nil
+}
+
+// retryListing is retryElsewhere without unknown host names and untrusted
+// certificates, which waiting does not fix.
+func retryListing(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+	return retryElsewhere(err)
+}
+
+func treeURL(ref Repo, path string) string {
+	url := ref.APIURL() + "/tree/" + neturl.PathEscape(ref.Revision)
+	if path != "" {
+		url += "/" + escapePath(path)
+	}
+	return url
+}
+
+// unexpandedDirs returns the listings of the directories among entries that have no
+// entries of their own. Some mirrors do not support recursive and only return the
+// first level; git has no empty directories, so those were not expanded.
+func unexpandedDirs(ref Repo, entries []FileEntry, filter Filter) []ListPage {
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		for dir := pathpkg.Dir(entry.Path); dir != "." && dir != "/" && !listed[dir]; dir = pathpkg.Dir(dir) {
+			listed[dir] = true
+		}
+	}
+	var pages []ListPage
+	for _, entry := range entries {
+		if entry.Type == "directory" && !listed[entry.Path] && !filter.SkipDir(entry.Path) {
+			pages = append(pages, ListPage{Folder: entry.Path, URL: treeURL(ref, entry.Path) + "?recursive=true"})
+		}
+	}
+	return pages
+}
+
+func pageEntries(pages []ListPage) [][]FileEntry {
+	res := make([][]FileEntry, len(pages))
+	for i, page := range pages {
+		res[i] = page.Entries
+	}
+	return res
+}
+
+// fetchTreePageRetrying fetches one page of a listing, retrying server and network
+// errors with backoff, see WithRetries.
+func (d *Downloader) fetchTreePageRetrying(ctx context.Context, url string) ([]FileEntry, string, error) {
+	for attempt := 0; ; attempt++ {
+		page, next, err := d.fetchTreePage(ctx, url)
+		if err == nil || attempt >= d.retries || ctx.Err() != nil || !retryListing(err) {
+			return page, next, err
+		}
+		backoff := d.retryBackoff(attempt)
+		d.logf("\nListing failed, retrying in %v: %v\n", backoff.Round(100*time.Millisecond), err)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return nil, "", err
+		}
+	}
+}
+
+// fetchTreePage fetches one page of a listing and returns the url of the next one.
+func (d *Downloader) fetchTreePage(ctx context.Context, url string) ([]FileEntry, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, ProxyURL(d.proxyURLHead, url), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	response, err := d.do(d.client, request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s: %w", url, AccessError(response.StatusCode, response.Status))
+	}
+	var raws []map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&raws); err != nil {
+		// 响应被截断多半是连接断了，可以重试
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, "", fmt.Errorf("%s: %w", url, err)
+		}
+		return nil, "", fmt.Errorf("%s: %v", url, err)
+	}
+	var page []FileEntry
+	for _, raw := range raws {
+		entry, err := ParseFileEntry(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		page = append(page, entry)
+	}
+	return page, nextPageURL(response.Header.Get("Link")), nil
+}
+
+// nextPageURL returns the rel="next" target of a Link header, or "" on the last page.
+func nextPageURL(link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(part, ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
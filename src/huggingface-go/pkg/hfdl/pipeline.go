@@ -0,0 +1,55 @@
+package hfdl
+
+import "io"
+
+const pipelineBufferSize = 256 << 10
+
+// pipelineCopy copies src to dst with the network reads and the disk writes running
+// in separate goroutines, connected by a queue of at most depth buffers. A slow
+// destination disk then no longer stalls the connection (and vice versa) until the
+// queue is full. depth < 1 falls back to a plain io.Copy.
+func pipelineCopy(dst io.Writer, src io.Reader, depth int) (written int64, err error) {
+	if depth < 1 {
+		return io.Copy(dst, src)
+	}
+	free := make(chan []byte, depth)
+	for i := 0; i < depth; i++ {
+		free <- make([]byte, pipelineBufferSize)
+	}
+	filled := make(chan []byte, depth)
+	stop := make(chan struct{})
+	var readErr error
+	go func() {
+		defer close(filled)
+		for {
+			var buf []byte
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+			n, err := src.Read(buf)
+			if n > 0 {
+				// 缓冲区总共只有 depth 个，这里不会阻塞
+				filled <- buf[:n]
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+		}
+	}()
+	for buf := range filled {
+		n, werr := dst.Write(buf)
+		written += int64(n)
+		if werr != nil {
+			// 读协程会在调用方关闭 response body 之后退出
+			close(stop)
+			return written, werr
+		}
+		free <- buf[:cap(buf)]
+	}
+	return written, readErr
+}
@@ -0,0 +1,70 @@
+package hfdl
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Prime sends a cheap request for every file to the first mirror (after any LAN peers,
+// which have nothing to warm) before the real transfer starts, so mirrors that fetch from the origin on first access can warm their
+// cache. method is "head" (HEAD request) or "range" (GET of the first byte).
+func (d *Downloader) Prime(ctx context.Context, resolvePaths []string, method string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	host := d.firstMirror()
+	d.logf("Priming %s for %d files with %d workers\n", d.hosts[host], len(resolvePaths), workers)
+	jobs := make(chan string)
+	var failed int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for resolvePath := range jobs {
+				if err := d.primeFile(ctx, host, resolvePath, method); err != nil && ctx.Err() == nil {
+					atomic.AddInt64(&failed, 1)
+					d.logf("Cannot prime %s: %v\n", resolvePath, err)
+				}
+			}
+		}()
+	}
+	for _, resolvePath := range resolvePaths {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- resolvePath
+	}
+	close(jobs)
+	wg.Wait()
+	if ctx.Err() != nil {
+		d.logf("Priming stopped: %v\n", context.Cause(ctx))
+		return
+	}
+	d.logf("Priming finished, %d of %d requests failed\n", failed, len(resolvePaths))
+}
+
+func (d *Downloader) primeFile(ctx context.Context, host int, resolvePath, method string) error {
+	httpMethod := http.MethodHead
+	if method == "range" {
+		httpMethod = http.MethodGet
+	}
+	request, err := http.NewRequestWithContext(ctx, httpMethod, d.fileURL(host, resolvePath), nil)
+	if err != nil {
+		return err
+	}
+	if method == "range" {
+		request.Header.Set("Range", "bytes=0-0")
+	}
+	response, err := d.do(d.client, request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 400 {
+		return AccessError(response.StatusCode, response.Status)
+	}
+	return nil
+}
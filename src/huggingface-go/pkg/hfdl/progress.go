@@ -0,0 +1,219 @@
+package hfdl
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// FileState is where a file is in its download.
+type FileState string
+
+const (
+	FileStarted     FileState = "started"
+	FileDownloading FileState = "downloading"
+	FileDone        FileState = "done"
+	FileFailed      FileState = "failed"
+)
+
+// FileEvent reports the progress of one file.
+type FileEvent struct {
+	Path    string // resolve path, e.g. /org/model/resolve/main/config.json
+	State   FileState
+	Size    int64 // as listed in the repo (compressed size for DownloadDecompressed), may be UnknownSize
+	Current int64 // bytes on disk so far, including a resumed part
+	Err     error // why the file failed
+}
+
+// Totals sums up every file a Progress has seen.
+type Totals struct {
+	Files   int   // files started
+	Done    int   // files finished
+	Failed  int   // files failed
+	Size    int64 // combined size of the started files of known size
+	Current int64 // bytes of them on disk so far
+}
+
+// ProgressListener receives the progress of downloads, e.g. to show it in a web page
+// or a GUI. OnFile is followed by OnTotals with the sums after the event, unless newer
+// sums were reported already. Calls are made one at a time from the download
+// goroutines, without holding up the other transfers' bookkeeping, and should return
+// quickly.
+type ProgressListener interface {
+	OnFile(FileEvent)
+	OnTotals(Totals)
+}
+
+// Progress tracks the files of one or more Downloaders (see WithProgressListener) and
+// forwards their events to a listener. Byte progress of a file is reported at most
+// once per interval; start, end and failure always are.
+type Progress struct {
+	listener ProgressListener
+	interval time.Duration
+
+	mu     sync.Mutex
+	files  map[string]*fileTrack
+	totals Totals
+	seq    int64 // of the last event
+
+	notifyMu sync.Mutex // the listener is called with it, not with mu
+	notified int64      // seq of the totals reported last
+}
+
+// progressReport is an event and the totals after it, to report once mu is released.
+type progressReport struct {
+	event  FileEvent
+	totals Totals
+	seq    int64
+}
+
+type fileTrack struct {
+	size, current int64
+	reported      time.Time
+}
+
+// NewProgress returns a Progress that reports to listener. Share it between
+// Downloaders to get totals over all of them.
+func NewProgress(listener ProgressListener, interval time.Duration) *Progress {
+	return &Progress{listener: listener, interval: interval, files: make(map[string]*fileTrack)}
+}
+
+// 下面的方法在 p 为 nil 时什么也不做，没有设置监听器的 Downloader 不用判断
+
+func (p *Progress) start(path string, size int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.files[path] = &fileTrack{size: size, reported: time.Now()}
+	p.totals.Files++
+	if size > 0 {
+		p.totals.Size += size
+	}
+	report := p.report(FileEvent{Path: path, State: FileStarted, Size: size})
+	p.mu.Unlock()
+	p.notify(report)
+}
+
+// set moves the position of a file, e.g. to the resumed offset or back to 0 on a retry.
+func (p *Progress) set(path string, current int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	var report *progressReport
+	if f := p.files[path]; f != nil {
+		p.totals.Current += current - f.current
+		f.current = current
+		report = p.progressed(path, f)
+	}
+	p.mu.Unlock()
+	p.notify(report)
+}
+
+func (p *Progress) add(path string, n int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	var report *progressReport
+	if f := p.files[path]; f != nil {
+		f.current += n
+		p.totals.Current += n
+		report = p.progressed(path, f)
+	}
+	p.mu.Unlock()
+	p.notify(report)
+}
+
+// progressed returns the report of a file's bytes when interval has passed since the
+// last one, nil otherwise; mu is held.
+func (p *Progress) progressed(path string, f *fileTrack) *progressReport {
+	if now := time.Now(); now.Sub(f.reported) >= p.interval {
+		f.reported = now
+		return p.report(FileEvent{Path: path, State: FileDownloading, Size: f.size, Current: f.current})
+	}
+	return nil
+}
+
+func (p *Progress) finish(path string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	f := p.files[path]
+	if f == nil {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.files, path)
+	event := FileEvent{Path: path, State: FileDone, Size: f.size, Current: f.current}
+	if err != nil {
+		event.State, event.Err = FileFailed, err
+		p.totals.Failed++
+	} else {
+		p.totals.Done++
+	}
+	report := p.report(event)
+	p.mu.Unlock()
+	p.notify(report)
+}
+
+// report copies an event and the current totals; mu is held.
+func (p *Progress) report(event FileEvent) *progressReport {
+	p.seq++
+	return &progressReport{event: event, totals: p.totals, seq: p.seq}
+}
+
+// notify passes a report to the listener, after mu was released. Totals older than
+// those already reported by another goroutine are left out.
+func (p *Progress) notify(report *progressReport) {
+	if report == nil {
+		return
+	}
+	p.notifyMu.Lock()
+	defer p.notifyMu.Unlock()
+	p.listener.OnFile(report.event)
+	if report.seq > p.notified {
+		p.notified = report.seq
+		p.listener.OnTotals(report.totals)
+	}
+}
+
+// fileBar is the progress of one transfer: the terminal bar of WithProgress, if any,
+// and the events of WithProgressListener.
+type fileBar struct {
+	bar      *pb.ProgressBar
+	progress *Progress
+	path     string
+}
+
+func (b *fileBar) SetCurrent(n int64) {
+	if b.bar != nil {
+		b.bar.SetCurrent(n)
+	}
+	b.progress.set(b.path, n)
+}
+
+// NewProxyReader counts what is read from r as progress of the file.
+func (b *fileBar) NewProxyReader(r io.Reader) io.Reader {
+	return &progressReader{r: r, bar: b}
+}
+
+type progressReader struct {
+	r   io.Reader
+	bar *fileBar
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if r.bar.bar != nil {
+			r.bar.bar.Add(n)
+		}
+		r.bar.progress.add(r.bar.path, int64(n))
+	}
+	return n, err
+}
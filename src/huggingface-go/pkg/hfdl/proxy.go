@@ -0,0 +1,45 @@
+package hfdl
+
+import (
+	"net/url"
+	"strings"
+)
+
+// proxyPlaceholders are the placeholders of a proxy template, see ProxyURL.
+var proxyPlaceholders = []string{"{url}", "{url_encoded}", "{scheme}", "{host}", "{path}"}
+
+// IsProxyTemplate reports whether proxy contains any ProxyURL placeholder.
+func IsProxyTemplate(proxy string) bool {
+	for _, placeholder := range proxyPlaceholders {
+		if strings.Contains(proxy, placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyURL returns the url requested for target through a url-rewriting proxy.
+// A plain proxy is put in front of target (-p https://proxy/ gives
+// https://proxy/https://huggingface.co/...). A template is filled in instead:
+//
+//	{url}          the target url as is
+//	{url_encoded}  the target url percent-encoded, e.g. for a query string
+//	{scheme}       https
+//	{host}         huggingface.co
+//	{path}         the path and query of the target, starting with /
+func ProxyURL(proxy, target string) string {
+	if !IsProxyTemplate(proxy) {
+		return proxy + target
+	}
+	var scheme, host, path string
+	if u, err := url.Parse(target); err == nil {
+		scheme, host, path = u.Scheme, u.Host, u.RequestURI()
+	}
+	return strings.NewReplacer(
+		"{url}", target,
+		"{url_encoded}", url.QueryEscape(target),
+		"{scheme}", scheme,
+		"{host}", host,
+		"{path}", path,
+	).Replace(proxy)
+}
@@ -0,0 +1,89 @@
+package hfdl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ReadRange writes length bytes of the file at resolvePath, starting at offset, to w.
+// When a host fails in the middle, the rest of the range is requested from the next
+// healthy host, so w receives every byte exactly once.
+func (d *Downloader) ReadRange(ctx context.Context, resolvePath string, offset, length int64, w io.Writer) error {
+	host := d.firstMirror()
+	stalls := 0
+	for switches := 0; ; switches++ {
+		n, err := d.fetchRange(ctx, host, resolvePath, offset, length, w)
+		offset, length = offset+n, length-n
+		if err == nil {
+			return nil
+		}
+		if next, ok := d.restartSlow(host, resolvePath, err, &stalls, switches); ok {
+			host = next
+			continue
+		}
+		if switches < 2*len(d.hosts) {
+			if next := d.failover(ctx, host, err); next >= 0 {
+				host = next
+				continue
+			}
+		}
+		return cancelCause(ctx, err)
+	}
+}
+
+// FileSize asks the preferred host for the size of resolvePath with a HEAD request,
+// for listings without sizes. The Hub gives the size of LFS files in X-Linked-Size,
+// other hosts in Content-Length.
+func (d *Downloader) FileSize(ctx context.Context, resolvePath string) (int64, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, d.fileURL(d.firstMirror(), resolvePath), nil)
+	if err != nil {
+		return UnknownSize, err
+	}
+	// 压缩后的 Content-Length 不是文件大小
+	request.Header.Set("Accept-Encoding", "identity")
+	response, err := d.do(d.client, request)
+	if err != nil {
+		return UnknownSize, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return UnknownSize, AccessError(response.StatusCode, response.Status)
+	}
+	if size, err := strconv.ParseInt(response.Header.Get("X-Linked-Size"), 10, 64); err == nil && size >= 0 {
+		return size, nil
+	}
+	if response.ContentLength < 0 {
+		return UnknownSize, fmt.Errorf("the server did not send the size")
+	}
+	return response.ContentLength, nil
+}
+
+func (d *Downloader) fetchRange(ctx context.Context, host int, resolvePath string, offset, length int64, w io.Writer) (int64, error) {
+	if length <= 0 {
+		return 0, nil
+	}
+	response, err := d.getFile(ctx, host, resolvePath, strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return 0, errNoRangeSupport
+	default:
+		return 0, AccessError(response.StatusCode, response.Status)
+	}
+	monitor := d.watchSpeed(ctx, response.Body, func() bool {
+		return d.healthyAlternate(host) >= 0
+	})
+	defer monitor.stop()
+	n, err := io.Copy(w, io.LimitReader(d.limiter.reader(ctx, monitor), length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+package hfdl
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket that caps the combined download speed of every
+// connection it is shared by, including the segments of one file.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for bytesPerSecond, or nil (no limit) when it is not positive.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	// 最多攒 1 秒的流量，但至少能装下一次读取
+	burst := rate
+	if burst < pipelineBufferSize {
+		burst = pipelineBufferSize
+	}
+	return &RateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take consumes n bytes and returns how long the caller has to wait before using them.
+func (l *RateLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// reader wraps r so that reads from it count against the limit. A nil limiter returns r.
+func (l *RateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// 单次读取不超过桶的容量，否则一次就会欠下很长的等待
+	if len(p) > int(r.limiter.burst) {
+		p = p[:int(r.limiter.burst)]
+	}
+	n, err := r.r.Read(p)
+	if wait := r.limiter.take(n); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.ctx.Done():
+			return n, context.Cause(r.ctx)
+		}
+	}
+	return n, err
+}
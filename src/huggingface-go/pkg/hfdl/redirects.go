@@ -0,0 +1,176 @@
+package hfdl
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 签名快到期的地址不再使用，留出一个请求的时间
+const redirectMargin = time.Minute
+
+// redirectCache remembers the presigned storage urls (S3, CloudFront, ...) that file
+// urls of the Hub redirect to, until their signature expires.
+type redirectCache struct {
+	mu      sync.Mutex
+	targets map[string]redirectTarget
+	pending map[string]chan struct{} // file urls being requested without a known target
+}
+
+type redirectTarget struct {
+	url     string
+	expires time.Time
+}
+
+func newRedirectCache() *redirectCache {
+	return &redirectCache{targets: make(map[string]redirectTarget), pending: make(map[string]chan struct{})}
+}
+
+// lookup returns the storage url original redirected to, "" when there is none that
+// is still valid.
+func (c *redirectCache) lookup(original string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	target, ok := c.targets[original]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(target.expires.Add(-redirectMargin)) {
+		delete(c.targets, original)
+		return ""
+	}
+	return target.url
+}
+
+// remember records that original ended up at final, when final is a signed url that
+// says when it expires. Other redirects may change at any time and are not kept.
+func (c *redirectCache) remember(original string, final *url.URL) {
+	if final.String() == original {
+		return
+	}
+	expires, ok := signedURLExpiry(final, time.Now())
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	c.targets[original] = redirectTarget{url: final.String(), expires: expires}
+	c.mu.Unlock()
+}
+
+func (c *redirectCache) forget(original string) {
+	c.mu.Lock()
+	delete(c.targets, original)
+	c.mu.Unlock()
+}
+
+// claim returns a channel that is closed when the request for original that is under
+// way has its answer, and whether there was none and the caller makes it, see release.
+func (c *redirectCache) claim(original string) (chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if done, ok := c.pending[original]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	c.pending[original] = done
+	return done, true
+}
+
+func (c *redirectCache) release(original string, done chan struct{}) {
+	c.mu.Lock()
+	delete(c.pending, original)
+	c.mu.Unlock()
+	close(done)
+}
+
+// signedURLExpiry returns when a presigned url stops working: X-Amz-Date plus
+// X-Amz-Expires (S3 and compatible stores), X-Goog-Date plus X-Goog-Expires (GCS),
+// Expires in unix seconds (CloudFront) or se (Azure SAS).
+func signedURLExpiry(u *url.URL, now time.Time) (time.Time, bool) {
+	query := u.Query()
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		if date, seconds := query.Get(prefix+"Date"), query.Get(prefix+"Expires"); date != "" && seconds != "" {
+			signed, err := time.Parse("20060102T150405Z", date)
+			n, nerr := strconv.ParseInt(seconds, 10, 64)
+			if err != nil || nerr != nil {
+				return time.Time{}, false
+			}
+			return signed.Add(time.Duration(n) * time.Second), true
+		}
+	}
+	if seconds, err := strconv.ParseInt(query.Get("Expires"), 10, 64); err == nil && seconds > now.Unix() {
+		return time.Unix(seconds, 0), true
+	}
+	if se, err := time.Parse(time.RFC3339, query.Get("se")); err == nil && query.Get("sig") != "" {
+		return se, true
+	}
+	return time.Time{}, false
+}
+
+// getFile requests resolvePath from d.hosts[host], with a Range header when byteRange
+// is not empty. The signed storage url the host redirects to is requested directly by
+// the next requests for the same file (the other segments, retries and resumes, the
+// column ranges of a parquet file) while it is valid, skipping the round trip through
+// the Hub or mirror. When the storage refuses it, the file url is requested again.
+func (d *Downloader) getFile(ctx context.Context, host int, resolvePath, byteRange string) (*http.Response, error) {
+	original := d.fileURL(host, resolvePath)
+	if response, ok, err := d.getRedirected(ctx, original, byteRange); ok {
+		return response, err
+	}
+	done, first := d.redirects.claim(original)
+	if first {
+		defer d.redirects.release(original, done)
+	} else {
+		// 同一个文件的其他分段先等第一个请求拿到跳转地址
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if response, ok, err := d.getRedirected(ctx, original, byteRange); ok {
+			return response, err
+		}
+	}
+	response, err := d.get(ctx, original, byteRange)
+	if err == nil && (response.StatusCode == http.StatusOK || response.StatusCode == http.StatusPartialContent) {
+		d.redirects.remember(original, response.Request.URL)
+	}
+	return response, err
+}
+
+// getRedirected requests the storage url that original redirected to before. ok is
+// false when there is none or the storage refused the request.
+func (d *Downloader) getRedirected(ctx context.Context, original, byteRange string) (*http.Response, bool, error) {
+	target := d.redirects.lookup(original)
+	if target == "" {
+		return nil, false, nil
+	}
+	response, err := d.get(ctx, target, byteRange)
+	if err == nil {
+		switch response.StatusCode {
+		case http.StatusOK, http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+			return response, true, nil
+		}
+		response.Body.Close()
+	}
+	if ctx.Err() != nil {
+		return nil, true, ctx.Err()
+	}
+	// 签名提前失效或者存储拒绝了请求，重新从 Hub 获取地址
+	d.redirects.forget(original)
+	return nil, false, nil
+}
+
+func (d *Downloader) get(ctx context.Context, rawURL, byteRange string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteRange != "" {
+		request.Header.Set("Range", "bytes="+byteRange)
+	}
+	return d.do(d.client, request)
+}
@@ -0,0 +1,300 @@
+package hfdl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// RepoType is the kind of Hub repository a URL points at.
+type RepoType string
+
+const (
+	RepoTypeModel   RepoType = "model"
+	RepoTypeDataset RepoType = "dataset"
+	RepoTypeSpace   RepoType = "space"
+)
+
+// DefaultEndpoint is the Hub itself, used for repo ids without a host.
+const DefaultEndpoint = "https://huggingface.co"
+
+// Repo describes a repository parsed from a Hub url.
+type Repo struct {
+	Endpoint string // e.g. https://huggingface.co
+	Type     RepoType
+	ID       string // e.g. Finnish-NLP/t5-large-nl36-finnish
+	Revision string
+	Path     string // folder inside the repo, may be empty
+	File     string // single file inside the repo, from blob/ and resolve/ urls
+}
+
+// ParseRepo accepts a full url, an hf:// uri or a bare repo id such as
+// org/model, datasets/org/name@revision, org/model@refs/pr/12, spaces/owner/app or
+// hf://datasets/org/name/sub/folder. Shorthand forms are resolved against DefaultEndpoint.
+func ParseRepo(arg string) (Repo, error) {
+	if strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://") {
+		return parseRepoURL(arg)
+	}
+	s := strings.Trim(strings.TrimPrefix(arg, "hf://"), "/")
+	ref := Repo{Endpoint: DefaultEndpoint, Type: RepoTypeModel, Revision: "main"}
+	parts := strings.Split(s, "/")
+	switch parts[0] {
+	case "datasets":
+		ref.Type = RepoTypeDataset
+		parts = parts[1:]
+	case "spaces":
+		ref.Type = RepoTypeSpace
+		parts = parts[1:]
+	case "models":
+		parts = parts[1:]
+	}
+	if len(parts) == 0 || parts[0] == "" {
+		return Repo{}, fmt.Errorf("cannot find repo id in %s", arg)
+	}
+	// org/name，或者没有组织名的老模型（gpt2、squad），版本号跟在 @ 后面
+	n := 2
+	if len(parts) == 1 || strings.Contains(parts[0], "@") {
+		n = 1
+	}
+	id := strings.Join(parts[:n], "/")
+	if i := strings.Index(id, "@"); i >= 0 {
+		revision, err := url.PathUnescape(id[i+1:])
+		if err != nil || revision == "" {
+			return Repo{}, fmt.Errorf("invalid revision in %s", arg)
+		}
+		ref.Revision = revision
+		id = id[:i]
+		// @refs/pr/12 的 / 被当成了路径的分隔符
+		if revision == "refs" && len(parts) >= n+2 && isSpecialRef(parts[n]) {
+			ref.Revision = strings.Join([]string{revision, parts[n], parts[n+1]}, "/")
+			n += 2
+		}
+	}
+	ref.ID = id
+	ref.Path = strings.Join(parts[n:], "/")
+	return ref, nil
+}
+
+// ParseRepoAt is ParseRepo for a Hub served at endpoint, which may have a path prefix
+// such as https://hub.example.com/hf: shorthand forms are resolved against endpoint,
+// and urls below it are parsed after the prefix.
+func ParseRepoAt(arg, endpoint string) (Repo, error) {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if rest, ok := strings.CutPrefix(arg, endpoint+"/"); ok {
+		ref, err := parseRepoURL("https://hub/" + rest)
+		if err != nil {
+			return Repo{}, fmt.Errorf("cannot find repo id in url: %s", arg)
+		}
+		ref.Endpoint = endpoint
+		return ref, nil
+	}
+	ref, err := ParseRepo(arg)
+	if err == nil && !strings.HasPrefix(arg, "http://") && !strings.HasPrefix(arg, "https://") {
+		ref.Endpoint = endpoint
+	}
+	return ref, err
+}
+
+// parseRepoURL parses urls like https://huggingface.co/datasets/org/name/tree/main/sub/folder,
+// https://huggingface.co/spaces/owner/name, the file urls .../blob/main/config.json and
+// .../resolve/main/model.safetensors, and pull requests: .../tree/refs%2Fpr%2F12 or
+// .../discussions/12.
+func parseRepoURL(raw string) (Repo, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Repo{}, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return Repo{}, fmt.Errorf("not a valid url: %s", raw)
+	}
+	ref := Repo{Endpoint: u.Scheme + "://" + u.Host, Type: RepoTypeModel, Revision: "main"}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) > 0 && parts[0] == "datasets" {
+		ref.Type = RepoTypeDataset
+		parts = parts[1:]
+	} else if len(parts) > 0 && parts[0] == "spaces" {
+		ref.Type = RepoTypeSpace
+		parts = parts[1:]
+	}
+	// 老的模型（如 gpt2）没有组织名
+	n := 2
+	if len(parts) == 1 || (len(parts) > 1 && isURLKind(parts[1])) {
+		n = 1
+	}
+	if len(parts) < n || parts[0] == "" {
+		return Repo{}, fmt.Errorf("cannot find repo id in url: %s", raw)
+	}
+	ref.ID = strings.Join(parts[:n], "/")
+	parts = parts[n:]
+	if len(parts) >= 2 && parts[0] == "discussions" {
+		// 拉取请求的页面，它的分支是 refs/pr/<编号>
+		if _, err := strconv.Atoi(parts[1]); err != nil {
+			return Repo{}, fmt.Errorf("not a pull request: %s", raw)
+		}
+		ref.Revision = "refs/pr/" + parts[1]
+		return ref, nil
+	}
+	if len(parts) >= 4 && isURLKind(parts[0]) && parts[1] == "refs" && isSpecialRef(parts[2]) {
+		// refs%2Fpr%2F12 在解码后的路径里是三段
+		parts = append([]string{parts[0], strings.Join(parts[1:4], "/")}, parts[4:]...)
+	}
+	if len(parts) >= 2 && parts[0] == "tree" {
+		ref.Revision = parts[1]
+		ref.Path = strings.Join(parts[2:], "/")
+	}
+	if len(parts) >= 3 && (parts[0] == "blob" || parts[0] == "resolve") {
+		ref.Revision = parts[1]
+		ref.File = strings.Join(parts[2:], "/")
+	}
+	return ref, nil
+}
+
+// isURLKind reports whether a path segment follows the repo id in browser urls.
+func isURLKind(segment string) bool {
+	return segment == "tree" || segment == "blob" || segment == "resolve" || segment == "discussions"
+}
+
+// isSpecialRef reports whether refs/<segment>/<name> is a ref outside branches and
+// tags: pr for pull requests, convert for the parquet conversion of datasets.
+func isSpecialRef(segment string) bool {
+	return segment == "pr" || segment == "convert"
+}
+
+// ListPath is the folder to list for the repo: Path, or the folder holding File.
+func (r Repo) ListPath() string {
+	if r.File == "" {
+		return r.Path
+	}
+	if dir := path.Dir(r.File); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// urlPrefix is the path segment in front of the repo id in browser and resolve urls.
+func (r Repo) urlPrefix() string {
+	switch r.Type {
+	case RepoTypeDataset:
+		return "/datasets/"
+	case RepoTypeSpace:
+		return "/spaces/"
+	}
+	return "/"
+}
+
+// WebURL returns the browser url of the repo, e.g. https://huggingface.co/datasets/org/name
+func (r Repo) WebURL() string {
+	return r.Endpoint + r.urlPrefix() + r.ID
+}
+
+// ResolvePath returns the download path of a file relative to the endpoint,
+// e.g. /datasets/org/name/resolve/main/data/train.parquet or /spaces/owner/app/resolve/main/app.py
+func (r Repo) ResolvePath(filePath string) string {
+	return r.urlPrefix() + r.ID + "/resolve/" + url.PathEscape(r.Revision) + "/" + escapePath(filePath)
+}
+
+// escapePath escapes each segment of a path in the repo for a url, so names with
+// #, ? or % reach the server as they are; the slashes stay.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// APIURL returns the Hub API url of the repo, e.g. https://huggingface.co/api/datasets/org/name
+func (r Repo) APIURL() string {
+	return r.Endpoint + "/api/" + string(r.Type) + "s/" + r.ID
+}
+
+// ResolveCommit returns the commit sha the revision of the repo currently points at.
+func (d *Downloader) ResolveCommit(ctx context.Context, ref Repo) (string, error) {
+	apiURL := ProxyURL(d.proxyURLHead, ref.APIURL()+"/revision/"+url.PathEscape(ref.Revision))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := d.do(d.client, request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %v", apiURL, AccessError(response.StatusCode, response.Status))
+	}
+	var info struct {
+		SHA string `json:"sha"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("%s: %v", apiURL, err)
+	}
+	if info.SHA == "" {
+		return "", fmt.Errorf("%s: no commit sha in the answer", apiURL)
+	}
+	return info.SHA, nil
+}
+
+// ResolveMoved asks the Hub API whether the repo has been renamed. The API answers
+// a renamed repo with a redirect to the new name (or a movedTo field); in that case
+// ref.ID is updated and the old id is returned, otherwise "" is returned.
+func (d *Downloader) ResolveMoved(ctx context.Context, ref *Repo) (string, error) {
+	client := *d.client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	oldID := ref.ID
+	// 仓库可能被连续改名多次
+	for i := 0; i < 5; i++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, ProxyURL(d.proxyURLHead, ref.APIURL()), nil)
+		if err != nil {
+			return "", err
+		}
+		response, err := d.do(&client, request)
+		if err != nil {
+			return "", err
+		}
+		var newID string
+		switch {
+		case response.StatusCode >= 300 && response.StatusCode < 400:
+			newID = movedRepoID(response.Header.Get("Location"), ref.Type)
+		case response.StatusCode == http.StatusOK:
+			var info struct {
+				MovedTo string `json:"movedTo"`
+			}
+			json.NewDecoder(response.Body).Decode(&info)
+			newID = info.MovedTo
+		default:
+			response.Body.Close()
+			return "", fmt.Errorf("%s: %s", ref.APIURL(), response.Status)
+		}
+		response.Body.Close()
+		if newID == "" || newID == ref.ID {
+			break
+		}
+		ref.ID = newID
+	}
+	if ref.ID == oldID {
+		return "", nil
+	}
+	return oldID, nil
+}
+
+// movedRepoID extracts the repo id from a redirect location such as /api/models/org/new-name
+func movedRepoID(location string, repoType RepoType) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return ""
+	}
+	prefix := "/api/" + string(repoType) + "s/"
+	i := strings.Index(u.Path, prefix)
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(u.Path[i+len(prefix):], "/")
+}
@@ -0,0 +1,84 @@
+package hfdl
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 重试前的等待从 retryDelay 开始翻倍，最多等这么久
+const maxRetryDelay = 5 * time.Minute
+
+// errFileTimeout ends a file request that took longer than the file timeout.
+var errFileTimeout = errors.New("request took longer than the file timeout")
+
+// retryBackoff returns how long to wait before retry number attempt, counted from 0:
+// the retry delay doubled for every earlier attempt, up to maxRetryDelay. The wait is
+// picked at random from the upper half, so that the workers a hiccup of the mirror
+// failed together do not all come back at the same moment.
+func (d *Downloader) retryBackoff(attempt int) time.Duration {
+	backoff := d.retryDelay
+	for i := 0; i < attempt && backoff < maxRetryDelay; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRetryDelay)
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// receivedKey is the context key of the bytes an attempt of retryFile received,
+// counted by the speedMonitor of every response body read under that context.
+type receivedKey struct{}
+
+// retryFile runs download until it succeeds, fails with an error that retrying does not
+// help with, or has failed d.retries times in a row. When resumable, an attempt that
+// received data is resumed right away and does not count against the retries: a large
+// file on a flaky connection keeps resuming as long as it gets further. The bytes are
+// counted as they arrive, not on disk, where a segmented .tmp file has its full size
+// from the start.
+func (d *Downloader) retryFile(ctx context.Context, resolvePath string, resumable bool, download func(ctx context.Context) error) error {
+	failures := 0
+	for {
+		var received atomic.Int64
+		err := download(context.WithValue(ctx, receivedKey{}, &received))
+		if err == nil || ctx.Err() != nil || !retryElsewhere(err) {
+			return err
+		}
+		if resumable && received.Load() > 0 {
+			// 这次下载到了新的数据，马上接着续传
+			failures = 0
+			d.logf("\n%s failed (%v), resuming\n", resolvePath, err)
+			continue
+		}
+		if failures >= d.retries {
+			return err
+		}
+		delay := d.retryBackoff(failures)
+		failures++
+		d.logf("\n%s failed (%v), retrying in %v (%d/%d)\n", resolvePath, err, delay.Round(100*time.Millisecond), failures, d.retries)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// fileRequestContext limits one request for a file to the file timeout.
+func (d *Downloader) fileRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.fileTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, d.fileTimeout, errFileTimeout)
+}
+
+// fileTimeoutError replaces the error of a request that ran into the file timeout of
+// its context with errFileTimeout.
+func fileTimeoutError(ctx context.Context, err error) error {
+	if err != nil && context.Cause(ctx) == errFileTimeout {
+		return errFileTimeout
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package hfdl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errNoRangeSupport = errors.New("server does not support range requests")
+
+// useSegments reports whether a file is big enough to be split into parallel ranges.
+// A single-stream partial download in progress is resumed as is.
+func (d *Downloader) useSegments(filePath string, fileSize int64) bool {
+	if (d.segments < 2 && d.adaptive == nil) || fileSize < d.segmentMinSize || d.storage != nil {
+		return false
+	}
+	_, err := os.Stat(filePath + ".tmp")
+	return os.IsNotExist(err)
+}
+
+// downloadSegmented downloads the file with d.segments parallel Range requests that
+// write chunks into a preallocated filePath+".segtmp" at their offsets, then renames it
+// into place. The chunks are recorded in a chunk map next to it, so an interrupted
+// download fetches only the chunks that are missing or no longer match their CRC-32.
+func (d *Downloader) downloadSegmented(ctx context.Context, host int, resolvePath, filePath string, fileSize int64, wantSHA256 string) error {
+	tmpPath := filePath + ".segtmp"
+	bar := d.startBar(resolvePath, fileSize)
+	for attempt := 0; ; attempt++ {
+		chunks := d.loadChunkMap(tmpPath, fileSize, wantSHA256)
+		if chunks == nil {
+			chunks = d.newChunkMap(tmpPath, fileSize, wantSHA256)
+		}
+		bar.SetCurrent(chunks.completed())
+		if err := d.fetchSegments(ctx, host, resolvePath, tmpPath, chunks, bar); err != nil {
+			// 已经完成的块留着，下次只下载其余的；不支持 Range 时改用一个连接，分段的文件没有用了
+			if err == errNoRangeSupport {
+				removeSegmented(tmpPath)
+			}
+			return err
+		}
+		if wantSHA256 == "" {
+			break
+		}
+		digest, err := HashFile(tmpPath)
+		if err != nil {
+			return err
+		}
+		if digest == wantSHA256 {
+			break
+		}
+		// 块的 CRC 只能发现写坏的块，哈希不对时不知道是哪一块，整个重新下载
+		removeSegmented(tmpPath)
+		if attempt > 0 {
+			return fmt.Errorf("sha256 mismatch: got %s, expected %s", digest, wantSHA256)
+		}
+		d.logf("\nsha256 mismatch for %s, downloading it again\n", filePath)
+	}
+	d.finishBar(bar)
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	os.Remove(tmpPath + chunkMapSuffix)
+	return nil
+}
+
+func (d *Downloader) fetchSegments(parent context.Context, host int, resolvePath, tmpPath string, chunks *chunkMap, bar *fileBar) error {
+	file, err := d.slots.openFile(tmpPath, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// 预先分配好整个文件，各个块直接写到自己的偏移处
+	if err := file.Truncate(chunks.Size); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	var firstErr error
+	var once sync.Once
+	fail := func(err error) {
+		// 只有第一个错误是真正的原因，其余分段是被它取消的
+		once.Do(func() {
+			firstErr = err
+			cancel(ErrSiblingFailed)
+		})
+	}
+	d.fetchChunks(ctx, host, resolvePath, file, chunks, bar, fail)
+	chunks.save()
+	if parent.Err() != nil {
+		return context.Cause(parent)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	return file.Close()
+}
+
+// fetchChunks downloads the missing chunks of the file with d.segments connections, or
+// as many as d.adaptive asks for, checking every second, so that a large file follows
+// the adjustments while it is downloading. Connections above the level finish their
+// chunk and stop.
+func (d *Downloader) fetchChunks(ctx context.Context, host int, resolvePath string, file *slotFile, chunks *chunkMap, bar *fileBar, fail func(error)) {
+	level := func() int { return d.segments }
+	if d.adaptive != nil {
+		level = d.adaptive.level
+	}
+	pending := chunks.pending()
+	var mu sync.Mutex
+	next := 0
+	workers := 0
+	var wg sync.WaitGroup
+	worker := func() {
+		defer wg.Done()
+		for {
+			mu.Lock()
+			if next >= len(pending) || ctx.Err() != nil || workers > level() {
+				workers--
+				mu.Unlock()
+				return
+			}
+			i := pending[next]
+			next++
+			mu.Unlock()
+			crc := crc32.NewIEEE()
+			if err := d.fetchSegment(ctx, host, resolvePath, file, chunks.Chunks[i].Start, chunks.Chunks[i].End, bar, crc); err != nil {
+				fail(err)
+				mu.Lock()
+				workers--
+				mu.Unlock()
+				return
+			}
+			chunks.complete(i, crc.Sum32())
+		}
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		mu.Lock()
+		remaining := next < len(pending) && ctx.Err() == nil
+		for remaining && workers < level() {
+			workers++
+			wg.Add(1)
+			go worker()
+		}
+		mu.Unlock()
+		if !remaining {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+	wg.Wait()
+}
+
+// fetchSegment downloads bytes start to end into file and adds them to crc. A stalled
+// connection, or a request that ran into the file timeout after receiving something,
+// is reconnected for the part of the range that is still missing.
+func (d *Downloader) fetchSegment(ctx context.Context, host int, resolvePath string, file *slotFile, start, end int64, bar *fileBar, crc hash.Hash32) error {
+	first := start
+	for stalls := 0; ; {
+		requestCtx, cancel := d.fileRequestContext(ctx)
+		written, err := d.fetchSegmentRange(requestCtx, host, resolvePath, file, start, end, bar, crc)
+		cancel()
+		err = fileTimeoutError(requestCtx, err)
+		start += written
+		switch {
+		case err == nil && start != end+1:
+			return fmt.Errorf("segment %d-%d: got %d bytes", first, end, start-first)
+		case err == nil || start > end:
+			// 连接断开前已经收到了这一段的全部数据
+			return nil
+		case err == errStalled && stalls < maxStallRestarts:
+			stalls++
+			d.logf("\nSegment %d-%d of %s stalled, reconnecting (%d/%d)\n", first, end, resolvePath, stalls, maxStallRestarts)
+		case err == errFileTimeout && written > 0:
+		default:
+			return err
+		}
+	}
+}
+
+// fetchSegmentRange writes bytes start to end into file and crc and returns how many it wrote.
+func (d *Downloader) fetchSegmentRange(ctx context.Context, host int, resolvePath string, file *slotFile, start, end int64, bar *fileBar, crc hash.Hash32) (int64, error) {
+	response, err := d.getFile(ctx, host, resolvePath, strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return 0, errNoRangeSupport
+	default:
+		return 0, AccessError(response.StatusCode, response.Status)
+	}
+	// 分段不能单独换主机，只检查停滞
+	monitor := d.watchSpeed(ctx, response.Body, nil)
+	defer monitor.stop()
+	return pipelineCopy(io.MultiWriter(io.NewOffsetWriter(file, start), crc), bar.NewProxyReader(d.adaptive.reader(d.limiter.reader(ctx, monitor))), d.writeQueue)
+}
+
+// HashFile returns the hex SHA-256 of a file.
+func HashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+package hfdl
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// FileSlots caps how many target files are open at the same time, so repos with
+// thousands of small files do not run into the per-process descriptor limit.
+type FileSlots struct {
+	sem     chan struct{}
+	open    int64
+	peak    int64
+	written int64 // bytes written through files opened here
+}
+
+// NewFileSlots allows n files to be open at once.
+func NewFileSlots(n int) *FileSlots {
+	if n < 1 {
+		n = 1
+	}
+	return &FileSlots{sem: make(chan struct{}, n)}
+}
+
+func (s *FileSlots) acquire() {
+	s.sem <- struct{}{}
+	open := atomic.AddInt64(&s.open, 1)
+	for {
+		peak := atomic.LoadInt64(&s.peak)
+		if open <= peak || atomic.CompareAndSwapInt64(&s.peak, peak, open) {
+			return
+		}
+	}
+}
+
+func (s *FileSlots) release() {
+	atomic.AddInt64(&s.open, -1)
+	<-s.sem
+}
+
+// openFile opens filePath like os.OpenFile while holding a slot; the slot is given back by closing the file.
+func (s *FileSlots) openFile(filePath string, flag int) (*slotFile, error) {
+	s.acquire()
+	file, err := os.OpenFile(filePath, flag, 0644)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return &slotFile{File: file, slots: s}, nil
+}
+
+type slotFile struct {
+	*os.File
+	slots  *FileSlots
+	closed bool
+}
+
+func (f *slotFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	atomic.AddInt64(&f.slots.written, int64(n))
+	return n, err
+}
+
+func (f *slotFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	atomic.AddInt64(&f.slots.written, int64(n))
+	return n, err
+}
+
+func (f *slotFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	defer f.slots.release()
+	return f.File.Close()
+}
+
+// Peak returns the largest number of files that were open at the same time.
+func (s *FileSlots) Peak() int64 {
+	return atomic.LoadInt64(&s.peak)
+}
+
+// Written returns the number of bytes written through files opened here.
+func (s *FileSlots) Written() int64 {
+	return atomic.LoadInt64(&s.written)
+}
@@ -0,0 +1,166 @@
+package hfdl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errTooSlow = errors.New("transfer too slow")
+	errStalled = errors.New("transfer stalled")
+)
+
+// 一个文件（或一个分段）因为停滞最多重新连接这么多次
+const maxStallRestarts = 5
+
+// speedMonitor counts the bytes read from a response body and closes the body when the
+// average throughput over the last window drops below floor bytes per second. It only
+// gives up on the connection when canSwitch reports that there is somewhere better to go.
+// Independently of that, a connection that stays below stallFloor for stallWindow is
+// closed as stalled, so that it can be restarted instead of hanging.
+//
+// The throughput is that of the network: the windows count the time spent waiting in
+// Read for the body, not the time the reader spends in the rate limiter or waiting for
+// the disk, so --limit-rate and a slow disk are not taken for a slow connection.
+type speedMonitor struct {
+	body        io.ReadCloser
+	read        int64
+	busy        int64         // nanoseconds spent in finished body reads
+	reading     int64         // unix nanoseconds the current body read started at, 0 between reads
+	received    *atomic.Int64 // of the retryFile attempt, may be nil
+	reason      atomic.Pointer[error]
+	done        chan struct{}
+	floor       int64
+	window      time.Duration
+	canSwitch   func() bool
+	stallFloor  int64
+	stallWindow time.Duration
+}
+
+// restartSlow returns the host a transfer that the speed monitor ended (errStalled or
+// errTooSlow) starts again from: after a stall another healthy host or the same one,
+// at most maxStallRestarts times; when too slow only another healthy host. ok is false
+// for other errors and when the transfer should give up.
+func (d *Downloader) restartSlow(host int, resolvePath string, err error, stalls *int, switches int) (next int, ok bool) {
+	switch {
+	case errors.Is(err, errStalled) && *stalls < maxStallRestarts:
+		*stalls++
+		if next := d.healthyAlternate(host); next >= 0 {
+			d.markHostSlow(d.hosts[host])
+			d.logf("\nTransfer of %s from %s stalled, retrying from %s\n", resolvePath, d.hosts[host], d.hosts[next])
+			return next, true
+		}
+		d.logf("\nTransfer of %s from %s stalled, reconnecting (%d/%d)\n", resolvePath, d.hosts[host], *stalls, maxStallRestarts)
+		return host, true
+	case errors.Is(err, errTooSlow) && switches < 2*len(d.hosts):
+		next := d.healthyAlternate(host)
+		d.markHostSlow(d.hosts[host])
+		if next >= 0 {
+			d.logf("\nTransfer from %s is too slow, retrying from %s\n", d.hosts[host], d.hosts[next])
+			return next, true
+		}
+	}
+	return host, false
+}
+
+// watchSpeed watches body with the limits of d; canSwitch may be nil when the transfer
+// cannot move to another host. The bytes read are also added to the counter of the
+// retryFile attempt of ctx.
+func (d *Downloader) watchSpeed(ctx context.Context, body io.ReadCloser, canSwitch func() bool) *speedMonitor {
+	m := &speedMonitor{body: body, done: make(chan struct{}), floor: d.minSpeed, window: d.minSpeedTime, canSwitch: canSwitch,
+		stallFloor: d.stallSpeed, stallWindow: d.stallTime}
+	m.received, _ = ctx.Value(receivedKey{}).(*atomic.Int64)
+	if canSwitch == nil {
+		m.floor = 0
+	}
+	if m.floor <= 0 || m.window <= 0 {
+		m.floor, m.window = 0, 0
+	}
+	if m.stallFloor <= 0 || m.stallWindow <= 0 {
+		m.stallFloor, m.stallWindow = 0, 0
+	}
+	if m.window > 0 || m.stallWindow > 0 {
+		go m.run()
+	}
+	return m
+}
+
+// speedSample is what a speedMonitor had read after how much time waiting for the body.
+type speedSample struct {
+	read, busy int64
+}
+
+func (m *speedMonitor) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// 每秒采样一次，比较等待网络的时间刚好超过窗口的两个样本之间的字节数
+	longest := int64(max(m.window, m.stallWindow))
+	var samples []speedSample
+	// speed returns the bytes per second over the last window of network time, false
+	// when less than window was spent waiting for the body so far.
+	speed := func(window time.Duration) (int64, bool) {
+		last := samples[len(samples)-1]
+		for i := len(samples) - 2; i >= 0; i-- {
+			if busy := last.busy - samples[i].busy; busy >= int64(window) {
+				return (last.read - samples[i].read) * int64(time.Second) / busy, true
+			}
+		}
+		return 0, false
+	}
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		busy := atomic.LoadInt64(&m.busy)
+		if since := atomic.LoadInt64(&m.reading); since > 0 {
+			// 正在等的这次读取也算，卡住的连接这样才能发现
+			busy += time.Now().UnixNano() - since
+		}
+		samples = append(samples, speedSample{read: atomic.LoadInt64(&m.read), busy: busy})
+		for len(samples) > 2 && busy-samples[1].busy >= longest {
+			samples = samples[1:]
+		}
+		if m.window > 0 {
+			if bytes, ok := speed(m.window); ok && bytes < m.floor && m.canSwitch() {
+				m.abort(errTooSlow)
+				return
+			}
+		}
+		if m.stallWindow > 0 {
+			if bytes, ok := speed(m.stallWindow); ok && bytes < m.stallFloor {
+				m.abort(errStalled)
+				return
+			}
+		}
+	}
+}
+
+func (m *speedMonitor) abort(reason error) {
+	m.reason.Store(&reason)
+	m.body.Close()
+}
+
+func (m *speedMonitor) Read(p []byte) (int, error) {
+	started := time.Now().UnixNano()
+	atomic.StoreInt64(&m.reading, started)
+	n, err := m.body.Read(p)
+	atomic.StoreInt64(&m.reading, 0)
+	atomic.AddInt64(&m.busy, time.Now().UnixNano()-started)
+	atomic.AddInt64(&m.read, int64(n))
+	if m.received != nil {
+		m.received.Add(int64(n))
+	}
+	if reason := m.reason.Load(); err != nil && reason != nil {
+		err = *reason
+	}
+	return n, err
+}
+
+func (m *speedMonitor) stop() {
+	close(m.done)
+}
@@ -0,0 +1,152 @@
+package hfdl
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Storage is where DownloadFile and DownloadDecompressed keep the files they download:
+// the local file system by default, or a WebDAV, SFTP, HDFS, ... backend given with
+// WithStorage. A file is written to <name>.tmp, appended to when its download is
+// resumed, and renamed to name once it is complete and verified; nothing else is
+// asked of the backend. Names are the file paths passed to the Downloader, with
+// slashes as they were given.
+type Storage interface {
+	// Open opens a file for reading, used to hash the part of a .tmp file that an
+	// earlier attempt downloaded.
+	Open(name string) (io.ReadCloser, error)
+	// Write opens a file for writing at its end, creating it when there is none, or
+	// empties it first when truncate is set. Its parent folder exists.
+	Write(name string, truncate bool) (io.WriteCloser, error)
+	// Stat describes a file; the error wraps fs.ErrNotExist when there is none.
+	Stat(name string) (fs.FileInfo, error)
+	// Rename moves a file to newName, replacing what is there.
+	Rename(oldName, newName string) error
+	// Remove deletes a file.
+	Remove(name string) error
+}
+
+// HashedStorage is a Storage that cannot read back the .tmp files it is writing, like
+// the parts of an S3 multipart upload. It keeps the SHA-256 of what was written
+// instead, so that a resumed download is still verified as a whole.
+type HashedStorage interface {
+	Storage
+	// WrittenSHA256 returns the state of a crypto/sha256 hash (its MarshalBinary)
+	// after everything written to name so far, as much as Stat reports.
+	WrittenSHA256(name string) ([]byte, error)
+}
+
+// WithStorage stores the downloaded files in s instead of the local file system. Other
+// backends cannot write a file at several offsets at once, so files are downloaded
+// with one connection each and WithSegments does not apply. Retries, failover,
+// progress and the stall check work the same as for local files.
+func WithStorage(s Storage) Option {
+	return func(d *Downloader) { d.storage = s }
+}
+
+// localStorage is the default Storage, holding one of the FileSlots for every file
+// open for writing.
+type localStorage struct {
+	slots *FileSlots
+}
+
+func (s localStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (s localStorage) Write(name string, truncate bool) (io.WriteCloser, error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	return s.slots.openFile(name, flag)
+}
+
+func (s localStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (s localStorage) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (s localStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// files returns the Storage the files of d are kept in.
+func (d *Downloader) files() Storage {
+	if d.storage != nil {
+		return d.storage
+	}
+	return localStorage{slots: d.slots}
+}
+
+// storedSize returns the size of a partial download, 0 when there is none.
+func (d *Downloader) storedSize(name string) int64 {
+	stat, err := d.files().Stat(name)
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
+
+// writeStored opens name in the storage and hands it to write, closing it once
+// afterwards. When write fails, what it wrote is flushed to disk first (the local
+// file system), so the next attempt resumes from there.
+func (d *Downloader) writeStored(name string, truncate bool, write func(w io.Writer) error) error {
+	file, err := d.files().Write(name, truncate)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		if syncer, ok := file.(interface{ Sync() error }); ok {
+			// 中断时（比如关机前的 SIGTERM）把已经收到的部分落盘，下次从这里续传
+			syncer.Sync()
+		}
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// hashStored returns the hex SHA-256 of a stored file.
+func (d *Downloader) hashStored(name string) (string, error) {
+	h := sha256.New()
+	if err := d.hashStoredPrefix(h, name, -1); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashStoredPrefix adds the first n bytes of a stored file to h, all of it when n < 0.
+func (d *Downloader) hashStoredPrefix(h hash.Hash, name string, n int64) error {
+	if hs, ok := d.files().(HashedStorage); ok {
+		// 读不回来的存储给出已经写入部分的哈希状态，h 是新的 sha256
+		if n >= 0 && d.storedSize(name) != n {
+			return fmt.Errorf("%s holds %d bytes, expected %d", name, d.storedSize(name), n)
+		}
+		state, err := hs.WrittenSHA256(name)
+		if err != nil {
+			return err
+		}
+		return h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+	}
+	file, err := d.files().Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if n < 0 {
+		_, err = io.Copy(h, file)
+	} else {
+		_, err = io.CopyN(h, file, n)
+	}
+	return err
+}
@@ -0,0 +1,261 @@
+package hfdl
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 这段时间内收到这么多 429/503，就认为镜像在限流
+	throttleWindow = 10 * time.Second
+	throttleBurst  = 3
+	// 降速后至少保持这么久，之后逐步恢复
+	throttleCooldown = time.Minute
+	// 单个请求因为限流最多等待这么久，超过后把 429 交给调用方
+	maxThrottleWait = 10 * time.Minute
+	// 服务器没有给出等待时间时的退避：从 2 秒开始翻倍，最多 1 分钟
+	throttleBackoffMin = 2 * time.Second
+	throttleBackoffMax = time.Minute
+)
+
+// Throttle limits the number of concurrent transfers and the rate at which requests
+// start. It is unlimited until the server starts answering 429 or 503; then it halves
+// the concurrency and spaces out requests for a cooldown period, and ramps back up
+// step by step while the server stays quiet.
+type Throttle struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	active   int
+	peak     int           // concurrency reached before the first slowdown
+	limit    int           // 0 means unlimited
+	interval time.Duration // minimum spacing between request starts
+	next     time.Time
+	recent   []time.Time // recent throttled answers
+	until    time.Time   // end of the current cooldown
+	hits     int         // 429/503 answers so far
+	logf     func(format string, args ...interface{})
+}
+
+// NewThrottle returns a Throttle to share between Downloaders talking to the same hosts.
+func NewThrottle() *Throttle {
+	t := &Throttle{}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// setLogger makes t report to logf unless it reports somewhere already. Downloaders
+// sharing t through a Client may be created at the same time.
+func (t *Throttle) setLogger(logf func(format string, args ...interface{})) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.logf == nil {
+		t.logf = logf
+	}
+}
+
+func (t *Throttle) acquire(ctx context.Context) error {
+	t.mu.Lock()
+	t.rampUp()
+	for t.limit > 0 && t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+	if t.active > t.peak && t.limit == 0 {
+		t.peak = t.active
+	}
+	wait := time.Until(t.next)
+	if t.next.Before(time.Now()) {
+		t.next = time.Now()
+	}
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+	if err := sleepContext(ctx, wait); err != nil {
+		t.release()
+		return err
+	}
+	return nil
+}
+
+func (t *Throttle) release() {
+	t.mu.Lock()
+	t.active--
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// throttled records a 429/503 answer and slows down when they pile up.
+func (t *Throttle) throttled() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hits++
+	now := time.Now()
+	recent := t.recent[:0]
+	for _, at := range t.recent {
+		if now.Sub(at) < throttleWindow {
+			recent = append(recent, at)
+		}
+	}
+	t.recent = append(recent, now)
+	if len(t.recent) < throttleBurst {
+		return
+	}
+	t.recent = t.recent[:0]
+	current := t.limit
+	if current == 0 {
+		current = t.active
+	}
+	t.limit = current / 2
+	if t.limit < 1 {
+		t.limit = 1
+	}
+	t.interval *= 2
+	if t.interval < 500*time.Millisecond {
+		t.interval = 500 * time.Millisecond
+	} else if t.interval > 10*time.Second {
+		t.interval = 10 * time.Second
+	}
+	t.until = now.Add(throttleCooldown)
+	t.logf("\nServer is throttling, slowing down to %d connections and one request every %v\n", t.limit, t.interval)
+}
+
+// hitCount returns the number of 429/503 answers so far.
+func (t *Throttle) hitCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hits
+}
+
+// pause holds back every request start until delay has passed, because a
+// Retry-After answer applies to the whole client, not only to one request.
+func (t *Throttle) pause(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(delay); until.After(t.next) {
+		t.next = until
+	}
+}
+
+// rampUp doubles the concurrency and halves the request spacing once a cooldown
+// passed without throttling, until the limits are gone. Called with t.mu held.
+func (t *Throttle) rampUp() {
+	if t.limit == 0 || time.Now().Before(t.until) {
+		return
+	}
+	t.limit *= 2
+	t.interval /= 2
+	if t.interval < 100*time.Millisecond {
+		t.interval = 0
+	}
+	if t.limit >= t.peak && t.interval == 0 {
+		t.limit = 0
+		t.logf("\nServer stopped throttling, back to full speed\n")
+	}
+	t.until = time.Now().Add(throttleCooldown / 2)
+	t.cond.Broadcast()
+}
+
+// releaseOnClose gives the transfer slot back when the response body is closed.
+type releaseOnClose struct {
+	body io.ReadCloser
+	once sync.Once
+	t    *Throttle
+}
+
+func (r *releaseOnClose) Read(p []byte) (int, error) {
+	return r.body.Read(p)
+}
+
+func (r *releaseOnClose) Close() error {
+	r.once.Do(r.t.release)
+	return r.body.Close()
+}
+
+// do sends request through the throttle. 429 and 503 answers are retried after the
+// delay the server asks for (Retry-After or the reset time of a RateLimit header),
+// or else after an exponential backoff with jitter, for at most maxThrottleWait in
+// total. The transfer slot is held until the response body is closed.
+func (t *Throttle) do(client *http.Client, request *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	backoff := throttleBackoffMin
+	for {
+		if err := t.acquire(request.Context()); err != nil {
+			return nil, err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.release()
+			return nil, err
+		}
+		if response.StatusCode != http.StatusTooManyRequests && response.StatusCode != http.StatusServiceUnavailable {
+			response.Body = &releaseOnClose{body: response.Body, t: t}
+			return response, nil
+		}
+		delay, told := retryAfter(response.Header, time.Now())
+		if told && delay < time.Second {
+			delay = time.Second
+		}
+		if !told {
+			// 没有提示时指数退避，加上随机抖动，避免所有连接同时重试
+			delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			if backoff *= 2; backoff > throttleBackoffMax {
+				backoff = throttleBackoffMax
+			}
+		}
+		if waited+delay > maxThrottleWait {
+			// 要等的时间太长（比如额度按天重置），直接报告，也算一次限流
+			t.mu.Lock()
+			t.hits++
+			t.mu.Unlock()
+			response.Body = &releaseOnClose{body: response.Body, t: t}
+			return response, nil
+		}
+		response.Body.Close()
+		t.release()
+		t.throttled()
+		if told {
+			t.pause(delay)
+			t.logf("\nRate limited by %s, waiting %v as asked by the server\n", request.URL.Host, delay.Round(time.Second))
+		}
+		waited += delay
+		select {
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryAfter returns how long the server asks clients to wait: the Retry-After header
+// in seconds or as an HTTP date, or the reset time (t=) of the RateLimit header the
+// Hub sends, e.g. "api";r=0;t=42.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if at, err := http.ParseTime(value); err == nil {
+			if at.Before(now) {
+				return 0, true
+			}
+			return at.Sub(now), true
+		}
+	}
+	for _, param := range strings.Split(header.Get("RateLimit"), ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if name != "t" {
+			continue
+		}
+		// 多条策略用逗号分隔，只看第一条
+		value, _, _ = strings.Cut(value, ",")
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,172 @@
+package hfdl
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// 解析结果缓存的时间，镜像的地址一般不会在一次下载中途改变
+	dnsCacheTTL = 5 * time.Minute
+	// 一个地址这么久还没连上就同时试下一个，和 net.Dialer 的默认值一样
+	dialFallbackDelay = 300 * time.Millisecond
+)
+
+// NewTransport returns an http.Transport tuned for many requests to few hosts:
+// TLS sessions are resumed instead of doing a full handshake for every new connection,
+// host names are resolved once per dnsCacheTTL, and more idle connections are kept per host.
+func NewTransport() *http.Transport {
+	transport := NewTransportTimeout(30 * time.Second)
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	return transport
+}
+
+// NewTransportTimeout is NewTransport with a limit on connecting to a host: both the
+// TCP connect and the TLS handshake may take at most connect.
+func NewTransportTimeout(connect time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(256)
+	transport.MaxIdleConnsPerHost = 32
+	transport.TLSHandshakeTimeout = connect
+	dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+	cache := &dnsCache{entries: make(map[string]dnsEntry)}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return cache.dial(ctx, dialer, network, addr)
+	}
+	return transport
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache remembers the addresses of host names for dnsCacheTTL.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(dnsCacheTTL)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+func (c *dnsCache) forget(host string) {
+	c.mu.Lock()
+	delete(c.entries, host)
+	c.mu.Unlock()
+}
+
+// dial connects to the cached addresses of addr's host the "happy eyeballs" way
+// (RFC 8305): the addresses are tried alternating IPv6 and IPv4, the next one is
+// started dialFallbackDelay after the previous or as soon as it failed, and the
+// first connection made wins, so one unreachable address costs a short delay
+// instead of the whole connect timeout.
+func (c *dnsCache) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		c.forget(host)
+		return nil, &net.DNSError{Err: "no addresses", Name: host}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, len(addrs))
+	ordered := interleaveFamilies(addrs)
+	next, pending := 0, 0
+	var firstErr error
+	for {
+		if next < len(ordered) {
+			ip := ordered[next]
+			next++
+			pending++
+			go func() {
+				conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+				results <- dialResult{conn, err}
+			}()
+		}
+		var fallback <-chan time.Time
+		if next < len(ordered) {
+			fallback = time.After(dialFallbackDelay)
+		}
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// 其他还在连的地址取消掉，已经连上的关闭
+				cancel()
+				go func() {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}()
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if pending == 0 && next == len(ordered) {
+				// 缓存的地址都连不上，下次重新解析
+				c.forget(host)
+				return nil, firstErr
+			}
+		case <-fallback:
+		}
+	}
+}
+
+// interleaveFamilies orders addrs IPv6, IPv4, IPv6, ... keeping the resolver's order
+// within each family, starting with the family of the first address.
+func interleaveFamilies(addrs []string) []string {
+	var first, second []string
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if (ip.To4() == nil) == (net.ParseIP(addrs[0]).To4() == nil) {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+	ordered := make([]string, 0, len(addrs))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
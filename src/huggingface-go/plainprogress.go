@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// What --progress shows while files download.
+const (
+	progressBars  = "bars"  // pb bars, redrawn in place
+	progressPlain = "plain" // a line now and then, for log files
+	progressNone  = "none"
+)
+
+// 纯文本模式下每个文件至少每过这么久或者每多下载 5% 打印一行
+const (
+	plainProgressInterval = 30 * time.Second
+	plainProgressStep     = 5
+)
+
+func validProgressMode(mode string) bool {
+	switch mode {
+	case "", progressBars, progressPlain, progressNone:
+		return true
+	}
+	return false
+}
+
+// progressOptions returns the Downloader options for --progress. Without a mode the
+// bars are shown on a terminal and plain lines otherwise (docker logs, nohup, CI),
+// where the redrawn bars would fill the log with escape codes.
+func progressOptions(mode string) []hfdl.Option {
+	if mode == "" {
+		mode = progressPlain
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			mode = progressBars
+		}
+	}
+	switch mode {
+	case progressBars:
+		return []hfdl.Option{hfdl.WithProgress(true)}
+	case progressPlain:
+		return []hfdl.Option{hfdl.WithProgress(false), hfdl.WithProgressListener(hfdl.NewProgress(newPlainProgress(), time.Second))}
+	}
+	return []hfdl.Option{hfdl.WithProgress(false)}
+}
+
+type plainFile struct {
+	percent int64
+	printed time.Time
+	started time.Time
+}
+
+// plainProgress prints the progress of large files as plain lines (--progress plain).
+type plainProgress struct {
+	mu    sync.Mutex
+	files map[string]*plainFile
+}
+
+func newPlainProgress() *plainProgress {
+	return &plainProgress{files: make(map[string]*plainFile)}
+}
+
+func (p *plainProgress) OnFile(f hfdl.FileEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	switch f.State {
+	case hfdl.FileStarted:
+		p.files[f.Path] = &plainFile{printed: now, started: now}
+	case hfdl.FileDownloading:
+		file := p.files[f.Path]
+		if file == nil || f.Size <= 0 {
+			return
+		}
+		percent := f.Current * 100 / f.Size
+		if percent/plainProgressStep == file.percent/plainProgressStep && now.Sub(file.printed) < plainProgressInterval {
+			return
+		}
+		file.percent, file.printed = percent, now
+		current, currentUnit := convertBytes(float64(f.Current))
+		size, sizeUnit := convertBytes(float64(f.Size))
+		fmt.Fprintf(stdout, "  %s: %d%% (%.2f %s / %.2f %s)\n", plainName(f.Path), percent, current, currentUnit, size, sizeUnit)
+	case hfdl.FileDone, hfdl.FileFailed:
+		file := p.files[f.Path]
+		delete(p.files, f.Path)
+		if f.State == hfdl.FileFailed {
+			fmt.Fprintf(stdout, "  %s: failed\n", plainName(f.Path))
+		} else if file != nil {
+			// 下载很快的小文件只有开头的 Downloading file 那一行
+			if elapsed := now.Sub(file.started); elapsed >= time.Second {
+				fmt.Fprintf(stdout, "  %s: done in %v\n", plainName(f.Path), elapsed.Round(time.Second))
+			}
+		}
+	}
+}
+
+func (p *plainProgress) OnTotals(hfdl.Totals) {}
+
+// plainName returns the file path in the repo from a resolve path.
+func plainName(resolvePath string) string {
+	if _, rest, ok := strings.Cut(resolvePath, "/resolve/"); ok {
+		if _, name, ok := strings.Cut(rest, "/"); ok {
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				return unescaped
+			}
+			return name
+		}
+	}
+	return resolvePath
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// FileFilter decides whether a listed file is downloaded.
+type FileFilter interface {
+	Keep(path string, size int64) bool
+}
+
+// URLRewriter rewrites a download url right before the request is sent.
+type URLRewriter interface {
+	RewriteURL(url string) string
+}
+
+type filterFunc func(path string, size int64) bool
+
+func (f filterFunc) Keep(path string, size int64) bool { return f(path, size) }
+
+type rewriterFunc func(url string) string
+
+func (f rewriterFunc) RewriteURL(url string) string { return f(url) }
+
+// loadPlugin opens a Go plugin built with `go build -buildmode=plugin`. A plugin cannot
+// import this program's types, so it exports plain functions instead:
+//
+//	func KeepFile(path string, size int64) bool
+//	func RewriteURL(url string) string
+//
+// Either one may be left out. Plugins are only supported on Linux, macOS and FreeBSD
+// and have to be built with the same Go version as this program.
+func loadPlugin(pluginPath string) (FileFilter, URLRewriter, error) {
+	p, err := plugin.Open(pluginPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var filter FileFilter
+	var rewriter URLRewriter
+	if sym, err := p.Lookup("KeepFile"); err == nil {
+		f, ok := sym.(func(string, int64) bool)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: KeepFile must be a func(path string, size int64) bool", pluginPath)
+		}
+		filter = filterFunc(f)
+	}
+	if sym, err := p.Lookup("RewriteURL"); err == nil {
+		f, ok := sym.(func(string) string)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: RewriteURL must be a func(url string) string", pluginPath)
+		}
+		rewriter = rewriterFunc(f)
+	}
+	if filter == nil && rewriter == nil {
+		return nil, nil, fmt.Errorf("%s exports neither KeepFile nor RewriteURL", pluginPath)
+	}
+	return filter, rewriter, nil
+}
+
+// applyFileFilters keeps the entries every filter agrees on.
+func applyFileFilters(entries []hfdl.FileEntry, filters []FileFilter) []hfdl.FileEntry {
+	if len(filters) == 0 {
+		return entries
+	}
+	kept := entries[:0:0]
+	for _, entry := range entries {
+		keep := true
+		for _, filter := range filters {
+			if !filter.Keep(entry.Path, entry.Size) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
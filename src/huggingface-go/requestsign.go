@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestSigner signs every request to the hub hosts for gateways that only let
+// signed urls through: with an HMAC key (--request-hmac-key) or by running an
+// external command (--request-sign-command). Other hosts, like the storage a
+// resolve url redirects to, are not signed.
+type requestSigner struct {
+	base    http.RoundTripper
+	key     []byte
+	ttl     time.Duration // how long an HMAC signature is valid
+	command string
+
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+// installRequestSigner puts a requestSigner in front of the current transport;
+// addHosts tells it which hosts to sign.
+func installRequestSigner(key, command string, ttl time.Duration) *requestSigner {
+	s := &requestSigner{base: http.DefaultTransport, key: []byte(key), ttl: ttl, command: command, hosts: make(map[string]bool)}
+	http.DefaultTransport = s
+	return s
+}
+
+func (s *requestSigner) addHosts(endpoints ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, endpoint := range endpoints {
+		if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+			s.hosts[u.Host] = true
+		}
+	}
+}
+
+func (s *requestSigner) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	sign := s.hosts[req.URL.Host]
+	s.mu.Unlock()
+	if !sign {
+		return s.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if len(s.key) > 0 {
+		s.signHMAC(req, time.Now())
+	}
+	if s.command != "" {
+		if err := s.signCommand(req); err != nil {
+			return nil, err
+		}
+	}
+	return s.base.RoundTrip(req)
+}
+
+// signHMAC adds expires=<unix time> and signature=<hex HMAC-SHA256> query parameters.
+// The signature covers "METHOD\nhost\npath\nexpires", with the path as sent.
+func (s *requestSigner) signHMAC(req *http.Request, now time.Time) {
+	expires := strconv.FormatInt(now.Add(s.ttl).Unix(), 10)
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", req.Method, req.URL.Host, req.URL.EscapedPath(), expires)
+	query := req.URL.Query()
+	query.Set("expires", expires)
+	query.Set("signature", hex.EncodeToString(mac.Sum(nil)))
+	req.URL.RawQuery = query.Encode()
+}
+
+// signCommand runs the sign command with HFGO_SIGN_METHOD and HFGO_SIGN_URL set. Each
+// line it prints is either "Name: value", a header to add, or an url that replaces
+// the url of the request, e.g. with signature query parameters appended.
+func (s *requestSigner) signCommand(req *http.Request) error {
+	cmd := shellCommand(s.command)
+	cmd.Env = append(os.Environ(), "HFGO_SIGN_METHOD="+req.Method, "HFGO_SIGN_URL="+req.URL.String())
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("--request-sign-command failed: %v", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://"):
+			u, err := url.Parse(line)
+			if err != nil {
+				return fmt.Errorf("--request-sign-command printed an invalid url: %v", err)
+			}
+			req.URL = u
+		default:
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				return fmt.Errorf("--request-sign-command printed %q, expected \"Name: value\" or an url", line)
+			}
+			req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+	return nil
+}
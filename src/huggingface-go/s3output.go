@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const (
+	// 分块上传每块至少这么大，S3 最多 10000 块，更大的文件块也跟着变大
+	s3PartSize = 64 << 20
+	s3MaxParts = 10000
+	// 存在对象元数据里的哈希，下次运行据此跳过已经上传的文件
+	s3SHA256Meta = "X-Amz-Meta-Sha256"
+	s3OIDMeta    = "X-Amz-Meta-Oid"
+)
+
+// s3Output is the bucket and key prefix of --output s3://bucket/prefix; files are
+// stored below <prefix>/<repo folder>/ like they would be below -f.
+type s3Output struct {
+	bucket   string
+	prefix   string
+	endpoint *url.URL // AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL for MinIO and other stores, nil for AWS
+	region   string
+	keyID    string
+	secret   string
+	token    string
+	workers  int // parts of a file uploaded at the same time, see --segments
+}
+
+// parseS3Output parses --output and reads the credentials, region and endpoint from
+// the standard AWS environment variables.
+func parseS3Output(raw string) (*s3Output, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("expected s3://bucket/prefix, got %q", raw)
+	}
+	out := &s3Output{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		keyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if out.keyID == "" || out.secret == "" {
+		return nil, fmt.Errorf("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if out.region == "" {
+		out.region = "us-east-1"
+	}
+	if endpoint := firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); endpoint != "" {
+		if out.endpoint, err = url.Parse(strings.TrimRight(endpoint, "/")); err != nil || out.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+	}
+	return out, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// folder returns the s3:// url of the repo folder relFolder, used in place of the
+// local target folder in messages and hooks.
+func (s *s3Output) folder(relFolder string) string {
+	return "s3://" + s.bucket + "/" + s.key(relFolder, "")
+}
+
+func (s *s3Output) key(relFolder, file string) string {
+	parts := make([]string, 0, 3)
+	for _, p := range []string{s.prefix, relFolder, file} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// objectURL returns the url of key: path style on custom endpoints, where MinIO and
+// most other stores expect it, and for bucket names with dots, which do not match
+// the certificate of a virtual host; virtual host style on AWS otherwise.
+func (s *s3Output) objectURL(key string, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: s.bucket + ".s3." + s.region + ".amazonaws.com"}
+	escaped := "/" + s3Escape(key, false)
+	switch {
+	case s.endpoint != nil:
+		u.Scheme, u.Host = s.endpoint.Scheme, s.endpoint.Host
+		escaped = strings.TrimRight(s.endpoint.EscapedPath(), "/") + "/" + s3Escape(s.bucket, true) + escaped
+	case strings.Contains(s.bucket, "."):
+		u.Host = "s3." + s.region + ".amazonaws.com"
+		escaped = "/" + s3Escape(s.bucket, true) + escaped
+	}
+	u.Path, _ = url.PathUnescape(escaped)
+	u.RawPath = escaped
+	u.RawQuery = s3CanonicalQuery(query)
+	return u
+}
+
+// s3Escape encodes s like SigV4 wants it: everything but A-Z, a-z, 0-9, - _ . ~ is
+// percent-encoded, and / too unless it separates the segments of a key.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to request, covering the
+// host and every header set so far, with body as the payload.
+func (s *s3Output) sign(request *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	amzDate := now.UTC().Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	if s.token != "" {
+		request.Header.Set("X-Amz-Security-Token", s.token)
+	}
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", request.Method, request.URL.EscapedPath(), request.URL.RawQuery)
+	for _, name := range names {
+		fmt.Fprintf(&canonical, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	fmt.Fprintf(&canonical, "\n%s\n%s", signedHeaders, hex.EncodeToString(payload[:]))
+
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical.String()))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	key := []byte("AWS4" + s.secret)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.keyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Client sends the signed requests of one run, retrying like uploader.do.
+type s3Client struct {
+	*s3Output
+	retries    int
+	retryDelay time.Duration
+}
+
+// s3Error is an error answer of the store with the code and message of its XML body.
+type s3Error struct {
+	status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d", e.status)
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.status, e.Code, e.Message)
+}
+
+// do sends a request for key with body, signed again for every attempt. Server
+// errors, throttling and dropped connections are retried with a doubling delay.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		c.sign(request, body, time.Now())
+		response, err := http.DefaultClient.Do(request)
+		if err == nil && response.StatusCode < 300 {
+			return response, nil
+		}
+		if err == nil {
+			e := &s3Error{status: response.StatusCode}
+			if method != http.MethodHead {
+				data, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+				xml.Unmarshal(data, e)
+			}
+			response.Body.Close()
+			err = e
+		}
+		var status *s3Error
+		retry := !errors.As(err, &status) || status.status == http.StatusTooManyRequests || status.status >= 500
+		if !retry || attempt >= c.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		fmt.Fprintf(stdout, "%s s3://%s/%s failed (%v), retrying in %s (%d/%d)\n", method, c.bucket, key, err, delay, attempt+1, c.retries)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay = min(delay*2, 5*time.Minute)
+	}
+}
+
+// stat returns the size and metadata of key, or ok false when there is no such object.
+func (c *s3Client) stat(ctx context.Context, key string) (size int64, meta http.Header, ok bool, err error) {
+	response, err := c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	var status *s3Error
+	if errors.As(err, &status) && status.status == http.StatusNotFound {
+		return 0, nil, false, nil
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	response.Body.Close()
+	return response.ContentLength, response.Header, true, nil
+}
+
+func (c *s3Client) putObject(ctx context.Context, key string, data []byte, header http.Header) error {
+	response, err := c.do(ctx, http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// createUpload starts a multipart upload of key with the metadata in header and
+// returns its upload id.
+func (c *s3Client) createUpload(ctx context.Context, key string, header http.Header) (string, error) {
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+	err = xml.NewDecoder(response.Body).Decode(&created)
+	response.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot start the multipart upload: %v", err)
+	}
+	return created.UploadID, nil
+}
+
+// uploadPart uploads part number (from 1) of a multipart upload and returns its ETag.
+func (c *s3Client) uploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	response, err := c.do(ctx, http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return "", fmt.Errorf("part %d: %w", number, err)
+	}
+	response.Body.Close()
+	return response.Header.Get("ETag"), nil
+}
+
+// completeUpload makes the parts with these ETags the object key.
+func (c *s3Client) completeUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	complete := struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{}
+	for i, etag := range etags {
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: i + 1, ETag: etag})
+	}
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	response, err := c.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, data)
+	if err != nil {
+		return err
+	}
+	// 出错时状态码也可能是 200，错误写在正文里
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if e := (&s3Error{status: response.StatusCode}); xml.Unmarshal(body, e) == nil && e.Code != "" {
+		return e
+	}
+	return nil
+}
+
+// abortUpload drops the parts of a multipart upload, also when ctx was cancelled.
+func (c *s3Client) abortUpload(ctx context.Context, key, uploadID string) {
+	// 放弃的分块不删掉会一直占用（并计费）存储空间
+	if response, err := c.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil); err == nil {
+		response.Body.Close()
+	} else {
+		fmt.Fprintf(stdout, "Cannot abort the multipart upload of s3://%s/%s: %v\n", c.bucket, key, err)
+	}
+}
+
+// sameObject reports whether key already holds entry as an earlier run stored it: the
+// size matches and so does the sha256 (LFS files) or git blob id (other files) that
+// was recorded in its metadata. Returns the sha256 of the object.
+func sameObject(size int64, meta http.Header, entry hfdl.FileEntry) (string, bool) {
+	sum := meta.Get(s3SHA256Meta)
+	if size != entry.Size || sum == "" {
+		return "", false
+	}
+	if entry.LFSOID != "" {
+		return sum, sum == entry.LFSOID
+	}
+	return sum, entry.OID != "" && meta.Get(s3OIDMeta) == entry.OID
+}
+
+// streamToS3 downloads the entries of a repo into the --output bucket instead of the
+// target folder and returns the number of files that failed. d keeps its files in
+// store, so they get the retries, failover, progress output and stall check of a
+// local download; files stored by an earlier run are skipped. When all are there, the
+// .hfgo-manifest.json and SHA256SUMS of a local download are stored next to them.
+func streamToS3(ctx context.Context, d *hfdl.Downloader, store *s3Storage, posts *postFileQueue, ref hfdl.Repo, entries []hfdl.FileEntry, opts *downloadOptions, relFolder string, manifest downloadManifest) int {
+	c := store.c
+	targetFolder := c.folder(relFolder)
+	sums := make(map[string]string)
+	hookSkipped := make(map[string]bool) // files the --pre-file hook left out
+	failed := 0
+	for i, entry := range entries {
+		if ctx.Err() != nil {
+			fmt.Fprintf(stdout, "Download of %s stopped: %v\n", ref.ID, context.Cause(ctx))
+			return failed + len(entries) - i
+		}
+		key := c.key(relFolder, entry.Path)
+		objectURL := "s3://" + c.bucket + "/" + key
+		fmt.Fprintf(stdout, "Downloading file %d/%d: %s\n", i+1, len(entries), entry.Path)
+		if entry.Size == hfdl.UnknownSize {
+			fmt.Fprintf(stdout, "Cannot stream %s: its size is unknown\n", entry.Path)
+			failed++
+			continue
+		}
+		size, meta, exists, err := c.stat(ctx, key)
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot check %s: %v\n", objectURL, err)
+		} else if sum, same := sameObject(size, meta, entry); exists && same {
+			fmt.Fprintf(stdout, "File %s already exists and has the same hash, skipping\n", objectURL)
+			sums[entry.Path] = sum
+			posts.add(fileHookEnv(ref, targetFolder, entry, objectURL, "skipped"))
+			continue
+		}
+		if err := runHook(opts.hooks.preFile, fileHookEnv(ref, targetFolder, entry, objectURL, "pending")); err != nil {
+			fmt.Fprintf(stdout, "--pre-file hook exited with %v, skipping %s\n", err, objectURL)
+			hookSkipped[entry.Path] = true
+			continue
+		}
+		store.expect(key, entry)
+		status := "downloaded"
+		if err := d.DownloadFile(ctx, ref.ResolvePath(entry.Path), key, entry.Size, entry.LFSOID); err != nil {
+			fmt.Fprintf(stdout, "Cannot stream %s to %s: %v\n", entry.Path, objectURL, err)
+			failed++
+			status = "failed"
+		} else {
+			sums[entry.Path] = store.sum(key)
+		}
+		posts.add(fileHookEnv(ref, targetFolder, entry, objectURL, status))
+	}
+	if failed > 0 {
+		return failed
+	}
+	for _, entry := range entries {
+		if hookSkipped[entry.Path] {
+			continue
+		}
+		manifest.Files = append(manifest.Files, markerFile{Path: entry.Path, Size: entry.Size, OID: entry.OID, SHA256: entry.LFSOID})
+	}
+	manifest.DownloadedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = c.putObject(ctx, c.key(relFolder, downloadManifestName), data, http.Header{"Content-Type": {"application/json"}})
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot store %s: %v\n", downloadManifestName, err)
+		return 1
+	}
+	if _, own := sums[checksumsName]; own {
+		fmt.Fprintf(stdout, "The repo has its own %s, not writing one\n", checksumsName)
+		return 0
+	}
+	if err := c.putObject(ctx, c.key(relFolder, checksumsName), []byte(formatChecksums(sums)), http.Header{"Content-Type": {"text/plain"}}); err != nil {
+		fmt.Fprintf(stdout, "Cannot store %s: %v\n", checksumsName, err)
+		return 1
+	}
+	return 0
+}
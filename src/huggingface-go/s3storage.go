@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// s3Storage is the hfdl.Storage of --output: the Downloader writes a file to
+// <key>.tmp, which becomes a multipart upload of key, and renames it to key once the
+// sha256 matched, which completes the upload, so a broken object never appears.
+// Parts are uploaded in the background, workers at the same time, while the download
+// goes on; a file smaller than a part goes up in one request when it is renamed.
+// Names are object keys.
+type s3Storage struct {
+	c       *s3Client
+	ctx     context.Context
+	workers int
+
+	mu      sync.Mutex
+	uploads map[string]*s3Upload   // by key
+	meta    map[string]http.Header // metadata of the files about to be written, see expect
+	sizes   map[string]int64       // their sizes, for the part size
+	sums    map[string]string      // sha256 of the objects stored
+}
+
+// s3Upload is a file being written: its uploaded parts and the tail that is not a
+// whole part yet and is kept in memory until more is written or the file is renamed.
+type s3Upload struct {
+	id       string // "" until the first part is full
+	partSize int64
+	etags    []string // of the uploaded parts
+	size     int64    // bytes written: the uploaded parts and the tail
+	tail     []byte
+	state    []byte // sha256 state after size bytes
+}
+
+func newS3Storage(ctx context.Context, c *s3Client) *s3Storage {
+	return &s3Storage{c: c, ctx: ctx, workers: max(c.workers, 1), uploads: make(map[string]*s3Upload), meta: make(map[string]http.Header), sizes: make(map[string]int64), sums: make(map[string]string)}
+}
+
+// expect tells s the size and hashes of the file that is about to be written to key;
+// they go into the metadata of the object, see sameObject.
+func (s *s3Storage) expect(key string, entry hfdl.FileEntry) {
+	meta := http.Header{}
+	if entry.LFSOID != "" {
+		meta.Set(s3SHA256Meta, entry.LFSOID)
+	}
+	if entry.OID != "" {
+		meta.Set(s3OIDMeta, entry.OID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[key], s.sizes[key] = meta, entry.Size
+}
+
+// sum returns the sha256 of an object s stored.
+func (s *s3Storage) sum(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sums[key]
+}
+
+func tmpKey(name string) (string, bool) {
+	return strings.CutSuffix(name, ".tmp")
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+	if _, ok := tmpKey(name); ok {
+		return nil, fmt.Errorf("%s is a multipart upload and cannot be read back", name)
+	}
+	response, err := s.c.do(s.ctx, http.MethodGet, name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s *s3Storage) Write(name string, truncate bool) (io.WriteCloser, error) {
+	key, ok := tmpKey(name)
+	if !ok {
+		return nil, fmt.Errorf("cannot write %s, only .tmp files are written", name)
+	}
+	s.mu.Lock()
+	u := s.uploads[key]
+	if truncate || u == nil {
+		if u != nil && u.id != "" {
+			defer s.c.abortUpload(s.ctx, key, u.id)
+		}
+		state, _ := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+		size := s.sizes[key]
+		u = &s3Upload{partSize: max(s3PartSize, (size+s3MaxParts-1)/s3MaxParts), state: state}
+		s.uploads[key] = u
+	}
+	meta := s.meta[key]
+	s.mu.Unlock()
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.state); err != nil {
+		return nil, err
+	}
+	return &s3PartWriter{s: s, key: key, meta: meta, u: u, h: h, buf: u.tail, slots: make(chan struct{}, s.workers), base: len(u.etags), uploaded: u.size - int64(len(u.tail))}, nil
+}
+
+func (s *s3Storage) Stat(name string) (fs.FileInfo, error) {
+	if key, ok := tmpKey(name); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if u := s.uploads[key]; u != nil {
+			return s3FileInfo{name: name, size: u.size}, nil
+		}
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	size, _, exists, err := s.c.stat(s.ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return s3FileInfo{name: name, size: size}, nil
+}
+
+// Rename completes the upload of oldName, <newName>.tmp, as newName.
+func (s *s3Storage) Rename(oldName, newName string) error {
+	key, ok := tmpKey(oldName)
+	if !ok || key != newName {
+		return fmt.Errorf("cannot rename %s to %s", oldName, newName)
+	}
+	s.mu.Lock()
+	u, meta := s.uploads[key], s.meta[key].Clone()
+	delete(s.uploads, key)
+	s.mu.Unlock()
+	if u == nil {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.state); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if u.id == "" {
+		// 不到一块的文件一次上传，哈希已经知道，写进元数据
+		if meta == nil {
+			meta = http.Header{}
+		}
+		meta.Set(s3SHA256Meta, sum)
+		if err := s.c.putObject(s.ctx, key, u.tail, meta); err != nil {
+			return err
+		}
+	} else {
+		etags := u.etags
+		if len(u.tail) > 0 {
+			etag, err := s.c.uploadPart(s.ctx, key, u.id, len(etags)+1, u.tail)
+			if err != nil {
+				s.c.abortUpload(s.ctx, key, u.id)
+				return err
+			}
+			etags = append(etags, etag)
+		}
+		if err := s.c.completeUpload(s.ctx, key, u.id, etags); err != nil {
+			s.c.abortUpload(s.ctx, key, u.id)
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.sums[key] = sum
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *s3Storage) Remove(name string) error {
+	if key, ok := tmpKey(name); ok {
+		s.mu.Lock()
+		u := s.uploads[key]
+		delete(s.uploads, key)
+		s.mu.Unlock()
+		if u != nil && u.id != "" {
+			s.c.abortUpload(s.ctx, key, u.id)
+		}
+		return nil
+	}
+	response, err := s.c.do(s.ctx, http.MethodDelete, name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (s *s3Storage) WrittenSHA256(name string) ([]byte, error) {
+	key, _ := tmpKey(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.uploads[key]
+	if u == nil {
+		return nil, &fs.PathError{Op: "hash", Path: name, Err: fs.ErrNotExist}
+	}
+	return u.state, nil
+}
+
+// s3PartWriter appends to an s3Upload: whole parts are uploaded in the background,
+// at most cap(slots) at a time, and Close records how far the upload got.
+type s3PartWriter struct {
+	s        *s3Storage
+	key      string
+	meta     http.Header
+	u        *s3Upload
+	h        hash.Hash
+	buf      []byte
+	base     int   // parts uploaded before this writer
+	uploaded int64 // bytes in those parts
+
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	parts []s3Part // started by this writer, in order
+	err   error    // of the first part that failed
+}
+
+// s3Part is a part started by an s3PartWriter, with the size and hash state of the
+// upload after it, for when a later part fails.
+type s3Part struct {
+	etag  string
+	size  int64
+	state []byte
+	done  bool
+}
+
+func (w *s3PartWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		w.mu.Lock()
+		err := w.err
+		w.mu.Unlock()
+		if err != nil {
+			return written, err
+		}
+		if len(w.buf) == int(w.u.partSize) {
+			if err := w.startPart(); err != nil {
+				return written, err
+			}
+		}
+		n := min(len(p), int(w.u.partSize)-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		w.h.Write(p[:n])
+		p, written = p[n:], written+n
+	}
+	return written, nil
+}
+
+// startPart uploads the full buffer as the next part, waiting for a free slot.
+func (w *s3PartWriter) startPart() error {
+	if w.u.id == "" {
+		id, err := w.s.c.createUpload(w.s.ctx, w.key, w.meta)
+		if err != nil {
+			return err
+		}
+		w.u.id = id
+	}
+	state, err := w.h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	select {
+	case w.slots <- struct{}{}:
+	case <-w.s.ctx.Done():
+		return context.Cause(w.s.ctx)
+	}
+	w.mu.Lock()
+	w.uploaded += int64(len(w.buf))
+	w.parts = append(w.parts, s3Part{size: w.uploaded, state: state})
+	index := len(w.parts) - 1
+	w.mu.Unlock()
+	data := w.buf
+	// 每个上传中的块占一份内存，内存不超过 workers 块加上正在填的一块
+	w.buf = make([]byte, 0, w.u.partSize)
+	w.wg.Add(1)
+	go func() {
+		defer func() { <-w.slots; w.wg.Done() }()
+		etag, err := w.s.c.uploadPart(w.s.ctx, w.key, w.u.id, w.base+index+1, data)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+			return
+		}
+		w.parts[index].etag, w.parts[index].done = etag, true
+	}()
+	return nil
+}
+
+// Close waits for the parts in flight and keeps what was uploaded in order, so the
+// next Write resumes after it: the tail stays in memory when every part went up,
+// otherwise the upload goes back to the end of the last part before the first that
+// failed.
+func (w *s3PartWriter) Close() error {
+	w.wg.Wait()
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	for _, part := range w.parts {
+		if !part.done {
+			// 失败的块和它之后的块下次重新上传，已经传好的同号块会被覆盖；第一块就失败时
+			// 之前留在内存里的尾部还在 u.tail 里
+			return w.err
+		}
+		w.u.etags = append(w.u.etags, part.etag)
+		w.u.size, w.u.state, w.u.tail = part.size, part.state, nil
+	}
+	state, err := w.h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	w.u.size, w.u.tail, w.u.state = w.uploaded+int64(len(w.buf)), w.buf, state
+	return nil
+}
+
+// s3FileInfo is what Stat knows about an object or upload.
+type s3FileInfo struct {
+	name string
+	size int64
+}
+
+func (i s3FileInfo) Name() string       { return i.name }
+func (i s3FileInfo) Size() int64        { return i.size }
+func (i s3FileInfo) Mode() fs.FileMode  { return 0644 }
+func (i s3FileInfo) ModTime() time.Time { return time.Time{} }
+func (i s3FileInfo) IsDir() bool        { return false }
+func (i s3FileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// How --max-total-size and --max-files pick the files that fit.
+const (
+	sampleFirst  = "first"  // in the order of the listing
+	sampleRandom = "random" // in a random order that is the same on every run
+)
+
+// sampleOptions limits a download to a slice of a large repo, e.g. a few hundred GB
+// of a multi-TB dataset. Zero limits mean no limit.
+type sampleOptions struct {
+	maxSize  int64
+	maxFiles int
+	mode     string
+	seed     int64 // 0 derives the seed from the repo and revision
+}
+
+func (s sampleOptions) enabled() bool {
+	return s.maxSize > 0 || s.maxFiles > 0
+}
+
+// markerSample records the limits in the .complete marker, so update and verify
+// know that the folder holds only part of the repo.
+type markerSample struct {
+	MaxTotalSize int64  `json:"max_total_size,omitempty"`
+	MaxFiles     int    `json:"max_files,omitempty"`
+	Mode         string `json:"mode"`
+	Seed         int64  `json:"seed,omitempty"`
+}
+
+func (s sampleOptions) marker() *markerSample {
+	if !s.enabled() {
+		return nil
+	}
+	return &markerSample{MaxTotalSize: s.maxSize, MaxFiles: s.maxFiles, Mode: s.mode, Seed: s.seed}
+}
+
+// sampleEntries returns the entries that fit the limits. Files are taken in listing
+// order, or shuffled with --sample random; a file too large for what is left of the
+// size budget is passed over for smaller ones after it. The random order only depends
+// on the seed, so a resumed or repeated run picks the same files. The entries kept
+// stay in listing order.
+func sampleEntries(entries []hfdl.FileEntry, ref hfdl.Repo, s sampleOptions) []hfdl.FileEntry {
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	if s.mode == sampleRandom {
+		seed := s.seed
+		if seed == 0 {
+			h := fnv.New64a()
+			fmt.Fprintf(h, "%s/%s@%s", ref.Type, ref.ID, ref.Revision)
+			seed = int64(h.Sum64())
+		}
+		rand.New(rand.NewSource(seed)).Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+	}
+	var kept []int
+	var size, total int64
+	unknown := 0
+	for _, i := range order {
+		entry := entries[i]
+		total += max(entry.Size, 0)
+		if s.maxFiles > 0 && len(kept) >= s.maxFiles {
+			continue
+		}
+		if s.maxSize > 0 {
+			if entry.Size == hfdl.UnknownSize {
+				// 大小未知的文件没法计入预算
+				unknown++
+				continue
+			}
+			if size+entry.Size > s.maxSize {
+				continue
+			}
+		}
+		kept = append(kept, i)
+		size += max(entry.Size, 0)
+	}
+	sort.Ints(kept)
+	sampled := make([]hfdl.FileEntry, len(kept))
+	for n, i := range kept {
+		sampled[n] = entries[i]
+	}
+	keptSize, keptUnit := convertBytes(float64(size))
+	totalSize, totalUnit := convertBytes(float64(total))
+	fmt.Fprintf(stdout, "Sampled %d of %d files with --sample %s: %.2f %s of %.2f %s\n", len(sampled), len(entries), s.mode, keptSize, keptUnit, totalSize, totalUnit)
+	if unknown > 0 {
+		fmt.Fprintf(stdout, "Left out %d files of unknown size, they cannot be counted against --max-total-size\n", unknown)
+	}
+	return sampled
+}
+
+// sampledEntries keeps the entries that a sampled download or one with --official-splits
+// stored, see verify.
+func sampledEntries(entries []hfdl.FileEntry, stored []markerFile) []hfdl.FileEntry {
+	paths := make(map[string]bool, len(stored))
+	for _, f := range stored {
+		paths[f.Path] = true
+		if f.DecompressedFrom != "" {
+			paths[f.DecompressedFrom] = true
+		}
+	}
+	var kept []hfdl.FileEntry
+	for _, entry := range entries {
+		if paths[entry.Path] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// searchSorts maps the --sort values to the sort keys of the Hub API.
+var searchSorts = map[string]string{
+	"downloads": "downloads",
+	"likes":     "likes",
+	"trending":  "trendingScore",
+	"created":   "createdAt",
+	"modified":  "lastModified",
+}
+
+// runSearch implements `huggingface-go search [flags] <query>`.
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var repoType, sort string
+	var limit int
+	fs.StringVar(&repoType, "type", "model", "kind of repo to search: model, dataset or space")
+	fs.StringVar(&sort, "sort", "downloads", "order of the results, most first: downloads, likes, trending, created or modified")
+	fs.IntVar(&limit, "limit", 20, "maximum number of results")
+	query := strings.Join(parseFlags(fs, &g, flagsFirst(fs, args), "search [flags] <query>"), " ")
+	switch hfdl.RepoType(repoType) {
+	case hfdl.RepoTypeModel, hfdl.RepoTypeDataset, hfdl.RepoTypeSpace:
+	default:
+		fmt.Fprintf(stdout, "Invalid --type value %q, expected model, dataset or space\n", repoType)
+		os.Exit(2)
+	}
+	if searchSorts[sort] == "" {
+		fmt.Fprintf(stdout, "Invalid --sort value %q, expected downloads, likes, trending, created or modified\n", sort)
+		os.Exit(2)
+	}
+	if query == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	endpoint := g.endpoint(g.hub())
+	g.installAuth(endpoint)
+	params := url.Values{}
+	params.Set("search", query)
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("sort", searchSorts[sort])
+	params.Set("direction", "-1")
+	// 只取要打印的字段；usedStorage 是仓库占用的空间，不支持 expand 的镜像不返回它
+	for _, field := range []string{"downloads", "likes", "lastModified", "usedStorage"} {
+		params.Add("expand[]", field)
+	}
+	var results []struct {
+		ID           string `json:"id"`
+		Downloads    int64  `json:"downloads"`
+		Likes        int64  `json:"likes"`
+		LastModified string `json:"lastModified"`
+		UsedStorage  *int64 `json:"usedStorage"`
+	}
+	if err := fetchJSON(g.proxyURLHead, endpoint+"/api/"+repoType+"s?"+params.Encode(), &results); err != nil {
+		fmt.Fprintf(stdout, "Cannot search: %v\n", err)
+		os.Exit(1)
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "No results")
+		return
+	}
+	prefix := ""
+	if hfdl.RepoType(repoType) != hfdl.RepoTypeModel {
+		// 打印出来的名字可以直接传给 download
+		prefix = repoType + "s/"
+	}
+	fmt.Fprintf(stdout, "%-60s %10s %6s %13s  %s\n", "ID", "DOWNLOADS", "LIKES", "SIZE", "MODIFIED")
+	for _, result := range results {
+		size := fmt.Sprintf("%13s", "-")
+		if result.UsedStorage != nil {
+			size = sizeColumn(*result.UsedStorage)
+		}
+		modified := "-"
+		if len(result.LastModified) >= len("2006-01-02") {
+			modified = result.LastModified[:len("2006-01-02")]
+		}
+		fmt.Fprintf(stdout, "%-60s %10d %6d %s  %s\n", prefix+result.ID, result.Downloads, result.Likes, size, modified)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secrets are the strings that are never printed or written to a file as they are:
+// the access token and the credentials in proxy and mirror urls, see redact. Tokens of
+// an endpoint profile are added while downloading, when they are refreshed.
+var (
+	secretsMu sync.Mutex
+	secrets   = make(map[string]bool)
+)
+
+// stdout is where the commands print: everything written to it reaches os.Stdout
+// with the secrets redacted, so a message cannot leak one by forgetting to call redact.
+// --json sends it to stderr and --tui into its view, see setOutput.
+var stdout io.Writer = redactWriter{os.Stdout}
+
+// setOutput makes the commands print to w from now on, with the secrets redacted.
+func setOutput(w io.Writer) {
+	stdout = redactWriter{w}
+}
+
+// redactWriter writes to w with the secrets replaced by their fingerprints.
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	// fmt 的每次输出是一次 Write，密钥不会被拆到两次里
+	if _, err := io.WriteString(r.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+var (
+	hfTokenPattern  = regexp.MustCompile(`hf_[A-Za-z0-9]{30,}`)
+	bearerPattern   = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]{8,}`)
+	userinfoPattern = regexp.MustCompile(`(://[^/\s:@]+:)([^/\s@]+)@`)
+)
+
+func addSecret(s string) {
+	// 太短的值替换掉会误伤正常输出
+	if len(s) >= 4 {
+		secretsMu.Lock()
+		secrets[s] = true
+		secretsMu.Unlock()
+	}
+}
+
+// addURLSecrets registers the password and the query values of a proxy or mirror url.
+func addURLSecrets(rawURL string) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	if password, ok := u.User.Password(); ok {
+		addSecret(password)
+		addSecret(url.PathEscape(password))
+	}
+	for _, values := range u.Query() {
+		for _, v := range values {
+			// {url_encoded} 这样的占位符不是密钥
+			if len(v) >= 8 && !strings.Contains(v, "{") {
+				addSecret(v)
+				addSecret(url.QueryEscape(v))
+			}
+		}
+	}
+}
+
+// fingerprint stands in for a secret: the same secret always gives the same
+// fingerprint, so shared logs still show whether two runs used the same token.
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "[redacted:" + hex.EncodeToString(sum[:4]) + "]"
+}
+
+// redact returns the text of v with every secret, Hugging Face token, bearer token
+// and url password replaced by its fingerprint.
+func redact(v interface{}) string {
+	s, _ := redactCount(fmt.Sprint(v))
+	return s
+}
+
+func redactCount(s string) (string, int) {
+	found := 0
+	secretsMu.Lock()
+	known := make([]string, 0, len(secrets))
+	for secret := range secrets {
+		known = append(known, secret)
+	}
+	secretsMu.Unlock()
+	// 长的先换，免得一个密钥是另一个的一部分
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, secret := range known {
+		if n := strings.Count(s, secret); n > 0 {
+			found += n
+			s = strings.ReplaceAll(s, secret, fingerprint(secret))
+		}
+	}
+	s = hfTokenPattern.ReplaceAllStringFunc(s, func(token string) string {
+		found++
+		return fingerprint(token)
+	})
+	s = bearerPattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := bearerPattern.FindStringSubmatch(match)
+		if strings.HasPrefix(match[len(m[1]):], "[redacted:") {
+			return match
+		}
+		found++
+		return m[1] + fingerprint(match[len(m[1]):])
+	})
+	s = userinfoPattern.ReplaceAllStringFunc(s, func(match string) string {
+		m := userinfoPattern.FindStringSubmatch(match)
+		if strings.HasPrefix(m[2], "[redacted:") || m[2] == "***" {
+			return match
+		}
+		found++
+		return m[1] + fingerprint(m[2]) + "@"
+	})
+	return s, found
+}
+
+// runRedact implements `huggingface-go redact [flags] [log-file]`: it prints the log
+// (or stdin) with the secrets replaced, to check a log before sharing it in an issue.
+// The token and proxy flags (or their HFGO_* variables) tell it which secrets to look for.
+func runRedact(args []string) int {
+	flags := flag.NewFlagSet("redact", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	rest := parseFlags(flags, &g, args, "redact [flags] [log-file]")
+	var in io.Reader = os.Stdin
+	switch len(rest) {
+	case 0:
+	case 1:
+		file, err := os.Open(rest[0])
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot read %s: %v\n", rest[0], err)
+			return exitFailed
+		}
+		defer file.Close()
+		in = file
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	reader := bufio.NewReader(in)
+	total := 0
+	for {
+		line, err := reader.ReadString('\n')
+		redacted, n := redactCount(line)
+		total += n
+		fmt.Fprint(stdout, redacted)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read the log: %v\n", err)
+			return exitFailed
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Redacted %d secrets\n", total)
+	return exitOK
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runServeFiles implements `huggingface-go serve-files [flags] <dir>`: the complete
+// downloads below dir are served read-only with the Hub's url layout, so other machines
+// can use this one as a mirror (-m http://host:8080). With --pull-through anything else
+// is fetched from upstream (-m, -d, -p and -t) and cached below dir; as that uses the
+// token of this machine, other machines then need --secret.
+func runServeFiles(args []string) {
+	flags := flag.NewFlagSet("serve-files", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	var listen, secret string
+	var pull bool
+	flags.StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on, e.g. :8080 for other machines")
+	flags.BoolVar(&pull, "pull-through", false, "fetch repos and files that are not below <dir> from upstream on demand and keep them in <dir>/"+blobFolderName)
+	flags.StringVar(&secret, "secret", "", "only serve clients that give this password in the mirror url (-m http://hfgo:<secret>@host:8080) or as a bearer token; needed with --pull-through on an address other machines can reach, as upstream is fetched with the token of this machine")
+	rest := parseFlags(flags, &g, args, "serve-files [flags] <dir>")
+	if len(rest) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if pull && secret == "" && !isLoopbackAddress(listen) {
+		fmt.Fprintf(stdout, "--pull-through on %s would let anyone who can reach it download with your token, set --secret (or HFGO_SECRET) or listen on 127.0.0.1\n", listen)
+		os.Exit(2)
+	}
+	addSecret(secret)
+	index := newRepoIndex()
+	index.secret = secret
+	if err := index.scan(rest[0]); err != nil {
+		fmt.Fprintf(stdout, "Cannot scan %s: %v\n", rest[0], err)
+		os.Exit(1)
+	}
+	if pull {
+		var err error
+		if index.pull, err = newPullThrough(&g, rest[0]); err != nil {
+			fmt.Fprintf(stdout, "Cannot create blob cache: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(stdout, "Fetching everything else from %s\n", strings.Join(index.pull.d.Hosts(), ", "))
+	}
+	for _, key := range index.keys() {
+		fmt.Fprintf(stdout, "Serving %s from %s\n", key, index.repos[key].folder)
+	}
+	if secret != "" {
+		fmt.Fprintf(stdout, "Listening on %s, use it with -m http://hfgo:<secret>@<this host>%s\n", listen, listen[strings.LastIndex(listen, ":"):])
+	} else {
+		fmt.Fprintf(stdout, "Listening on %s, use it with -m http://<this host>%s\n", listen, listen[strings.LastIndex(listen, ":"):])
+	}
+	if err := http.ListenAndServe(listen, index); err != nil {
+		fmt.Fprintf(stdout, "Cannot serve: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// servedRepo is one complete download, described by its .complete marker, or a
+// listing fetched by the pull-through cache.
+type servedRepo struct {
+	folder string
+	marker completeMarker
+	files  map[string]markerFile
+	pull   *pullThrough // set for listings from upstream, files are then blobs below folder
+	ref    hfdl.Repo
+	commit string // from .hfgo-manifest.json, "" when unknown
+}
+
+func newServedRepo(folder string, marker completeMarker) *servedRepo {
+	repo := &servedRepo{folder: folder, marker: marker, files: make(map[string]markerFile, len(marker.Files))}
+	// 标记文件可能被改过，指到文件夹外面的路径不列出也不提供给其他机器
+	repo.marker.Files = nil
+	for _, f := range marker.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) || hasDotDot(f.Path) {
+			continue
+		}
+		repo.marker.Files = append(repo.marker.Files, f)
+		repo.files[f.Path] = f
+	}
+	return repo
+}
+
+// repoIndex maps type/id@revision to the local folders that hold it.
+type repoIndex struct {
+	mu     sync.RWMutex
+	repos  map[string]*servedRepo
+	pull   *pullThrough // nil unless --pull-through
+	secret string       // --secret, "" to serve everyone
+}
+
+// isLoopbackAddress reports whether a listen address only accepts connections from
+// this machine. ":8080" listens on every interface.
+func isLoopbackAddress(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authorized reports whether a request carries the --secret, as the password of
+// basic auth (from the userinfo of the mirror url) or as a bearer token.
+func (x *repoIndex) authorized(r *http.Request) bool {
+	if x.secret == "" {
+		return true
+	}
+	given, ok := "", false
+	if _, password, basic := r.BasicAuth(); basic {
+		given, ok = password, true
+	} else if token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); bearer {
+		given, ok = token, true
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(x.secret)) == 1
+}
+
+func newRepoIndex() *repoIndex {
+	return &repoIndex{repos: make(map[string]*servedRepo)}
+}
+
+func indexKey(repoType hfdl.RepoType, id, revision string) string {
+	return string(repoType) + "/" + id + "@" + revision
+}
+
+// scan adds every folder below root that holds a .complete marker. Partial
+// downloads have no marker and are not served.
+func (x *repoIndex) scan(root string) error {
+	return filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == blobFolderName {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || entry.Name() != completeMarkerName {
+			return nil
+		}
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		var marker completeMarker
+		if err := json.Unmarshal(data, &marker); err != nil {
+			fmt.Fprintf(stdout, "Ignoring invalid marker %s: %v\n", filePath, err)
+			return nil
+		}
+		x.add(filepath.Dir(filePath), marker)
+		return nil
+	})
+}
+
+// add serves the folder under the revision of its marker and, since downloads pin the
+// commit and ask for /resolve/<commit>/..., under the commit of its manifest.
+func (x *repoIndex) add(folder string, marker completeMarker) {
+	repo := newServedRepo(folder, marker)
+	if manifest, err := readDownloadManifest(folder); err == nil && manifest.Repo == marker.Repo && manifest.Type == marker.Type {
+		repo.commit = manifest.Commit
+	}
+	x.mu.Lock()
+	x.repos[indexKey(marker.Type, marker.Repo, marker.Revision)] = repo
+	if repo.commit != "" && repo.commit != marker.Revision {
+		x.repos[indexKey(marker.Type, marker.Repo, repo.commit)] = repo
+	}
+	x.mu.Unlock()
+}
+
+func (x *repoIndex) lookup(repoType hfdl.RepoType, id, revision string) *servedRepo {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.repos[indexKey(repoType, id, revision)]
+}
+
+// find returns any revision of the repo, for the repo info request.
+func (x *repoIndex) find(repoType hfdl.RepoType, id string) *servedRepo {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for _, repo := range x.repos {
+		if repo.marker.Type == repoType && repo.marker.Repo == id {
+			return repo
+		}
+	}
+	return nil
+}
+
+func (x *repoIndex) keys() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	keys := make([]string, 0, len(x.repos))
+	for key := range x.repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hubRequest is a parsed Hub url.
+type hubRequest struct {
+	kind     string // resolve, tree or info
+	repoType hfdl.RepoType
+	id       string
+	revision string
+	path     string
+}
+
+// parseHubRequest understands
+//
+//	/[datasets/|spaces/]<id>/resolve/<revision>/<path>
+//	/api/<type>s/<id>/tree/<revision>[/<path>]
+//	/api/<type>s/<id>[/revision/<revision>]
+//
+// where the revision may be escaped (refs%2Fpr%2F3).
+func parseHubRequest(escapedPath string) (hubRequest, bool) {
+	req := hubRequest{repoType: hfdl.RepoTypeModel}
+	p := strings.TrimPrefix(escapedPath, "/")
+	var rest string
+	if api, ok := strings.CutPrefix(p, "api/"); ok {
+		typeName, repoPath, _ := strings.Cut(api, "/")
+		req.repoType = hfdl.RepoType(strings.TrimSuffix(typeName, "s"))
+		if id, after, ok := strings.Cut(repoPath, "/tree/"); ok {
+			req.kind, req.id, rest = "tree", id, after
+		} else {
+			req.kind = "info"
+			req.id, req.revision, _ = strings.Cut(repoPath, "/revision/")
+		}
+	} else {
+		for _, t := range []hfdl.RepoType{hfdl.RepoTypeDataset, hfdl.RepoTypeSpace} {
+			if after, ok := strings.CutPrefix(p, string(t)+"s/"); ok {
+				req.repoType, p = t, after
+			}
+		}
+		id, after, ok := strings.Cut(p, "/resolve/")
+		if !ok {
+			return req, false
+		}
+		req.kind, req.id, rest = "resolve", id, after
+	}
+	switch req.repoType {
+	case hfdl.RepoTypeModel, hfdl.RepoTypeDataset, hfdl.RepoTypeSpace:
+	default:
+		return req, false
+	}
+	if req.kind != "info" {
+		req.revision, req.path, _ = strings.Cut(rest, "/")
+		if req.revision == "" || (req.kind == "resolve" && req.path == "") {
+			return req, false
+		}
+	}
+	var err error
+	if req.id, err = url.PathUnescape(req.id); err != nil || req.id == "" {
+		return req, false
+	}
+	if req.revision, err = url.PathUnescape(req.revision); err != nil {
+		return req, false
+	}
+	if req.path, err = url.PathUnescape(req.path); err != nil {
+		return req, false
+	}
+	req.path = strings.Trim(req.path, "/")
+	return req, true
+}
+
+func (x *repoIndex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !x.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="huggingface-go"`)
+		writeJSONError(w, http.StatusUnauthorized, "Invalid credentials, give the --secret of the server in the mirror url")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "read-only server", http.StatusMethodNotAllowed)
+		return
+	}
+	req, ok := parseHubRequest(r.URL.EscapedPath())
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch req.kind {
+	case "info":
+		repo := x.find(req.repoType, req.id)
+		if req.revision != "" {
+			repo = x.lookup(req.repoType, req.id, req.revision)
+		}
+		switch {
+		case repo != nil:
+			info := map[string]interface{}{"id": req.id, "private": false}
+			if repo.commit != "" {
+				// 下载按这个提交固定版本
+				info["sha"] = repo.commit
+			}
+			writeJSON(w, info)
+		case x.pull != nil:
+			x.pull.forward(w, r)
+		default:
+			writeJSONError(w, http.StatusNotFound, "Repository not found")
+		}
+	case "tree":
+		repo, err := x.repo(r.Context(), req)
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if repo == nil {
+			writeJSONError(w, http.StatusNotFound, "Revision not found")
+			return
+		}
+		writeJSON(w, repo.tree(req.path, r.URL.Query().Get("recursive") == "true"))
+	case "resolve":
+		repo, err := x.repo(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		if repo == nil {
+			http.NotFound(w, r)
+			return
+		}
+		repo.serveFile(w, r, req.path)
+	}
+}
+
+// repo returns the local copy of the requested revision, or the upstream listing
+// with --pull-through. Without either it returns nil.
+func (x *repoIndex) repo(ctx context.Context, req hubRequest) (*servedRepo, error) {
+	if repo := x.lookup(req.repoType, req.id, req.revision); repo != nil || x.pull == nil {
+		return repo, nil
+	}
+	return x.pull.repo(ctx, req.repoType, req.id, req.revision)
+}
+
+// serveFile sends one file of the repo with Range support. Only files listed in
+// the marker are served, nothing else from the folder.
+func (s *servedRepo) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	f, ok := s.files[filePath]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	localPath := filepath.Join(s.folder, filepath.FromSlash(f.Path))
+	if s.pull != nil {
+		var err error
+		if localPath, err = s.pull.blob(r.Context(), s.ref, f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 和 Hub 一样：LFS 文件的 ETag 是 sha256，其余文件是 git blob id
+	etag := f.OID
+	if f.SHA256 != "" {
+		etag = f.SHA256
+	}
+	if etag != "" {
+		w.Header().Set("ETag", `"`+etag+`"`)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, path.Base(f.Path), stat.ModTime(), file)
+}
+
+// tree lists the files below dir in the format of the Hub's tree api.
+func (s *servedRepo) tree(dir string, recursive bool) []map[string]interface{} {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	out := make([]map[string]interface{}, 0)
+	dirs := make(map[string]bool)
+	addDir := func(dirPath string) {
+		if !dirs[dirPath] {
+			dirs[dirPath] = true
+			out = append(out, map[string]interface{}{"type": "directory", "oid": "", "size": 0, "path": dirPath})
+		}
+	}
+	for _, f := range s.marker.Files {
+		rel, ok := strings.CutPrefix(f.Path, prefix)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(rel, "/")
+		for i := 1; i < len(parts); i++ {
+			addDir(prefix + strings.Join(parts[:i], "/"))
+			if !recursive {
+				break
+			}
+		}
+		if len(parts) > 1 && !recursive {
+			continue
+		}
+		entry := map[string]interface{}{"type": "file", "oid": f.OID, "size": f.Size, "path": f.Path}
+		if f.SHA256 != "" {
+			entry["lfs"] = map[string]interface{}{"oid": f.SHA256, "size": f.Size}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
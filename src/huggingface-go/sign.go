@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 签名文件和 .complete 放在一起，签的是 .complete 的原始内容
+const manifestSignatureName = completeMarkerName + ".sig"
+
+// manifestSignature is the content of the .complete.sig file.
+type manifestSignature struct {
+	Algorithm string `json:"algorithm"`  // ed25519, ecdsa-sha256 or rsa-sha256
+	KeySHA256 string `json:"key_sha256"` // SHA-256 of the DER public key, to pick the right key
+	Signature []byte `json:"signature"`
+}
+
+// loadSigningKey reads a PEM private key (PKCS#8, EC or PKCS#1 RSA).
+func loadSigningKey(keyPath string) (crypto.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", keyPath)
+	}
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", keyPath, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported key type %T", keyPath, key)
+	}
+	return signer, nil
+}
+
+func loadVerifyKey(keyPath string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", keyPath)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", keyPath, err)
+	}
+	return key, nil
+}
+
+func keyFingerprint(public crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// signManifest signs the .complete marker of folder and writes the signature next to it.
+func signManifest(folder string, signer crypto.Signer) error {
+	data, err := os.ReadFile(filepath.Join(folder, completeMarkerName))
+	if err != nil {
+		return err
+	}
+	sig := manifestSignature{}
+	if sig.KeySHA256, err = keyFingerprint(signer.Public()); err != nil {
+		return err
+	}
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig.Algorithm = "ed25519"
+		sig.Signature, err = signer.Sign(rand.Reader, data, crypto.Hash(0))
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		sig.Algorithm = "ecdsa-sha256"
+		if _, ok := signer.Public().(*rsa.PublicKey); ok {
+			sig.Algorithm = "rsa-sha256"
+		}
+		digest := sha256.Sum256(data)
+		sig.Signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return fmt.Errorf("unsupported key type %T", signer.Public())
+	}
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(folder, manifestSignatureName+".tmp")
+	if err := os.WriteFile(tmpPath, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(folder, manifestSignatureName))
+}
+
+// verifyManifestSignature checks the .complete.sig of folder against a PEM public key.
+func verifyManifestSignature(folder string, public crypto.PublicKey) error {
+	data, err := os.ReadFile(filepath.Join(folder, completeMarkerName))
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(filepath.Join(folder, manifestSignatureName))
+	if err != nil {
+		return err
+	}
+	var sig manifestSignature
+	if err := json.Unmarshal(raw, &sig); err != nil {
+		return fmt.Errorf("invalid %s: %v", manifestSignatureName, err)
+	}
+	if fingerprint, err := keyFingerprint(public); err != nil || fingerprint != sig.KeySHA256 {
+		return fmt.Errorf("%s was signed with another key (%s)", manifestSignatureName, sig.KeySHA256)
+	}
+	digest := sha256.Sum256(data)
+	valid := false
+	switch key := public.(type) {
+	case ed25519.PublicKey:
+		valid = sig.Algorithm == "ed25519" && ed25519.Verify(key, data, sig.Signature)
+	case *ecdsa.PublicKey:
+		valid = sig.Algorithm == "ecdsa-sha256" && ecdsa.VerifyASN1(key, digest[:], sig.Signature)
+	case *rsa.PublicKey:
+		valid = sig.Algorithm == "rsa-sha256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig.Signature) == nil
+	default:
+		return fmt.Errorf("unsupported key type %T", public)
+	}
+	if !valid {
+		return errors.New("manifest signature does not match, the .complete manifest was modified")
+	}
+	return nil
+}
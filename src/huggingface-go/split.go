@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 每个卷上留出一点余量，不把磁盘写满
+const volumeReserve = 64 << 20
+
+// planPlacement assigns every entry of --split-across to a volume (an index into
+// volumes). Files already present on a volume stay there; the others go, largest
+// first, to the volume with the most free space left. relFolder is the repo folder
+// below each volume.
+func planPlacement(volumes []string, relFolder string, entries []hfdl.FileEntry) (map[string]int, error) {
+	free := make([]int64, len(volumes))
+	for i, volume := range volumes {
+		bytes, ok := diskFree(volume)
+		if !ok {
+			return nil, fmt.Errorf("cannot determine the free space of %s", volume)
+		}
+		free[i] = int64(bytes) - volumeReserve
+	}
+	placement := make(map[string]int, len(entries))
+	var pending []hfdl.FileEntry
+	for _, entry := range entries {
+		placed := false
+		for i, volume := range volumes {
+			filePath := path.Join(volume, relFolder, entry.Path)
+			if stat, err := os.Lstat(filePath); err == nil && stat.Mode().IsRegular() {
+				placement[entry.Path], placed = i, true
+				break
+			}
+			if stat, err := os.Stat(filePath + ".tmp"); err == nil {
+				// 继续之前没下载完的文件
+				placement[entry.Path], placed = i, true
+				free[i] -= entry.Size - stat.Size()
+				break
+			}
+		}
+		if !placed {
+			pending = append(pending, entry)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return pending[i].Size > pending[j].Size })
+	for _, entry := range pending {
+		best := 0
+		for i := range volumes {
+			if free[i] > free[best] {
+				best = i
+			}
+		}
+		if free[best] < max(entry.Size, 0) {
+			return nil, fmt.Errorf("no volume has room for %s (%d bytes)", entry.Path, entry.Size)
+		}
+		placement[entry.Path] = best
+		free[best] -= max(entry.Size, 0)
+	}
+	return placement, nil
+}
+
+// printPlacement summarizes how many files and bytes go to each volume.
+func printPlacement(volumes []string, entries []hfdl.FileEntry, placement map[string]int) {
+	counts := make([]int, len(volumes))
+	sizes := make([]float64, len(volumes))
+	for _, entry := range entries {
+		counts[placement[entry.Path]]++
+		sizes[placement[entry.Path]] += float64(max(entry.Size, 0))
+	}
+	for i, volume := range volumes {
+		size, unit := convertBytes(sizes[i])
+		fmt.Fprintf(stdout, "  %s: %d files, %.2f %s\n", volume, counts[i], size, unit)
+	}
+}
+
+// linkPlacedFiles makes the files stored on other volumes reachable from the repo
+// folder on the first volume through symlinks, so the repo loads from one path.
+func linkPlacedFiles(targetFolder string, placed map[string]string) error {
+	for filePath, volumeFolder := range placed {
+		target, err := filepath.Abs(filepath.Join(volumeFolder, filepath.FromSlash(filePath)))
+		if err != nil {
+			return err
+		}
+		link := filepath.Join(targetFolder, filepath.FromSlash(filePath))
+		if current, err := os.Readlink(link); err == nil && current == target {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return err
+		}
+		os.Remove(link)
+		if err := os.Symlink(target, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
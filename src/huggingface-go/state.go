@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 中断（崩溃或 Ctrl+C）后留在目标文件夹里，下次运行直接用里面的文件列表，不用重新列出整个仓库
+const stateFileName = ".hfgo-state.json"
+
+// 每个文件下载完都重写整个状态文件太慢（文件多时是平方级的），最多这么久写一次；
+// 中断时少记的状态下次按大小跳过
+const stateSaveInterval = 5 * time.Second
+
+// Per-file status recorded in the state file.
+const (
+	statePending = "pending"
+	stateDone    = "done"
+	stateFailed  = "failed"
+	stateSkipped = "skipped" // left out by the --pre-file hook
+)
+
+// runState is the content of .hfgo-state.json. It is only valid for the same
+// repo, revision, commit, path and filters it was written for.
+type runState struct {
+	Repo      string        `json:"repo"`
+	Type      hfdl.RepoType `json:"type"`
+	Revision  string        `json:"revision"`
+	Commit    string        `json:"commit,omitempty"` // the listing is of this commit
+	Path      string        `json:"path,omitempty"`
+	Include   []string      `json:"include,omitempty"`
+	Exclude   []string      `json:"exclude,omitempty"`
+	Entries   []stateEntry  `json:"entries"` // the listing, before --unknown-entries and plugins
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	folder string
+	index  map[string]int // entry of each path
+	saved  time.Time
+}
+
+type stateEntry struct {
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	OID    string `json:"oid,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Status string `json:"status"`
+	Offset int64  `json:"offset,omitempty"` // bytes already in the .tmp file
+}
+
+func newRunState(folder string, ref hfdl.Repo, commit string, filter fileFilter, listing []hfdl.FileEntry) *runState {
+	state := &runState{Repo: ref.ID, Type: ref.Type, Revision: ref.Revision, Commit: commit, Path: ref.Path, Include: filter.include, Exclude: filter.exclude, folder: folder}
+	for _, entry := range listing {
+		state.Entries = append(state.Entries, stateEntry{Type: entry.Type, Path: entry.Path, Size: entry.Size, OID: entry.OID, SHA256: entry.LFSOID, Status: statePending})
+	}
+	return state
+}
+
+// loadRunState returns the state left in folder by an earlier run of the same job,
+// or nil when there is none or it was written for something else. A state of another
+// commit than commit (the revision has moved on since) is not used either.
+func loadRunState(folder string, ref hfdl.Repo, commit string, filter fileFilter) *runState {
+	data, err := os.ReadFile(filepath.Join(folder, stateFileName))
+	if err != nil {
+		return nil
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	if state.Repo != ref.ID || state.Type != ref.Type || state.Revision != ref.Revision || state.Path != ref.Path ||
+		(commit != "" && state.Commit != commit) || !slices.Equal(state.Include, filter.include) || !slices.Equal(state.Exclude, filter.exclude) {
+		return nil
+	}
+	state.folder = folder
+	return &state
+}
+
+// listing converts the saved entries back into a tree listing.
+func (s *runState) listing() []hfdl.FileEntry {
+	entries := make([]hfdl.FileEntry, 0, len(s.Entries))
+	for _, entry := range s.Entries {
+		entries = append(entries, hfdl.FileEntry{Type: entry.Type, Path: entry.Path, Size: entry.Size, OID: entry.OID, LFSOID: entry.SHA256})
+	}
+	return entries
+}
+
+// count returns how many entries have the given status.
+func (s *runState) count(status string) int {
+	n := 0
+	for _, entry := range s.Entries {
+		if entry.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// setStatus records the status of one file and how much of its .tmp file exists.
+func (s *runState) setStatus(filePath, status string) {
+	if s == nil {
+		return
+	}
+	if s.index == nil {
+		s.index = make(map[string]int, len(s.Entries))
+		for i, entry := range s.Entries {
+			s.index[entry.Path] = i
+		}
+	}
+	i, ok := s.index[filePath]
+	if !ok {
+		return
+	}
+	entry := &s.Entries[i]
+	entry.Status, entry.Offset = status, 0
+	if status != stateDone {
+		if stat, err := os.Stat(filepath.Join(s.folder, filepath.FromSlash(filePath)) + ".tmp"); err == nil {
+			entry.Offset = stat.Size()
+		}
+	}
+}
+
+// save writes the state when stateSaveInterval has passed since it was last written.
+func (s *runState) save() {
+	if s != nil && time.Since(s.saved) >= stateSaveInterval {
+		s.flush()
+	}
+}
+
+// flush writes the state atomically; errors are ignored, the state is only a shortcut.
+func (s *runState) flush() {
+	if s == nil {
+		return
+	}
+	s.saved = time.Now()
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return
+	}
+	tmpPath := filepath.Join(s.folder, stateFileName+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return
+	}
+	os.Rename(tmpPath, filepath.Join(s.folder, stateFileName))
+}
+
+func (s *runState) remove() {
+	if s != nil {
+		os.Remove(filepath.Join(s.folder, stateFileName))
+	}
+}
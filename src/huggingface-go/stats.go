@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runStats samples resource usage during a run for --stats.
+type runStats struct {
+	start          time.Time
+	done           chan struct{}
+	peakSys        uint64
+	peakGoroutines int64
+	slots          *hfdl.FileSlots // set once downloading starts, counts bytes written to disk
+	fileBytes      int64           // size of the files completed in this run
+}
+
+func startStats() *runStats {
+	s := &runStats{start: time.Now(), done: make(chan struct{})}
+	s.sample()
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.sample()
+			}
+		}
+	}()
+	return s
+}
+
+func (s *runStats) sample() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	if m.Sys > atomic.LoadUint64(&s.peakSys) {
+		atomic.StoreUint64(&s.peakSys, m.Sys)
+	}
+	if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&s.peakGoroutines) {
+		atomic.StoreInt64(&s.peakGoroutines, n)
+	}
+}
+
+func (s *runStats) addFileBytes(n int64) {
+	atomic.AddInt64(&s.fileBytes, n)
+}
+
+func (s *runStats) report() {
+	close(s.done)
+	s.sample()
+	fmt.Fprintln(stdout, "Run statistics:")
+	fmt.Fprintf(stdout, "  Wall time: %s\n", time.Since(s.start).Round(time.Millisecond))
+	if user, system, ok := cpuTime(); ok {
+		fmt.Fprintf(stdout, "  CPU time: %s (user %s, system %s)\n", (user + system).Round(time.Millisecond), user.Round(time.Millisecond), system.Round(time.Millisecond))
+	}
+	peak, unit := convertBytes(float64(atomic.LoadUint64(&s.peakSys)))
+	fmt.Fprintf(stdout, "  Peak memory: %.2f %s (Go runtime)", peak, unit)
+	if rss, ok := peakRSS(); ok {
+		peak, unit = convertBytes(float64(rss))
+		fmt.Fprintf(stdout, ", peak RSS %.2f %s", peak, unit)
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, "  Peak goroutines: %d\n", atomic.LoadInt64(&s.peakGoroutines))
+	if s.slots == nil {
+		return
+	}
+	fmt.Fprintf(stdout, "  Peak open files: %d\n", s.slots.Peak())
+	written := s.slots.Written()
+	fileBytes := atomic.LoadInt64(&s.fileBytes)
+	w, wUnit := convertBytes(float64(written))
+	f, fUnit := convertBytes(float64(fileBytes))
+	if fileBytes > 0 {
+		fmt.Fprintf(stdout, "  Disk writes: %.2f %s for %.2f %s of files (write amplification %.2f)\n", w, wUnit, f, fUnit, float64(written)/float64(fileBytes))
+	} else {
+		fmt.Fprintf(stdout, "  Disk writes: %.2f %s\n", w, wUnit)
+	}
+}
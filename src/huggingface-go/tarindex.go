@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const tarIndexSuffix = ".idx"
+
+// tarSample is one line of a shard index: the members of a WebDataset sample, which
+// share the key in front of the first dot of their name, with the offset and size of
+// their content in the tar file.
+type tarSample struct {
+	Key   string              `json:"key"`
+	Files map[string][2]int64 `json:"files"` // extension -> [offset, size]
+}
+
+// indexTarShards writes a <shard>.tar.idx next to every plain .tar file of the
+// download (see --index-tars). Indexes newer than their shard are kept.
+func indexTarShards(folder string, files []markerFile) {
+	for _, f := range files {
+		if !strings.HasSuffix(f.Path, ".tar") {
+			continue
+		}
+		tarPath := filepath.Join(folder, filepath.FromSlash(f.Path))
+		shard, err := os.Stat(tarPath)
+		if err != nil {
+			continue
+		}
+		if index, err := os.Stat(tarPath + tarIndexSuffix); err == nil && !index.ModTime().Before(shard.ModTime()) {
+			continue
+		}
+		samples, err := writeTarIndex(tarPath)
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot index %s: %v\n", f.Path, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "Indexed %s: %d samples\n", f.Path, samples)
+	}
+}
+
+// countingReader tracks the offset in the tar file; archive/tar reads headers in
+// whole blocks, so after Next the offset is where the member's content starts.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeTarIndex writes the JSON lines index of one shard and returns the number of samples.
+func writeTarIndex(tarPath string) (int, error) {
+	in, err := os.Open(tarPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmpPath := tarPath + tarIndexSuffix + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+
+	counter := &countingReader{r: bufio.NewReaderSize(in, 1<<20)}
+	tr := tar.NewReader(counter)
+	samples := 0
+	var sample *tarSample
+	flush := func() error {
+		if sample == nil {
+			return nil
+		}
+		samples++
+		return encoder.Encode(sample)
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// WebDataset：dir/0001.jpg 和 dir/0001.cls 属于同一个样本 dir/0001
+		dir, name := path.Split(header.Name)
+		key, ext, _ := strings.Cut(name, ".")
+		key = dir + key
+		if sample == nil || sample.Key != key {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+			sample = &tarSample{Key: key, Files: make(map[string][2]int64)}
+		}
+		sample.Files[ext] = [2]int64{counter.n, header.Size}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return samples, os.Rename(tmpPath, tarPath+tarIndexSuffix)
+}
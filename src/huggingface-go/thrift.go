@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A minimal reader and writer for the Thrift compact protocol, enough to load a
+// parquet footer, edit a few fields and write it back. Structs are kept as generic
+// field lists so fields this code does not know about survive the round trip.
+
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+// thriftField holds bool, int64 (for all integer types), float64, []byte,
+// *thriftListValue, *thriftMapValue or *thriftStructValue.
+type thriftField struct {
+	id    int16
+	typ   byte
+	value interface{}
+}
+
+type thriftStructValue struct {
+	fields []thriftField
+}
+
+type thriftListValue struct {
+	elem   byte
+	values []interface{}
+}
+
+type thriftMapValue struct {
+	key, elem  byte
+	keys, vals []interface{}
+}
+
+var errThriftTruncated = errors.New("truncated thrift data")
+
+func (s *thriftStructValue) field(id int16) *thriftField {
+	for i := range s.fields {
+		if s.fields[i].id == id {
+			return &s.fields[i]
+		}
+	}
+	return nil
+}
+
+func (s *thriftStructValue) int(id int16) (int64, bool) {
+	if f := s.field(id); f != nil {
+		v, ok := f.value.(int64)
+		return v, ok
+	}
+	return 0, false
+}
+
+func (s *thriftStructValue) string(id int16) string {
+	if f := s.field(id); f != nil {
+		if v, ok := f.value.([]byte); ok {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+func (s *thriftStructValue) structField(id int16) *thriftStructValue {
+	if f := s.field(id); f != nil {
+		v, _ := f.value.(*thriftStructValue)
+		return v
+	}
+	return nil
+}
+
+func (s *thriftStructValue) list(id int16) *thriftListValue {
+	if f := s.field(id); f != nil {
+		v, _ := f.value.(*thriftListValue)
+		return v
+	}
+	return nil
+}
+
+// setInt updates an existing integer field, keeping its wire type.
+func (s *thriftStructValue) setInt(id int16, v int64) {
+	if f := s.field(id); f != nil {
+		f.value = v
+	}
+}
+
+func (s *thriftStructValue) remove(ids ...int16) {
+	kept := s.fields[:0]
+	for _, f := range s.fields {
+		drop := false
+		for _, id := range ids {
+			drop = drop || f.id == id
+		}
+		if !drop {
+			kept = append(kept, f)
+		}
+	}
+	s.fields = kept
+}
+
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func decodeThriftStruct(data []byte) (*thriftStructValue, error) {
+	r := &thriftReader{data: data}
+	return r.readStruct(0)
+}
+
+func (r *thriftReader) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errThriftTruncated
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errThriftTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+func (r *thriftReader) zigzag() (int64, error) {
+	v, err := r.varint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (r *thriftReader) readStruct(depth int) (*thriftStructValue, error) {
+	if depth > 64 {
+		return nil, errors.New("thrift data nested too deeply")
+	}
+	s := &thriftStructValue{}
+	var last int16
+	for {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == thriftStop {
+			return s, nil
+		}
+		typ := header & 0x0f
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			v, err := r.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		last = id
+		var value interface{}
+		if typ == thriftTrue || typ == thriftFalse {
+			// 结构体里的布尔值直接编码在类型里
+			value = typ == thriftTrue
+		} else if value, err = r.readValue(typ, depth); err != nil {
+			return nil, err
+		}
+		s.fields = append(s.fields, thriftField{id: id, typ: typ, value: value})
+	}
+}
+
+func (r *thriftReader) readValue(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		b, err := r.byte()
+		return b == 1, err
+	case thriftByte:
+		b, err := r.byte()
+		return int64(int8(b)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftDouble:
+		if r.pos+8 > len(r.data) {
+			return nil, errThriftTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+		r.pos += 8
+		return v, nil
+	case thriftBinary:
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(r.data)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		v := r.data[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		return v, nil
+	case thriftList, thriftSet:
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		l := &thriftListValue{elem: header & 0x0f}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = r.varint(); err != nil {
+				return nil, err
+			}
+		}
+		if size > uint64(len(r.data)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		for i := uint64(0); i < size; i++ {
+			v, err := r.readValue(l.elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			l.values = append(l.values, v)
+		}
+		return l, nil
+	case thriftMap:
+		size, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		m := &thriftMapValue{}
+		if size == 0 {
+			return m, nil
+		}
+		if size > uint64(len(r.data)-r.pos) {
+			return nil, errThriftTruncated
+		}
+		types, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		m.key, m.elem = types>>4, types&0x0f
+		for i := uint64(0); i < size; i++ {
+			k, err := r.readValue(m.key, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := r.readValue(m.elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			m.keys, m.vals = append(m.keys, k), append(m.vals, v)
+		}
+		return m, nil
+	case thriftStruct:
+		return r.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("unknown thrift type %d", typ)
+}
+
+func encodeThriftStruct(s *thriftStructValue) []byte {
+	var buf []byte
+	return appendThriftStruct(buf, s)
+}
+
+func appendZigzag(buf []byte, v int64) []byte {
+	return binary.AppendUvarint(buf, uint64(v<<1)^uint64(v>>63))
+}
+
+func appendThriftStruct(buf []byte, s *thriftStructValue) []byte {
+	var last int16
+	for _, f := range s.fields {
+		typ := f.typ
+		if typ == thriftTrue || typ == thriftFalse {
+			typ = thriftFalse
+			if f.value.(bool) {
+				typ = thriftTrue
+			}
+		}
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|typ)
+		} else {
+			buf = appendZigzag(append(buf, typ), int64(f.id))
+		}
+		last = f.id
+		if typ != thriftTrue && typ != thriftFalse {
+			buf = appendThriftValue(buf, typ, f.value)
+		}
+	}
+	return append(buf, thriftStop)
+}
+
+func appendThriftValue(buf []byte, typ byte, value interface{}) []byte {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		if value.(bool) {
+			return append(buf, 1)
+		}
+		return append(buf, 0)
+	case thriftByte:
+		return append(buf, byte(value.(int64)))
+	case thriftI16, thriftI32, thriftI64:
+		return appendZigzag(buf, value.(int64))
+	case thriftDouble:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(value.(float64)))
+	case thriftBinary:
+		v := value.([]byte)
+		return append(binary.AppendUvarint(buf, uint64(len(v))), v...)
+	case thriftList, thriftSet:
+		l := value.(*thriftListValue)
+		if len(l.values) < 15 {
+			buf = append(buf, byte(len(l.values))<<4|l.elem)
+		} else {
+			buf = binary.AppendUvarint(append(buf, 0xf0|l.elem), uint64(len(l.values)))
+		}
+		for _, v := range l.values {
+			buf = appendThriftValue(buf, l.elem, v)
+		}
+		return buf
+	case thriftMap:
+		m := value.(*thriftMapValue)
+		buf = binary.AppendUvarint(buf, uint64(len(m.keys)))
+		if len(m.keys) == 0 {
+			return buf
+		}
+		buf = append(buf, m.key<<4|m.elem)
+		for i := range m.keys {
+			buf = appendThriftValue(buf, m.key, m.keys[i])
+			buf = appendThriftValue(buf, m.elem, m.vals[i])
+		}
+		return buf
+	case thriftStruct:
+		return appendThriftStruct(buf, value.(*thriftStructValue))
+	}
+	return buf
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// Panes of the --tui view, switched with tab.
+const (
+	tuiActive = iota
+	tuiQueued
+	tuiErrors
+	tuiPanes
+)
+
+var tuiPaneNames = [tuiPanes]string{"Active", "Queued", "Errors"}
+
+const (
+	tuiRedraw   = 500 * time.Millisecond
+	tuiKeepLogs = 200              // lines of output and errors kept for the view
+	tuiWindow   = 10 * time.Second // the total speed is averaged over this long
+)
+
+// tuiTransfer is a file being downloaded.
+type tuiTransfer struct {
+	path    string // resolve path
+	size    int64
+	current int64
+	speed   float64 // bytes per second, smoothed
+	started time.Time
+	seen    time.Time // time of the last event
+}
+
+type tuiSample struct {
+	at          time.Time
+	transferred int64
+}
+
+// tuiQueue holds the files of one repo that have not been reached yet; downloadRepo
+// goes through them in order, so next only moves forward.
+type tuiQueue struct {
+	repo    string
+	entries []hfdl.FileEntry
+	next    int
+	tui     *downloadTUI
+}
+
+// downloadTUI is the full-screen view of --tui: the active transfers with their
+// speeds, the queued files, recent errors and the totals with an ETA. It replaces
+// the pb bars, which flicker and scroll away with many files and --repo-workers.
+// Everything else the program prints goes to it (see Write) and is shown at the bottom.
+type downloadTUI struct {
+	terminal   *os.File // the real stdout
+	state      *term.State
+	cancel     context.CancelCauseFunc
+	stopped    chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+	removeExit func()
+	partial    []byte // printed after the last newline
+
+	mu          sync.Mutex
+	started     time.Time
+	active      map[string]*tuiTransfer
+	queues      []*tuiQueue
+	queued      int         // files in the queues
+	total       int64       // known size of every file queued so far
+	finished    int64       // known size of the files reached and done or skipped
+	transferred int64       // bytes received so far, for the total speed
+	samples     []tuiSample // transferred over the last tuiWindow
+	totals      hfdl.Totals
+	errors      []string
+	logs        []string
+	pane        int
+	cursor      [tuiPanes]int
+	top         [tuiPanes]int
+	quits       int
+}
+
+// newTUI checks that the view can be shown; start then shows it.
+func newTUI() (*downloadTUI, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, errors.New("--tui needs a terminal")
+	}
+	return &downloadTUI{
+		terminal: os.Stdout,
+		stopped:  make(chan struct{}),
+		done:     make(chan struct{}),
+		active:   make(map[string]*tuiTransfer),
+	}, nil
+}
+
+// start switches the terminal to the alternate screen and raw mode and starts
+// drawing. Output printed while it is shown goes to the view instead of the screen.
+// The terminal is restored by stop, or by exit when the program quits at once.
+func (t *downloadTUI) start() error {
+	var err error
+	if t.state, err = term.MakeRaw(int(os.Stdin.Fd())); err != nil {
+		return err
+	}
+	t.started = time.Now()
+	t.removeExit = atExit(t.stop)
+	setOutput(t)
+	// 备用屏幕，退出后恢复原来的终端内容
+	fmt.Fprint(t.terminal, "\x1b[?1049h\x1b[?25l")
+	go t.readKeys()
+	go t.drawLoop()
+	return nil
+}
+
+// attach returns a context that is cancelled like a first Ctrl+C when q is pressed;
+// raw mode turns Ctrl+C into a key press instead of a signal.
+func (t *downloadTUI) attach(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	t.mu.Lock()
+	t.cancel = cancel
+	t.mu.Unlock()
+	return ctx
+}
+
+// stop restores the terminal and prints the errors the view showed, so they are
+// still visible after it is gone.
+func (t *downloadTUI) stop() {
+	t.stopOnce.Do(t.restore)
+}
+
+func (t *downloadTUI) restore() {
+	t.removeExit()
+	close(t.stopped)
+	<-t.done
+	setOutput(t.terminal)
+	fmt.Fprint(t.terminal, "\x1b[?25h\x1b[?1049l")
+	term.Restore(int(os.Stdin.Fd()), t.state)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range t.errors {
+		fmt.Fprintln(stdout, line)
+	}
+	fmt.Fprintf(stdout, "%d files done, %d failed in %v\n", t.totals.Done, t.totals.Failed, time.Since(t.started).Round(time.Second))
+}
+
+// Write keeps the lines the program prints while the view is shown; those reporting
+// a problem also go to the errors pane.
+func (t *downloadTUI) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		end := bytes.IndexByte(t.partial, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(t.partial[:end]))
+		t.partial = t.partial[end+1:]
+		if line == "" {
+			continue
+		}
+		t.logs = keepLast(append(t.logs, line), tuiKeepLogs)
+		if strings.HasPrefix(line, "Cannot ") || strings.HasPrefix(line, "Warning") || strings.Contains(line, " failed") {
+			t.errors = keepLast(append(t.errors, line), tuiKeepLogs)
+		}
+	}
+	return len(p), nil
+}
+
+func keepLast(lines []string, n int) []string {
+	if len(lines) > n {
+		return append(lines[:0], lines[len(lines)-n:]...)
+	}
+	return lines
+}
+
+func (t *downloadTUI) drawLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(tuiRedraw)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-ticker.C:
+		case <-t.stopped:
+			return
+		}
+	}
+}
+
+func (t *downloadTUI) draw() {
+	width, height, err := term.GetSize(int(t.terminal.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	t.mu.Lock()
+	screen := t.render(width, height, time.Now())
+	t.mu.Unlock()
+	fmt.Fprint(t.terminal, screen)
+}
+
+func (t *downloadTUI) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case <-t.stopped:
+			return
+		default:
+		}
+		t.key(string(buf[:n]))
+		t.draw()
+	}
+}
+
+// queue adds the files of a repo to the queued pane. The returned queue is nil
+// when t is nil, its methods then do nothing.
+func (t *downloadTUI) queue(ref hfdl.Repo, entries []hfdl.FileEntry) *tuiQueue {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q := &tuiQueue{repo: ref.ID, entries: entries, tui: t}
+	t.queues = append(t.queues, q)
+	t.queued += len(entries)
+	for _, entry := range entries {
+		t.total += max(entry.Size, 0)
+	}
+	return q
+}
+
+// reached takes the next file off the queue when downloadRepo gets to it, whether
+// it is downloaded or skipped. Its size counts as finished right away unless it is
+// downloaded, the transfer then reports the bytes.
+func (q *tuiQueue) reached() {
+	if q == nil || q.next >= len(q.entries) {
+		return
+	}
+	t := q.tui
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished += max(q.entries[q.next].Size, 0)
+	q.next++
+	t.queued--
+}
+
+// close drops what is left of the queue, e.g. when the repo was cancelled.
+func (q *tuiQueue) close() {
+	if q == nil {
+		return
+	}
+	t := q.tui
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, entry := range q.entries[q.next:] {
+		t.total -= max(entry.Size, 0)
+	}
+	t.queued -= len(q.entries) - q.next
+	q.next = len(q.entries)
+}
+
+func (t *downloadTUI) OnFile(f hfdl.FileEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	switch f.State {
+	case hfdl.FileStarted:
+		t.active[f.Path] = &tuiTransfer{path: f.Path, size: f.Size, started: now, seen: now}
+		// 文件在 reached 时已经按大小算作完成，下载中的部分由 current 计入
+		t.finished -= max(f.Size, 0)
+	case hfdl.FileDownloading:
+		transfer := t.active[f.Path]
+		if transfer == nil {
+			return
+		}
+		if f.Current >= transfer.current {
+			t.transferred += f.Current - transfer.current
+		}
+		if elapsed := now.Sub(transfer.seen).Seconds(); elapsed > 0 && f.Current >= transfer.current {
+			speed := float64(f.Current-transfer.current) / elapsed
+			if transfer.speed == 0 {
+				transfer.speed = speed
+			} else {
+				transfer.speed = 0.7*transfer.speed + 0.3*speed
+			}
+		}
+		transfer.current, transfer.seen = f.Current, now
+	case hfdl.FileDone, hfdl.FileFailed:
+		delete(t.active, f.Path)
+		// 失败的文件也不会再有进展，和跳过的一样算进已完成
+		t.finished += max(f.Size, 0)
+		if f.State == hfdl.FileFailed {
+			t.errors = keepLast(append(t.errors, fmt.Sprintf("%s: %s", plainName(f.Path), redact(f.Err))), tuiKeepLogs)
+		}
+	}
+}
+
+func (t *downloadTUI) OnTotals(totals hfdl.Totals) {
+	t.mu.Lock()
+	t.totals = totals
+	t.mu.Unlock()
+}
+
+// transfers returns the active transfers, the longest running first.
+func (t *downloadTUI) transfers() []*tuiTransfer {
+	list := make([]*tuiTransfer, 0, len(t.active))
+	for _, transfer := range t.active {
+		list = append(list, transfer)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if !list[a].started.Equal(list[b].started) {
+			return list[a].started.Before(list[b].started)
+		}
+		return list[a].path < list[b].path
+	})
+	return list
+}
+
+// rows returns the lines of a pane, and for each the full text shown below the list
+// for the selected line.
+func (t *downloadTUI) rows(pane, width int, now time.Time) (rows, details []string) {
+	switch pane {
+	case tuiActive:
+		for _, transfer := range t.transfers() {
+			rows = append(rows, transferRow(transfer, width, now))
+			details = append(details, fmt.Sprintf("%s, started %v ago", plainName(transfer.path), now.Sub(transfer.started).Round(time.Second)))
+		}
+	case tuiQueued:
+		for _, q := range t.queues {
+			for _, entry := range q.entries[q.next:] {
+				size := sizeColumn(entry.Size)
+				rows = append(rows, padRight(fitLeft(entry.Path, width-len(size)-2), width-len(size)-1)+" "+size)
+				details = append(details, q.repo+": "+entry.Path)
+			}
+		}
+	case tuiErrors:
+		// 最新的在最上面
+		for i := len(t.errors) - 1; i >= 0; i-- {
+			rows = append(rows, fitRight(t.errors[i], width))
+			details = append(details, t.errors[i])
+		}
+	}
+	return rows, details
+}
+
+// transferRow is "name  45% [=====     ] 2.10 GB / 4.66 GB  40.2 MB/s  1m2s".
+func transferRow(transfer *tuiTransfer, width int, now time.Time) string {
+	current, currentUnit := convertBytes(float64(transfer.current))
+	speed, speedUnit := convertBytes(transfer.speed)
+	if now.Sub(transfer.seen) > 5*time.Second {
+		// 好几秒没有进展，不再显示旧的速度
+		speed = 0
+	}
+	var progress string
+	if transfer.size > 0 {
+		size, sizeUnit := convertBytes(float64(transfer.size))
+		percent := min(transfer.current*100/transfer.size, 100)
+		eta := "-"
+		if speed > 0 {
+			eta = formatETA(float64(transfer.size-transfer.current) / transfer.speed)
+		}
+		progress = fmt.Sprintf("%3d%% %s %7.2f %s / %7.2f %s %7.2f %s/s %8s", percent, progressBar(percent, 10), current, currentUnit, size, sizeUnit, speed, speedUnit, eta)
+	} else {
+		progress = fmt.Sprintf("%7.2f %s %7.2f %s/s", current, currentUnit, speed, speedUnit)
+	}
+	nameWidth := width - len(progress) - 2
+	if nameWidth < 10 {
+		return fitLeft(plainName(transfer.path), width)
+	}
+	return padRight(fitLeft(plainName(transfer.path), nameWidth), nameWidth) + "  " + progress
+}
+
+func (t *downloadTUI) render(width, height int, now time.Time) string {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	line := func(s string) {
+		b.WriteString(fitRight(s, width) + "\r\n")
+	}
+
+	// 总速度按最近 10 秒收到的字节计算，换文件时不会掉到 0；剩余的是队列里和下载中的文件还差的大小
+	t.samples = append(t.samples, tuiSample{at: now, transferred: t.transferred})
+	for len(t.samples) > 2 && now.Sub(t.samples[1].at) >= tuiWindow {
+		t.samples = t.samples[1:]
+	}
+	var speed float64
+	if first := t.samples[0]; now.Sub(first.at) > 0 {
+		speed = float64(t.transferred-first.transferred) / now.Sub(first.at).Seconds()
+	}
+	remaining := t.total - t.finished
+	for _, transfer := range t.active {
+		if transfer.size > 0 {
+			remaining -= min(transfer.current, transfer.size)
+		}
+	}
+	remaining = max(remaining, 0)
+	done, doneUnit := convertBytes(float64(t.total - remaining))
+	total, totalUnit := convertBytes(float64(t.total))
+	speedValue, speedUnit := convertBytes(speed)
+	eta := "-"
+	if speed > 0 {
+		eta = formatETA(float64(remaining) / speed)
+	}
+	percent := int64(100)
+	if t.total > 0 {
+		percent = (t.total - remaining) * 100 / t.total
+	}
+	line(fmt.Sprintf("huggingface-go  %d active  %d queued  %d done  %d failed  elapsed %v",
+		len(t.active), t.queued, t.totals.Done, t.totals.Failed, now.Sub(t.started).Round(time.Second)))
+	line(fmt.Sprintf("%3d%% %s %.2f %s of %.2f %s  %.2f %s/s  ETA %s", percent, progressBar(percent, 30), done, doneUnit, total, totalUnit, speedValue, speedUnit, eta))
+	line("")
+
+	var tabs []string
+	counts := [tuiPanes]int{len(t.active), t.queued, len(t.errors)}
+	for pane, name := range tuiPaneNames {
+		tab := fmt.Sprintf(" %s (%d) ", name, counts[pane])
+		if pane == t.pane {
+			tab = "\x1b[7m" + tab + "\x1b[0m"
+		}
+		tabs = append(tabs, tab)
+	}
+	b.WriteString(strings.Join(tabs, " ") + "\r\n")
+
+	// 下面留出选中行的详情、最近的输出和按键说明
+	logLines := min(len(t.logs), max((height-8)/4, 1))
+	listHeight := max(height-8-logLines, 1)
+	rows, details := t.rows(t.pane, width, now)
+	cursor := &t.cursor[t.pane]
+	top := &t.top[t.pane]
+	*cursor = max(min(*cursor, len(rows)-1), 0)
+	if *cursor < *top {
+		*top = *cursor
+	}
+	if *cursor >= *top+listHeight {
+		*top = *cursor - listHeight + 1
+	}
+	for i := *top; i < *top+listHeight; i++ {
+		switch {
+		case i >= len(rows):
+			b.WriteString("\r\n")
+		case i == *cursor:
+			b.WriteString("\x1b[7m" + padRight(rows[i], width) + "\x1b[0m\r\n")
+		default:
+			b.WriteString(rows[i] + "\r\n")
+		}
+	}
+	if *cursor < len(details) {
+		line(details[*cursor])
+	} else {
+		line("")
+	}
+	line(strings.Repeat("-", width))
+	for _, log := range t.logs[len(t.logs)-logLines:] {
+		line(log)
+	}
+	fmt.Fprintf(&b, "\x1b[%d;1H%s", height, fitRight("tab pane  up/down j/k move  pgup/pgdn page  home/end  q stop (twice to quit)", width))
+	return b.String()
+}
+
+func (t *downloadTUI) key(k string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cursor := &t.cursor[t.pane]
+	switch k {
+	case "\t", "\x1b[C", "l":
+		t.pane = (t.pane + 1) % tuiPanes
+	case "\x1b[Z", "\x1b[D", "h":
+		t.pane = (t.pane + tuiPanes - 1) % tuiPanes
+	case "1", "2", "3":
+		t.pane = int(k[0] - '1')
+	case "\x1b[A", "k":
+		*cursor--
+	case "\x1b[B", "j":
+		*cursor++
+	case "\x1b[5~":
+		*cursor -= 10
+	case "\x1b[6~":
+		*cursor += 10
+	case "\x1b[H", "\x1b[1~", "g":
+		*cursor = 0
+	case "\x1b[F", "\x1b[4~", "G":
+		// render 会把它限制到最后一行
+		*cursor = 1 << 30
+	case "q", "\x03":
+		t.quits++
+		if t.quits > 1 {
+			t.mu.Unlock()
+			// exit 也会恢复终端
+			exit(exitCancelled)
+		}
+		t.logs = keepLast(append(t.logs, "Stopping, saving the partial files (press q again to quit at once)"), tuiKeepLogs)
+		if t.cancel != nil {
+			t.cancel(errUserCancelled)
+		}
+	}
+	*cursor = max(*cursor, 0)
+}
+
+// progressBar is "[=====     ]" for percent of width characters.
+func progressBar(percent int64, width int) string {
+	filled := int(min(max(percent, 0), 100)) * width / 100
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// formatETA rounds the seconds left to whole seconds, or minutes when it is more than an hour.
+func formatETA(seconds float64) string {
+	eta := time.Duration(seconds * float64(time.Second))
+	if eta > time.Hour {
+		return eta.Round(time.Minute).String()
+	}
+	return eta.Round(time.Second).String()
+}
+
+// fitLeft shortens s to width characters by cutting its start, for paths whose end
+// matters most.
+func fitLeft(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width || width < 1 {
+		return s
+	}
+	return "…" + string(runes[len(runes)-width+1:])
+}
+
+// fitRight shortens s to width characters by cutting its end.
+func fitRight(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width || width < 1 {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func padRight(s string, width int) string {
+	if n := width - len([]rune(s)); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// 更新时新版本先下载到这个目录里，完整后才换进仓库目录
+const updateStagingName = ".hfgo-update"
+
+// runUpdate implements `huggingface-go update [flags] <folder>`: the files of a complete
+// download are compared by hash against the current head of its revision, and only
+// new and changed files are downloaded. Returns the exit code.
+func runUpdate(args []string) int {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	var deleteRemoved, dryRun bool
+	var progressMode, reportPath string
+	flags.BoolVar(&deleteRemoved, "delete", false, "also delete the local files that were removed upstream")
+	flags.BoolVar(&dryRun, "dry-run", false, "only print what would be downloaded and deleted")
+	flags.StringVar(&reportPath, "report", "", "write what the run changed (added, updated and deleted files with their sizes, the commits before and after) as JSON to this file")
+	flags.StringVar(&progressMode, "progress", "", "how to show the download progress: bars, plain or none; by default bars on a terminal and plain otherwise")
+	rest := parseFlags(flags, &g, args, "update [flags] <folder>")
+	if !validProgressMode(progressMode) {
+		fmt.Fprintf(stdout, "Invalid --progress value %q, expected bars, plain or none\n", progressMode)
+		os.Exit(2)
+	}
+	if len(rest) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	folder := filepath.Clean(rest[0])
+	staging := filepath.Join(folder, updateStagingName)
+	if _, err := readCompleteMarker(folder); err != nil {
+		// 上次更新在换入新文件时中断了，先把它做完
+		if _, staged := readCompleteMarker(staging); staged == nil {
+			fmt.Fprintf(stdout, "Finishing the update of %s that was interrupted while moving the new files in\n", folder)
+			if err := applyUpdate(folder, staging); err != nil {
+				fmt.Fprintf(stdout, "Cannot move the new files into %s: %v\n", folder, err)
+				return exitFailed
+			}
+		}
+	}
+	marker, err := readCompleteMarker(folder)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot update %s: %v\n", folder, err)
+		return exitFailed
+	}
+	if len(marker.Placement) > 0 {
+		fmt.Fprintf(stdout, "Cannot update %s: its files are split across volumes, download it again with --split-across\n", folder)
+		return exitFailed
+	}
+	if marker.Sample != nil {
+		fmt.Fprintf(stdout, "Cannot update %s: it holds a sample of the files (--max-total-size or --max-files), download it again instead\n", folder)
+		return exitFailed
+	}
+	for _, f := range marker.Files {
+		if f.DecompressedFrom != "" || len(f.Columns) > 0 || len(f.RowGroups) > 0 || f.Parts > 0 {
+			fmt.Fprintf(stdout, "Cannot update %s: %s was decompressed, cut to some columns or split into parts, download it again instead\n", folder, f.Path)
+			return exitFailed
+		}
+	}
+	ref := hfdl.Repo{Endpoint: g.hub(), Type: marker.Type, ID: marker.Repo, Revision: marker.Revision, Path: marker.Path}
+	report := &updateReport{Folder: folder, Repo: ref.ID, Revision: ref.Revision, DryRun: dryRun, Added: []updateFile{}, Renamed: []updateFile{}, Updated: []updateFile{}, Deleted: []updateFile{}}
+	if manifest, err := readDownloadManifest(folder); err == nil {
+		ref.Endpoint = manifest.Endpoint
+		report.FromCommit = manifest.Commit
+		fmt.Fprintf(stdout, "Local copy of %s@%s is at commit %s\n", ref.ID, ref.Revision, manifest.Commit)
+	}
+	filter := fileFilter{include: marker.Include, exclude: marker.Exclude}
+
+	ctx, stop := runContext(0)
+	defer stop()
+	upstreamRef := ref
+	d, _ := g.openRepo(ctx, &upstreamRef)
+	if commit, err := d.ResolveCommit(ctx, upstreamRef); err == nil {
+		report.ToCommit = commit
+		fmt.Fprintf(stdout, "Upstream %s points at commit %s\n", ref.Revision, commit)
+	}
+	listing, err := d.ListFiles(ctx, upstreamRef, marker.Path, filter)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot fetch entries: %v\n", err)
+		return exitFailed
+	}
+	entries, _, err := applyUnknownEntriesPolicy(listing, unknownEntriesSkip)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot update %s: %v\n", folder, err)
+		return exitFailed
+	}
+	if marker.OfficialSplits {
+		if entries, err = selectOfficialSplits(g.proxyURLHead, upstreamRef, entries); err != nil {
+			fmt.Fprintf(stdout, "Cannot update %s: %v\n", folder, err)
+			return exitFailed
+		}
+	}
+
+	// 按 git blob id 和 sha256 比较，而不是只比较大小
+	local := make(map[string]markerFile, len(marker.Files))
+	for _, f := range marker.Files {
+		local[f.Path] = f
+	}
+	var added, changed []updateFile
+	var removed []markerFile
+	var addedEntries []hfdl.FileEntry
+	upstream := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		upstream[entry.Path] = true
+		old, ok := local[entry.Path]
+		switch {
+		case !ok:
+			addedEntries = append(addedEntries, entry)
+		case (entry.Size != hfdl.UnknownSize && old.Size != entry.Size) || old.OID != entry.OID || old.SHA256 != entry.LFSOID:
+			size := entry.Size
+			if size == hfdl.UnknownSize {
+				size = old.Size
+			}
+			changed = append(changed, updateFile{Path: entry.Path, Size: size, OldSize: old.Size})
+		default:
+			// 本地文件丢了或者被改过大小，也要重新下载
+			if stat, err := os.Stat(filepath.Join(folder, filepath.FromSlash(entry.Path))); err != nil || stat.Size() != old.Size {
+				changed = append(changed, updateFile{Path: entry.Path, Size: old.Size, OldSize: old.Size})
+			}
+		}
+	}
+	for _, f := range marker.Files {
+		if !upstream[f.Path] {
+			removed = append(removed, f)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Path < removed[j].Path })
+	renamed := findRenames(addedEntries, removed)
+	for _, entry := range addedEntries {
+		if !isRenamed(renamed, entry.Path) {
+			added = append(added, updateFile{Path: entry.Path, Size: max(entry.Size, 0)})
+		}
+	}
+	for _, f := range added {
+		fmt.Fprintf(stdout, "+ %s\n", f.Path)
+	}
+	for _, f := range renamed {
+		fmt.Fprintf(stdout, "> %s (renamed from %s)\n", f.Path, f.From)
+	}
+	for _, f := range changed {
+		fmt.Fprintf(stdout, "~ %s\n", f.Path)
+	}
+	for _, f := range removed {
+		fmt.Fprintf(stdout, "- %s\n", f.Path)
+	}
+	fmt.Fprintf(stdout, "%d new, %d renamed, %d changed, %d removed upstream, %d unchanged\n", len(added), len(renamed), len(changed), len(removed), len(entries)-len(added)-len(renamed)-len(changed))
+	report.Unchanged = len(entries) - len(added) - len(renamed) - len(changed)
+	// 报告在所有出口都写，定时任务每次运行都留下记录
+	finish := func(code int) int {
+		report.OK = code == exitOK
+		if report.OK && len(added)+len(renamed)+len(changed)+len(removed) > 0 {
+			report.print()
+		}
+		if reportPath != "" {
+			if err := report.write(reportPath); err != nil {
+				fmt.Fprintf(stdout, "Cannot write the report to %s: %v\n", reportPath, err)
+				return max(code, exitFailed)
+			}
+		}
+		return code
+	}
+	if len(added)+len(renamed)+len(changed)+len(removed) == 0 {
+		fmt.Fprintf(stdout, "%s is up to date\n", folder)
+		return finish(exitOK)
+	}
+	if dryRun {
+		report.Added, report.Renamed, report.Updated = append(report.Added, added...), append(report.Renamed, renamed...), append(report.Updated, changed...)
+		for _, f := range removed {
+			if deleteRemoved {
+				report.Deleted = append(report.Deleted, updateFile{Path: f.Path, OldSize: f.Size})
+			} else {
+				report.Kept = append(report.Kept, updateFile{Path: f.Path, Size: f.Size})
+			}
+		}
+		return finish(exitOK)
+	}
+
+	// 新版本先完整下载到暂存目录，校验过后才换进来：更新失败或被中断时原来的副本不变，
+	// 再运行一次会在暂存目录里接着下载
+	if err := stageUpdate(folder, staging, marker.Files, entries, changed, renamed, removed); err != nil {
+		fmt.Fprintf(stdout, "Cannot prepare %s: %v\n", staging, err)
+		return exitFailed
+	}
+	opts := &downloadOptions{
+		globalOptions:  g,
+		folder:         staging,
+		unknownEntries: unknownEntriesSkip,
+		oversize:       oversizeFail,
+		filter:         filter,
+		officialSplits: marker.OfficialSplits,
+		downloader: []hfdl.Option{
+			hfdl.WithWriteQueue(16),
+			hfdl.WithSegments(4, 256<<20),
+			hfdl.WithThrottle(hfdl.NewThrottle()),
+		},
+	}
+	opts.downloader = append(opts.downloader, progressOptions(progressMode)...)
+	result := downloadRepo(ctx, ref, opts)
+	report.Added, report.Renamed, report.Updated = append(report.Added, added...), append(report.Renamed, renamed...), append(report.Updated, changed...)
+	if !result.ok {
+		fmt.Fprintf(stdout, "%s is unchanged, run update again to resume\n", folder)
+		return finish(exitCode(ctx, false))
+	}
+	if err := applyUpdate(folder, staging); err != nil {
+		fmt.Fprintf(stdout, "Cannot move the new files into %s: %v, run update again to finish\n", folder, err)
+		return finish(exitFailed)
+	}
+	report.measure()
+	if !deleteRemoved {
+		for _, f := range removed {
+			report.Kept = append(report.Kept, updateFile{Path: f.Path, Size: f.Size})
+		}
+		return finish(exitOK)
+	}
+	ok := true
+	for _, f := range removed {
+		if err := os.Remove(filepath.Join(folder, filepath.FromSlash(f.Path))); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(stdout, "Cannot delete %s: %v\n", f.Path, err)
+			ok = false
+			continue
+		}
+		fmt.Fprintf(stdout, "Deleted %s\n", f.Path)
+		report.Deleted = append(report.Deleted, updateFile{Path: f.Path, OldSize: f.Size})
+		// 顺便删掉因此变空的子目录，os.Remove 不会删除非空目录
+		for dir := filepath.Dir(filepath.FromSlash(f.Path)); dir != "."; dir = filepath.Dir(dir) {
+			if os.Remove(filepath.Join(folder, dir)) != nil {
+				break
+			}
+		}
+	}
+	return finish(exitCode(ctx, ok))
+}
+
+// stageUpdate prepares the staging folder of an update: files that did not change are
+// hard linked (or copied) from folder, and renamed files from their old path, so only
+// new and changed files are downloaded into it. Files left there by an interrupted
+// update are kept when they already have the new content.
+func stageUpdate(folder, staging string, files []markerFile, entries []hfdl.FileEntry, changed, renamed []updateFile, removed []markerFile) error {
+	stale := make(map[string]bool, len(changed)+len(removed))
+	for _, f := range changed {
+		stale[f.Path] = true
+	}
+	for _, f := range removed {
+		stale[f.Path] = true
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(staging, completeMarkerName))
+	for _, f := range files {
+		if stale[f.Path] {
+			continue
+		}
+		src := filepath.Join(folder, filepath.FromSlash(f.Path))
+		dst := filepath.Join(staging, filepath.FromSlash(f.Path))
+		if err := linkStaged(src, dst); err != nil {
+			return err
+		}
+	}
+	for _, f := range renamed {
+		src := filepath.Join(folder, filepath.FromSlash(f.From))
+		dst := filepath.Join(staging, filepath.FromSlash(f.Path))
+		if err := linkStaged(src, dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// 本地的旧文件丢了或者改过时，在下面按哈希检查后删掉，改为下载
+		stale[f.Path] = true
+	}
+	for _, entry := range entries {
+		if !stale[entry.Path] {
+			continue
+		}
+		// 上次没更新完留下的文件，内容不是这次的版本就重新下载
+		dst := filepath.Join(staging, filepath.FromSlash(entry.Path))
+		if _, err := os.Stat(dst); err == nil && verifyLocalFile(dst, entry) != nil {
+			if err := os.Remove(dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linkStaged hard links (or copies) src to dst in the staging folder, unless dst is
+// already there.
+func linkStaged(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(src, dst); err != nil {
+		// 不支持硬链接的文件系统上复制一份
+		return copyFile(src, dst)
+	}
+	return nil
+}
+
+// findRenames pairs the files added upstream with files removed upstream that have
+// the same content (git blob id, sha256 and size): the repo moved or renamed them, and
+// the local copy is used instead of downloading them again.
+func findRenames(added []hfdl.FileEntry, removed []markerFile) []updateFile {
+	type content struct {
+		oid, sha256 string
+		size        int64
+	}
+	sources := make(map[content][]markerFile)
+	for _, f := range removed {
+		if f.OID != "" || f.SHA256 != "" {
+			key := content{f.OID, f.SHA256, f.Size}
+			sources[key] = append(sources[key], f)
+		}
+	}
+	var renamed []updateFile
+	for _, entry := range added {
+		key := content{entry.OID, entry.LFSOID, entry.Size}
+		if from := sources[key]; len(from) > 0 {
+			renamed = append(renamed, updateFile{Path: entry.Path, Size: entry.Size, From: from[0].Path})
+			sources[key] = from[1:]
+		}
+	}
+	return renamed
+}
+
+func isRenamed(renamed []updateFile, filePath string) bool {
+	for _, f := range renamed {
+		if f.Path == filePath {
+			return true
+		}
+	}
+	return false
+}
+
+// applyUpdate moves the files of a complete staging folder into folder, replacing the
+// old ones, and the .complete marker last. An update interrupted in between is
+// finished by the next run.
+func applyUpdate(folder, staging string) error {
+	if err := removeCompleteMarker(folder); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(staging, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(staging, p)
+		if err != nil {
+			return err
+		}
+		switch name := filepath.ToSlash(rel); {
+		case name == completeMarkerName, name == stateFileName, name == listingFileName, strings.HasSuffix(name, ".tmp"):
+			return nil
+		}
+		dst := filepath.Join(folder, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		// 硬链接到同一个文件时 rename 什么都不做，暂存目录最后整个删掉
+		return os.Rename(p, dst)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(staging, completeMarkerName), filepath.Join(folder, completeMarkerName)); err != nil {
+		return err
+	}
+	return os.RemoveAll(staging)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// updateReport is what one run of update changed in the folder, printed at the end
+// and written as JSON with --report, so the runs of a scheduled mirror can be told
+// apart at a glance.
+type updateReport struct {
+	Folder     string       `json:"folder"`
+	Repo       string       `json:"repo"`
+	Revision   string       `json:"revision"`
+	FromCommit string       `json:"from_commit,omitempty"`
+	ToCommit   string       `json:"to_commit,omitempty"`
+	DryRun     bool         `json:"dry_run,omitempty"`
+	OK         bool         `json:"ok"`
+	Added      []updateFile `json:"added"`
+	Renamed    []updateFile `json:"renamed"` // moved upstream, taken from the old local path
+	Updated    []updateFile `json:"updated"`
+	Deleted    []updateFile `json:"deleted"`
+	Kept       []updateFile `json:"kept,omitempty"` // removed upstream, kept without --delete
+	Unchanged  int          `json:"unchanged"`
+	DeltaBytes int64        `json:"delta_bytes"`
+	FinishedAt time.Time    `json:"finished_at"`
+}
+
+// updateFile is a file of the report; Size is 0 for deleted files and OldSize 0 for
+// added ones.
+type updateFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	OldSize int64  `json:"old_size,omitempty"`
+	From    string `json:"from,omitempty"` // the old path of a renamed file
+}
+
+// measure replaces the sizes of the listing by those of the files downloaded, which
+// differ when the listing had none.
+func (r *updateReport) measure() {
+	for _, files := range [][]updateFile{r.Added, r.Renamed, r.Updated} {
+		for i, f := range files {
+			if stat, err := os.Stat(filepath.Join(r.Folder, filepath.FromSlash(f.Path))); err == nil {
+				files[i].Size = stat.Size()
+			}
+		}
+	}
+}
+
+func (r *updateReport) delta() int64 {
+	var delta int64
+	for _, files := range [][]updateFile{r.Added, r.Renamed, r.Updated, r.Deleted} {
+		for _, f := range files {
+			delta += f.Size - f.OldSize
+		}
+	}
+	return delta
+}
+
+// print writes a git status like summary: the counts with the bytes each group adds
+// or frees, then the total change.
+func (r *updateReport) print() {
+	r.DeltaBytes = r.delta()
+	group := func(files []updateFile) string {
+		var delta int64
+		for _, f := range files {
+			delta += f.Size - f.OldSize
+		}
+		return fmt.Sprintf("%d (%s)", len(files), formatDelta(delta))
+	}
+	verb := "Changed"
+	if r.DryRun {
+		verb = "Would change"
+	}
+	fmt.Fprintf(stdout, "%s %s: %s added, %s renamed, %s updated, %s deleted, %d unchanged; %s in total\n", verb, r.Folder, group(r.Added), group(r.Renamed), group(r.Updated), group(r.Deleted), r.Unchanged, formatDelta(r.DeltaBytes))
+	if len(r.Kept) > 0 && !r.DryRun {
+		fmt.Fprintf(stdout, "Kept %d files that were removed upstream, use --delete to remove them\n", len(r.Kept))
+	}
+}
+
+// write saves the report atomically, so whatever watches the file never reads half of it.
+func (r *updateReport) write(reportPath string) error {
+	r.DeltaBytes = r.delta()
+	r.FinishedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := reportPath + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, reportPath)
+}
+
+// formatDelta formats a change in bytes with its sign, e.g. +1.20 GB or -3.00 MB.
+func formatDelta(n int64) string {
+	sign := "+"
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	size, unit := convertBytes(float64(n))
+	return fmt.Sprintf("%s%.2f %s", sign, size, unit)
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+const (
+	// 每次 preupload 请求询问的文件数
+	preuploadBatch = 100
+	// preupload 根据文件开头的这么多字节判断是否是二进制文件
+	preuploadSample = 512
+	lfsMediaType    = "application/vnd.git-lfs+json"
+)
+
+// uploadFile is a local file to commit to the repo.
+type uploadFile struct {
+	path   string // in the repo
+	local  string
+	size   int64
+	sha256 string
+	lfs    bool // the Hub wants it uploaded to LFS storage, see preupload
+}
+
+// uploader talks to the commit and LFS apis of the Hub for one repo and branch,
+// directly: the write token never goes through the -p proxy.
+type uploader struct {
+	ref        hfdl.Repo
+	parent     string // commit the branch pointed at when it was listed
+	retries    int
+	retryDelay time.Duration
+}
+
+// runUpload implements `huggingface-go upload [flags] <repo> <local file or folder> [path in repo]`:
+// new and changed files are committed to a branch of the repo in one commit, large
+// and binary files through LFS storage. Returns the exit code.
+func runUpload(args []string) int {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	var g globalOptions
+	g.register(flags)
+	var filter fileFilter
+	var revision, message, description string
+	var create, private, deleteRemoved, dryRun bool
+	var workers int
+	flags.StringVar(&revision, "revision", "", "branch to commit to, main by default or the one in the repo argument (org/model@branch); the branch must exist")
+	flags.StringVar(&message, "message", "", "commit message, by default \"Upload <n> files with huggingface-go\"")
+	flags.StringVar(&description, "description", "", "longer description of the commit")
+	flags.BoolVar(&create, "create", false, "create the repo first if it does not exist yet")
+	flags.BoolVar(&private, "private", false, "with --create, make the new repo private")
+	flags.BoolVar(&deleteRemoved, "delete", false, "also delete the files of the repo below the target path that are not in the local folder, so the repo mirrors it (.gitattributes is kept)")
+	flags.BoolVar(&dryRun, "dry-run", false, "only print what would be uploaded and deleted")
+	flags.IntVar(&workers, "workers", 4, "number of files uploaded to LFS storage at the same time")
+	flags.Var((*stringList)(&filter.include), "include", "only upload files matching this glob, e.g. *.safetensors, can be repeated or comma separated")
+	flags.Var((*stringList)(&filter.exclude), "exclude", "skip files matching this glob, e.g. *.bin or checkpoints/, can be repeated or comma separated")
+	rest := parseFlags(flags, &g, args, "upload [flags] <repo> <local file or folder> [path in repo]")
+	if len(rest) < 2 || len(rest) > 3 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if workers < 1 {
+		fmt.Fprintf(stdout, "Invalid --workers value %d, expected at least 1\n", workers)
+		os.Exit(2)
+	}
+	ref, err := g.parseRepo(rest[0])
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot parse repo url: %v\n", err)
+		os.Exit(2)
+	}
+	if revision != "" {
+		ref.Revision = revision
+	}
+	if g.token == "" {
+		fmt.Fprintln(stdout, "Uploading needs a token with write access: --token, HFGO_TOKEN or HF_TOKEN")
+		os.Exit(2)
+	}
+	target := ""
+	if len(rest) == 3 {
+		target = strings.Trim(path.Clean("/"+filepath.ToSlash(rest[2])), "/")
+	}
+	files, folder, err := collectUploadFiles(rest[1], target, filter)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot upload %s: %v\n", rest[1], err)
+		return exitFailed
+	}
+
+	// 上传总是发到 Hub 本身，镜像只能下载；-p 代理也不用，写权限的 token 不能经过别人的代理
+	g.tokenToProxy = false
+	g.installAuth(ref.Endpoint)
+	if err := checkTokenAccess("", ref, true, false); err != nil {
+		fmt.Fprintf(stdout, "Cannot upload to %s: %v\n", ref.ID, err)
+		return exitFailed
+	}
+	ctx, stop := runContext(0)
+	defer stop()
+	u := &uploader{ref: ref, retries: g.retries, retryDelay: g.retryDelay}
+	if create && !dryRun {
+		if err := u.createRepo(ctx, private); err != nil {
+			fmt.Fprintf(stdout, "Cannot create %s: %v\n", ref.ID, err)
+			return exitFailed
+		}
+	}
+	listFolder := target
+	if !folder {
+		listFolder = strings.TrimSuffix(path.Dir(target), ".")
+	}
+	d := hfdl.New([]string{ref.Endpoint}, append(g.downloaderOptions(), hfdl.WithProxy(""))...)
+	if u.parent, err = d.ResolveCommit(ctx, ref); err != nil {
+		fmt.Fprintf(stdout, "Cannot resolve the commit of %s@%s: %v\n", ref.ID, ref.Revision, err)
+		return exitFailed
+	}
+	// 按提交列出文件，提交时以它为父提交，期间有别人推送时 Hub 会拒绝，--delete 不会删掉新文件
+	listRef := ref
+	listRef.Revision = u.parent
+	listing, err := d.ListFiles(ctx, listRef, listFolder, nil)
+	var status *hfdl.StatusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		// 目标目录还不存在
+		listing, err = nil, nil
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot list %s@%s: %v\n", ref.ID, ref.Revision, err)
+		return exitFailed
+	}
+	remote := make(map[string]hfdl.FileEntry, len(listing))
+	for _, entry := range listing {
+		remote[entry.Path] = entry
+	}
+
+	var changed []uploadFile
+	unchanged := 0
+	local := make(map[string]bool, len(files))
+	for _, f := range files {
+		local[f.path] = true
+		if f.sha256, err = hfdl.HashFile(f.local); err != nil {
+			fmt.Fprintf(stdout, "Cannot read %s: %v\n", f.local, err)
+			return exitFailed
+		}
+		entry, ok := remote[f.path]
+		if ok && sameContent(f, entry) {
+			unchanged++
+			continue
+		}
+		if ok {
+			fmt.Fprintf(stdout, "~ %s\n", f.path)
+		} else {
+			fmt.Fprintf(stdout, "+ %s\n", f.path)
+		}
+		changed = append(changed, f)
+	}
+	var deleted []string
+	if deleteRemoved && folder {
+		for _, entry := range listing {
+			// .gitattributes 决定哪些文件走 LFS，不能因为本地没有就删掉
+			if !local[entry.Path] && entry.Path != ".gitattributes" && filter.Match(strings.TrimPrefix(strings.TrimPrefix(entry.Path, target), "/")) {
+				fmt.Fprintf(stdout, "- %s\n", entry.Path)
+				deleted = append(deleted, entry.Path)
+			}
+		}
+		sort.Strings(deleted)
+	}
+	var size int64
+	for _, f := range changed {
+		size += f.size
+	}
+	uploadSize, uploadUnit := convertBytes(float64(size))
+	fmt.Fprintf(stdout, "%d new or changed files (%.2f %s), %d deleted, %d unchanged\n", len(changed), uploadSize, uploadUnit, len(deleted), unchanged)
+	if len(changed)+len(deleted) == 0 {
+		fmt.Fprintf(stdout, "%s@%s already has these files, nothing to commit\n", ref.ID, ref.Revision)
+		return exitOK
+	}
+	if dryRun {
+		return exitOK
+	}
+
+	if changed, err = u.preupload(ctx, changed); err != nil {
+		fmt.Fprintf(stdout, "Cannot prepare the upload: %v\n", err)
+		return exitCode(ctx, false)
+	}
+	if len(changed)+len(deleted) == 0 {
+		fmt.Fprintf(stdout, "%s@%s already has the other files, nothing to commit\n", ref.ID, ref.Revision)
+		return exitOK
+	}
+	if err := u.uploadLFS(ctx, changed, workers); err != nil {
+		fmt.Fprintf(stdout, "Cannot upload to LFS storage: %v\n", err)
+		return exitCode(ctx, false)
+	}
+	if message == "" {
+		message = fmt.Sprintf("Upload %d files with huggingface-go", len(changed))
+		if len(changed) == 0 {
+			message = fmt.Sprintf("Delete %d files with huggingface-go", len(deleted))
+		}
+	}
+	commit, err := u.commit(ctx, changed, deleted, message, description)
+	var moved *uploadStatusError
+	if errors.As(err, &moved) && (moved.code == http.StatusConflict || moved.code == http.StatusPreconditionFailed) {
+		fmt.Fprintf(stdout, "Cannot commit to %s@%s: it moved on from %s while uploading, run the upload again (%v)\n", ref.ID, ref.Revision, shortCommit(u.parent), err)
+		return exitFailed
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot commit to %s@%s: %v\n", ref.ID, ref.Revision, err)
+		return exitCode(ctx, false)
+	}
+	fmt.Fprintf(stdout, "Committed %d files and %d deletions to %s@%s: %s\n", len(changed), len(deleted), ref.ID, ref.Revision, commit)
+	return exitOK
+}
+
+// collectUploadFiles returns the files to upload from localPath: the file itself,
+// stored as target (or its name), or the files of the folder below target. Files
+// huggingface-go writes next to a download (.complete, .hfgo-*.json, .tmp, SHA256SUMS,
+// the .hfgo-assets folder, ...) and the .git folder are left out. folder reports whether localPath is a folder.
+func collectUploadFiles(localPath, target string, filter fileFilter) ([]uploadFile, bool, error) {
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return nil, false, err
+	}
+	if !stat.IsDir() {
+		if target == "" {
+			target = filepath.Base(localPath)
+		}
+		return []uploadFile{{path: target, local: localPath, size: stat.Size()}}, false, nil
+	}
+	var rels []string
+	sizes := make(map[string]int64)
+	err = filepath.WalkDir(localPath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" || (entry.Name() == "huggingface" && filepath.Base(filepath.Dir(p)) == ".cache") ||
+				(p != localPath && (entry.Name() == assetsFolderName || entry.Name() == blobFolderName || entry.Name() == updateStagingName)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		rels = append(rels, rel)
+		sizes[rel] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	// 下载留下的 SHA256SUMS 等文件只在不属于仓库时才算工具文件，没有 .complete 时不知道，
+	// 按工具写的处理
+	known := make(map[string]bool, len(rels))
+	if marker, err := readCompleteMarker(localPath); err == nil {
+		for _, f := range marker.Files {
+			known[f.Path] = true
+		}
+	} else {
+		for _, rel := range rels {
+			known[rel] = true
+		}
+		delete(known, checksumsName)
+	}
+	var files []uploadFile
+	for _, rel := range rels {
+		if isToolFile(rel, known) || !filter.Match(rel) {
+			continue
+		}
+		files = append(files, uploadFile{path: path.Join(target, rel), local: filepath.Join(localPath, filepath.FromSlash(rel)), size: sizes[rel]})
+	}
+	if len(files) == 0 {
+		return nil, true, fmt.Errorf("no files to upload")
+	}
+	return files, true, nil
+}
+
+// sameContent reports whether the repo already has the content of f: LFS files are
+// compared by sha256, other files by git blob id.
+func sameContent(f uploadFile, entry hfdl.FileEntry) bool {
+	if entry.Size != hfdl.UnknownSize && entry.Size != f.size {
+		return false
+	}
+	if entry.LFSOID != "" {
+		return entry.LFSOID == f.sha256
+	}
+	if entry.OID == "" {
+		return false
+	}
+	oid, err := gitBlobID(f.local, f.size)
+	return err == nil && oid == entry.OID
+}
+
+// createRepo creates the repo, doing nothing when it exists already.
+func (u *uploader) createRepo(ctx context.Context, private bool) error {
+	body := map[string]interface{}{"name": path.Base(u.ref.ID), "type": string(u.ref.Type), "private": private}
+	if org := path.Dir(u.ref.ID); org != "." {
+		body["organization"] = org
+	}
+	if u.ref.Type == hfdl.RepoTypeSpace {
+		body["sdk"] = "static"
+	}
+	err := u.postJSON(ctx, u.ref.Endpoint+"/api/repos/create", "application/json", body, nil)
+	var status *uploadStatusError
+	if errors.As(err, &status) && status.code == http.StatusConflict {
+		return nil
+	}
+	if err == nil {
+		fmt.Fprintf(stdout, "Created %s %s\n", u.ref.Type, u.ref.ID)
+	}
+	return err
+}
+
+// preupload asks the Hub which files go to LFS storage, by size and by whether their
+// first bytes look binary, and drops the files the repo's .gitignore ignores.
+func (u *uploader) preupload(ctx context.Context, files []uploadFile) ([]uploadFile, error) {
+	type preuploadFile struct {
+		Path   string `json:"path"`
+		Sample string `json:"sample"`
+		Size   int64  `json:"size"`
+	}
+	var kept []uploadFile
+	for start := 0; start < len(files); start += preuploadBatch {
+		batch := files[start:min(start+preuploadBatch, len(files))]
+		request := struct {
+			Files []preuploadFile `json:"files"`
+		}{}
+		for _, f := range batch {
+			sample, err := readSample(f.local)
+			if err != nil {
+				return nil, err
+			}
+			request.Files = append(request.Files, preuploadFile{Path: f.path, Sample: base64.StdEncoding.EncodeToString(sample), Size: f.size})
+		}
+		var answer struct {
+			Files []struct {
+				Path         string `json:"path"`
+				UploadMode   string `json:"uploadMode"`
+				ShouldIgnore bool   `json:"shouldIgnore"`
+			} `json:"files"`
+		}
+		preuploadURL := u.ref.APIURL() + "/preupload/" + url.PathEscape(u.ref.Revision)
+		if err := u.postJSON(ctx, preuploadURL, "application/json", request, &answer); err != nil {
+			return nil, err
+		}
+		modes := make(map[string]string, len(answer.Files))
+		ignored := make(map[string]bool)
+		for _, f := range answer.Files {
+			modes[f.Path] = f.UploadMode
+			ignored[f.Path] = f.ShouldIgnore
+		}
+		for _, f := range batch {
+			if ignored[f.path] {
+				fmt.Fprintf(stdout, "Skipping %s, the repo's .gitignore ignores it\n", f.path)
+				continue
+			}
+			f.lfs = modes[f.path] == "lfs"
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
+func readSample(filePath string) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sample := make([]byte, preuploadSample)
+	n, err := io.ReadFull(file, sample)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return sample[:n], err
+}
+
+// lfsAction is an upload or verify action of the LFS batch api.
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// uploadLFS uploads the content of the LFS files the storage does not have yet,
+// workers at a time. Files over the storage's chunk size are uploaded in parts.
+func (u *uploader) uploadLFS(ctx context.Context, files []uploadFile, workers int) error {
+	type object struct {
+		OID  string `json:"oid"`
+		Size int64  `json:"size"`
+	}
+	bySHA := make(map[string]uploadFile)
+	request := struct {
+		Operation string            `json:"operation"`
+		Transfers []string          `json:"transfers"`
+		Objects   []object          `json:"objects"`
+		HashAlgo  string            `json:"hash_algo"`
+		Ref       map[string]string `json:"ref"`
+	}{Operation: "upload", Transfers: []string{"basic", "multipart"}, HashAlgo: "sha256", Ref: map[string]string{"name": u.ref.Revision}}
+	for _, f := range files {
+		if f.lfs && bySHA[f.sha256].path == "" {
+			bySHA[f.sha256] = f
+			request.Objects = append(request.Objects, object{OID: f.sha256, Size: f.size})
+		}
+	}
+	if len(request.Objects) == 0 {
+		return nil
+	}
+	var answer struct {
+		Objects []struct {
+			OID     string `json:"oid"`
+			Actions struct {
+				Upload *lfsAction `json:"upload"`
+				Verify *lfsAction `json:"verify"`
+			} `json:"actions"`
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"objects"`
+	}
+	if err := u.postJSON(ctx, u.ref.WebURL()+".git/info/lfs/objects/batch", lfsMediaType, request, &answer); err != nil {
+		return err
+	}
+
+	jobs := make(chan int)
+	errs := make(chan error, len(answer.Objects))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range jobs {
+				object := answer.Objects[n]
+				f := bySHA[object.OID]
+				if object.Error != nil {
+					errs <- fmt.Errorf("%s: %d %s", f.path, object.Error.Code, object.Error.Message)
+					continue
+				}
+				if object.Actions.Upload == nil {
+					// 存储里已经有相同内容的文件，例如其他仓库或之前的提交上传过
+					fmt.Fprintf(stdout, "%s is already in LFS storage\n", f.path)
+					continue
+				}
+				if err := u.uploadObject(ctx, f, object.Actions.Upload, object.Actions.Verify); err != nil {
+					errs <- fmt.Errorf("%s: %v", f.path, err)
+					continue
+				}
+				fileSize, fileUnit := convertBytes(float64(f.size))
+				fmt.Fprintf(stdout, "Uploaded %s (%.2f %s)\n", f.path, fileSize, fileUnit)
+			}
+		}()
+	}
+	for n := range answer.Objects {
+		jobs <- n
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	return errors.Join(collectErrors(errs)...)
+}
+
+func collectErrors(errs <-chan error) []error {
+	var all []error
+	for err := range errs {
+		all = append(all, err)
+	}
+	return all
+}
+
+// uploadObject uploads one file: in a single PUT, or in parts when the action has a
+// chunk_size and one url per part, then completes the parts and verifies the object.
+func (u *uploader) uploadObject(ctx context.Context, f uploadFile, upload, verify *lfsAction) error {
+	file, err := os.Open(f.local)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	chunkSize, _ := strconv.ParseInt(upload.Header["chunk_size"], 10, 64)
+	if chunkSize <= 0 {
+		if err := u.put(ctx, upload.Href, io.NewSectionReader(file, 0, f.size), f.size, nil); err != nil {
+			return err
+		}
+	} else {
+		// 每个分片的上传地址放在 00001、00002… 这些键里
+		var keys []string
+		for key := range upload.Header {
+			if _, err := strconv.Atoi(key); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, _ := strconv.Atoi(keys[i])
+			b, _ := strconv.Atoi(keys[j])
+			return a < b
+		})
+		type part struct {
+			PartNumber int    `json:"partNumber"`
+			ETag       string `json:"etag"`
+		}
+		var parts []part
+		for i, key := range keys {
+			offset := int64(i) * chunkSize
+			length := min(chunkSize, f.size-offset)
+			var etag string
+			if err := u.put(ctx, upload.Header[key], io.NewSectionReader(file, offset, length), length, &etag); err != nil {
+				return fmt.Errorf("part %d of %d: %v", i+1, len(keys), err)
+			}
+			parts = append(parts, part{PartNumber: i + 1, ETag: etag})
+		}
+		complete := struct {
+			OID   string `json:"oid"`
+			Parts []part `json:"parts"`
+		}{f.sha256, parts}
+		if err := u.postJSON(ctx, upload.Href, lfsMediaType, complete, nil); err != nil {
+			return fmt.Errorf("cannot complete the parts: %v", err)
+		}
+	}
+	if verify == nil {
+		return nil
+	}
+	body := struct {
+		OID  string `json:"oid"`
+		Size int64  `json:"size"`
+	}{f.sha256, f.size}
+	return u.postJSON(ctx, verify.Href, lfsMediaType, body, nil)
+}
+
+// commit creates the commit with the regular files inline and the LFS files by
+// their sha256 on top of u.parent, returning the id of the new commit.
+func (u *uploader) commit(ctx context.Context, files []uploadFile, deleted []string, message, description string) (string, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.Encode(map[string]interface{}{"key": "header", "value": map[string]string{"summary": message, "description": description, "parentCommit": u.parent}})
+	for _, f := range files {
+		if f.lfs {
+			encoder.Encode(map[string]interface{}{"key": "lfsFile", "value": map[string]interface{}{"path": f.path, "algo": "sha256", "oid": f.sha256, "size": f.size}})
+			continue
+		}
+		content, err := os.ReadFile(f.local)
+		if err != nil {
+			return "", err
+		}
+		encoder.Encode(map[string]interface{}{"key": "file", "value": map[string]string{"path": f.path, "content": base64.StdEncoding.EncodeToString(content), "encoding": "base64"}})
+	}
+	for _, p := range deleted {
+		encoder.Encode(map[string]interface{}{"key": "deletedFile", "value": map[string]string{"path": p}})
+	}
+	var answer struct {
+		CommitOID string `json:"commitOid"`
+		CommitURL string `json:"commitUrl"`
+	}
+	commitURL := u.ref.APIURL() + "/commit/" + url.PathEscape(u.ref.Revision)
+	if err := u.send(ctx, http.MethodPost, commitURL, "application/x-ndjson", body.Bytes(), &answer); err != nil {
+		return "", err
+	}
+	if answer.CommitURL != "" {
+		return answer.CommitURL, nil
+	}
+	return answer.CommitOID, nil
+}
+
+// postJSON posts v as JSON to a url of the Hub and decodes the answer into out, if not nil.
+func (u *uploader) postJSON(ctx context.Context, target, contentType string, v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return u.send(ctx, http.MethodPost, target, contentType, data, out)
+}
+
+func (u *uploader) send(ctx context.Context, method, target, contentType string, data []byte, out interface{}) error {
+	response, err := u.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", contentType)
+		if contentType == lfsMediaType {
+			request.Header.Set("Accept", lfsMediaType)
+		}
+		return request, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// put uploads length bytes of r to a storage url, storing the ETag of the answer in
+// etag when not nil.
+func (u *uploader) put(ctx context.Context, target string, r *io.SectionReader, length int64, etag *string) error {
+	response, err := u.do(ctx, func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodPut, target, io.NewSectionReader(r, 0, length))
+		if err != nil {
+			return nil, err
+		}
+		request.ContentLength = length
+		return request, nil
+	})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if etag != nil {
+		*etag = response.Header.Get("ETag")
+	}
+	return nil
+}
+
+// do sends the request newRequest builds, again after server errors, 429 and dropped
+// connections, up to u.retries times with a doubling delay. Other error statuses are
+// returned with the start of the body, which holds the Hub's reason.
+func (u *uploader) do(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	delay := u.retryDelay
+	for attempt := 0; ; attempt++ {
+		request, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err == nil && response.StatusCode < 300 {
+			return response, nil
+		}
+		if err == nil {
+			body, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+			response.Body.Close()
+			err = &uploadStatusError{code: response.StatusCode, status: strings.TrimSpace(response.Status + " " + string(body))}
+		}
+		var status *uploadStatusError
+		retry := !errors.As(err, &status) || status.code == http.StatusTooManyRequests || status.code >= 500
+		if !retry || attempt >= u.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		// 存储地址的查询参数里是签名，不打印
+		fmt.Fprintf(stdout, "%s %s://%s%s failed (%v), retrying in %s (%d/%d)\n", request.Method, request.URL.Scheme, request.URL.Host, request.URL.Path, err, delay, attempt+1, u.retries)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay = min(delay*2, 5*time.Minute)
+	}
+}
+
+// uploadStatusError is an error status of the Hub or the storage with the start of
+// the body, where the Hub explains it.
+type uploadStatusError struct {
+	code   int
+	status string
+}
+
+func (e *uploadStatusError) Error() string {
+	if e.code == http.StatusUnauthorized || e.code == http.StatusForbidden {
+		return fmt.Sprintf("access denied (%s): the token needs write access to the repo", e.status)
+	}
+	return e.status
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	usageLedgerName     = "usage.jsonl"
+	usageFlushInterval  = 30 * time.Second
+	usageFlushFileBytes = 1 << 20     // a response at least this large is written out when it is closed,
+	usageFlushFileDelay = time.Second // but at most this often
+	// 账本超过这么大时合并成每月每个主机一行，只保留最近的 usageKeepMonths 个月
+	usageLedgerMaxBytes = 1 << 20
+	usageKeepMonths     = 24
+)
+
+// usageRecord is one line of the ledger: bytes received from a host in a month. Each
+// run appends lines, `huggingface-go usage` adds them up.
+type usageRecord struct {
+	Month string `json:"month"` // 2006-01, local time
+	Host  string `json:"host"`
+	Bytes int64  `json:"bytes"`
+}
+
+// usageLedgerPath returns the ledger next to the config file, "" when there is no
+// home folder.
+func usageLedgerPath() string {
+	if path := configPath(); path != "" {
+		return filepath.Join(filepath.Dir(path), usageLedgerName)
+	}
+	return ""
+}
+
+// usageCounter counts the response bytes of every request by host, for users on
+// metered connections or with an egress budget. The hub, mirrors, proxies and the
+// storage hosts they redirect to are counted separately. Reads only add to atomic
+// counters; the ledger is written outside the lock.
+type usageCounter struct {
+	base http.RoundTripper
+	path string
+
+	mu      sync.Mutex
+	hosts   map[string]*atomic.Int64 // bytes not written to the ledger yet
+	total   atomic.Int64             // bytes of this run, for the --notify-url summary
+	flushed atomic.Int64             // unix nanoseconds of the last flush
+	failed  atomic.Bool
+}
+
+// usage is installed by parseFlags, nil before.
+var usage *usageCounter
+
+func installUsageCounter() {
+	usage = &usageCounter{base: http.DefaultTransport, path: usageLedgerPath(), hosts: make(map[string]*atomic.Int64)}
+	usage.flushed.Store(time.Now().UnixNano())
+	http.DefaultTransport = usage
+}
+
+func (u *usageCounter) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := u.base.RoundTrip(req)
+	if err == nil {
+		response.Body = &usageBody{ReadCloser: response.Body, counter: u, pending: u.host(req.URL.Host)}
+	}
+	return response, err
+}
+
+// host returns the counter of a host.
+func (u *usageCounter) host(name string) *atomic.Int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pending := u.hosts[name]
+	if pending == nil {
+		pending = new(atomic.Int64)
+		u.hosts[name] = pending
+	}
+	return pending
+}
+
+// received returns the bytes received by this run so far.
+func (u *usageCounter) received() int64 {
+	if u == nil {
+		return 0
+	}
+	return u.total.Load()
+}
+
+// flushAfter flushes when the last flush is at least interval ago. Of the readers
+// that find it due at the same time, only one flushes.
+func (u *usageCounter) flushAfter(interval time.Duration) {
+	last := u.flushed.Load()
+	now := time.Now().UnixNano()
+	if now-last >= int64(interval) && u.flushed.CompareAndSwap(last, now) {
+		u.flush()
+	}
+}
+
+// flush appends what was counted since the last flush to the ledger, and compacts
+// the ledger when it grew past usageLedgerMaxBytes. Does nothing when u is nil.
+func (u *usageCounter) flush() {
+	if u == nil {
+		return
+	}
+	now := time.Now()
+	u.flushed.Store(now.UnixNano())
+	if u.path == "" {
+		return
+	}
+	month := now.Format("2006-01")
+	// 只在锁里取走计数，写文件在锁外面
+	u.mu.Lock()
+	taken := make(map[string]int64, len(u.hosts))
+	for host, pending := range u.hosts {
+		if n := pending.Swap(0); n > 0 {
+			taken[host] = n
+		}
+	}
+	u.mu.Unlock()
+	if len(taken) == 0 {
+		return
+	}
+	var lines []byte
+	for host, n := range taken {
+		line, _ := json.Marshal(usageRecord{Month: month, Host: host, Bytes: n})
+		lines = append(append(lines, line...), '\n')
+	}
+	// 一次写入追加的所有行，同时运行的几个进程不会写乱
+	err := os.MkdirAll(filepath.Dir(u.path), 0755)
+	if err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(u.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err == nil {
+			_, err = file.Write(lines)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if err != nil {
+		// 没写进去的下次再写
+		u.mu.Lock()
+		for host, n := range taken {
+			u.hosts[host].Add(n)
+		}
+		u.mu.Unlock()
+		if !u.failed.Swap(true) {
+			fmt.Fprintf(stdout, "Warning: cannot record the download volume in %s: %v\n", u.path, err)
+		}
+		return
+	}
+	if stat, err := os.Stat(u.path); err == nil && stat.Size() > usageLedgerMaxBytes {
+		if err := compactUsageLedger(u.path, now); err != nil && !u.failed.Swap(true) {
+			fmt.Fprintf(stdout, "Warning: cannot compact the usage ledger %s: %v\n", u.path, err)
+		}
+	}
+}
+
+// compactUsageLedger rewrites the ledger with one line per month and host, dropping
+// the months older than usageKeepMonths before now.
+func compactUsageLedger(path string, now time.Time) error {
+	totals, err := readUsageLedger(path, nil)
+	if err != nil {
+		return err
+	}
+	oldest := now.AddDate(0, -usageKeepMonths+1, 0).Format("2006-01")
+	var lines []byte
+	for month, hosts := range totals {
+		if month < oldest {
+			continue
+		}
+		for host, n := range hosts {
+			line, _ := json.Marshal(usageRecord{Month: month, Host: host, Bytes: n})
+			lines = append(append(lines, line...), '\n')
+		}
+	}
+	// 先写临时文件再替换，中途退出也不会丢掉账本
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, lines, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// readUsageLedger adds up the ledger by month and host; skip, if not nil, is called
+// for the lines that cannot be read.
+func readUsageLedger(path string, skip func(line int, err error)) (map[string]map[string]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	totals := make(map[string]map[string]int64) // month -> host -> bytes
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// 进程被强制结束时最后一行可能不完整
+			if skip != nil {
+				skip(line, err)
+			}
+			continue
+		}
+		if totals[record.Month] == nil {
+			totals[record.Month] = make(map[string]int64)
+		}
+		totals[record.Month][record.Host] += record.Bytes
+	}
+	return totals, scanner.Err()
+}
+
+// usageBody counts what is read of a response body.
+type usageBody struct {
+	io.ReadCloser
+	counter *usageCounter
+	pending *atomic.Int64 // of the host
+	read    int64
+}
+
+func (b *usageBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.read += int64(n)
+		b.pending.Add(int64(n))
+		b.counter.total.Add(int64(n))
+		b.counter.flushAfter(usageFlushInterval)
+	}
+	return n, err
+}
+
+func (b *usageBody) Close() error {
+	err := b.ReadCloser.Close()
+	// 下载完一个文件就写入账本，大量 API 请求的小响应攒到一起写
+	if b.read >= usageFlushFileBytes {
+		b.counter.flushAfter(usageFlushFileDelay)
+	}
+	return err
+}
+
+// runUsage implements `huggingface-go usage [--month 2006-01]`: the bytes downloaded
+// per month and host, from the ledger.
+func runUsage(args []string) {
+	fs := flag.NewFlagSet("usage", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var month string
+	fs.StringVar(&month, "month", "", "only show this month, e.g. 2024-05")
+	parseFlags(fs, &g, args, "usage [flags]")
+	path := usageLedgerPath()
+	totals, err := readUsageLedger(path, func(line int, err error) {
+		fmt.Fprintf(stdout, "Skipping line %d of %s: %v\n", line, path, err)
+	})
+	if os.IsNotExist(err) {
+		fmt.Fprintf(stdout, "Nothing downloaded yet, %s does not exist\n", path)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot read the usage ledger: %v\n", err)
+		os.Exit(1)
+	}
+	if month != "" {
+		totals = map[string]map[string]int64{month: totals[month]}
+		if totals[month] == nil {
+			delete(totals, month)
+		}
+	}
+	if len(totals) == 0 {
+		fmt.Fprintf(stdout, "Nothing downloaded in %s\n", month)
+		return
+	}
+	months := make([]string, 0, len(totals))
+	for m := range totals {
+		months = append(months, m)
+	}
+	sort.Strings(months)
+	fmt.Fprintf(stdout, "%-8s  %-40s  %12s\n", "MONTH", "HOST", "DOWNLOADED")
+	for _, m := range months {
+		hosts := make([]string, 0, len(totals[m]))
+		var sum int64
+		for host, n := range totals[m] {
+			hosts = append(hosts, host)
+			sum += n
+		}
+		// 用得最多的主机在前
+		sort.Slice(hosts, func(a, b int) bool { return totals[m][hosts[a]] > totals[m][hosts[b]] })
+		for i, host := range hosts {
+			label := m
+			if i > 0 {
+				label = ""
+			}
+			size, unit := convertBytes(float64(totals[m][host]))
+			fmt.Fprintf(stdout, "%-8s  %-40s  %9.2f %s\n", label, host, size, unit)
+		}
+		if len(hosts) > 1 {
+			size, unit := convertBytes(float64(sum))
+			fmt.Fprintf(stdout, "%-8s  %-40s  %9.2f %s\n", "", "total", size, unit)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"; go install
+// builds take it from the module version.
+var version = "dev"
+
+// defaultUserAgent describes this tool instead of Go's "Go-http-client/1.1", which
+// some mirrors reject.
+func defaultUserAgent() string {
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	return fmt.Sprintf("huggingface-go/%s (%s/%s; +https://github.com/xieincz/huggingface-go)", v, runtime.GOOS, runtime.GOARCH)
+}
+
+// parseHostHeaders reads the --host-header values, host=Name: value, into the
+// headers to send to each host.
+func parseHostHeaders(values []string) (map[string]http.Header, error) {
+	headers := make(map[string]http.Header)
+	for _, value := range values {
+		host, header, _ := strings.Cut(value, "=")
+		name, headerValue, ok := strings.Cut(header, ":")
+		host, name = strings.ToLower(strings.TrimSpace(host)), strings.TrimSpace(name)
+		if !ok || host == "" || name == "" {
+			return nil, fmt.Errorf("%q is not host=Name: value", value)
+		}
+		if headers[host] == nil {
+			headers[host] = make(http.Header)
+		}
+		headers[host].Add(name, strings.TrimSpace(headerValue))
+	}
+	return headers, nil
+}
+
+// quirksTransport sends the User-Agent (--user-agent) with every request and the
+// --host-header headers with the requests to their hosts, for mirrors that only
+// answer browsers or want a Referer.
+type quirksTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	hosts     map[string]http.Header // by host, with or without the port
+}
+
+func (t *quirksTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	byName, byHost := t.hosts[strings.ToLower(req.URL.Hostname())], t.hosts[strings.ToLower(req.URL.Host)]
+	if req.Header.Get("User-Agent") != "" && byName == nil && byHost == nil {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	// host:port 的设置比只写 host 的优先
+	for _, headers := range []http.Header{byName, byHost} {
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// runVerify implements `huggingface-go verify [flags] <url>`: every file of the repo must
+// exist in the local folder with the right size and content hash. Files that are
+// missing or corrupt are reported, as well as local files the repo does not have;
+// --repair downloads the bad ones again.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var g globalOptions
+	g.register(fs)
+	var repoURL, targetParentFolder, progressMode string
+	var filter fileFilter
+	var repair bool
+	fs.StringVar(&repoURL, "u", "", "huggingface url, hf:// uri or repo id, can also be given as the first argument")
+	fs.StringVar(&targetParentFolder, "f", "./", "folder the repo was downloaded into (the parent of the repo folder)")
+	fs.Var((*stringList)(&filter.include), "include", "only verify files matching this glob, can be repeated or comma separated")
+	fs.Var((*stringList)(&filter.exclude), "exclude", "skip files matching this glob, can be repeated or comma separated")
+	fs.BoolVar(&repair, "repair", false, "download the missing and corrupt files again and check them once more")
+	fs.StringVar(&progressMode, "progress", "", "how to show the progress of --repair: bars, plain or none")
+	parseFlags(fs, &g, args, "verify [flags] <url>")
+	if !validProgressMode(progressMode) {
+		fmt.Fprintf(stdout, "Invalid --progress value %q, expected bars, plain or none\n", progressMode)
+		os.Exit(2)
+	}
+	ref := g.repoArg(fs, repoURL)
+	ctx, stop := runContext(0)
+	defer stop()
+	d, movedFrom := g.openRepo(ctx, &ref, progressOptions(progressMode)...)
+	targetFolder := path.Join(targetParentFolder, localFolderName(ref, movedFrom))
+	// 按下载时记下的提交检查和修复，上游后来的改动不算损坏，要更新用 update
+	if manifest, err := readDownloadManifest(targetFolder); err == nil && manifest.Repo == ref.ID && manifest.Commit != "" && manifest.Revision == ref.Revision {
+		fmt.Fprintf(stdout, "Checking against commit %s of %s, recorded in %s\n", manifest.Commit, ref.Revision, downloadManifestName)
+		ref.Revision = manifest.Commit
+	}
+
+	entries, err := d.ListFiles(ctx, ref, ref.ListPath(), filter)
+	if err != nil {
+		fmt.Fprintf(stdout, "Cannot fetch entries: %v\n", err)
+		os.Exit(1)
+	}
+	if entries, err = selectFile(entries, ref.File); err != nil {
+		fmt.Fprintln(stdout, err)
+		os.Exit(1)
+	}
+	// --decompress 和 --columns 保存的文件和仓库里的内容不同，没法按仓库的哈希检查
+	derived := make(map[string]string)
+	if marker, err := readCompleteMarker(targetFolder); err == nil {
+		for _, f := range marker.Files {
+			if f.DecompressedFrom != "" {
+				derived[f.DecompressedFrom] = f.Path
+			} else if len(f.Columns) > 0 || len(f.RowGroups) > 0 {
+				derived[f.Path] = f.Path
+			}
+		}
+		if marker.Sample != nil || marker.OfficialSplits {
+			// 只下载了一部分文件时，其余的不算缺失
+			entries = sampledEntries(entries, marker.Files)
+			fmt.Fprintf(stdout, "%s holds %d files selected with --max-total-size, --max-files or --official-splits, only they are checked\n", targetFolder, len(entries))
+		}
+	}
+	known := make(map[string]bool)
+	var missing, corrupt []hfdl.FileEntry
+	checked := 0
+	for _, entry := range entries {
+		if entry.Type != "file" {
+			continue
+		}
+		known[entry.Path] = true
+		if stored, ok := derived[entry.Path]; ok {
+			known[stored] = true
+			fmt.Fprintf(stdout, "SKIP    %s: stored as %s after --decompress or --columns, run download again to check it\n", entry.Path, stored)
+			continue
+		}
+		checked++
+		err := verifyLocalFile(filepath.Join(targetFolder, filepath.FromSlash(entry.Path)), entry)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Fprintf(stdout, "MISSING %s\n", entry.Path)
+			missing = append(missing, entry)
+		case err != nil:
+			fmt.Fprintf(stdout, "CORRUPT %s: %v\n", entry.Path, err)
+			corrupt = append(corrupt, entry)
+		default:
+			fmt.Fprintf(stdout, "OK      %s\n", entry.Path)
+		}
+	}
+	var extra []string
+	if ref.File == "" {
+		extra = extraLocalFiles(targetFolder, ref.ListPath(), known, filter)
+		for _, p := range extra {
+			fmt.Fprintf(stdout, "EXTRA   %s\n", p)
+		}
+	}
+	bad := append(missing, corrupt...)
+	if repair && len(bad) > 0 {
+		bad = repairFiles(ctx, d, ref, targetFolder, bad)
+		// 汇总按修复之后的状态重新统计
+		fmt.Fprintf(stdout, "Repaired %d of %d files\n", len(missing)+len(corrupt)-len(bad), len(missing)+len(corrupt))
+		missing, corrupt = nil, nil
+		for _, entry := range bad {
+			if _, err := os.Stat(filepath.Join(targetFolder, filepath.FromSlash(entry.Path))); os.IsNotExist(err) {
+				missing = append(missing, entry)
+			} else {
+				corrupt = append(corrupt, entry)
+			}
+		}
+	}
+	if len(extra) > 0 {
+		fmt.Fprintf(stdout, "%d local files in %s are not in the repo\n", len(extra), targetFolder)
+	}
+	if len(bad) > 0 {
+		fmt.Fprintf(stdout, "%d of %d files in %s failed verification: %d missing, %d corrupt\n", len(bad), checked, targetFolder, len(missing), len(corrupt))
+		if !repair {
+			fmt.Fprintln(stdout, "Run verify again with --repair to download them again")
+		}
+		os.Exit(1)
+	}
+	fmt.Fprintf(stdout, "All %d files in %s are intact\n", checked, targetFolder)
+}
+
+// repairFiles downloads the bad files again and returns those that still fail.
+func repairFiles(ctx context.Context, d *hfdl.Downloader, ref hfdl.Repo, targetFolder string, bad []hfdl.FileEntry) []hfdl.FileEntry {
+	var still []hfdl.FileEntry
+	for i, entry := range bad {
+		localPath := filepath.Join(targetFolder, filepath.FromSlash(entry.Path))
+		if _, err := readSplitManifest(localPath); err == nil {
+			fmt.Fprintf(stdout, "Cannot repair %s: it is split into parts, run download again with --oversize split\n", entry.Path)
+			still = append(still, entry)
+			continue
+		}
+		fmt.Fprintf(stdout, "Repairing file %d/%d: %s\n", i+1, len(bad), entry.Path)
+		// 坏文件和它的 .tmp 都删掉，否则会被当成已经下载的部分接着下
+		os.Remove(localPath)
+		os.Remove(localPath + ".tmp")
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			fmt.Fprintf(stdout, "Cannot repair %s: %v\n", entry.Path, err)
+			still = append(still, entry)
+			continue
+		}
+		err := d.DownloadFile(ctx, ref.ResolvePath(entry.Path), localPath, entry.Size, entry.LFSOID)
+		if err == nil {
+			err = verifyLocalFile(localPath, entry)
+		}
+		if err != nil {
+			fmt.Fprintf(stdout, "Cannot repair %s: %v\n", entry.Path, err)
+			still = append(still, entry)
+			continue
+		}
+		fmt.Fprintf(stdout, "REPAIRED %s\n", entry.Path)
+	}
+	return still
+}
+
+// extraLocalFiles returns the files below listPath in the download that are not in
+// the repo, leaving out what huggingface-go itself keeps there (markers, partial
+// files, split parts, tar indexes) and files the filter does not select.
+func extraLocalFiles(targetFolder, listPath string, known map[string]bool, filter fileFilter) []string {
+	var extra []string
+	root := filepath.Join(targetFolder, filepath.FromSlash(listPath))
+	filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if entry.Name() == blobFolderName || entry.Name() == assetsFolderName || entry.Name() == updateStagingName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(targetFolder, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if known[rel] || !filter.Match(rel) || isToolFile(rel, known) {
+			return nil
+		}
+		extra = append(extra, rel)
+		return nil
+	})
+	return extra
+}
+
+// isToolFile reports whether a local file was written by huggingface-go next to the repo files.
+func isToolFile(rel string, known map[string]bool) bool {
+	switch path.Base(rel) {
+	case completeMarkerName, manifestSignatureName, downloadManifestName, stateFileName, listingFileName, hashCacheName, offlineCardName:
+		return true
+	case checksumsName:
+		return rel == checksumsName && !known[rel]
+	}
+	// 没下载完的文件：单连接的 .tmp，分段下载的 .segtmp 和它的块记录
+	for _, suffix := range []string{".tmp", ".segtmp", ".segtmp.chunks.json"} {
+		if strings.HasSuffix(rel, suffix) {
+			return true
+		}
+	}
+	for _, suffix := range []string{splitManifestSuffix, tarIndexSuffix} {
+		if base, ok := strings.CutSuffix(rel, suffix); ok && known[base] {
+			return true
+		}
+	}
+	// model.safetensors.part000 是 --oversize split 的分片
+	if i := strings.LastIndex(rel, ".part"); i > 0 && known[rel[:i]] {
+		return true
+	}
+	return false
+}
+
+// verifyLocalFile checks size and content of one file: LFS files against their
+// SHA-256, other files against their git blob id.
+func verifyLocalFile(localPath string, entry hfdl.FileEntry) error {
+	stat, err := os.Stat(localPath)
+	if os.IsNotExist(err) {
+		// --oversize split 存成了几个分片
+		if manifest, err := readSplitManifest(localPath); err == nil {
+			return verifySplitFile(localPath, manifest, entry)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if entry.Size != hfdl.UnknownSize && stat.Size() != entry.Size {
+		return fmt.Errorf("size %d, expected %d", stat.Size(), entry.Size)
+	}
+	if entry.LFSOID != "" {
+		digest, err := hfdl.HashFile(localPath)
+		if err != nil {
+			return err
+		}
+		if digest != entry.LFSOID {
+			return fmt.Errorf("sha256 %s, expected %s", digest, entry.LFSOID)
+		}
+		return nil
+	}
+	if entry.OID == "" {
+		return nil
+	}
+	digest, err := gitBlobID(localPath, stat.Size())
+	if err != nil {
+		return err
+	}
+	if digest != entry.OID {
+		return fmt.Errorf("git blob id %s, expected %s", digest, entry.OID)
+	}
+	return nil
+}
+
+// gitBlobID computes the id git gives a file: sha1 of "blob <size>\x00" and the content.
+func gitBlobID(filePath string, size int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha1.New()
+	io.WriteString(h, "blob "+strconv.FormatInt(size, 10)+"\x00")
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
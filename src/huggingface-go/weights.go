@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// legacyWeights pairs the weight files that transformers, diffusers and peft save
+// next to or instead of safetensors with the safetensors files holding the same
+// weights: <legacy><ext>, its shards <legacy>-00001-of-00002<ext> and its shard
+// index <legacy><ext>.index.json duplicate <safetensors>.safetensors or its shards.
+var legacyWeights = []struct{ legacy, ext, safetensors string }{
+	{"pytorch_model", ".bin", "model"},
+	{"tf_model", ".h5", "model"},
+	{"flax_model", ".msgpack", "model"},
+	{"diffusion_pytorch_model", ".bin", "diffusion_pytorch_model"},
+	{"diffusion_flax_model", ".msgpack", "diffusion_pytorch_model"},
+	{"adapter_model", ".bin", "adapter_model"},
+}
+
+// 和同名的 .safetensors 放在一起时视为重复的格式，比如 v1-5-pruned.ckpt
+var legacyWeightExtensions = []string{".bin", ".ckpt", ".pt", ".pth", ".h5", ".msgpack"}
+
+// 分片文件名的后缀，比如 model-00001-of-00003
+var shardSuffix = regexp.MustCompile(`-\d+-of-\d+$`)
+
+// dropDuplicateWeights drops the weights that also exist as safetensors (--prefer-safetensors):
+// the transformers/diffusers/peft weight files whose safetensors counterpart is in the
+// same folder, see legacyWeights, and files named like a .safetensors file with another
+// weight extension. Other weights are kept even next to unrelated safetensors files.
+// It returns the kept and the dropped entries.
+func dropDuplicateWeights(entries []hfdl.FileEntry) ([]hfdl.FileEntry, []hfdl.FileEntry) {
+	models := make(map[string]bool) // folder/name of the safetensors weights, without the shard suffix
+	stems := make(map[string]bool)  // path without .safetensors
+	for _, entry := range entries {
+		if stem, ok := strings.CutSuffix(entry.Path, ".safetensors"); ok {
+			stems[stem] = true
+			models[shardSuffix.ReplaceAllString(stem, "")] = true
+		}
+	}
+	var kept, dropped []hfdl.FileEntry
+	for _, entry := range entries {
+		if isDuplicateWeight(entry.Path, models, stems) {
+			dropped = append(dropped, entry)
+		} else {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, dropped
+}
+
+func isDuplicateWeight(filePath string, models, stems map[string]bool) bool {
+	dir, name := path.Split(filePath)
+	for _, pair := range legacyWeights {
+		if !models[dir+pair.safetensors] {
+			continue
+		}
+		stem, ok := strings.CutSuffix(strings.TrimSuffix(name, ".index.json"), pair.ext)
+		if ok && shardSuffix.ReplaceAllString(stem, "") == pair.legacy {
+			return true
+		}
+	}
+	ext := path.Ext(filePath)
+	for _, e := range legacyWeightExtensions {
+		if ext == e && stems[strings.TrimSuffix(filePath, ext)] {
+			return true
+		}
+	}
+	return false
+}
+
+func printDroppedWeights(dropped []hfdl.FileEntry) {
+	if len(dropped) == 0 {
+		return
+	}
+	var total int64
+	lines := make([]string, 0, len(dropped))
+	for _, entry := range dropped {
+		total += entry.Size
+		lines = append(lines, "  "+entry.Path)
+	}
+	size, unit := convertBytes(float64(total))
+	fmt.Fprintf(stdout, "Skipping %d files (%.2f %s) that are also available as safetensors:\n%s\n", len(dropped), size, unit, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"huggingface-go/pkg/hfdl"
+)
+
+// tokenInfo is what /api/whoami-v2 says about the token: whose it is, the orgs they
+// are in and what the token may do. Fine-grained tokens list their permissions per
+// user, org or repo.
+type tokenInfo struct {
+	Name string `json:"name"`
+	Orgs []struct {
+		Name      string `json:"name"`
+		RoleInOrg string `json:"roleInOrg"` // admin, write, contributor or read
+	} `json:"orgs"`
+	Auth struct {
+		AccessToken struct {
+			Role        string `json:"role"` // read, write or fineGrained
+			FineGrained struct {
+				CanReadGatedRepos bool `json:"canReadGatedRepos"`
+				Scoped            []struct {
+					Entity struct {
+						Type string `json:"type"` // user, org, model, dataset or space
+						Name string `json:"name"`
+					} `json:"entity"`
+					Permissions []string `json:"permissions"`
+				} `json:"scoped"`
+			} `json:"fineGrained"`
+		} `json:"accessToken"`
+	} `json:"auth"`
+}
+
+// 同一个端点只问一次，批量下载时每个仓库都要检查
+var tokenInfos = struct {
+	sync.Mutex
+	m map[string]*tokenInfo
+}{m: make(map[string]*tokenInfo)}
+
+// fetchTokenInfo asks endpoint about the token. It returns nil when the endpoint has
+// no whoami-v2 (most mirrors do not) and an error when it rejects the token.
+func fetchTokenInfo(proxyURLHead, endpoint string) (*tokenInfo, error) {
+	tokenInfos.Lock()
+	defer tokenInfos.Unlock()
+	if info, ok := tokenInfos.m[endpoint]; ok {
+		return info, nil
+	}
+	response, err := http.Get(hfdl.ProxyURL(proxyURLHead, endpoint+"/api/whoami-v2"))
+	if err != nil {
+		// 连不上时留给下载本身报错
+		return nil, nil
+	}
+	defer response.Body.Close()
+	var info *tokenInfo
+	switch response.StatusCode {
+	case http.StatusOK:
+		info = new(tokenInfo)
+		if json.NewDecoder(response.Body).Decode(info) != nil {
+			info = nil
+		}
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%s rejects the token (%s): it is invalid, expired or was revoked", endpoint, response.Status)
+	}
+	tokenInfos.m[endpoint] = info
+	return info, nil
+}
+
+// checkTokenAccess fails, saying what is missing, when the token cannot read the repo
+// (or with write, write to it), instead of a 403 in the middle of the job. Nothing is
+// checked when the endpoint cannot tell.
+func checkTokenAccess(proxyURLHead string, ref hfdl.Repo, write, gated bool) error {
+	info, err := fetchTokenInfo(proxyURLHead, ref.Endpoint)
+	if err != nil || info == nil {
+		return err
+	}
+	// 没有组织名的老仓库（gpt2）不属于任何用户或组织
+	owner, _, ok := strings.Cut(ref.ID, "/")
+	if !ok {
+		owner = ""
+	}
+	access, permission := "read", "repo.content.read"
+	if write {
+		access, permission = "write", "repo.write"
+	}
+	token := info.Auth.AccessToken
+	switch token.Role {
+	case "fineGrained":
+		if gated && !write && !token.FineGrained.CanReadGatedRepos {
+			return fmt.Errorf("token lacks read access to gated repos: allow reading the public gated repos you can access in its settings")
+		}
+		for _, scope := range token.FineGrained.Scoped {
+			entity := scope.Entity
+			if (entity.Name == owner && (entity.Type == "user" || entity.Type == "org")) || (entity.Name == ref.ID && entity.Type == string(ref.Type)) {
+				if slices.Contains(scope.Permissions, permission) {
+					return nil
+				}
+			}
+		}
+		if gated && !write {
+			// 公开的门控仓库不需要单独授权
+			return nil
+		}
+		if owner != "" && owner != info.Name {
+			return fmt.Errorf("token lacks %s access to org %s", access, owner)
+		}
+		return fmt.Errorf("token lacks %s access to %s", access, ref.ID)
+	case "read":
+		if write {
+			return fmt.Errorf("token of %s is a read token, it lacks write access to %s", info.Name, ref.ID)
+		}
+	}
+	if !write || owner == "" || owner == info.Name {
+		return nil
+	}
+	for _, org := range info.Orgs {
+		if org.Name == owner {
+			if org.RoleInOrg == "read" {
+				return fmt.Errorf("token lacks write access to org %s: %s only has the read role there", owner, info.Name)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("token lacks write access to org %s: %s is not a member", owner, info.Name)
+}
+
+// checkRepoAccess is the preflight of a download with a token: when the repo is
+// private or gated, or the Hub does not show it, the token is checked for read access.
+func checkRepoAccess(proxyURLHead string, ref hfdl.Repo) error {
+	response, err := http.Get(hfdl.ProxyURL(proxyURLHead, ref.APIURL()))
+	if err != nil {
+		return nil
+	}
+	defer response.Body.Close()
+	var repo struct {
+		Private bool        `json:"private"`
+		Gated   interface{} `json:"gated"` // false, "auto" or "manual"
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		if json.NewDecoder(response.Body).Decode(&repo) != nil {
+			return nil
+		}
+		gated := repo.Gated != nil && repo.Gated != false
+		if !repo.Private && !gated {
+			return nil
+		}
+		return checkTokenAccess(proxyURLHead, ref, false, gated && !repo.Private)
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		// 私有仓库对看不到它的 token 也返回 404
+		return checkTokenAccess(proxyURLHead, ref, false, false)
+	}
+	return nil
+}